@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"server/internal/config"
+	"server/internal/server"
+
+	"github.com/joho/godotenv"
+)
+
+// rewrites username keyed users, user-state and answer-logs to user ids,
+// fills in the leaderboard stats added after them and scopes idempotency keys
+// to users
+func main() {
+	godotenv.Load()
+	cfg, err := config.Load("migrate", os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	// the migrations rewrite mongo documents, memory storage starts out migrated
+	if cfg.Storage == config.STORAGE_MEMORY {
+		log.Fatal("migrate only runs against mongo")
+	}
+	base, err := server.InitialiseServer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	n, err := base.MigrateToUserIDs(context.Background())
+	if err != nil {
+		log.Fatalf("migration stopped after %d users: %v", n, err)
+	}
+	log.Printf("migrated %d users", n)
+	if n > 0 {
+		// their tokens name them by username, the server wants the id (and a
+		// session) now
+		log.Println("users signed in before the migration are signed out, they have to sign in again")
+	}
+
+	n, err = base.BackfillBoardStats(context.Background())
+	if err != nil {
+		log.Fatalf("backfill stopped after %d users: %v", n, err)
+	}
+	log.Printf("backfilled board stats for %d users", n)
+
+	if err := base.ScopeAnswerKeys(context.Background()); err != nil {
+		log.Fatalf("answer key index: %v", err)
+	}
+	log.Println("idempotency keys are unique per user")
+}
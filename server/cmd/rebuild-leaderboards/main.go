@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"server/internal/config"
+	"server/internal/server"
+
+	"github.com/joho/godotenv"
+)
+
+// refills the redis leaderboards from mongo, for when redis lost them or drifted
+func main() {
+	godotenv.Load()
+	cfg, err := config.Load("rebuild-leaderboards", os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	base, err := server.InitialiseServer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	n, err := base.RebuildLeaderboards(context.Background())
+	if err != nil {
+		log.Fatalf("rebuild stopped after %d users: %v", n, err)
+	}
+	log.Printf("rebuilt leaderboards from %d users", n)
+}
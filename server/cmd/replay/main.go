@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"server/internal/config"
+	"server/internal/quiz"
+	"server/internal/server"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/joho/godotenv"
+)
+
+// rebuilds user-state from answer-logs, for a state that got corrupted or to
+// move everyone onto new algorithm params. Prints what changes per user.
+func main() {
+	var users, version string
+	var all, dryRun, asJSON bool
+	godotenv.Load()
+	cfg, err := config.Load("replay", os.Args[1:], func(fs *flag.FlagSet) {
+		fs.StringVar(&users, "users", "", "comma separated user ids to replay")
+		fs.BoolVar(&all, "all", false, "replay every user")
+		fs.StringVar(&version, "version", "", `params version to replay on, "latest" or a number. by default each answer uses the params it was scored with`)
+		fs.BoolVar(&dryRun, "dry-run", false, "only report the differences, save nothing")
+		fs.BoolVar(&asJSON, "json", false, "print one json result per user")
+	})
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if (users == "") == !all {
+		log.Fatal("give either -users or -all")
+	}
+	base, err := server.InitialiseServer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts := quiz.ReplayOptions{DryRun: dryRun}
+	switch version {
+	case "":
+	case "latest":
+		opts.Version = base.Algorithm().Version
+	default:
+		opts.Version, err = strconv.Atoi(version)
+		if err != nil || opts.Version < 1 {
+			log.Fatalf("bad -version %q", version)
+		}
+	}
+	var ids []string
+	if !all {
+		for _, id := range strings.Split(users, ",") {
+			ids = append(ids, strings.TrimSpace(id))
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if !asJSON {
+		fmt.Fprintln(w, "user\tusername\tfield\tstored\treplayed\t")
+	}
+	replayed, differ, saved, failed := 0, 0, 0, 0
+	err = quiz.NewQuizServer(base).ReplayUsers(context.Background(), opts, ids, func(res quiz.ReplayResult) {
+		replayed++
+		if len(res.Diffs) > 0 {
+			differ++
+		}
+		if res.Saved {
+			saved++
+		}
+		if res.Error != "" {
+			failed++
+		}
+
+		if asJSON {
+			enc.Encode(res)
+			return
+		}
+		if res.Error != "" {
+			fmt.Fprintf(w, "%s\t%s\terror\t%s\t\t\n", res.UserID, res.Username, res.Error)
+		}
+		for _, d := range res.Diffs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%v\t%v\t\n", res.UserID, res.Username, d.Field, d.Stored, d.Replayed)
+		}
+	})
+	w.Flush()
+	if err != nil {
+		log.Fatalf("replay stopped after %d users: %v", replayed, err)
+	}
+
+	if len(ids) > replayed {
+		log.Printf("%d of the users have no state", len(ids)-replayed)
+	}
+	if dryRun {
+		log.Printf("replayed %d users, %d would change, %d failed (dry run, nothing saved)", replayed, differ, failed)
+		return
+	}
+	log.Printf("replayed %d users, %d changed, %d saved, %d failed", replayed, differ, saved, failed)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"server/internal/auth"
+	"server/internal/models"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// a shadow excluded user cant find out about it from their own export
+func TestExportLeavesOutReview(t *testing.T) {
+	h := newHarness(t)
+	mallory, admin := h.register("mallory"), h.admin("admin")
+	h.play(mallory, true)
+
+	h.expect(h.do(http.MethodPost, "/admin/reviews/"+mallory.id+"/exclude", admin.token,
+		gin.H{"note": "scripted answers"}), http.StatusOK, nil)
+	h.expect(h.do(http.MethodPatch, "/account/username", mallory.token,
+		gin.H{"username": "mal"}), http.StatusOK, nil)
+
+	w := h.do(http.MethodGet, "/account/export", mallory.token, nil)
+	var export auth.AccountExport
+	h.expect(w, http.StatusOK, &export)
+
+	// the rename is still there, so the audit rows werent dropped wholesale
+	if len(export.Audit) != 1 || export.Audit[0].Action != "account.rename" {
+		t.Fatalf("audit %+v", export.Audit)
+	}
+	body := strings.ToLower(w.Body.String())
+	for _, leak := range []string{"review", "exclu", "scripted answers", admin.id} {
+		if strings.Contains(body, leak) {
+			t.Fatalf("export mentions %q: %s", leak, w.Body.String())
+		}
+	}
+}
+
+// logout works without a live session, a browser with a dead cookie can
+// always get rid of it
+func TestLogoutWithoutSession(t *testing.T) {
+	h := newHarness(t)
+	h.register("nina")
+
+	// a cookie session, then requests with its cookies
+	req := httptest.NewRequest(http.MethodPost, "/v1/auth/session", strings.NewReader(`{"username": "nina"}`))
+	req.Header.Set(auth.SESSION_MODE_HEADER, "cookie")
+	w := httptest.NewRecorder()
+	h.router.ServeHTTP(w, req)
+	cookies := w.Result().Cookies()
+	send := func(method string, path string, csrf bool) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, "/v1"+path, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		for _, c := range cookies {
+			req.AddCookie(c)
+			if csrf && c.Name == auth.CSRF_COOKIE {
+				req.Header.Set(auth.CSRF_HEADER, c.Value)
+			}
+		}
+		w := httptest.NewRecorder()
+		h.router.ServeHTTP(w, req)
+		return w
+	}
+	cleared := func(w *httptest.ResponseRecorder) {
+		t.Helper()
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("logout %d: %s", w.Code, w.Body.String())
+		}
+		got := map[string]bool{}
+		for _, c := range w.Result().Cookies() {
+			got[c.Name] = c.MaxAge < 0 && c.Value == ""
+		}
+		if !got[auth.SESSION_COOKIE] || !got[auth.CSRF_COOKIE] {
+			t.Fatalf("cookies not cleared: %v", w.Result().Cookies())
+		}
+	}
+	if len(cookies) == 0 {
+		t.Fatalf("no cookies: %d %s", w.Code, w.Body.String())
+	}
+
+	// without the csrf header the cookies go but the session stays
+	cleared(send(http.MethodPost, "/auth/logout", false))
+	h.expect(send(http.MethodGet, "/auth/sessions", false), http.StatusOK, nil)
+
+	cleared(send(http.MethodPost, "/auth/logout", true))
+	h.expect(send(http.MethodGet, "/auth/sessions", false), http.StatusUnauthorized, nil)
+	// the session is over, logging out again still clears the cookies
+	cleared(send(http.MethodPost, "/auth/logout", true))
+
+	cookies = nil
+	cleared(send(http.MethodPost, "/auth/logout", false))
+	cleared(h.do(http.MethodPost, "/auth/logout", "not-a-token", nil))
+}
+
+// knowing an admins username isnt enough, the admin routes need a session
+// started with the admin key
+func TestAdminNeedsKey(t *testing.T) {
+	h := newHarness(t)
+	root := h.admin("root")
+	h.expect(h.do(http.MethodGet, "/admin/reviews", root.token, nil), http.StatusOK, nil)
+
+	for _, key := range []string{"", "guess"} {
+		h.expect(h.do(http.MethodPost, "/auth/session", "", gin.H{"username": "root", "adminKey": key}), http.StatusUnauthorized, nil)
+	}
+
+	// the role alone, on a session started without the key, does nothing
+	olga := h.register("olga")
+	if err := h.base.Users.SetRole(context.Background(), olga.id, auth.ROLE_ADMIN); err != nil {
+		t.Fatal(err)
+	}
+	h.expect(h.do(http.MethodGet, "/admin/reviews", olga.token, nil), http.StatusForbidden, nil)
+
+	// and with no key set nobody signs in as an admin
+	key := h.base.Config.AdminKey
+	h.base.Config.AdminKey = ""
+	h.expect(h.do(http.MethodPost, "/auth/session", "", gin.H{"username": "root", "adminKey": key}), http.StatusUnauthorized, nil)
+}
+
+// deleting an account leaves nothing that names the user, what is kept for
+// statistics is under an anonymous id
+func TestDeleteAccountErasesEverything(t *testing.T) {
+	h := newHarness(t)
+	root, vic := h.admin("root"), h.register("vic")
+	var e models.Experiment
+	h.expect(h.do(http.MethodPost, "/admin/experiments", root.token, gin.H{"name": "split", "variants": []gin.H{
+		{"name": "a", "allocation": 50}, {"name": "b", "allocation": 50},
+	}}), http.StatusCreated, &e)
+	h.play(vic, true)
+	h.expect(h.do(http.MethodPatch, "/account/username", vic.token, gin.H{"username": "victor"}), http.StatusOK, nil)
+
+	var export auth.AccountExport
+	h.expect(h.do(http.MethodGet, "/account/export", vic.token, nil), http.StatusOK, &export)
+	if len(export.Exposures) != 1 || export.Exposures[0].ExperimentID != e.Id {
+		t.Fatalf("exposures %+v", export.Exposures)
+	}
+
+	h.expect(h.do(http.MethodDelete, "/account", vic.token, nil), http.StatusOK, nil)
+
+	ctx := context.Background()
+	var exposures []models.Exposure
+	cursor, err := h.base.CollExposures.Find(ctx, bson.M{"experimentId": e.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cursor.All(ctx, &exposures); err != nil {
+		t.Fatal(err)
+	}
+	if len(exposures) != 1 || exposures[0].Variant != export.Exposures[0].Variant ||
+		exposures[0].UserID == vic.id || strings.Contains(exposures[0].Id, vic.id) {
+		t.Fatalf("exposures after delete %+v", exposures)
+	}
+
+	var audit []models.AuditLog
+	cursor, err = h.base.CollAudit.Find(ctx, bson.M{"subject": vic.id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cursor.All(ctx, &audit); err != nil {
+		t.Fatal(err)
+	}
+	if len(audit) != 2 {
+		t.Fatalf("audit %+v", audit)
+	}
+	for _, entry := range audit {
+		for _, name := range []string{"vic", "victor"} {
+			if strings.Contains(fmt.Sprint(entry.Details), name) {
+				t.Fatalf("audit %s still names the user: %v", entry.Action, entry.Details)
+			}
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"server/internal/quiz"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestRegisterSessionNextAnswer(t *testing.T) {
+	h := newHarness(t)
+
+	h.expect(h.do(http.MethodGet, "/quiz/next", "", nil), http.StatusUnauthorized, nil)
+
+	alice := h.register("alice")
+	h.expect(h.do(http.MethodPost, "/auth/register", "", gin.H{"username": "alice"}), http.StatusConflict, nil)
+	h.expect(h.do(http.MethodPost, "/auth/session", "", gin.H{"username": "nobody"}), http.StatusNotFound, nil)
+
+	// a new session works the same as the one from register
+	var session struct {
+		UserID       string `json:"userId"`
+		SessionToken string `json:"sessionToken"`
+	}
+	h.expect(h.do(http.MethodPost, "/auth/session", "", gin.H{"username": "alice"}), http.StatusOK, &session)
+	if session.UserID != alice.id {
+		t.Fatalf("session for %s, registered as %s", session.UserID, alice.id)
+	}
+	alice.token = session.SessionToken
+
+	q := h.next(alice)
+	if q.Difficulty != 3 || q.StateVersion != 1 || q.CurrentScore != 0 || q.CurrentStreak != 0 {
+		t.Fatalf("new players start on difficulty 3 version 1, got %+v", q)
+	}
+
+	var res quiz.SubmitAnswerRes
+	h.expect(h.submit(alice, q, h.choice(q, true), q.StateVersion, uuid.NewString()), http.StatusOK, &res)
+	if !res.Correct || res.NewStreak != 1 || res.StateVersion != 2 {
+		t.Fatalf("after one correct answer got %+v", res)
+	}
+	if res.ScoreDelta != 33 || res.TotalScore != 33 {
+		t.Fatalf("difficulty 3 with streak 1 scores 30 * 1.1, got %v (total %v)", res.ScoreDelta, res.TotalScore)
+	}
+	if res.LeaderboardRankScore != 1 || res.LeaderboardRankStreak != 1 {
+		t.Fatalf("the only player is first, got ranks %d and %d", res.LeaderboardRankScore, res.LeaderboardRankStreak)
+	}
+
+	q = h.next(alice)
+	if q.StateVersion != 2 || q.CurrentScore != 33 || q.CurrentStreak != 1 {
+		t.Fatalf("next question doesnt carry the new state: %+v", q)
+	}
+	if n := h.answerLogs(alice); n != 1 {
+		t.Fatalf("expected 1 answer log, got %d", n)
+	}
+}
+
+func TestStaleStateVersion(t *testing.T) {
+	h := newHarness(t)
+	bob := h.register("bob")
+
+	q := h.next(bob)
+	h.expect(h.submit(bob, q, h.choice(q, true), q.StateVersion+1, uuid.NewString()), http.StatusConflict, nil)
+
+	// two answers to the same question, the second one is on an old version
+	h.expect(h.submit(bob, q, h.choice(q, true), q.StateVersion, uuid.NewString()), http.StatusOK, nil)
+	h.expect(h.submit(bob, q, h.choice(q, false), q.StateVersion, uuid.NewString()), http.StatusConflict, nil)
+
+	after := h.next(bob)
+	if after.StateVersion != q.StateVersion+1 || after.CurrentStreak != 1 {
+		t.Fatalf("rejected answers changed the state: %+v", after)
+	}
+	if n := h.answerLogs(bob); n != 1 {
+		t.Fatalf("rejected answers were logged, %d logs", n)
+	}
+}
+
+func TestConcurrentAnswersOnOneVersion(t *testing.T) {
+	h := newHarness(t)
+	carol := h.register("carol")
+	q := h.next(carol)
+	answer := h.choice(q, true)
+
+	const n = 8
+	codes := make([]int, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = h.submit(carol, q, answer, q.StateVersion, uuid.NewString()).Code
+		}()
+	}
+	wg.Wait()
+
+	ok := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusOK:
+			ok++
+		case http.StatusConflict:
+		default:
+			t.Fatalf("expected 200 or 409, got %d", code)
+		}
+	}
+	if ok != 1 {
+		t.Fatalf("%d answers got in on one version", ok)
+	}
+	if n := h.answerLogs(carol); n != 1 {
+		t.Fatalf("expected 1 answer log, got %d", n)
+	}
+}
+
+func TestDuplicateSubmission(t *testing.T) {
+	h := newHarness(t)
+	dave := h.register("dave")
+
+	q := h.next(dave)
+	key := uuid.NewString()
+	first := h.submit(dave, q, h.choice(q, true), q.StateVersion, key)
+	h.expect(first, http.StatusOK, nil)
+
+	// the retry is on the version it was sent with, it still gets the answer back
+	again := h.submit(dave, q, h.choice(q, true), q.StateVersion, key)
+	h.expect(again, http.StatusOK, nil)
+	if !bytes.Equal(first.Body.Bytes(), again.Body.Bytes()) {
+		t.Fatalf("replay differs:\n%s\n%s", first.Body, again.Body)
+	}
+
+	// same key for another answer
+	h.expect(h.submit(dave, q, h.choice(q, false), q.StateVersion, key), http.StatusUnprocessableEntity, nil)
+
+	after := h.next(dave)
+	if after.StateVersion != q.StateVersion+1 || after.CurrentStreak != 1 {
+		t.Fatalf("the duplicate was counted: %+v", after)
+	}
+	if n := h.answerLogs(dave); n != 1 {
+		t.Fatalf("expected 1 answer log, got %d", n)
+	}
+}
+
+func TestConcurrentDuplicateSubmission(t *testing.T) {
+	h := newHarness(t)
+	erin := h.register("erin")
+	q := h.next(erin)
+	answer, key := h.choice(q, true), uuid.NewString()
+
+	const n = 8
+	bodies := make([][]byte, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := h.submit(erin, q, answer, q.StateVersion, key)
+			if w.Code == http.StatusOK {
+				bodies[i] = w.Body.Bytes()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// answers that lose the race see the new version before the log, those
+	// are stale. everything that got through has the same body.
+	var first []byte
+	for _, b := range bodies {
+		if b == nil {
+			continue
+		}
+		if first == nil {
+			first = b
+		} else if !bytes.Equal(first, b) {
+			t.Fatalf("replays differ:\n%s\n%s", first, b)
+		}
+	}
+	if first == nil {
+		t.Fatal("no submission got through")
+	}
+	if n := h.answerLogs(erin); n != 1 {
+		t.Fatalf("expected 1 answer log, got %d", n)
+	}
+	if after := h.next(erin); after.StateVersion != q.StateVersion+1 {
+		t.Fatalf("state moved %d versions", after.StateVersion-q.StateVersion)
+	}
+}
+
+func TestLeaderboardRanks(t *testing.T) {
+	h := newHarness(t)
+	alice, bob, carol, dave := h.register("alice"), h.register("bob"), h.register("carol"), h.register("dave")
+
+	// alice 33 + 36, bob and dave 33 each, carol nothing
+	h.play(alice, true)
+	_, res := h.play(alice, true)
+	if res.LeaderboardRankScore != 1 {
+		t.Fatalf("alice should be first, got %d", res.LeaderboardRankScore)
+	}
+	h.play(bob, true)
+	// achievedAt is stored in ms, dave has to get there in a later one for the
+	// tie order below to be down to who was first
+	time.Sleep(2 * time.Millisecond)
+	_, res = h.play(dave, true)
+	if res.LeaderboardRankScore != 2 || res.LeaderboardRankStreak != 2 {
+		t.Fatalf("dave ties bob for second, got %d and %d", res.LeaderboardRankScore, res.LeaderboardRankStreak)
+	}
+	h.play(carol, false)
+
+	type row struct {
+		id    string
+		rank  int
+		value float64
+	}
+	check := func(path string, me player, want []row) {
+		t.Helper()
+		res := h.board(me, path)
+		if len(res.Entries) != len(want) {
+			t.Fatalf("%s: %d entries, want %d", path, len(res.Entries), len(want))
+		}
+		for i, w := range want {
+			e := res.Entries[i]
+			if e.Rank != w.rank || e.Value != w.value || (w.id != "" && e.UserID != w.id) {
+				t.Fatalf("%s: entry %d is %+v, want %+v", path, i, e, w)
+			}
+		}
+		last := want[len(want)-1]
+		if res.CurrentUser.UserID != me.id || res.CurrentUser.Rank != last.rank {
+			t.Fatalf("%s: current user %+v, want rank %d", path, res.CurrentUser, last.rank)
+		}
+	}
+
+	// ties are in the order they got there, bob answered first
+	check("/leaderboard/score", carol, []row{
+		{alice.id, 1, 69}, {bob.id, 2, 33}, {dave.id, 2, 33}, {carol.id, 4, 0},
+	})
+	check("/leaderboard/score?rank=dense", carol, []row{
+		{alice.id, 1, 69}, {bob.id, 2, 33}, {dave.id, 2, 33}, {carol.id, 3, 0},
+	})
+	check("/leaderboard/streak", carol, []row{
+		{alice.id, 1, 2}, {bob.id, 2, 1}, {dave.id, 2, 1}, {carol.id, 4, 0},
+	})
+	check("/leaderboard/score?limit=2", bob, []row{
+		{alice.id, 1, 69}, {bob.id, 2, 33},
+	})
+}
+
+// the steps from the algorithm section of the readme: 2 correct in a row with
+// 60% of the last 5 correct to go up, 1 wrong to go down, 1 to 10
+func TestAdaptiveDifficulty(t *testing.T) {
+	h := newHarness(t)
+	frank := h.register("frank")
+
+	steps := []struct {
+		correct    bool
+		difficulty int
+		why        string
+	}{
+		{true, 3, "one correct isnt enough"},
+		{true, 4, "two in a row with a full window"},
+		{false, 3, "one wrong goes down"},
+		{true, 3, "the wrong answer reset the run"},
+		{true, 4, "two in a row, 4 of 5 correct"},
+		{false, 3, "down"},
+		{false, 2, "down again"},
+		{true, 2, "one correct"},
+		{true, 3, "two in a row, 3 of 5 is exactly 60%"},
+		{true, 3, "the run starts over after going up"},
+		{false, 2, "down"},
+		{false, 1, "down"},
+		{false, 1, "1 is the floor"},
+		{true, 1, "one correct"},
+		{true, 1, "two in a row but only 2 of 5 correct"},
+		{true, 2, "three in a row, 3 of 5 correct"},
+	}
+	for i, step := range steps {
+		_, res := h.play(frank, step.correct)
+		if res.NewDifficulty != step.difficulty {
+			t.Fatalf("step %d (%s): difficulty %d, want %d", i+1, step.why, res.NewDifficulty, step.difficulty)
+		}
+		if q := h.next(frank); q.Difficulty != step.difficulty {
+			t.Fatalf("step %d: served a question at %d, want %d", i+1, q.Difficulty, step.difficulty)
+		}
+	}
+}
+
+func TestDifficultyAndMultiplierCaps(t *testing.T) {
+	h := newHarness(t)
+	grace := h.register("grace")
+
+	// from 3 every second correct answer goes up, 14 reach 10
+	var res quiz.SubmitAnswerRes
+	for i := range 45 {
+		var q quiz.NextQuestionRes
+		q, res = h.play(grace, true)
+		want := min(3+(i+1)/2, 10)
+		if res.NewDifficulty != want {
+			t.Fatalf("answer %d: difficulty %d, want %d", i+1, res.NewDifficulty, want)
+		}
+		if res.NewStreak != i+1 || q.Difficulty != min(3+i/2, 10) {
+			t.Fatalf("answer %d: streak %d on difficulty %d", i+1, res.NewStreak, q.Difficulty)
+		}
+	}
+	// 1 + 45 * 0.1 is over 5, so the multiplier is capped
+	if res.ScoreDelta != 500 {
+		t.Fatalf("difficulty 10 at 5x scores 500, got %v", res.ScoreDelta)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"server/internal/config"
+	"server/internal/experiments"
+	"server/internal/models"
+	"server/internal/server"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestExperiment(t *testing.T) {
+	h := newHarness(t)
+	root := h.admin("root")
+
+	fast := config.Default().Algorithm
+	fast.CorrectStreakToUp = 1
+	bad := [][]gin.H{
+		{{"name": "only", "allocation": 50}},
+		{{"name": "a", "allocation": 60}, {"name": "b", "allocation": 50}},
+		{{"name": "a", "allocation": 10}, {"name": "a", "allocation": 10}},
+		{{"name": "a", "allocation": 10}, {"name": "b", "allocation": 0}},
+		{{"name": "a", "allocation": 10}, {"name": "b", "allocation": 10, "params": gin.H{"rollingWindowSize": 5}}},
+	}
+	for _, variants := range bad {
+		h.expect(h.do(http.MethodPost, "/admin/experiments", root.token, gin.H{"name": "bad", "variants": variants}), http.StatusBadRequest, nil)
+	}
+
+	var e models.Experiment
+	h.expect(h.do(http.MethodPost, "/admin/experiments", root.token, gin.H{"name": "one step up", "variants": []gin.H{
+		{"name": "control", "allocation": 40},
+		{"name": "fast", "allocation": 40, "params": fast},
+	}}), http.StatusCreated, &e)
+	h.expect(h.do(http.MethodPost, "/admin/experiments", root.token, gin.H{"name": "another", "variants": []gin.H{
+		{"name": "a", "allocation": 50}, {"name": "b", "allocation": 50},
+	}}), http.StatusConflict, nil)
+
+	// one correct answer each, fast moves up on it and control doesnt. 30 users
+	// leave an arm empty about once in 5 million runs.
+	arms := map[string][]player{}
+	for i := range 30 {
+		p := h.register(fmt.Sprintf("exp%d", i))
+		v := server.VariantFor(e, p.id)
+		arm := ""
+		if v != nil {
+			arm = v.Name
+		}
+		arms[arm] = append(arms[arm], p)
+
+		_, res := h.play(p, true)
+		want := 3
+		if arm == "fast" {
+			want = 4
+		}
+		if res.NewDifficulty != want {
+			t.Fatalf("%s in arm %q went to %d, want %d", p.name, arm, res.NewDifficulty, want)
+		}
+		log := h.lastAnswer(p)
+		if arm == "" && (log.ExperimentID != "" || log.Variant != "") {
+			t.Fatalf("%s is outside the experiment but logged in %+v", p.name, log)
+		}
+		if arm != "" && (log.ExperimentID != e.Id || log.Variant != arm) {
+			t.Fatalf("%s is in %s but logged in %q %q", p.name, arm, log.ExperimentID, log.Variant)
+		}
+		// only answers scored on the stored params carry their version
+		if want := map[string]int{"": 1, "control": 1, "fast": 0}[arm]; log.ParamsVersion != want {
+			t.Fatalf("%s in arm %q logged params version %d, want %d", p.name, arm, log.ParamsVersion, want)
+		}
+	}
+	if len(arms["control"]) == 0 || len(arms["fast"]) == 0 {
+		t.Fatalf("an arm is empty: %d control, %d fast", len(arms["control"]), len(arms["fast"]))
+	}
+
+	// the first fast user comes back two days later and answers wrong
+	again := arms["fast"][0]
+	later := h.lastAnswer(again)
+	later.Id, later.IdempotencyKey = "later", "later"
+	later.Correct, later.ScoreDelta, later.Difficulty = false, 0, 4
+	later.AnsweredAt = later.AnsweredAt.Add(48 * time.Hour)
+	if err := h.base.Answers.Insert(context.Background(), later); err != nil {
+		t.Fatal(err)
+	}
+
+	var analysis experiments.AnalysisRes
+	h.expect(h.do(http.MethodGet, "/admin/experiments/"+e.Id+"/analysis", root.token, nil), http.StatusOK, &analysis)
+	if len(analysis.Arms) != 2 {
+		t.Fatalf("got %d arms", len(analysis.Arms))
+	}
+	control, fastArm := analysis.Arms[0], analysis.Arms[1]
+	nc, nf := len(arms["control"]), len(arms["fast"])
+	if control.Variant != "control" || control.Users != nc || control.Exposed != nc || control.Answers != nc || control.Accuracy != 1 {
+		t.Fatalf("control: %+v", control)
+	}
+	if fastArm.Users != nf || fastArm.Exposed != nf || fastArm.Answers != nf+1 {
+		t.Fatalf("fast: %+v", fastArm)
+	}
+	if got, want := fastArm.Accuracy, float64(nf)/float64(nf+1); got != want {
+		t.Fatalf("fast accuracy %v, want %v", got, want)
+	}
+	if got, want := fastArm.ReturnedAfter1d, 1/float64(nf); got != want || control.ReturnedAfter1d != 0 {
+		t.Fatalf("returned after a day: fast %v want %v, control %v", got, want, control.ReturnedAfter1d)
+	}
+	if p := control.Trajectory; len(p) != 1 || p[0].Answer != 1 || p[0].Users != nc || p[0].Difficulty != 3 {
+		t.Fatalf("control trajectory %+v", p)
+	}
+	if p := fastArm.Trajectory; len(p) != 1 || p[0].Users != nf || p[0].Difficulty != 3 {
+		t.Fatalf("fast trajectory %+v", p)
+	}
+
+	// the control arm plays the stored params, they stay put until the end
+	h.expect(h.do(http.MethodPut, "/admin/algorithm", root.token, gin.H{"version": 1, "params": fast}), http.StatusConflict, nil)
+
+	var ended models.Experiment
+	h.expect(h.do(http.MethodPost, "/admin/experiments/"+e.Id+"/end", root.token, nil), http.StatusOK, &ended)
+	if ended.Active || ended.EndedAt == nil {
+		t.Fatalf("got %+v", ended)
+	}
+	h.expect(h.do(http.MethodPost, "/admin/experiments/"+e.Id+"/end", root.token, nil), http.StatusConflict, nil)
+	h.expect(h.do(http.MethodPost, "/admin/experiments/nope/end", root.token, nil), http.StatusNotFound, nil)
+
+	// back on the stored params
+	after := h.register("after")
+	h.play(after, true)
+	if log := h.lastAnswer(after); log.ExperimentID != "" {
+		t.Fatalf("answer after the end logged in %q", log.ExperimentID)
+	}
+	h.expect(h.do(http.MethodPut, "/admin/algorithm", root.token, gin.H{"version": 1, "params": fast}), http.StatusCreated, nil)
+}
+
+func (h *harness) lastAnswer(p player) models.AnswerLog {
+	h.t.Helper()
+	logs := h.answers(p)
+	if len(logs) == 0 {
+		h.t.Fatalf("%s has no answers", p.name)
+	}
+	return logs[len(logs)-1]
+}
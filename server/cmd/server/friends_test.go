@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"server/internal/models"
+	"server/internal/server"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// two accepts at once for the last slot, only one of them gets it and nothing
+// stays counted for the other
+func TestAcceptLastFriendSlot(t *testing.T) {
+	h := newHarness(t)
+	hub, ann, ben := h.register("hub"), h.register("ann"), h.register("ben")
+	for _, p := range []player{ann, ben} {
+		h.expect(h.do(http.MethodPost, "/friends/requests", p.token, gin.H{"username": "hub"}), http.StatusCreated, nil)
+	}
+	ctx := context.Background()
+	if _, err := h.base.CollFriendCount.InsertOne(ctx, models.FriendCount{UserID: hub.id, Count: server.MaxFriends - 1}); err != nil {
+		t.Fatal(err)
+	}
+	count := func(p player) int {
+		t.Helper()
+		// no count yet is none
+		var fc models.FriendCount
+		err := h.base.CollFriendCount.FindOne(ctx, bson.M{"_id": p.id}).Decode(&fc)
+		if err != nil && err != mongo.ErrNoDocuments {
+			t.Fatal(err)
+		}
+		return fc.Count
+	}
+
+	codes := make([]int, 2)
+	var wg sync.WaitGroup
+	for i, p := range []player{ann, ben} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = h.do(http.MethodPost, "/friends/requests/"+p.id+"/accept", hub.token, nil).Code
+		}()
+	}
+	wg.Wait()
+
+	winner, loser := ann, ben
+	switch {
+	case codes[0] == http.StatusOK && codes[1] == http.StatusConflict:
+	case codes[0] == http.StatusConflict && codes[1] == http.StatusOK:
+		winner, loser = ben, ann
+	default:
+		t.Fatalf("accepts got %v, want one 200 and one 409", codes)
+	}
+	if count(hub) != server.MaxFriends || count(winner) != 1 || count(loser) != 0 {
+		t.Fatalf("counts hub %d, %s %d, %s %d", count(hub), winner.name, count(winner), loser.name, count(loser))
+	}
+
+	// unfriending frees the slot, a declined request never took one
+	h.expect(h.do(http.MethodDelete, "/friends/"+winner.id, hub.token, nil), http.StatusOK, nil)
+	if count(hub) != server.MaxFriends-1 || count(winner) != 0 {
+		t.Fatalf("after unfriending hub %d, %s %d", count(hub), winner.name, count(winner))
+	}
+	h.expect(h.do(http.MethodPost, "/friends/requests/"+loser.id+"/accept", hub.token, nil), http.StatusOK, nil)
+
+	// a deleted account gives its friends their slots back
+	h.expect(h.do(http.MethodDelete, "/account", loser.token, nil), http.StatusOK, nil)
+	if count(hub) != server.MaxFriends-1 {
+		t.Fatalf("hub kept the deleted friends slot: %d", count(hub))
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"server/internal/auth"
+	"server/internal/config"
+	"server/internal/models"
+	"server/internal/quiz"
+	"server/internal/server"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// harness is the whole api from newRouter on the memory store, requests go
+// straight into the router without a listener
+type harness struct {
+	t          *testing.T
+	base       *server.Server
+	router     *gin.Engine
+	registered int
+}
+
+type player struct {
+	id    string
+	name  string
+	token string
+}
+
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+
+	cfg := config.Default()
+	cfg.Storage = config.STORAGE_MEMORY
+	cfg.JWTSecret = "e2e-secret"
+	cfg.AdminKey = "e2e-admin-key"
+	cfg.Env = "dev"
+	cfg.ParamsPoll = 10 * time.Millisecond
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	base, err := server.InitialiseServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	h := &harness{t: t, base: base, router: newRouter(ctx, base)}
+	h.addQuestions()
+	return h
+}
+
+// addQuestions fills the difficulties the sample questions dont have, so a
+// player can climb all the way to 10
+func (h *harness) addQuestions() {
+	h.t.Helper()
+	for d := 6; d <= 10; d++ {
+		err := h.base.Questions.Insert(context.Background(), models.Question{
+			Id:            fmt.Sprintf("e2e-%d", d),
+			Difficulty:    d,
+			Topic:         "e2e",
+			Prompt:        fmt.Sprintf("question at %d?", d),
+			Choices:       []string{"right", "wrong"},
+			CorrectAnswer: "right",
+		})
+		if err != nil {
+			h.t.Fatal(err)
+		}
+	}
+}
+
+// do sends a json request, token can be empty
+func (h *harness) do(method string, path string, token string, body any) *httptest.ResponseRecorder {
+	h.t.Helper()
+	return h.doFrom("192.0.2.1:1234", method, path, token, body)
+}
+
+// doFrom is do from another address, registering is rate limited per ip
+func (h *harness) doFrom(addr string, method string, path string, token string, body any) *httptest.ResponseRecorder {
+	h.t.Helper()
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		r = bytes.NewReader(b)
+	}
+	req := httptest.NewRequest(method, "/v1"+path, r)
+	req.RemoteAddr = addr
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.router.ServeHTTP(w, req)
+	return w
+}
+
+// expect fails unless the response has status code, then decodes it into out
+func (h *harness) expect(w *httptest.ResponseRecorder, code int, out any) {
+	h.t.Helper()
+	if w.Code != code {
+		h.t.Fatalf("expected %d, got %d: %s", code, w.Code, w.Body.String())
+	}
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			h.t.Fatalf("bad body %q: %v", w.Body.String(), err)
+		}
+	}
+}
+
+func (h *harness) register(name string) player {
+	h.t.Helper()
+	var res struct {
+		UserID       string `json:"userId"`
+		SessionToken string `json:"sessionToken"`
+	}
+	h.registered++
+	addr := fmt.Sprintf("10.0.%d.%d:1234", h.registered/256, h.registered%256)
+	h.expect(h.doFrom(addr, http.MethodPost, "/auth/register", "", gin.H{"username": name}), http.StatusCreated, &res)
+	if res.UserID == "" || res.SessionToken == "" {
+		h.t.Fatalf("register %s gave no id or token", name)
+	}
+	return player{id: res.UserID, name: name, token: res.SessionToken}
+}
+
+// admin registers a player, gives them the admin role and signs them in
+// again with the admin key
+func (h *harness) admin(name string) player {
+	h.t.Helper()
+	p := h.register(name)
+	if err := h.base.Users.SetRole(context.Background(), p.id, auth.ROLE_ADMIN); err != nil {
+		h.t.Fatal(err)
+	}
+	var res struct {
+		SessionToken string `json:"sessionToken"`
+	}
+	h.expect(h.do(http.MethodPost, "/auth/session", "", gin.H{"username": name, "adminKey": h.base.Config.AdminKey}), http.StatusOK, &res)
+	p.token = res.SessionToken
+	return p
+}
+
+func (h *harness) next(p player) quiz.NextQuestionRes {
+	h.t.Helper()
+	var q quiz.NextQuestionRes
+	h.expect(h.do(http.MethodGet, "/quiz/next", p.token, nil), http.StatusOK, &q)
+	return q
+}
+
+// choice is the right answer to q, or a wrong one
+func (h *harness) choice(q quiz.NextQuestionRes, correct bool) string {
+	h.t.Helper()
+	stored, err := h.base.Questions.Get(context.Background(), q.QuestionID)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if correct {
+		return stored.CorrectAnswer
+	}
+	for _, c := range stored.Choices {
+		if c != stored.CorrectAnswer {
+			return c
+		}
+	}
+	h.t.Fatalf("question %s has no wrong answer", q.QuestionID)
+	return ""
+}
+
+func (h *harness) submit(p player, q quiz.NextQuestionRes, answer string, version int, key string) *httptest.ResponseRecorder {
+	h.t.Helper()
+	return h.do(http.MethodPost, "/quiz/answer", p.token, quiz.SubmitAnswerReq{
+		QuestionID:           q.QuestionID,
+		Answer:               answer,
+		StateVersion:         version,
+		AnswerIdempotencyKey: key,
+	})
+}
+
+// play gets the next question and answers it, checking the score the way the
+// readme gives it
+func (h *harness) play(p player, correct bool) (quiz.NextQuestionRes, quiz.SubmitAnswerRes) {
+	h.t.Helper()
+	q := h.next(p)
+	var res quiz.SubmitAnswerRes
+	h.expect(h.submit(p, q, h.choice(q, correct), q.StateVersion, uuid.NewString()), http.StatusOK, &res)
+
+	if res.Correct != correct {
+		h.t.Fatalf("answered correct=%v, got correct=%v", correct, res.Correct)
+	}
+	want := 0.0
+	if correct {
+		want = float64(q.Difficulty) * 10 * math.Min(1+float64(res.NewStreak)*0.1, 5)
+	}
+	if math.Abs(res.ScoreDelta-want) > 1e-9 {
+		h.t.Fatalf("difficulty %d streak %d: score delta %v, want %v", q.Difficulty, res.NewStreak, res.ScoreDelta, want)
+	}
+	if res.StateVersion != q.StateVersion+1 {
+		h.t.Fatalf("state version %d after answering on %d", res.StateVersion, q.StateVersion)
+	}
+	return q, res
+}
+
+func (h *harness) board(p player, path string) quiz.LeaderboardRes {
+	h.t.Helper()
+	var res quiz.LeaderboardRes
+	h.expect(h.do(http.MethodGet, path, p.token, nil), http.StatusOK, &res)
+	return res
+}
+
+func (h *harness) answerLogs(p player) int64 {
+	h.t.Helper()
+	return int64(len(h.answers(p)))
+}
+
+// answers is everything p answered, oldest first
+func (h *harness) answers(p player) []models.AnswerLog {
+	h.t.Helper()
+	logs, err := h.base.Answers.ForUser(context.Background(), p.id)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return logs
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"server/internal/quiz"
+	"server/internal/server"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// a board that is gone from redis (flushed, evicted) reads from the store
+// instead of coming back empty
+func TestLeaderboardMissingBoard(t *testing.T) {
+	h := newHarness(t)
+	alice, bob := h.register("alice"), h.register("bob")
+	h.play(alice, true)
+	h.play(alice, true)
+	h.play(bob, true)
+
+	now := time.Now()
+	err := h.base.Boards.Delete(context.Background(),
+		h.base.LeaderboardKey(server.BOARD_SCORE, server.PERIOD_ALL, now),
+		h.base.LeaderboardKey(server.BOARD_SCORE, server.PERIOD_WEEKLY, now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/leaderboard/score", "/leaderboard/score?period=weekly", "/leaderboard/score?around=me"} {
+		res := h.board(bob, path)
+		if len(res.Entries) != 2 || res.Entries[0].UserID != alice.id || res.Entries[1].UserID != bob.id ||
+			res.Entries[0].Rank != 1 || res.Entries[1].Rank != 2 {
+			t.Fatalf("%s: entries %+v", path, res.Entries)
+		}
+		if res.CurrentUser.UserID != bob.id || res.CurrentUser.Rank != 2 || res.CurrentUser.Value != res.Entries[1].Value {
+			t.Fatalf("%s: current user %+v", path, res.CurrentUser)
+		}
+	}
+}
+
+// a user gone from the store but still on the board is left out of the list,
+// the users after them keep the rank currentUser gives them
+func TestLeaderboardSkipsMissingUsers(t *testing.T) {
+	h := newHarness(t)
+	alice, bob, carol, dave := h.register("alice"), h.register("bob"), h.register("carol"), h.register("dave")
+	for i, p := range []player{alice, bob, carol, dave} {
+		for range 4 - i {
+			h.play(p, true)
+		}
+	}
+	if err := h.base.States.Delete(context.Background(), bob.id); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/leaderboard/score", "/leaderboard/score?offset=1&limit=1", "/leaderboard/score?offset=1"} {
+		for _, p := range []player{carol, dave} {
+			res := h.board(p, path)
+			for _, entry := range res.Entries {
+				if entry.UserID == bob.id {
+					t.Fatalf("%s: missing user listed %+v", path, res.Entries)
+				}
+				if entry.UserID == p.id && entry.Rank != res.CurrentUser.Rank {
+					t.Fatalf("%s: %s listed at %d, current user %+v", path, p.name, entry.Rank, res.CurrentUser)
+				}
+			}
+		}
+	}
+	res := h.board(alice, "/leaderboard/score")
+	if len(res.Entries) != 3 || res.Entries[0].Rank != 1 || res.Entries[1].Rank != 3 || res.Entries[2].Rank != 4 {
+		t.Fatalf("entries %+v", res.Entries)
+	}
+
+	// a page holding only the missing user still leads on to the next one
+	res = h.board(alice, "/leaderboard/score?offset=1&limit=1")
+	if len(res.Entries) != 0 || res.NextOffset == nil || *res.NextOffset != 2 {
+		t.Fatalf("page of the missing user %+v next %v", res.Entries, res.NextOffset)
+	}
+	res = h.board(alice, "/leaderboard/score?offset=2&limit=1")
+	if len(res.Entries) != 1 || res.Entries[0].UserID != carol.id {
+		t.Fatalf("page after the missing user %+v", res.Entries)
+	}
+}
+
+// a shadow excluded user still sees themselves where they would be, on every
+// view of their own, nobody else sees them
+func TestShadowExcludedSeesThemselves(t *testing.T) {
+	h := newHarness(t)
+	root, alice, bob, carol := h.admin("root"), h.register("alice"), h.register("bob"), h.register("carol")
+	for i, p := range []player{alice, bob, carol} {
+		for range 3 - i {
+			h.play(p, true)
+		}
+	}
+	h.expect(h.do(http.MethodPost, "/friends/requests", alice.token, gin.H{"username": "bob"}), http.StatusCreated, nil)
+	h.expect(h.do(http.MethodPost, "/friends/requests/"+alice.id+"/accept", bob.token, nil), http.StatusOK, nil)
+	h.expect(h.do(http.MethodPost, "/admin/reviews/"+alice.id+"/exclude", root.token, gin.H{"note": "bot"}), http.StatusOK, nil)
+
+	ids := func(res quiz.LeaderboardRes) []string {
+		var out []string
+		for _, e := range res.Entries {
+			out = append(out, e.UserID+"@"+strconv.Itoa(e.Rank))
+		}
+		return out
+	}
+	at := func(p player, rank int) string { return p.id + "@" + strconv.Itoa(rank) }
+
+	for _, path := range []string{"/leaderboard/score", "/leaderboard/score?around=me", "/leaderboard/score?rank=dense"} {
+		res := h.board(alice, path)
+		want := []string{at(alice, 1), at(bob, 2), at(carol, 3)}
+		if !slices.Equal(ids(res), want) || res.CurrentUser.Rank != 1 {
+			t.Fatalf("%s: alice sees %v, current user %+v", path, ids(res), res.CurrentUser)
+		}
+	}
+	// the page after hers moves down with her
+	if res := h.board(alice, "/leaderboard/score?offset=1&limit=1"); !slices.Equal(ids(res), []string{at(carol, 3)}) {
+		t.Fatalf("alices second page %v", ids(res))
+	}
+	if res := h.board(alice, "/leaderboard/score?scope=friends"); !slices.Equal(ids(res), []string{at(alice, 1), at(bob, 2)}) {
+		t.Fatalf("alices friends %v", ids(res))
+	}
+
+	if res := h.board(bob, "/leaderboard/score"); !slices.Equal(ids(res), []string{at(bob, 1), at(carol, 2)}) {
+		t.Fatalf("bob sees %v", ids(res))
+	}
+	if res := h.board(bob, "/leaderboard/score?scope=friends"); !slices.Equal(ids(res), []string{at(bob, 1)}) {
+		t.Fatalf("bobs friends %v", ids(res))
+	}
+}
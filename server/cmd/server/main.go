@@ -1,10 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log" // blank import registers methods
+	"os"
+	"server/internal/anticheat"
 	"server/internal/auth"
+	"server/internal/config"
+	"server/internal/experiments"
+	"server/internal/friends"
 	"server/internal/quiz"
+	"server/internal/ratelimit"
 	"server/internal/server"
+	"server/internal/teams"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -13,35 +23,128 @@ import (
 // main.go
 func main() {
 	godotenv.Load()
-	base, err := server.InitialiseServer()
+	cfg, err := config.Load("server", os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	base, err := server.InitialiseServer(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
 	// base.PopulateQuestions()
 
+	r := newRouter(context.Background(), base)
+	r.Run(cfg.Port)
+}
+
+// newRouter wires every route on base, the background jobs run until ctx is
+// done. the e2e tests boot the api through here too.
+func newRouter(ctx context.Context, base *server.Server) *gin.Engine {
 	authServer := auth.NewAuthServer(base)
 	quizServer := quiz.NewQuizServer(base)
+	friendsServer := friends.NewFriendsServer(base)
+	teamsServer := teams.NewTeamsServer(base)
+	antiCheatServer := anticheat.NewAntiCheatServer(base)
+	experimentsServer := experiments.NewExperimentsServer(base)
+	go antiCheatServer.Run(ctx)
+	go quizServer.Live.Run(ctx)
+	go base.WatchParams(ctx)
 
 	r := gin.Default()
+	// the client ip is what ratelimit.ByIP counts, only believe the forwarded
+	// headers of our own proxies
+	if err := r.SetTrustedProxies(base.Config.TrustedProxies); err != nil {
+		log.Fatal(err)
+	}
 
-	r.Use(auth.CORSMiddleware())
+	r.Use(authServer.CORSMiddleware())
 
 	v1 := r.Group("/v1")
-	v1.POST("/auth/register", authServer.RegisterUser) // works
-	v1.POST("/auth/session", authServer.Session)       // works
+	limiter := ratelimit.New(base.Cache)
+	// the limits of every rule come from the rate-limits setting
+	rule := func(name string, key ratelimit.KeyFunc) ratelimit.Rule {
+		rl := base.Config.RateLimits[name]
+		return ratelimit.Rule{Name: name, Limit: rl.Limit, Window: rl.Window, Key: key}
+	}
+
+	v1.POST("/auth/register", limiter.Limit(rule("register", ratelimit.ByIP)), authServer.RegisterUser) // works
+	v1.POST("/auth/session", limiter.Limit(
+		rule("session", ratelimit.ByIP),
+		// slows down guessing one username from many ips
+		rule("session-user", ratelimit.ByBodyField("username")),
+	), authServer.Session) // works
+	v1.POST("/auth/guest", limiter.Limit(rule("guest", ratelimit.ByIP)), authServer.Guest)
+	v1.POST("/auth/logout", authServer.Logout)
 
 	protected := v1.Group("/")
-	protected.Use(authServer.AuthMiddleware())
+	protected.Use(authServer.AuthMiddleware(), auth.CSRFMiddleware())
+	protected.POST("/auth/upgrade", authServer.UpgradeGuest)
+	protected.GET("/auth/sessions", authServer.ListSessions)
+	protected.DELETE("/auth/sessions/:sessionId", authServer.RevokeSession)
 	protected.GET("/quiz/next", quizServer.HandleNextQuestion) // working
-	protected.POST("/quiz/answer", quizServer.SubmitAnswer)    // working
-	protected.GET("/leaderboard/score", quizServer.GetScoreLeaderboard)
-	protected.GET("/leaderboard/streak", quizServer.GetStreakLeaderboard)
+	protected.POST("/quiz/answer", limiter.Limit(
+		rule("answer", ratelimit.ByUser),
+	), quizServer.SubmitAnswer) // working
+	protected.GET("/leaderboard/topics", quizServer.ListTopics)
+	protected.GET("/leaderboard/topic/:topic", quizServer.GetLeaderboard)
+	protected.GET("/leaderboard/teams/:board", teamsServer.GetTeamLeaderboard)
+	protected.GET("/leaderboard/:board", quizServer.GetLeaderboard)
+	live := limiter.Limit(rule("live", ratelimit.ByUser))
+	protected.GET("/leaderboard/topic/:topic/live", live, quizServer.StreamLeaderboard)
+	protected.GET("/leaderboard/:board/live", live, quizServer.StreamLeaderboard)
+	protected.GET("/seasons", quizServer.ListSeasons)
+	protected.GET("/seasons/:seasonId/standings", quizServer.GetSeasonStandings)
+	protected.GET("/friends", friendsServer.ListFriends)
+	protected.DELETE("/friends/:userId", friendsServer.RemoveFriend)
+	protected.GET("/friends/requests", friendsServer.ListFriendRequests)
+	protected.POST("/friends/requests", limiter.Limit(
+		rule("friend-request", ratelimit.ByUser),
+	), friendsServer.SendFriendRequest)
+	protected.POST("/friends/requests/:userId/accept", friendsServer.AcceptFriendRequest)
+	protected.POST("/teams", limiter.Limit(
+		rule("team-create", ratelimit.ByUser),
+	), teamsServer.CreateTeam)
+	protected.GET("/teams/mine", teamsServer.GetMyTeam)
+	protected.GET("/teams/invites", teamsServer.ListTeamInvites)
+	protected.GET("/teams/:teamId", teamsServer.GetTeam)
+	protected.DELETE("/teams/:teamId", teamsServer.DeleteTeam)
+	protected.POST("/teams/:teamId/invites", limiter.Limit(
+		rule("team-invite", ratelimit.ByUser),
+	), teamsServer.InviteTeamMember)
+	protected.POST("/teams/:teamId/invites/accept", teamsServer.AcceptTeamInvite)
+	protected.DELETE("/teams/:teamId/invites/:userId", teamsServer.DropTeamInvite)
+	protected.DELETE("/teams/:teamId/members/:userId", teamsServer.RemoveTeamMember)
+	protected.GET("/account/export", authServer.ExportAccount)
+	protected.DELETE("/account", authServer.DeleteAccount)
+	protected.PATCH("/account/username", authServer.RenameAccount)
+
+	admin := protected.Group("/admin")
+	admin.Use(authServer.AdminMiddleware())
+	admin.DELETE("/users/:username", authServer.AdminDeleteAccount)
+	admin.POST("/seasons", quizServer.StartSeason)
+	admin.POST("/seasons/:seasonId/end", quizServer.EndSeason)
+	admin.GET("/reviews", antiCheatServer.ListReviews)
+	admin.POST("/reviews/scan", antiCheatServer.ScanNow)
+	admin.GET("/reviews/:userId", antiCheatServer.GetReview)
+	admin.POST("/reviews/:userId/exclude", antiCheatServer.ExcludeUser)
+	admin.POST("/reviews/:userId/clear", antiCheatServer.ClearUser)
+	admin.GET("/algorithm", quizServer.GetAlgorithmParams)
+	admin.GET("/algorithm/versions", quizServer.ListAlgorithmParams)
+	admin.PUT("/algorithm", quizServer.UpdateAlgorithmParams)
+	admin.GET("/experiments", experimentsServer.ListExperiments)
+	admin.POST("/experiments", experimentsServer.StartExperiment)
+	admin.GET("/experiments/:experimentId", experimentsServer.GetExperiment)
+	admin.POST("/experiments/:experimentId/end", experimentsServer.EndExperiment)
+	admin.GET("/experiments/:experimentId/analysis", experimentsServer.GetAnalysis)
 
 	// protected.GET("/quiz/metrics", quizServer.GetMetrics)
 	// protected.GET("/leaderboard/score", quizServer.LeaderboardScore)
 	// protected.GET("/leaderboard/streak", quizServer.LeaderboardStreak)
 
-	r.Run(":8081")
+	return r
 }
 
 // have to do indempotency, answer log
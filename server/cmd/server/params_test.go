@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"server/internal/config"
+	"server/internal/models"
+	"server/internal/quiz"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAlgorithmParamsUpdate(t *testing.T) {
+	h := newHarness(t)
+	root, henry, iris := h.admin("root"), h.register("henry"), h.register("iris")
+
+	h.expect(h.do(http.MethodGet, "/admin/algorithm", henry.token, nil), http.StatusForbidden, nil)
+
+	var current models.AlgorithmParams
+	h.expect(h.do(http.MethodGet, "/admin/algorithm", root.token, nil), http.StatusOK, &current)
+	if current.Version != 1 || current.Params != config.Default().Algorithm {
+		t.Fatalf("the config should be stored as version 1, got %+v", current)
+	}
+	if _, res := h.play(henry, true); res.NewDifficulty != 3 {
+		t.Fatalf("one correct answer moved henry to %d", res.NewDifficulty)
+	}
+
+	// one correct answer is enough to go up from now on
+	params := current.Params
+	params.CorrectStreakToUp = 1
+	var updated models.AlgorithmParams
+	h.expect(h.do(http.MethodPut, "/admin/algorithm", root.token, gin.H{"version": 1, "params": params}), http.StatusCreated, &updated)
+	if updated.Version != 2 || updated.Params != params || updated.UpdatedBy != root.id {
+		t.Fatalf("got %+v", updated)
+	}
+	h.expect(h.do(http.MethodPut, "/admin/algorithm", root.token, gin.H{"version": 1, "params": params}), http.StatusConflict, nil)
+	bad := params
+	bad.MomentumThreshold = 2
+	h.expect(h.do(http.MethodPut, "/admin/algorithm", root.token, gin.H{"version": 2, "params": bad}), http.StatusBadRequest, nil)
+
+	if _, res := h.play(iris, true); res.NewDifficulty != 4 {
+		t.Fatalf("version 2 should move iris up after one correct answer, got %d", res.NewDifficulty)
+	}
+	if v := h.paramsVersions(henry); len(v) != 1 || v[0] != 1 {
+		t.Fatalf("henry answered on version 1, logs say %v", v)
+	}
+	if v := h.paramsVersions(iris); len(v) != 1 || v[0] != 2 {
+		t.Fatalf("iris answered on version 2, logs say %v", v)
+	}
+}
+
+// a version stored by another instance is picked up without a restart
+func TestAlgorithmParamsReload(t *testing.T) {
+	h := newHarness(t)
+	root := h.admin("root")
+
+	params := config.Default().Algorithm
+	params.MaxStreakMultiplier = 2
+	_, err := h.base.CollParams.InsertOne(context.Background(), models.AlgorithmParams{
+		Version:   2,
+		Params:    params,
+		UpdatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for h.base.Algorithm().Version != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("version 2 never got loaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	var history quiz.ParamsHistoryRes
+	h.expect(h.do(http.MethodGet, "/admin/algorithm/versions", root.token, nil), http.StatusOK, &history)
+	if len(history.Versions) != 2 || history.Versions[0].Params != params || history.Versions[1].Version != 1 {
+		t.Fatalf("got %+v", history)
+	}
+}
+
+func (h *harness) paramsVersions(p player) []int {
+	h.t.Helper()
+	var versions []int
+	for _, l := range h.answers(p) {
+		versions = append(versions, l.ParamsVersion)
+	}
+	return versions
+}
+
+// a params change and an experiment start at the same time dont both go
+// through, the params never change under a running experiment
+func TestParamsChangeRacesExperimentStart(t *testing.T) {
+	h := newHarness(t)
+	root := h.admin("root")
+	params := config.Default().Algorithm
+
+	for i := range 20 {
+		version := h.base.Algorithm().Version
+		params.MaxStreakMultiplier = float64(2 + i%3)
+		codes := make([]int, 2)
+		var e models.Experiment
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			codes[0] = h.do(http.MethodPut, "/admin/algorithm", root.token, gin.H{"version": version, "params": params}).Code
+		}()
+		go func() {
+			defer wg.Done()
+			w := h.do(http.MethodPost, "/admin/experiments", root.token, gin.H{"name": "race", "variants": []gin.H{
+				{"name": "a", "allocation": 50}, {"name": "b", "allocation": 50},
+			}})
+			codes[1] = w.Code
+			if w.Code == http.StatusCreated {
+				h.expect(w, http.StatusCreated, &e)
+			}
+		}()
+		wg.Wait()
+
+		// both is only fine when the params landed before the experiment started
+		if codes[1] == http.StatusCreated && h.base.Algorithm().Version != e.ParamsVersion {
+			t.Fatalf("params went to version %d under an experiment on %d (codes %v)", h.base.Algorithm().Version, e.ParamsVersion, codes)
+		}
+		if codes[1] == http.StatusCreated {
+			h.expect(h.do(http.MethodPost, "/admin/experiments/"+e.Id+"/end", root.token, nil), http.StatusOK, nil)
+		}
+	}
+}
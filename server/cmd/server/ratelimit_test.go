@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// without trusted proxies X-Forwarded-For cant change the ip the limits count
+func TestRateLimitIgnoresForwardedFor(t *testing.T) {
+	h := newHarness(t)
+	limit := h.base.Config.RateLimits["register"].Limit
+	codes := map[int]int{}
+	for i := range limit + 5 {
+		body := strings.NewReader(`{"username": "u` + strings.Repeat("x", i%5) + string(rune('a'+i)) + `"}`)
+		req := httptest.NewRequest(http.MethodPost, "/v1/auth/register", body)
+		req.RemoteAddr = "198.51.100.7:1234"
+		req.Header.Set("X-Forwarded-For", "203.0.113."+string(rune('0'+i%10)))
+		w := httptest.NewRecorder()
+		h.router.ServeHTTP(w, req)
+		codes[w.Code]++
+	}
+	if codes[http.StatusCreated] != limit || codes[http.StatusTooManyRequests] != 5 {
+		t.Fatalf("got %v", codes)
+	}
+}
+
+// the body a username limit reads is capped, a huge one isnt read into memory
+func TestRateLimitBodyCap(t *testing.T) {
+	h := newHarness(t)
+	h.register("bob")
+	body := `{"username": "bob", "pad": "` + strings.Repeat("x", 1<<20) + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/auth/session", strings.NewReader(body))
+	req.RemoteAddr = "198.51.100.7:1234"
+	w := httptest.NewRecorder()
+	h.router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	h.expect(h.do(http.MethodPost, "/auth/session", "", gin.H{"username": "bob"}), http.StatusOK, nil)
+}
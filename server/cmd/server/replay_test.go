@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"server/internal/config"
+	"server/internal/models"
+	"server/internal/quiz"
+	"server/internal/server"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestReplay(t *testing.T) {
+	h := newHarness(t)
+	root, jack, kate := h.admin("root"), h.register("jack"), h.register("kate")
+
+	answers := []bool{true, true, false, true, true, true, false, false, true, true}
+	for _, correct := range answers {
+		h.play(jack, correct)
+	}
+	h.play(kate, true)
+	// the rest of jacks answers are scored on version 2
+	params := config.Default().Algorithm
+	params.CorrectStreakToUp = 1
+	h.expect(h.do(http.MethodPut, "/admin/algorithm", root.token, gin.H{"version": 1, "params": params}), http.StatusCreated, nil)
+	for _, correct := range answers {
+		h.play(jack, correct)
+	}
+	good := h.state(jack)
+
+	replay := func(opts quiz.ReplayOptions, ids ...string) map[string]quiz.ReplayResult {
+		t.Helper()
+		results := map[string]quiz.ReplayResult{}
+		err := quiz.NewQuizServer(h.base).ReplayUsers(context.Background(), opts, ids, func(res quiz.ReplayResult) {
+			results[res.UserID] = res
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+
+	if res := replay(quiz.ReplayOptions{DryRun: true}, jack.id)[jack.id]; res.Answers != 20 || len(res.Diffs) != 0 || res.Error != "" {
+		t.Fatalf("replaying an intact state should change nothing, got %+v", res)
+	}
+
+	broken := h.state(jack)
+	broken.TotalScore, broken.CurrentDifficulty, broken.Streak, broken.CorrectWindow = 0, 9, 0, []bool{}
+	err := h.base.States.Save(context.Background(), broken, broken.StateVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := replay(quiz.ReplayOptions{DryRun: true}, jack.id)[jack.id]
+	fields := map[string]bool{}
+	for _, d := range res.Diffs {
+		fields[d.Field] = true
+	}
+	if len(fields) != 4 || !fields["totalScore"] || !fields["currentDifficulty"] || !fields["streak"] || !fields["correctWindow"] || res.Saved {
+		t.Fatalf("dry run: %+v", res)
+	}
+	if h.state(jack).TotalScore != 0 {
+		t.Fatal("the dry run saved")
+	}
+
+	if res := replay(quiz.ReplayOptions{}, jack.id)[jack.id]; !res.Saved {
+		t.Fatalf("not saved: %+v", res)
+	}
+	fixed := h.state(jack)
+	if fixed.StateVersion != good.StateVersion+1 || fixed.TotalScore != good.TotalScore || fixed.CurrentDifficulty != good.CurrentDifficulty ||
+		fixed.Streak != good.Streak || len(fixed.CorrectWindow) != len(good.CorrectWindow) || fixed.MaxDifficulty != good.MaxDifficulty {
+		t.Fatalf("replayed %+v\nwant %+v", fixed, good)
+	}
+	// the api serves the replayed state, not the cached one
+	if q := h.next(jack); q.StateVersion != fixed.StateVersion || q.CurrentScore != good.TotalScore {
+		t.Fatalf("next question on %+v", q)
+	}
+
+	// everyone on version 1, only jacks second half was scored on another one
+	all := replay(quiz.ReplayOptions{Version: 1, DryRun: true})
+	if len(all) != 3 {
+		t.Fatalf("replayed %d users, want 3", len(all))
+	}
+	if len(all[jack.id].Diffs) == 0 || len(all[kate.id].Diffs) != 0 || len(all[root.id].Diffs) != 0 {
+		t.Fatalf("on version 1: jack %+v, kate %+v, root %+v", all[jack.id], all[kate.id], all[root.id])
+	}
+
+	err = quiz.NewQuizServer(h.base).ReplayUsers(context.Background(), quiz.ReplayOptions{Version: 3}, nil, func(res quiz.ReplayResult) {
+		t.Fatalf("a missing version replayed %+v", res)
+	})
+	if err == nil {
+		t.Fatal("replayed on a version that isnt stored")
+	}
+}
+
+func (h *harness) state(p player) models.UserState {
+	h.t.Helper()
+	state, err := h.base.States.Get(context.Background(), p.id)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return *state
+}
+
+// a user who played in a season that has ended is out of it, a replay agrees
+// and doesnt put them back on its board
+func TestReplayAfterSeasonEnds(t *testing.T) {
+	h := newHarness(t)
+	root, lena := h.admin("root"), h.register("lena")
+
+	var season models.Season
+	h.expect(h.do(http.MethodPost, "/admin/seasons", root.token, gin.H{"name": "spring"}), http.StatusCreated, &season)
+	h.play(lena, true)
+	h.play(lena, true)
+	h.expect(h.do(http.MethodPost, "/admin/seasons/"+season.Id+"/end", root.token, nil), http.StatusOK, nil)
+
+	for _, opts := range []quiz.ReplayOptions{{DryRun: true}, {}} {
+		var res quiz.ReplayResult
+		err := quiz.NewQuizServer(h.base).ReplayUsers(context.Background(), opts, []string{lena.id}, func(r quiz.ReplayResult) {
+			res = r
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Answers != 2 || len(res.Diffs) != 0 || res.Error != "" {
+			t.Fatalf("replay %+v: %+v", opts, res)
+		}
+	}
+	if n, err := h.base.Boards.Size(context.Background(), server.SeasonLeaderboardKey(season.Id)); err != nil || n != 0 {
+		t.Fatalf("season board has %d (%v)", n, err)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"server/internal/models"
+	"server/internal/quiz"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ending a season resets everyone in it, a state cached from before cant put
+// the old season back on the next answer
+func TestEndSeasonResetsCachedStates(t *testing.T) {
+	h := newHarness(t)
+	admin, ivy := h.admin("admin"), h.register("ivy")
+
+	var season models.Season
+	h.expect(h.do(http.MethodPost, "/admin/seasons", admin.token, gin.H{"name": "spring"}), http.StatusCreated, &season)
+	h.play(ivy, true)
+	before := h.state(ivy)
+	if before.SeasonID != season.Id || before.SeasonScore <= 0 {
+		t.Fatalf("not in the season %+v", before)
+	}
+
+	var ended quiz.EndSeasonRes
+	h.expect(h.do(http.MethodPost, "/admin/seasons/"+season.Id+"/end", admin.token, nil), http.StatusOK, &ended)
+	if ended.Ranked != 1 {
+		t.Fatalf("ranked %d", ended.Ranked)
+	}
+	after := h.state(ivy)
+	if after.SeasonID != "" || after.SeasonScore != 0 || after.StateVersion != before.StateVersion+1 {
+		t.Fatalf("after the season %+v", after)
+	}
+
+	// served from the state as it is now, not the cached one
+	if q := h.next(ivy); q.StateVersion != after.StateVersion {
+		t.Fatalf("served on version %d, want %d", q.StateVersion, after.StateVersion)
+	}
+	h.play(ivy, true)
+	if st := h.state(ivy); st.SeasonID != "" || st.SeasonScore != 0 {
+		t.Fatalf("the old season came back %+v", st)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"server/internal/models"
+	"server/internal/teams"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// an owner can only invite, the user is on the team once they accept
+func TestTeamInviteNeedsAccepting(t *testing.T) {
+	h := newHarness(t)
+	olga, pete, quinn := h.register("olga"), h.register("pete"), h.register("quinn")
+
+	var team models.Team
+	h.expect(h.do(http.MethodPost, "/teams", olga.token, gin.H{"name": "owls"}), http.StatusCreated, &team)
+	members := func() int {
+		t.Helper()
+		var res teams.TeamRes
+		h.expect(h.do(http.MethodGet, "/teams/"+team.Id, olga.token, nil), http.StatusOK, &res)
+		if len(res.Members) != res.MemberCount {
+			t.Fatalf("%d members listed, count says %d", len(res.Members), res.MemberCount)
+		}
+		return res.MemberCount
+	}
+
+	h.expect(h.do(http.MethodPost, "/teams/"+team.Id+"/invites", olga.token, gin.H{"username": "pete"}), http.StatusCreated, nil)
+	h.expect(h.do(http.MethodPost, "/teams/"+team.Id+"/invites", olga.token, gin.H{"username": "pete"}), http.StatusConflict, nil)
+	// only the owner invites
+	h.expect(h.do(http.MethodPost, "/teams/"+team.Id+"/invites", quinn.token, gin.H{"username": "quinn"}), http.StatusForbidden, nil)
+
+	if n := members(); n != 1 {
+		t.Fatalf("an invite alone put %d on the team", n)
+	}
+	h.expect(h.do(http.MethodGet, "/teams/mine", pete.token, nil), http.StatusNotFound, nil)
+
+	var invites teams.TeamInvitesRes
+	h.expect(h.do(http.MethodGet, "/teams/invites", pete.token, nil), http.StatusOK, &invites)
+	if len(invites.Invites) != 1 || invites.Invites[0].TeamName != "owls" || invites.Invites[0].InvitedBy != olga.id {
+		t.Fatalf("invites %+v", invites)
+	}
+
+	// nobody else can use petes invite
+	h.expect(h.do(http.MethodPost, "/teams/"+team.Id+"/invites/accept", quinn.token, nil), http.StatusNotFound, nil)
+	h.expect(h.do(http.MethodPost, "/teams/"+team.Id+"/invites/accept", pete.token, nil), http.StatusCreated, nil)
+	if n := members(); n != 2 {
+		t.Fatalf("%d members after accepting", n)
+	}
+	h.expect(h.do(http.MethodPost, "/teams/"+team.Id+"/invites/accept", pete.token, nil), http.StatusNotFound, nil)
+
+	// a declined invite cant be accepted later
+	h.expect(h.do(http.MethodPost, "/teams/"+team.Id+"/invites", olga.token, gin.H{"username": "quinn"}), http.StatusCreated, nil)
+	h.expect(h.do(http.MethodDelete, "/teams/"+team.Id+"/invites/"+quinn.id, quinn.token, nil), http.StatusOK, nil)
+	h.expect(h.do(http.MethodPost, "/teams/"+team.Id+"/invites/accept", quinn.token, nil), http.StatusNotFound, nil)
+	if n := members(); n != 2 {
+		t.Fatalf("%d members after a decline", n)
+	}
+}
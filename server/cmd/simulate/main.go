@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"server/internal/config"
+	"server/internal/quiz"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// plays made up users through the adaptive algorithm and prints how fast
+// they settle, how much the difficulty bounces around and what they score
+func main() {
+	abilities := flag.String("abilities", "1,2,3,4,5,6,7,8,9,10", "comma separated abilities, the difficulty a user gets half right")
+	users := flag.Int("users", 200, "users per ability")
+	answers := flag.Int("answers", 2000, "answers per user")
+	slope := flag.Float64("slope", 1, "how fast users stop getting questions right above their ability")
+	start := flag.Int("start", 3, "difficulty users start at")
+	seed := flag.Int64("seed", 1, "random seed, the same seed gives the same report")
+	asJSON := flag.Bool("json", false, "print the report as json")
+	// the constants to try, the same flags the server takes
+	algo := config.Default().Algorithm
+	flag.IntVar(&algo.CorrectStreakToUp, "correct-streak-to-up", algo.CorrectStreakToUp, "correct answers in a row to raise difficulty")
+	flag.IntVar(&algo.WrongStreakToDown, "wrong-streak-to-down", algo.WrongStreakToDown, "wrong answers in a row to lower difficulty")
+	flag.IntVar(&algo.RollingWindowSize, "rolling-window-size", algo.RollingWindowSize, "answers momentum is worked out over")
+	flag.Float64Var(&algo.MomentumThreshold, "momentum-threshold", algo.MomentumThreshold, "share of the window that has to be correct to raise difficulty")
+	flag.Float64Var(&algo.MaxStreakMultiplier, "max-streak-multiplier", algo.MaxStreakMultiplier, "cap on the streak score multiplier")
+	flag.Parse()
+
+	cfg := quiz.SimConfig{Users: *users, Answers: *answers, Slope: *slope, Start: *start, Seed: *seed, Algorithm: algo}
+	for _, a := range strings.Split(*abilities, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(a), 64)
+		if err != nil {
+			log.Fatalf("bad ability %q", a)
+		}
+		cfg.Abilities = append(cfg.Abilities, v)
+	}
+
+	groups, err := quiz.Simulate(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(groups)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "ability\tsettled\tconverged p50\tp90\tnever\tchanges/100\treversals/100\taccuracy\tscore/answer\ttotal p10\tp50\tp90\t")
+	for _, g := range groups {
+		fmt.Fprintf(w, "%.1f\t%.2f\t%.0f\t%.0f\t%d\t%.1f\t%.1f\t%.2f\t%.1f\t%.0f\t%.0f\t%.0f\t\n",
+			g.Ability, g.Settled, g.ConvergedAfter.P50, g.ConvergedAfter.P90, g.NeverConverged,
+			g.ChangesPer100, g.ReversalsPer100, g.Accuracy, g.ScorePerAnswer,
+			g.TotalScore.P10, g.TotalScore.P50, g.TotalScore.P90)
+	}
+	w.Flush()
+}
@@ -0,0 +1,197 @@
+package anticheat
+
+import (
+	"context"
+	"errors"
+	"log"
+	"server/internal/auth"
+	"server/internal/models"
+	"server/internal/store"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const REVIEW_NOT_FOUND = "review not found"
+
+// flag puts a user in the queue with the latest findings. Excluded users just
+// get the new findings, cleared users only come back for answers given after
+// their review. Guests are left alone, they arent on any board.
+func (s *Server) flag(ctx context.Context, userID string, username string, findings []models.Finding) (bool, error) {
+	state, err := s.States.Get(ctx, userID)
+	if err == store.ErrNotFound || (err == nil && state.Guest) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if state.Username != "" {
+		username = state.Username
+	}
+
+	var review models.CheatReview
+	err = s.CollReviews.FindOne(ctx, bson.M{"_id": userID}).Decode(&review)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return false, err
+	}
+	exists := err == nil
+
+	now := time.Now().UTC()
+	set := bson.M{"username": username, "findings": findings, "updatedAt": now}
+
+	switch {
+	case exists && review.Status == models.REVIEW_EXCLUDED:
+	case exists && review.Status == models.REVIEW_CLEARED:
+		if review.ReviewedAt != nil && !newerThan(findings, *review.ReviewedAt) {
+			return false, nil
+		}
+		set["status"] = models.REVIEW_OPEN
+		set["flaggedAt"] = now
+	case exists:
+		// still open, keeps its place in the queue
+	default:
+		set["status"] = models.REVIEW_OPEN
+		set["flaggedAt"] = now
+	}
+
+	_, err = s.CollReviews.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": set},
+		options.UpdateOne().SetUpsert(true))
+	return err == nil, err
+}
+
+func newerThan(findings []models.Finding, t time.Time) bool {
+	for _, f := range findings {
+		if f.LastAt.After(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// listReviews pages through the queue in the order users were flagged
+func (s *Server) listReviews(status string, offset int, limit int) ([]models.CheatReview, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	cursor, err := s.CollReviews.Find(ctx, bson.M{"status": status},
+		options.Find().
+			SetSort(bson.D{{Key: "flaggedAt", Value: 1}, {Key: "_id", Value: 1}}).
+			SetSkip(int64(offset)).
+			SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	reviews := []models.CheatReview{}
+	if err := cursor.All(ctx, &reviews); err != nil {
+		return nil, err
+	}
+	return reviews, nil
+}
+
+func (s *Server) getReview(userID string) (*models.CheatReview, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	var review models.CheatReview
+	err := s.CollReviews.FindOne(ctx, bson.M{"_id": userID}).Decode(&review)
+	if err == mongo.ErrNoDocuments {
+		return nil, errors.New(REVIEW_NOT_FOUND)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+// exclude shadow excludes a user: they drop off every public board but keep
+// playing and see their own values and ranks as before. Works with or without
+// a review from the detector.
+func (s *Server) exclude(userID string, actor string, note string) (*models.CheatReview, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.BatchTimeout)
+	defer cancel()
+
+	// the version bump makes any stale cached copy fail its next write
+	// instead of dropping the flag again
+	state, err := s.States.Exclude(ctx, userID)
+	if err == store.ErrNotFound {
+		return nil, errors.New(auth.USER_NOT_FOUND)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.DeleteCachedState(ctx, "user_state:"+userID); err != nil {
+		log.Println("cache error:", err)
+	}
+	if err := s.RemoveFromLeaderboards(ctx, userID); err != nil {
+		log.Println("leaderboard error:", err)
+	}
+
+	return s.review(ctx, userID, state.Username, models.REVIEW_EXCLUDED, actor, note)
+}
+
+// clear closes a review, and lifts the exclusion if there was one
+func (s *Server) clear(userID string, actor string, note string) (*models.CheatReview, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.BatchTimeout)
+	defer cancel()
+
+	review, err := s.getReview(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := s.States.Include(ctx, userID)
+	if err != nil && err != store.ErrNotFound {
+		return nil, err
+	}
+	if err == nil {
+		if err := s.DeleteCachedState(ctx, "user_state:"+userID); err != nil {
+			log.Println("cache error:", err)
+		}
+		if err := s.RestoreToLeaderboards(ctx, *state); err != nil {
+			log.Println("leaderboard error:", err)
+		}
+	}
+
+	return s.review(ctx, userID, review.Username, models.REVIEW_CLEARED, actor, note)
+}
+
+// review records an admin decision on the users review
+func (s *Server) review(ctx context.Context, userID string, username string, status string, actor string, note string) (*models.CheatReview, error) {
+	now := time.Now().UTC()
+	var review models.CheatReview
+	err := s.CollReviews.FindOneAndUpdate(ctx,
+		bson.M{"_id": userID},
+		bson.M{
+			"$set": bson.M{
+				"status":     status,
+				"reviewedBy": actor,
+				"reviewedAt": now,
+				"note":       note,
+				"updatedAt":  now,
+			},
+			"$setOnInsert": bson.M{
+				"username":  username,
+				"findings":  []models.Finding{},
+				"flaggedAt": now,
+			},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&review)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.users.WriteAudit(models.AuditLog{
+		Action:  models.ReviewAction(status),
+		Subject: userID,
+		Actor:   actor,
+		Details: map[string]any{"note": note},
+	}); err != nil {
+		log.Println("audit error:", err)
+	}
+
+	return &review, nil
+}
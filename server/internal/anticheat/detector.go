@@ -0,0 +1,227 @@
+package anticheat
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"server/internal/models"
+	"server/internal/store"
+	"strings"
+	"time"
+)
+
+// The detector walks the last ScanWindow of answer-logs one user at a time and
+// flags patterns an honest player is very unlikely to produce:
+//
+//	fast_answers      most timed answers came back in under a second
+//	perfect_hard_run  30+ correct answers in a row at difficulty 8 and up
+//	burst             30+ answers inside one minute
+//	key_churn         one serve of a question answered under 5+ idempotency keys
+//
+// A flag only puts the user in the review queue, nothing happens to them until
+// an admin excludes them.
+const (
+	RULE_FAST_ANSWERS = "fast_answers"
+	RULE_PERFECT_RUN  = "perfect_hard_run"
+	RULE_BURST        = "burst"
+	RULE_KEY_CHURN    = "key_churn"
+
+	fastAnswer       = time.Second
+	minTimedAnswers  = 20
+	fastShare        = 0.5
+	hardDifficulty   = 8
+	perfectRunLength = 30
+	burstWindow      = time.Minute
+	burstAnswers     = 30
+	churnKeys        = 5
+
+	ScanWindow = 24 * time.Hour
+	ScanEvery  = 10 * time.Minute
+
+	// only one instance scans per interval
+	scanLock = "anticheat:scan"
+)
+
+// Run scans every ScanEvery until ctx is done
+func (s *Server) Run(ctx context.Context) {
+	ticker := time.NewTicker(ScanEvery)
+	defer ticker.Stop()
+
+	for {
+		if s.takeScanLock(ctx) {
+			flagged, err := s.Scan(ctx, time.Now().Add(-ScanWindow))
+			if err != nil {
+				log.Println("anticheat scan error:", err)
+			} else if flagged > 0 {
+				log.Printf("anticheat flagged %d users", flagged)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// takeScanLock is true for the one instance that should scan this interval
+func (s *Server) takeScanLock(ctx context.Context) bool {
+	ok, err := s.Cache.SetNX(ctx, scanLock, []byte("1"), ScanEvery-time.Second)
+	if err != nil {
+		log.Println("anticheat lock error:", err)
+	}
+	return ok
+}
+
+// Scan checks every answer from since on and returns how many users it put
+// in (or kept in) the review queue
+func (s *Server) Scan(ctx context.Context, since time.Time) (int, error) {
+	flagged := 0
+	err := s.Answers.EachUser(ctx, store.AnswerQuery{Since: since}, func(answers []models.AnswerLog) error {
+		// deleted accounts keep their answers under a throwaway id
+		if strings.HasPrefix(answers[0].UserID, "deleted:") {
+			return nil
+		}
+		findings := detect(answers)
+		if len(findings) == 0 {
+			return nil
+		}
+		last := answers[len(answers)-1]
+		ok, err := s.flag(ctx, last.UserID, last.Username, findings)
+		if ok {
+			flagged++
+		}
+		return err
+	})
+	return flagged, err
+}
+
+// detect runs every rule over one users answers, oldest first
+func detect(answers []models.AnswerLog) []models.Finding {
+	var findings []models.Finding
+	for _, rule := range []func([]models.AnswerLog) *models.Finding{
+		fastAnswers, perfectRun, burst, keyChurn,
+	} {
+		if f := rule(answers); f != nil {
+			findings = append(findings, *f)
+		}
+	}
+	return findings
+}
+
+func fastAnswers(answers []models.AnswerLog) *models.Finding {
+	var f models.Finding
+	timed := 0
+	for _, a := range answers {
+		if a.ResponseMs <= 0 {
+			continue
+		}
+		timed++
+		if a.ResponseMs < fastAnswer.Milliseconds() {
+			if f.Count == 0 {
+				f.FirstAt = a.AnsweredAt
+			}
+			f.Count++
+			f.LastAt = a.AnsweredAt
+		}
+	}
+	if timed < minTimedAnswers || float64(f.Count) < fastShare*float64(timed) {
+		return nil
+	}
+
+	f.Rule = RULE_FAST_ANSWERS
+	f.Detail = fmt.Sprintf("%d of %d timed answers under %s", f.Count, timed, fastAnswer)
+	return &f
+}
+
+// perfectRun only looks at hard questions, easier ones in between dont break the run
+func perfectRun(answers []models.AnswerLog) *models.Finding {
+	var best, run models.Finding
+	for _, a := range answers {
+		if a.Difficulty < hardDifficulty {
+			continue
+		}
+		if !a.Correct {
+			run = models.Finding{}
+			continue
+		}
+		if run.Count == 0 {
+			run.FirstAt = a.AnsweredAt
+		}
+		run.Count++
+		run.LastAt = a.AnsweredAt
+		if run.Count > best.Count {
+			best = run
+		}
+	}
+	if best.Count < perfectRunLength {
+		return nil
+	}
+
+	best.Rule = RULE_PERFECT_RUN
+	best.Detail = fmt.Sprintf("%d correct in a row at difficulty %d+", best.Count, hardDifficulty)
+	return &best
+}
+
+func burst(answers []models.AnswerLog) *models.Finding {
+	var best models.Finding
+	start := 0
+	for end, a := range answers {
+		for a.AnsweredAt.Sub(answers[start].AnsweredAt) >= burstWindow {
+			start++
+		}
+		if n := end - start + 1; n > best.Count {
+			best = models.Finding{Count: n, FirstAt: answers[start].AnsweredAt, LastAt: a.AnsweredAt}
+		}
+	}
+	if best.Count < burstAnswers {
+		return nil
+	}
+
+	best.Rule = RULE_BURST
+	best.Detail = fmt.Sprintf("%d answers inside %s", best.Count, burstWindow)
+	return &best
+}
+
+// keyChurn catches a client replaying a question it knows with fresh keys. An
+// honest client answers each serve once (a retry reuses its key), so keys are
+// counted per serve and a long session that gets the same question again and
+// again never adds up. Answers to a question that was never served all count as
+// one serve, that is what answering without asking looks like.
+func keyChurn(answers []models.AnswerLog) *models.Finding {
+	type serve struct {
+		session, question string
+		servedAt          int64
+	}
+	counts := map[serve]*models.Finding{}
+	var worst *models.Finding
+	for _, a := range answers {
+		if a.SessionID == "" {
+			continue
+		}
+		var servedAt int64
+		if !a.ServedAt.IsZero() {
+			servedAt = a.ServedAt.UnixMilli()
+		}
+		p := serve{a.SessionID, a.QuestionID, servedAt}
+		f, ok := counts[p]
+		if !ok {
+			f = &models.Finding{FirstAt: a.AnsweredAt}
+			counts[p] = f
+		}
+		f.Count++
+		f.LastAt = a.AnsweredAt
+		if worst == nil || f.Count > worst.Count {
+			worst = f
+		}
+	}
+	if worst == nil || worst.Count < churnKeys {
+		return nil
+	}
+
+	f := *worst
+	f.Rule = RULE_KEY_CHURN
+	f.Detail = fmt.Sprintf("one serve of a question was answered under %d keys", f.Count)
+	return &f
+}
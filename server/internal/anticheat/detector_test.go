@@ -0,0 +1,63 @@
+package anticheat
+
+import (
+	"fmt"
+	"server/internal/models"
+	"testing"
+	"time"
+)
+
+// a long session on a small question bank gets the same question served again
+// and again, one answer per serve is honest however often it comes back
+func TestKeyChurnLongHonestSession(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var answers []models.AnswerLog
+	for i := range 40 {
+		served := t0.Add(time.Duration(i) * 10 * time.Second)
+		answers = append(answers, models.AnswerLog{
+			IdempotencyKey: fmt.Sprint(i),
+			SessionID:      "s1",
+			QuestionID:     []string{"q1", "q2"}[i%2],
+			ServedAt:       served,
+			AnsweredAt:     served.Add(4 * time.Second),
+		})
+	}
+	if f := keyChurn(answers); f != nil {
+		t.Fatalf("flagged an honest session: %+v", f)
+	}
+
+	// the same serve answered under fresh keys
+	for i := range churnKeys {
+		replay := answers[0]
+		replay.IdempotencyKey = fmt.Sprint("replay", i)
+		replay.AnsweredAt = replay.AnsweredAt.Add(time.Duration(i+1) * time.Millisecond)
+		answers = append(answers, replay)
+	}
+	if f := keyChurn(answers); f == nil || f.Count != churnKeys+1 {
+		t.Fatalf("replayed serve: %+v", f)
+	}
+}
+
+// answering a question that was never served has no serve to count against
+func TestKeyChurnUnserved(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var answers []models.AnswerLog
+	for i := range churnKeys {
+		answers = append(answers, models.AnswerLog{
+			IdempotencyKey: fmt.Sprint(i),
+			SessionID:      "s1",
+			QuestionID:     "q1",
+			AnsweredAt:     t0.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	if f := keyChurn(answers); f == nil || f.Rule != RULE_KEY_CHURN {
+		t.Fatalf("unserved answers: %+v", f)
+	}
+	// without a session there is nothing to group by
+	for i := range answers {
+		answers[i].SessionID = ""
+	}
+	if f := keyChurn(answers); f != nil {
+		t.Fatalf("flagged without a session: %+v", f)
+	}
+}
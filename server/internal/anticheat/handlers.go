@@ -0,0 +1,137 @@
+package anticheat
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"server/internal/auth"
+	"server/internal/models"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+type ReviewsRes struct {
+	Reviews    []models.CheatReview `json:"reviews"`
+	NextOffset *int                 `json:"nextOffset,omitempty"`
+}
+
+type ReviewReq struct {
+	Note string `json:"note" binding:"max=500"`
+}
+
+type ScanRes struct {
+	Flagged int `json:"flagged"`
+}
+
+// ListReviews pages through the review queue, ?status=open (default), cleared or excluded
+func (s *Server) ListReviews(c *gin.Context) {
+	status := c.DefaultQuery("status", models.REVIEW_OPEN)
+	switch status {
+	case models.REVIEW_OPEN, models.REVIEW_CLEARED, models.REVIEW_EXCLUDED:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be open, cleared or excluded"})
+		return
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a positive number"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit < 1 || limit > maxPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxPageSize)})
+		return
+	}
+
+	reviews, err := s.listReviews(status, offset, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	res := ReviewsRes{Reviews: reviews}
+	if len(reviews) == limit {
+		next := offset + limit
+		res.NextOffset = &next
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (s *Server) GetReview(c *gin.Context) {
+	review, err := s.getReview(c.Param("userId"))
+	if err != nil {
+		if err.Error() == REVIEW_NOT_FOUND {
+			c.JSON(http.StatusNotFound, gin.H{"error": REVIEW_NOT_FOUND})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, review)
+}
+
+// ExcludeUser shadow excludes :userId from the public leaderboards
+func (s *Server) ExcludeUser(c *gin.Context) {
+	note, ok := reviewNote(c)
+	if !ok {
+		return
+	}
+
+	review, err := s.exclude(c.Param("userId"), c.GetString("userId"), note)
+	if err != nil {
+		if err.Error() == auth.USER_NOT_FOUND {
+			c.JSON(http.StatusNotFound, gin.H{"error": auth.USER_NOT_FOUND})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, review)
+}
+
+// ClearUser closes the review of :userId and puts them back on the boards
+func (s *Server) ClearUser(c *gin.Context) {
+	note, ok := reviewNote(c)
+	if !ok {
+		return
+	}
+
+	review, err := s.clear(c.Param("userId"), c.GetString("userId"), note)
+	if err != nil {
+		if err.Error() == REVIEW_NOT_FOUND {
+			c.JSON(http.StatusNotFound, gin.H{"error": REVIEW_NOT_FOUND})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, review)
+}
+
+// ScanNow runs the detector over the last ScanWindow right away
+func (s *Server) ScanNow(c *gin.Context) {
+	flagged, err := s.Scan(c.Request.Context(), time.Now().Add(-ScanWindow))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "scan failed " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ScanRes{Flagged: flagged})
+}
+
+// reviewNote reads the optional note, the body can be left out
+func reviewNote(c *gin.Context) (string, bool) {
+	var req ReviewReq
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "note max 500 chars"})
+		return "", false
+	}
+	return req.Note, true
+}
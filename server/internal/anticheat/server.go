@@ -0,0 +1,15 @@
+package anticheat
+
+import (
+	"server/internal/auth"
+	"server/internal/server"
+)
+
+type Server struct {
+	*server.Server
+	users *auth.Server // audit log
+}
+
+func NewAntiCheatServer(s *server.Server) *Server {
+	return &Server{Server: s, users: auth.NewAuthServer(s)}
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"server/internal/models"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AccountExport struct {
+	ExportedAt time.Time               `json:"exportedAt"`
+	User       models.User             `json:"user"`
+	State      *models.UserState       `json:"state"`
+	Answers    []models.AnswerLog      `json:"answers"`
+	Audit      []models.AuditLog       `json:"audit"`
+	Sessions   []models.Session        `json:"sessions"`
+	Standings  []models.SeasonStanding `json:"standings"`
+	Friends    []models.Friendship     `json:"friends"`
+	Team       *models.TeamMember      `json:"team"`
+	Invites    []models.TeamInvite     `json:"invites"`
+	Exposures  []models.Exposure       `json:"exposures"`
+}
+
+type DeleteAccountRes struct {
+	UserID            string `json:"userId"`
+	AnswersAnonymised int64  `json:"answersAnonymised"`
+}
+
+type RenameRes struct {
+	UserID   string `json:"userId"`
+	Username string `json:"username"`
+}
+
+// ExportAccount returns everything stored about the caller as a downloadable json archive
+func (s *Server) ExportAccount(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	export, err := s.exportUserData(userID)
+	if err != nil {
+		if err.Error() == USER_NOT_FOUND {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user doenst exist"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export data"})
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="`+userID+`-export.json"`)
+	c.JSON(http.StatusOK, export)
+}
+
+// RenameAccount changes the callers username, answers and ranks stay attached to the user id
+func (s *Server) RenameAccount(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	var req RegisterReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username bw 1-10 chars"})
+		return
+	}
+
+	req.Username = strings.TrimSpace(req.Username)
+	if isGuestName(req.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username cant start with " + GUEST_PREFIX})
+		return
+	}
+
+	user, err := s.GetUser(userID)
+	if err != nil {
+		if err.Error() == USER_NOT_FOUND {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user doenst exist"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if user.Guest {
+		c.JSON(http.StatusConflict, gin.H{"error": "guests have to upgrade instead"})
+		return
+	}
+
+	if err := s.renameUser(userID, req.Username); err != nil {
+		if err.Error() == USER_EXISTS {
+			c.JSON(http.StatusConflict, gin.H{"error": "username already taken"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	if err := s.WriteAudit(models.AuditLog{
+		Action:  "account.rename",
+		Subject: userID,
+		Actor:   userID,
+		Details: map[string]any{"from": user.Username, "to": req.Username},
+	}); err != nil {
+		log.Println("audit error:", err)
+	}
+
+	c.JSON(http.StatusOK, RenameRes{
+		UserID:   userID,
+		Username: req.Username,
+	})
+}
+
+func (s *Server) DeleteAccount(c *gin.Context) {
+	userID := c.GetString("userId")
+	s.deleteAccount(c, userID, userID)
+}
+
+// AdminDeleteAccount lets an admin erase any user by username
+func (s *Server) AdminDeleteAccount(c *gin.Context) {
+	user, err := s.FindInUsersTable(c.Param("username"))
+	if err != nil {
+		if err.Error() == USER_NOT_FOUND {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user doenst exist"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "sum error finding user"})
+		return
+	}
+
+	s.deleteAccount(c, user.Id, c.GetString("userId"))
+}
+
+func (s *Server) deleteAccount(c *gin.Context, subject string, actor string) {
+	anonymised, err := s.deleteUserData(subject)
+	if err != nil {
+		if err.Error() == USER_NOT_FOUND {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user doenst exist"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete account"})
+		return
+	}
+
+	// the data is already gone at this point so a failed audit write is only logged
+	if err := s.WriteAudit(models.AuditLog{
+		Action:  "account.delete",
+		Subject: subject,
+		Actor:   actor,
+		Details: map[string]any{"answersAnonymised": anonymised},
+	}); err != nil {
+		log.Println("audit error:", err)
+	}
+
+	c.JSON(http.StatusOK, DeleteAccountRes{
+		UserID:            subject,
+		AnswersAnonymised: anonymised,
+	})
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	SESSION_COOKIE = "session"
+	CSRF_COOKIE    = "csrf_token"
+	CSRF_HEADER    = "X-CSRF-Token"
+
+	// browsers send this to get an HttpOnly cookie instead of a token in the body
+	SESSION_MODE_HEADER = "X-Session-Mode"
+)
+
+func wantsCookies(c *gin.Context) bool {
+	return c.GetHeader(SESSION_MODE_HEADER) == "cookie"
+}
+
+// respondWithSession issues a token for the user, either as a bearer token in the
+// body or, in cookie mode, as an HttpOnly cookie plus a csrf token for double submit
+func (s *Server) respondWithSession(c *gin.Context, status int, userID string, username string, lifetime time.Duration, admin bool) {
+	sessionID, err := s.createSession(userID, c.Request.UserAgent(), c.ClientIP(), lifetime, admin)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	token, err := s.GenerateJWT(userID, sessionID, lifetime)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+		return
+	}
+
+	if !wantsCookies(c) {
+		c.JSON(status, RegisterRes{
+			UserID:       userID,
+			Username:     username,
+			SessionToken: token,
+		})
+		return
+	}
+
+	csrf, err := newCSRFToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+		return
+	}
+
+	s.setCookie(c, SESSION_COOKIE, token, lifetime, true)
+	// readable by js so the client can echo it back in the header
+	s.setCookie(c, CSRF_COOKIE, csrf, lifetime, false)
+
+	c.JSON(status, RegisterRes{
+		UserID:    userID,
+		Username:  username,
+		CSRFToken: csrf,
+	})
+}
+
+func (s *Server) setCookie(c *gin.Context, name string, value string, lifetime time.Duration, httpOnly bool) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(lifetime.Seconds()),
+		HttpOnly: httpOnly,
+		Secure:   s.CookieSecure,
+		SameSite: s.CookieSameSite,
+	})
+}
+
+// Logout clears the session cookies and ends the session of the token it is
+// sent with. It runs without AuthMiddleware, a browser stuck with an expired
+// or revoked cookie has to be able to get rid of it, so a token that doesnt
+// check out only gets the cookies cleared. A cookie session is only ended
+// with the csrf token, another site can clear the cookies at most.
+func (s *Server) Logout(c *gin.Context) {
+	tokenStr, via := requestToken(c)
+	if tokenStr != "" && (via != SESSION_COOKIE || csrfMatches(c)) {
+		if userID, sessionID, err := s.parseToken(tokenStr); err == nil {
+			if err := s.revokeSession(userID, sessionID); err != nil && err.Error() != SESSION_NOT_FOUND {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+				return
+			}
+		}
+	}
+
+	s.setCookie(c, SESSION_COOKIE, "", -time.Second, true)
+	s.setCookie(c, CSRF_COOKIE, "", -time.Second, false)
+	c.Status(http.StatusNoContent)
+}
+
+// csrfMatches is the double submit check, the csrf cookie has to come back
+// in the header
+func csrfMatches(c *gin.Context) bool {
+	cookie, err := c.Cookie(CSRF_COOKIE)
+	header := c.GetHeader(CSRF_HEADER)
+	return err == nil && cookie != "" && subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) == 1
+}
+
+// CSRFMiddleware must run after AuthMiddleware. Only cookie sessions are checked,
+// a bearer header cant be attached by another site so those requests are safe.
+func CSRFMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		if c.GetString("authVia") != SESSION_COOKIE {
+			c.Next()
+			return
+		}
+
+		if !csrfMatches(c) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "csrf token mismatch"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
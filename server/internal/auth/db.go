@@ -3,60 +3,467 @@ package auth
 import (
 	"context"
 	"errors"
+	"log"
 	"server/internal/models"
+	"server/internal/server"
+	"server/internal/store"
 	"time"
 
+	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 const (
 	USER_EXISTS    = "user already exists"
 	USER_NOT_FOUND = "user not found"
+	NOT_A_GUEST    = "user is not a guest"
+
+	SESSION_NOT_FOUND = "session not found"
+
+	GUEST_PREFIX = "guest-"
+
+	ROLE_ADMIN = "admin"
 )
 
-func (s *Server) PutUserIntoDb(username string) error {
-	user := bson.M{
-		"_id": username, // PK
+// PutUserIntoDb creates the user and returns its generated id
+func (s *Server) PutUserIntoDb(username string) (string, error) {
+	user := models.User{
+		Id:        uuid.NewString(), // PK, never changes
+		Username:  username,         // unique index, can be renamed
+		CreatedAt: time.Now().UTC(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+	if err := s.Users.Insert(ctx, user); err != nil {
+		// username taken
+		if err == store.ErrDuplicate {
+			return "", errors.New(USER_EXISTS)
+		}
+		return "", err
+	}
+
+	return user.Id, nil
+
+}
+
+// PutGuestIntoDb creates a guest with a generated name, returns id and name
+func (s *Server) PutGuestIntoDb() (string, string, error) {
+	id := uuid.NewString()
+	user := models.User{
+		Id:        id,
+		Username:  GUEST_PREFIX + id[:8],
+		Guest:     true,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+	if err := s.Users.Insert(ctx, user); err != nil {
+		return "", "", err
+	}
+
+	return user.Id, user.Username, nil
+}
+
+func (s *Server) PutIntoUserStateDB(state models.UserState) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+	return s.States.Insert(ctx, state)
+
+}
+
+func (s *Server) FindInUsersTable(username string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
 
-		"createdAt": time.Now().UTC(),
+	user, err := s.Users.ByUsername(ctx, username)
+	if err == store.ErrNotFound {
+		return nil, errors.New(USER_NOT_FOUND)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+}
+
+func (s *Server) GetUser(userID string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
 	defer cancel()
-	_, err := s.CollUsers.InsertOne(ctx, user)
+
+	user, err := s.Users.Get(ctx, userID)
+	if err == store.ErrNotFound {
+		return nil, errors.New(USER_NOT_FOUND)
+	}
 	if err != nil {
-		// Duplicate key error (username )
-		if mongo.IsDuplicateKeyError(err) {
-			return errors.New(USER_EXISTS)
-		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// renameUser changes the display handle, the id and all history stay as they are
+func (s *Server) renameUser(userID string, username string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	switch err := s.Users.Rename(ctx, userID, username); err {
+	case nil:
+	case store.ErrDuplicate:
+		return errors.New(USER_EXISTS)
+	case store.ErrNotFound:
+		return errors.New(USER_NOT_FOUND)
+	default:
 		return err
 	}
 
+	// state carries a copy of the handle for leaderboards, the version bump
+	// makes any stale cached copy fail its next write instead of undoing the rename
+	if err := s.States.Rename(ctx, userID, username); err != nil {
+		return err
+	}
+
+	if err := s.DeleteCachedState(ctx, "user_state:"+userID); err != nil {
+		log.Println("cache error:", err)
+	}
+
 	return nil
+}
+
+// upgradeGuest gives a guest a real username, only matches while the user is still a guest
+func (s *Server) upgradeGuest(userID string, username string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	switch err := s.Users.UpgradeGuest(ctx, userID, username); err {
+	case nil:
+	case store.ErrDuplicate:
+		return errors.New(USER_EXISTS)
+	case store.ErrNotFound:
+		return errors.New(NOT_A_GUEST)
+	default:
+		return err
+	}
+
+	// the version bump makes any stale cached copy fail its next write
+	state, err := s.States.Upgrade(ctx, userID, username)
+	if err != nil {
+		return err
+	}
+
+	if err := s.DeleteCachedState(ctx, "user_state:"+userID); err != nil {
+		log.Println("cache error:", err)
+	}
+
+	// no longer a guest, so their score goes public
+	if err := s.UpdateLeaderboards(ctx, *state); err != nil {
+		log.Println("leaderboard error:", err)
+	}
 
+	return nil
 }
 
-func (s *Server) PutIntoUserStateDB(state models.UserState) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// exportUserData collects every document we hold about the user
+func (s *Server) exportUserData(userID string) (*AccountExport, error) {
+	user, err := s.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.BatchTimeout)
+	defer cancel()
+
+	export := &AccountExport{
+		ExportedAt: time.Now().UTC(),
+		User:       *user,
+		Answers:    []models.AnswerLog{},
+		Audit:      []models.AuditLog{},
+		Sessions:   []models.Session{},
+		Standings:  []models.SeasonStanding{},
+		Friends:    []models.Friendship{},
+		Invites:    []models.TeamInvite{},
+		Exposures:  []models.Exposure{},
+	}
+
+	state, err := s.States.Get(ctx, userID)
+	if err != nil && err != store.ErrNotFound {
+		return nil, err
+	}
+	export.State = state
+
+	if export.Answers, err = s.Answers.ForUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	// review decisions are left out, shadow exclusion only works while the user
+	// cant see it
+	cursor, err := s.CollAudit.Find(ctx,
+		bson.M{"subject": userID, "action": bson.M{"$nin": models.ReviewActions}},
+		options.Find().SetSort(bson.M{"at": 1}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &export.Audit); err != nil {
+		return nil, err
+	}
+
+	cursor, err = s.CollSessions.Find(ctx, bson.M{"userId": userID},
+		options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &export.Sessions); err != nil {
+		return nil, err
+	}
+
+	cursor, err = s.CollStandings.Find(ctx, bson.M{"userId": userID})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &export.Standings); err != nil {
+		return nil, err
+	}
+
+	cursor, err = s.CollFriends.Find(ctx, bson.M{"users": userID},
+		options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &export.Friends); err != nil {
+		return nil, err
+	}
+
+	if export.Team, err = s.TeamOf(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	cursor, err = s.CollInvites.Find(ctx, bson.M{"userId": userID},
+		options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &export.Invites); err != nil {
+		return nil, err
+	}
+
+	cursor, err = s.CollExposures.Find(ctx, bson.M{"userId": userID},
+		options.Find().SetSort(bson.M{"exposedAt": 1}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &export.Exposures); err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// deleteUserData erases the user and their state and anonymises their answer logs.
+// The user document goes last so a failed run can simply be retried.
+func (s *Server) deleteUserData(userID string) (int64, error) {
+	if _, err := s.GetUser(userID); err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.BatchTimeout)
 	defer cancel()
-	_, err := s.CollUserState.InsertOne(ctx, state)
+
+	// answer logs stay for question statistics but can no longer be tied to the user
+	anon := "deleted:" + uuid.NewString()
+	anonymised, err := s.Answers.Anonymize(ctx, userID, anon)
+	if err != nil {
+		return 0, err
+	}
+
+	// exposures stay counted for the experiments, under the same id as the answers
+	if err := s.anonymiseExposures(ctx, userID, anon); err != nil {
+		return 0, err
+	}
+
+	// the audit rows stay but the names in them go
+	if _, err := s.CollAudit.UpdateMany(ctx,
+		bson.M{"subject": userID, "action": bson.M{"$in": []string{"account.rename", "account.upgrade"}}},
+		bson.M{"$unset": bson.M{"details.from": "", "details.to": "", "details.username": ""}}); err != nil {
+		return 0, err
+	}
+
+	if err := s.States.Delete(ctx, userID); err != nil {
+		return 0, err
+	}
+
+	if err := s.DeleteCachedState(ctx, "user_state:"+userID); err != nil {
+		log.Println("cache error:", err)
+	}
+
+	if err := s.RemoveFromLeaderboards(ctx, userID); err != nil {
+		log.Println("leaderboard error:", err)
+	}
+
+	if _, err := s.CollSessions.DeleteMany(ctx, bson.M{"userId": userID}); err != nil {
+		return 0, err
+	}
+
+	// archived standings keep the other players ranks as they were, the row just goes away
+	if _, err := s.CollStandings.DeleteMany(ctx, bson.M{"userId": userID}); err != nil {
+		return 0, err
+	}
+
+	if _, err := s.CollReviews.DeleteOne(ctx, bson.M{"_id": userID}); err != nil {
+		return 0, err
+	}
+
+	// their team carries on without them (or goes away if they were the last one)
+	if err := s.LeaveTeam(ctx, userID); err != nil && err.Error() != server.NOT_IN_TEAM {
+		return 0, err
+	}
+
+	if err := s.DropFriendships(ctx, userID); err != nil {
+		return 0, err
+	}
+
+	if _, err := s.CollInvites.DeleteMany(ctx, bson.M{"userId": userID}); err != nil {
+		return 0, err
+	}
+
+	if err := s.Users.Delete(ctx, userID); err != nil {
+		return 0, err
+	}
+
+	return anonymised, nil
+}
+
+// anonymiseExposures moves the users experiment exposures over to anon. The
+// id holds the user id so every row is written again under a new one, a retry
+// after a failed delete can count the user twice in an experiment.
+func (s *Server) anonymiseExposures(ctx context.Context, userID string, anon string) error {
+	cursor, err := s.CollExposures.Find(ctx, bson.M{"userId": userID})
+	if err != nil {
+		return err
+	}
+	var exposures []models.Exposure
+	if err := cursor.All(ctx, &exposures); err != nil {
+		return err
+	}
+
+	for _, e := range exposures {
+		if err := s.LogExposure(ctx, e.ExperimentID, e.Variant, anon, e.ExposedAt); err != nil {
+			return err
+		}
+		if _, err := s.CollExposures.DeleteOne(ctx, bson.M{"_id": e.Id}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteAudit stores an audit entry, id and time are filled in here
+func (s *Server) WriteAudit(entry models.AuditLog) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	entry.Id = uuid.NewString()
+	entry.At = time.Now().UTC()
+	_, err := s.CollAudit.InsertOne(ctx, entry)
 	return err
+}
+
+func (s *Server) createSession(userID string, userAgent string, ip string, lifetime time.Duration, admin bool) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
 
+	now := time.Now().UTC()
+	session := models.Session{
+		Id:         uuid.NewString(),
+		UserID:     userID,
+		UserAgent:  userAgent,
+		IP:         ip,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(lifetime),
+		Admin:      admin,
+	}
+	if _, err := s.CollSessions.InsertOne(ctx, session); err != nil {
+		return "", err
+	}
+
+	return session.Id, nil
 }
 
-func (s *Server) FindInUsersTable(username string) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (s *Server) getSession(sessionID string) (*models.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
 	defer cancel()
-	err := s.CollUsers.FindOne(ctx, bson.M{"_id": username}).Err()
 
+	var session models.Session
+	err := s.CollSessions.FindOne(ctx, bson.M{"_id": sessionID}).Decode(&session)
 	if err == mongo.ErrNoDocuments {
-		return false, errors.New(USER_NOT_FOUND)
+		return nil, errors.New(SESSION_NOT_FOUND)
 	}
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	return true, nil
+	return &session, nil
+}
 
+func (s *Server) touchSession(sessionID string, userAgent string, ip string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	_, err := s.CollSessions.UpdateOne(ctx,
+		bson.M{"_id": sessionID},
+		bson.M{"$set": bson.M{
+			"lastUsedAt": time.Now().UTC(),
+			"userAgent":  userAgent,
+			"ip":         ip,
+		}},
+	)
+	return err
+}
+
+// listSessions returns the users live sessions, most recently used first
+func (s *Server) listSessions(userID string) ([]models.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	cursor, err := s.CollSessions.Find(ctx,
+		bson.M{
+			"userId":    userID,
+			"revokedAt": bson.M{"$exists": false},
+			"expiresAt": bson.M{"$gt": time.Now().UTC()},
+		},
+		options.Find().SetSort(bson.M{"lastUsedAt": -1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []models.Session{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// revokeSession only matches sessions owned by userID
+func (s *Server) revokeSession(userID string, sessionID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	res, err := s.CollSessions.UpdateOne(ctx,
+		bson.M{"_id": sessionID, "userId": userID, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now().UTC()}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errors.New(SESSION_NOT_FOUND)
+	}
+
+	return nil
 }
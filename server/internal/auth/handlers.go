@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"server/internal/models"
 	"strings"
@@ -13,8 +15,10 @@ type RegisterReq struct {
 }
 
 type RegisterRes struct {
+	UserID       string `json:"userId"`
 	Username     string `json:"username"`
-	SessionToken string `json:"sessionToken"`
+	SessionToken string `json:"sessionToken,omitempty"` // empty in cookie mode
+	CSRFToken    string `json:"csrfToken,omitempty"`    // only in cookie mode
 }
 
 func (s *Server) RegisterUser(c *gin.Context) {
@@ -25,10 +29,14 @@ func (s *Server) RegisterUser(c *gin.Context) {
 	}
 
 	req.Username = strings.TrimSpace(req.Username)
+	if isGuestName(req.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username cant start with " + GUEST_PREFIX})
+		return
+	}
 
 	// put into db
 
-	err := s.PutUserIntoDb(req.Username)
+	userID, err := s.PutUserIntoDb(req.Username)
 	if err != nil {
 		if err.Error() == USER_EXISTS {
 			c.JSON(http.StatusConflict, gin.H{"error": "username already taken"})
@@ -42,42 +50,25 @@ func (s *Server) RegisterUser(c *gin.Context) {
 
 	// start state
 
-	state := models.UserState{
-		Username:          req.Username,
-		CurrentDifficulty: 3, // 1-10 scale
-		Streak:            0,
-		MaxStreak:         0,
-		TotalScore:        0,
-		StateVersion:      1,
-		CorrectWindow:     []bool{},
-		MomentumScore:     0.5, // neutral starting momentum
-	}
-
-	err = s.PutIntoUserStateDB(state)
+	err = s.PutIntoUserStateDB(newUserState(userID, req.Username, false))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 		return
 
 	}
 
-	// generate jwt token
-	token, err := s.GenerateJWT(req.Username)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
-		return
-	}
-
-	// this needs to set cookies right?
+	// generate jwt token, as a cookie if the client asked for one
+	s.respondWithSession(c, http.StatusCreated, userID, req.Username, s.Config.TokenLifetime, false)
 
-	c.JSON(http.StatusCreated, RegisterRes{
-		Username:     req.Username,
-		SessionToken: token,
-	})
+}
 
+type SessionReq struct {
+	Username string `json:"username" binding:"required,min=1,max=10"`
+	AdminKey string `json:"adminKey"` // admins only, the admin-key setting
 }
 
 func (s *Server) Session(c *gin.Context) {
-	var req RegisterReq
+	var req SessionReq
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "username required"})
 		return
@@ -93,7 +84,12 @@ func (s *Server) Session(c *gin.Context) {
 	//     c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 	//     return
 	// }
-	if ok, err := s.FindInUsersTable(req.Username); !ok {
+	user, err := s.FindInUsersTable(req.Username)
+	if err == nil && user.Guest {
+		// guests only have their token, their generated name is not a login
+		err = errors.New(USER_NOT_FOUND)
+	}
+	if err != nil {
 		if err.Error() == USER_NOT_FOUND {
 			c.JSON(http.StatusNotFound, gin.H{"error": "user doenst exist"})
 			return
@@ -105,15 +101,93 @@ func (s *Server) Session(c *gin.Context) {
 
 	}
 
-	token, err := s.GenerateJWT(req.Username)
+	// a username is no secret, an admin also needs the key
+	admin := user.Role == ROLE_ADMIN
+	if admin && !s.adminKeyMatches(req.AdminKey) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "admin key required"})
+		return
+	}
+
+	s.respondWithSession(c, http.StatusOK, user.Id, user.Username, s.Config.TokenLifetime, admin)
+}
+
+// Guest starts an anonymous account that can play right away and be upgraded later
+func (s *Server) Guest(c *gin.Context) {
+	userID, username, err := s.PutGuestIntoDb()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	err = s.PutIntoUserStateDB(newUserState(userID, username, true))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "cant generate token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 		return
 	}
 
-	c.JSON(http.StatusOK, RegisterRes{
+	s.respondWithSession(c, http.StatusCreated, userID, username, s.Config.GuestTokenLifetime, false)
+}
+
+// UpgradeGuest turns the calling guest into a registered user, keeping the same
+// id so state and answer logs carry over untouched
+func (s *Server) UpgradeGuest(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	var req RegisterReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username bw 1-10 chars"})
+		return
+	}
+
+	req.Username = strings.TrimSpace(req.Username)
+	if isGuestName(req.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username cant start with " + GUEST_PREFIX})
+		return
+	}
+
+	if err := s.upgradeGuest(userID, req.Username); err != nil {
+		switch err.Error() {
+		case USER_EXISTS:
+			c.JSON(http.StatusConflict, gin.H{"error": "username already taken"})
+		case NOT_A_GUEST:
+			c.JSON(http.StatusConflict, gin.H{"error": "account is already registered"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		}
+		return
+	}
+
+	if err := s.WriteAudit(models.AuditLog{
+		Action:  "account.upgrade",
+		Subject: userID,
+		Actor:   userID,
+		Details: map[string]any{"username": req.Username},
+	}); err != nil {
+		log.Println("audit error:", err)
+	}
+
+	// the guest token is short lived, end it and hand out a full one
+	if err := s.revokeSession(userID, c.GetString("sessionId")); err != nil {
+		log.Println("session revoke error:", err)
+	}
+	s.respondWithSession(c, http.StatusOK, userID, req.Username, s.Config.TokenLifetime, false)
+}
+
+func newUserState(userID string, username string, guest bool) models.UserState {
+	return models.UserState{
+		UserID:            userID,
+		Username:          username,
+		CurrentDifficulty: 3, // 1-10 scale
+		Streak:            0,
+		MaxStreak:         0,
+		TotalScore:        0,
+		StateVersion:      1,
+		Guest:             guest,
+		CorrectWindow:     []bool{},
+		MomentumScore:     0.5, // neutral starting momentum
+	}
+}
 
-		Username:     req.Username,
-		SessionToken: token,
-	})
+func isGuestName(username string) bool {
+	return strings.HasPrefix(strings.ToLower(username), GUEST_PREFIX)
 }
@@ -1,57 +1,131 @@
 package auth
 
 import (
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+const sessionTouchInterval = time.Minute
+
+// requestToken is the token a request came with and how it came, the bearer
+// header wins and the session cookie is the fallback for browsers. Both are
+// empty without one.
+func requestToken(c *gin.Context) (string, string) {
+	if authHeader := c.GetHeader("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+		return strings.TrimPrefix(authHeader, "Bearer "), "bearer"
+	}
+	if cookie, err := c.Cookie(SESSION_COOKIE); err == nil && cookie != "" {
+		return cookie, SESSION_COOKIE
+	}
+	return "", ""
+}
+
+// parseToken checks a token was signed by us and hasnt expired, and returns
+// the user and the session it was issued for
+func (s *Server) parseToken(tokenStr string) (string, string, error) {
+	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method")
+		}
+		return s.JwtSecret, nil
+	})
+	if err != nil || !token.Valid {
+		return "", "", errors.New("invalid token")
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	userID, ok := claims["sub"].(string)
+	// every token has to belong to a session so devices can be signed out
+	sessionID, ok2 := claims["sid"].(string)
+	if !ok || !ok2 {
+		return "", "", errors.New("invalid token")
+	}
+	return userID, sessionID, nil
+}
+
 func (s *Server) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if !strings.HasPrefix(authHeader, "Bearer ") {
+		tokenStr, via := requestToken(c)
+		if tokenStr == "" {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing token"})
 			return
 		}
+		c.Set("authVia", via)
 
-		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-		token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method")
-			}
-			return s.JwtSecret, nil
-		})
-
-		if err != nil || !token.Valid {
+		userID, sessionID, err := s.parseToken(tokenStr)
+		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			return
 		}
 
-		claims := token.Claims.(jwt.MapClaims)
-		username, ok := claims["sub"].(string)
-		if !ok {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		// the session has to still be live
+		session, err := s.getSession(sessionID)
+		if err != nil {
+			if err.Error() == SESSION_NOT_FOUND {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "session ended"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "cant check session"})
 			return
 		}
+		if session.UserID != userID || session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "session ended"})
+			return
+		}
+
+		// only write lastUsedAt once in a while, not on every request
+		if time.Since(session.LastUsedAt) > sessionTouchInterval {
+			if err := s.touchSession(sessionID, c.Request.UserAgent(), c.ClientIP()); err != nil {
+				log.Println("session touch error:", err)
+			}
+		}
 
-		c.Set("username", username)
-		// c.Set("userId", claims["sub"].(string))
+		c.Set("userId", userID)
+		c.Set("sessionId", sessionID)
+		c.Set("adminSession", session.Admin)
 
 		c.Next()
 	}
 }
 
-var allowedOrigin = map[string]bool{
-	os.Getenv("CLIENT_IP"):  true,
-	"http://localhost:3000": true,
-	"http://localhost:5173": true,
+// adminKeyMatches checks the key an admin signed in with, nothing matches
+// while no key is set
+func (s *Server) adminKeyMatches(key string) bool {
+	return s.Config.AdminKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(s.Config.AdminKey)) == 1
 }
 
-func CORSMiddleware() gin.HandlerFunc {
+// AdminMiddleware must run after AuthMiddleware. the role alone isnt enough,
+// the session has to have been started with the admin key
+func (s *Server) AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := s.GetUser(c.GetString("userId"))
+		if err != nil || user.Role != ROLE_ADMIN || !c.GetBool("adminSession") {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin only"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// CORSMiddleware allows the frontend at client-ip and the local dev servers
+func (s *Server) CORSMiddleware() gin.HandlerFunc {
+	allowedOrigin := map[string]bool{
+		"http://localhost:3000": true,
+		"http://localhost:5173": true,
+	}
+	if s.Config.ClientIP != "" {
+		allowedOrigin[s.Config.ClientIP] = true
+	}
+
 	return func(c *gin.Context) {
 		origin := c.Request.Header.Get("Origin")
 
@@ -59,8 +133,8 @@ func CORSMiddleware() gin.HandlerFunc {
 			c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
 		}
 
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, PUT, PATCH, DELETE, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+CSRF_HEADER+", "+SESSION_MODE_HEADER)
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 
 		if c.Request.Method == "OPTIONS" {
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"net/http"
+	"server/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SessionInfo struct {
+	models.Session
+	Current bool `json:"current"`
+}
+
+type SessionsRes struct {
+	Sessions []SessionInfo `json:"sessions"`
+}
+
+// ListSessions shows every device the caller is signed in on
+func (s *Server) ListSessions(c *gin.Context) {
+	userID := c.GetString("userId")
+	current := c.GetString("sessionId")
+
+	sessions, err := s.listSessions(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	res := SessionsRes{Sessions: make([]SessionInfo, 0, len(sessions))}
+	for _, session := range sessions {
+		res.Sessions = append(res.Sessions, SessionInfo{
+			Session: session,
+			Current: session.Id == current,
+		})
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// RevokeSession signs one of the callers devices out
+func (s *Server) RevokeSession(c *gin.Context) {
+	if err := s.revokeSession(c.GetString("userId"), c.Param("sessionId")); err != nil {
+		if err.Error() == SESSION_NOT_FOUND {
+			c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,448 @@
+// Package config is every setting the server reads, with its default. Values
+// come from the defaults, then a json file, then the env, then flags, each
+// one overriding the last. Every setting has a flag (-db-timeout), the same
+// env name in capitals (DB_TIMEOUT) and the same key in the file
+// ({"db-timeout": "10s"}).
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	STORAGE_MONGO  = "mongo"
+	STORAGE_MEMORY = "memory" // memstore, no mongo or redis, nothing survives a restart
+)
+
+type Config struct {
+	Port      string
+	Env       string // "dev" lets cookies go over plain http
+	JWTSecret string
+	// AdminKey has to be sent with an admins username to sign in, while it is
+	// empty no admin can sign in at all
+	AdminKey string
+	// ClientIP is the origin of the frontend, allowed by CORS next to the
+	// local dev servers
+	ClientIP string
+	// TrustedProxies are the proxies whose X-Forwarded-For is believed for
+	// the client ip, none by default so nobody can pick their own ip and get
+	// around the rate limits
+	TrustedProxies Proxies
+
+	Storage       string
+	MongoURI      string
+	MongoDatabase string
+	RedisAddrs    RingAddrs
+
+	CookieSameSite string
+	// daily, weekly and monthly leaderboards roll over at midnight here
+	LeaderboardTZ string
+
+	StartupTimeout time.Duration // connecting to mongo and creating indexes
+	DBTimeout      time.Duration // one query from a handler
+	// BatchTimeout is for work going through many documents at once: ending
+	// a season, exporting or deleting an account, excluding or clearing a
+	// user and analysing an experiment
+	BatchTimeout time.Duration
+
+	TokenLifetime      time.Duration
+	GuestTokenLifetime time.Duration // guests have to upgrade to keep playing
+	StateCacheTTL      time.Duration
+
+	// Algorithm is only the first stored version of the algorithm params,
+	// after that admins change them through the api and every instance
+	// reloads them each ParamsPoll
+	Algorithm  Algorithm
+	ParamsPoll time.Duration
+
+	// RateLimits are the limits of the rate limit rules on the routes, by
+	// rule name
+	RateLimits RateLimits
+}
+
+// Algorithm is the adaptive difficulty and scoring constants, see the
+// algorithm section of the readme
+type Algorithm struct {
+	CorrectStreakToUp   int     `bson:"correctStreakToUp"   json:"correctStreakToUp"`   // correct answers in a row to go up (hysteresis)
+	WrongStreakToDown   int     `bson:"wrongStreakToDown"   json:"wrongStreakToDown"`   // wrong answers in a row to go down
+	RollingWindowSize   int     `bson:"rollingWindowSize"   json:"rollingWindowSize"`   // answers momentum is worked out over
+	MomentumThreshold   float64 `bson:"momentumThreshold"   json:"momentumThreshold"`   // share of the window that has to be correct to go up
+	MaxStreakMultiplier float64 `bson:"maxStreakMultiplier" json:"maxStreakMultiplier"` // cap on 1 + 0.1 * streak
+}
+
+func Default() Config {
+	return Config{
+		Port:          ":8081",
+		Storage:       STORAGE_MONGO,
+		MongoDatabase: "scaler",
+		RedisAddrs: RingAddrs{
+			"server1": ":6379",
+			"server2": ":6380",
+		},
+		CookieSameSite:     "lax",
+		LeaderboardTZ:      "UTC",
+		StartupTimeout:     10 * time.Second,
+		DBTimeout:          10 * time.Second,
+		BatchTimeout:       5 * time.Minute,
+		TokenLifetime:      30 * 24 * time.Hour,
+		GuestTokenLifetime: 24 * time.Hour,
+		StateCacheTTL:      time.Hour,
+		Algorithm: Algorithm{
+			CorrectStreakToUp:   2,
+			WrongStreakToDown:   1,
+			RollingWindowSize:   5,
+			MomentumThreshold:   0.6,
+			MaxStreakMultiplier: 5,
+		},
+		ParamsPoll: 15 * time.Second,
+		RateLimits: RateLimits{
+			"register":       {10, time.Hour},
+			"session":        {30, time.Minute},
+			"session-user":   {10, time.Minute},
+			"guest":          {10, time.Hour},
+			"answer":         {60, time.Minute},
+			"live":           {30, time.Minute},
+			"friend-request": {30, time.Hour},
+			"team-create":    {5, time.Hour},
+			"team-invite":    {50, time.Hour},
+		},
+	}
+}
+
+// flags puts every setting of c on a flag set, the defaults shown in -help are
+// whatever c holds
+func (c *Config) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&c.Port, "port", c.Port, "address to listen on")
+	fs.StringVar(&c.Env, "env", c.Env, `"dev" for local development, cookies are then sent without https`)
+	fs.StringVar(&c.JWTSecret, "jwt-secret", c.JWTSecret, "key session tokens are signed with (required)")
+	fs.StringVar(&c.AdminKey, "admin-key", c.AdminKey, "key admin accounts sign in with next to their username, admins cant sign in without it")
+	fs.StringVar(&c.ClientIP, "client-ip", c.ClientIP, "origin of the frontend allowed by CORS")
+	fs.Var(&c.TrustedProxies, "trusted-proxies", "ips or cidrs of the proxies in front of the api as a,b, their X-Forwarded-For gives the client ip")
+	fs.StringVar(&c.Storage, "storage", c.Storage, `"mongo" or "memory"`)
+	fs.StringVar(&c.MongoURI, "mongodb-uri", c.MongoURI, "mongo connection string (required with mongo storage)")
+	fs.StringVar(&c.MongoDatabase, "mongodb-database", c.MongoDatabase, "mongo database")
+	fs.Var(&c.RedisAddrs, "redis-addrs", "redis ring shards as name=addr,name=addr")
+	fs.StringVar(&c.CookieSameSite, "cookie-samesite", c.CookieSameSite, `"lax", "strict" or "none" (none needs https)`)
+	fs.StringVar(&c.LeaderboardTZ, "leaderboard-tz", c.LeaderboardTZ, "time zone periodic leaderboards roll over in")
+	fs.DurationVar(&c.StartupTimeout, "startup-timeout", c.StartupTimeout, "time to connect and create indexes")
+	fs.DurationVar(&c.DBTimeout, "db-timeout", c.DBTimeout, "time a single database call from a request gets")
+	fs.DurationVar(&c.BatchTimeout, "batch-timeout", c.BatchTimeout, "time a season end, account export or delete, review decision or experiment analysis gets")
+	fs.DurationVar(&c.TokenLifetime, "token-lifetime", c.TokenLifetime, "how long a session lasts")
+	fs.DurationVar(&c.GuestTokenLifetime, "guest-token-lifetime", c.GuestTokenLifetime, "how long a guest session lasts")
+	fs.DurationVar(&c.StateCacheTTL, "state-cache-ttl", c.StateCacheTTL, "how long user state stays cached")
+	a := &c.Algorithm
+	fs.IntVar(&a.CorrectStreakToUp, "correct-streak-to-up", a.CorrectStreakToUp, "correct answers in a row to raise difficulty")
+	fs.IntVar(&a.WrongStreakToDown, "wrong-streak-to-down", a.WrongStreakToDown, "wrong answers in a row to lower difficulty")
+	fs.IntVar(&a.RollingWindowSize, "rolling-window-size", a.RollingWindowSize, "answers momentum is worked out over")
+	fs.Float64Var(&a.MomentumThreshold, "momentum-threshold", a.MomentumThreshold, "share of the window that has to be correct to raise difficulty")
+	fs.Float64Var(&a.MaxStreakMultiplier, "max-streak-multiplier", a.MaxStreakMultiplier, "cap on the streak score multiplier")
+	fs.DurationVar(&c.ParamsPoll, "params-poll", c.ParamsPoll, "how often stored algorithm params are reloaded")
+	fs.Var(&c.RateLimits, "rate-limits", "rate limit rules to change as name=limit/window,name=limit/window")
+	return fs
+}
+
+// EnvName is the env variable for a flag, jwt-secret is JWT_SECRET
+func EnvName(flagName string) string {
+	return strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Load reads the settings for a command from the defaults, the json file
+// named by -config or CONFIG_FILE, the env and args, and validates them.
+// With -help the usage is printed and flag.ErrHelp returned. extra adds flags
+// of the command itself, those only come from args.
+func Load(name string, args []string, extra ...func(fs *flag.FlagSet)) (Config, error) {
+	c := Default()
+	fs := c.flags(name)
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "json file of settings, keys are the flag names")
+
+	// flags win, so they are skipped when reading the file and the env, and
+	// so are the commands own flags
+	skip := map[string]bool{"config": true}
+	settings := map[string]bool{}
+	fs.VisitAll(func(f *flag.Flag) { settings[f.Name] = true })
+	for _, add := range extra {
+		add(fs)
+	}
+	fs.VisitAll(func(f *flag.Flag) { skip[f.Name] = skip[f.Name] || !settings[f.Name] })
+
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+	fs.Visit(func(f *flag.Flag) { skip[f.Name] = true })
+
+	if *file != "" {
+		if err := loadFile(fs, *file, settings, skip); err != nil {
+			return c, err
+		}
+	}
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if skip[f.Name] {
+			return
+		}
+		// empty counts as unset, compose passes through variables nobody set
+		if v := os.Getenv(EnvName(f.Name)); v != "" {
+			if err := fs.Set(f.Name, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", EnvName(f.Name), err))
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return c, errors.Join(errs...)
+	}
+	c.Storage = strings.ToLower(c.Storage)
+	return c, c.Validate()
+}
+
+func loadFile(fs *flag.FlagSet, path string, settings map[string]bool, skip map[string]bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var values map[string]any
+	dec := json.NewDecoder(f)
+	dec.UseNumber() // keeps 0.6 as written
+	if err := dec.Decode(&values); err != nil && err != io.EOF {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	var errs []error
+	for key, v := range values {
+		if !settings[key] || key == "config" {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", path, key))
+			continue
+		}
+		if skip[key] {
+			continue
+		}
+		if err := fs.Set(key, fmt.Sprint(v)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", path, key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate reports every bad setting at once, so a deploy can be fixed in
+// one go
+func (c Config) Validate() error {
+	var errs []error
+	bad := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Port == "" {
+		bad("port is empty")
+	}
+	if c.JWTSecret == "" {
+		bad("jwt-secret is required")
+	}
+	switch c.Storage {
+	case STORAGE_MONGO:
+		if c.MongoURI == "" {
+			bad("mongodb-uri is required with mongo storage")
+		}
+		if c.MongoDatabase == "" {
+			bad("mongodb-database is empty")
+		}
+		if len(c.RedisAddrs) == 0 {
+			bad("redis-addrs is empty")
+		}
+	case STORAGE_MEMORY:
+	default:
+		bad("storage %q isnt mongo or memory", c.Storage)
+	}
+	if !slices.Contains([]string{"lax", "strict", "none"}, strings.ToLower(c.CookieSameSite)) {
+		bad("cookie-samesite %q isnt lax, strict or none", c.CookieSameSite)
+	}
+	if _, err := c.Location(); err != nil {
+		bad("leaderboard-tz: %v", err)
+	}
+
+	durations := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"startup-timeout", c.StartupTimeout},
+		{"db-timeout", c.DBTimeout},
+		{"batch-timeout", c.BatchTimeout},
+		{"token-lifetime", c.TokenLifetime},
+		{"guest-token-lifetime", c.GuestTokenLifetime},
+		{"state-cache-ttl", c.StateCacheTTL},
+		{"params-poll", c.ParamsPoll},
+	}
+	for _, d := range durations {
+		if d.d <= 0 {
+			bad("%s has to be above 0, got %v", d.name, d.d)
+		}
+	}
+
+	rules := Default().RateLimits
+	for name, rl := range c.RateLimits {
+		if _, ok := rules[name]; !ok {
+			bad("rate-limits: no rule is called %q", name)
+		}
+		if rl.Limit < 1 || rl.Window <= 0 {
+			bad("rate-limits: %s needs a limit of at least 1 in a window above 0, got %d/%v", name, rl.Limit, rl.Window)
+		}
+	}
+	for name := range rules {
+		if _, ok := c.RateLimits[name]; !ok {
+			bad("rate-limits: %s is missing", name)
+		}
+	}
+
+	if err := c.Algorithm.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (a Algorithm) Validate() error {
+	var errs []error
+	if a.CorrectStreakToUp < 1 {
+		errs = append(errs, fmt.Errorf("correct-streak-to-up has to be at least 1, got %d", a.CorrectStreakToUp))
+	}
+	if a.WrongStreakToDown < 1 {
+		errs = append(errs, fmt.Errorf("wrong-streak-to-down has to be at least 1, got %d", a.WrongStreakToDown))
+	}
+	if a.RollingWindowSize < 1 {
+		errs = append(errs, fmt.Errorf("rolling-window-size has to be at least 1, got %d", a.RollingWindowSize))
+	}
+	if a.MomentumThreshold < 0 || a.MomentumThreshold > 1 {
+		errs = append(errs, fmt.Errorf("momentum-threshold has to be between 0 and 1, got %v", a.MomentumThreshold))
+	}
+	if a.MaxStreakMultiplier < 1 {
+		errs = append(errs, fmt.Errorf("max-streak-multiplier has to be at least 1, got %v", a.MaxStreakMultiplier))
+	}
+	return errors.Join(errs...)
+}
+
+// Location is the leaderboard time zone
+func (c Config) Location() (*time.Location, error) {
+	return time.LoadLocation(c.LeaderboardTZ)
+}
+
+// RingAddrs are redis ring shards by name, written name=addr,name=addr
+type RingAddrs map[string]string
+
+func (r *RingAddrs) String() string {
+	if r == nil {
+		return ""
+	}
+	names := make([]string, 0, len(*r))
+	for name := range *r {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + "=" + (*r)[name]
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r *RingAddrs) Set(v string) error {
+	addrs := RingAddrs{}
+	for _, part := range strings.Split(v, ",") {
+		name, addr, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || name == "" || addr == "" {
+			return fmt.Errorf("%q isnt name=addr", part)
+		}
+		addrs[name] = addr
+	}
+	*r = addrs
+	return nil
+}
+
+// Proxies are ips or cidrs, written a,b
+type Proxies []string
+
+func (p *Proxies) String() string {
+	if p == nil {
+		return ""
+	}
+	return strings.Join(*p, ",")
+}
+
+func (p *Proxies) Set(v string) error {
+	var proxies Proxies
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(part); err != nil && net.ParseIP(part) == nil {
+			return fmt.Errorf("%q isnt an ip or cidr", part)
+		}
+		proxies = append(proxies, part)
+	}
+	*p = proxies
+	return nil
+}
+
+// RateLimit lets Limit requests through in any Window
+type RateLimit struct {
+	Limit  int
+	Window time.Duration
+}
+
+// RateLimits are rate limit rules by name, written name=limit/window,... as
+// in answer=60/1m. Setting it only changes the rules it names, the others
+// keep what they had.
+type RateLimits map[string]RateLimit
+
+func (r *RateLimits) String() string {
+	if r == nil {
+		return ""
+	}
+	names := make([]string, 0, len(*r))
+	for name := range *r {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		rl := (*r)[name]
+		parts[i] = fmt.Sprintf("%s=%d/%v", name, rl.Limit, rl.Window)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r *RateLimits) Set(v string) error {
+	limits := RateLimits{}
+	for name, rl := range *r {
+		limits[name] = rl
+	}
+	for _, part := range strings.Split(v, ",") {
+		name, rule, ok := strings.Cut(strings.TrimSpace(part), "=")
+		limit, window, ok2 := strings.Cut(rule, "/")
+		if !ok || !ok2 || name == "" {
+			return fmt.Errorf("%q isnt name=limit/window", part)
+		}
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return fmt.Errorf("%q: %w", part, err)
+		}
+		d, err := time.ParseDuration(window)
+		if err != nil {
+			return fmt.Errorf("%q: %w", part, err)
+		}
+		limits[name] = RateLimit{Limit: n, Window: d}
+	}
+	*r = limits
+	return nil
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaultsNeedOnlySecrets(t *testing.T) {
+	c := Default()
+	c.JWTSecret, c.MongoURI = "secret", "mongodb://localhost"
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != ":8081" || c.MongoDatabase != "scaler" || c.RedisAddrs.String() != "server1=:6379,server2=:6380" {
+		t.Fatalf("defaults changed: %+v", c)
+	}
+}
+
+// flags beat the env, the env beats the file, the file beats the defaults
+func TestLoadOrder(t *testing.T) {
+	path := writeFile(t, `{
+		"jwt-secret": "from-file",
+		"storage": "memory",
+		"db-timeout": "3s",
+		"token-lifetime": "48h",
+		"momentum-threshold": 0.7,
+		"correct-streak-to-up": 3
+	}`)
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("DB_TIMEOUT", "4s")
+	t.Setenv("CORRECT_STREAK_TO_UP", "4")
+	t.Setenv("CLIENT_IP", "")
+
+	c, err := Load("test", []string{"-db-timeout", "5s", "-port", ":9000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.JWTSecret != "from-file" || c.TokenLifetime != 48*time.Hour || c.Algorithm.MomentumThreshold != 0.7 {
+		t.Errorf("file values missing: %+v", c)
+	}
+	if c.Algorithm.CorrectStreakToUp != 4 {
+		t.Errorf("env should beat the file, got %d", c.Algorithm.CorrectStreakToUp)
+	}
+	if c.DBTimeout != 5*time.Second || c.Port != ":9000" {
+		t.Errorf("flags should beat everything, got %v and %q", c.DBTimeout, c.Port)
+	}
+	if c.GuestTokenLifetime != 24*time.Hour || c.MongoDatabase != "scaler" {
+		t.Errorf("unset values should keep their defaults: %+v", c)
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		args []string
+		want []string
+	}{
+		{"missing secrets", `{}`, nil, []string{"jwt-secret is required", "mongodb-uri is required"}},
+		{"unknown key", `{"jwt-secret": "x", "storage": "memory", "prot": ":1"}`, nil, []string{`unknown setting "prot"`}},
+		{"bad duration", `{"jwt-secret": "x", "storage": "memory", "db-timeout": "soon"}`, nil, []string{"db-timeout"}},
+		{"bad values", `{"jwt-secret": "x"}`, []string{
+			"-storage", "sqlite", "-cookie-samesite", "loose", "-leaderboard-tz", "Mars/Base",
+			"-token-lifetime", "0s", "-rolling-window-size", "0", "-momentum-threshold", "1.5",
+		}, []string{"storage", "cookie-samesite", "leaderboard-tz", "token-lifetime", "rolling-window-size", "momentum-threshold"}},
+		{"bad ring", `{"jwt-secret": "x", "mongodb-uri": "m", "redis-addrs": "server1"}`, nil, []string{"name=addr"}},
+		{"bad proxy", `{"jwt-secret": "x", "storage": "memory", "trusted-proxies": "10.0.0.0/8,proxy"}`, nil, []string{`"proxy" isnt an ip or cidr`}},
+		{"bad rate limit", `{"jwt-secret": "x", "storage": "memory", "rate-limits": "answer=60"}`, nil, []string{"name=limit/window"}},
+		{"bad rate limits", `{"jwt-secret": "x", "storage": "memory"}`, []string{"-rate-limits", "anwser=60/1m,live=0/1m"},
+			[]string{`no rule is called "anwser"`, "live needs a limit"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CONFIG_FILE", writeFile(t, tt.file))
+			for _, env := range []string{"JWT_SECRET", "MONGODB_URI", "STORAGE"} {
+				t.Setenv(env, "")
+			}
+			_, err := Load("test", tt.args)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("error %q doesnt mention %q", err, w)
+				}
+			}
+		})
+	}
+}
+
+func TestRingAddrs(t *testing.T) {
+	var r RingAddrs
+	if err := r.Set("b=:2, a=redis:1"); err != nil {
+		t.Fatal(err)
+	}
+	if r["a"] != "redis:1" || r["b"] != ":2" || r.String() != "a=redis:1,b=:2" {
+		t.Fatalf("got %v", r)
+	}
+}
+
+// a rule that isnt set keeps its default
+func TestRateLimits(t *testing.T) {
+	r := Default().RateLimits
+	if err := r.Set("answer=120/30s, live=5/1h"); err != nil {
+		t.Fatal(err)
+	}
+	if r["answer"] != (RateLimit{120, 30 * time.Second}) || r["live"] != (RateLimit{5, time.Hour}) {
+		t.Fatalf("got %v", r.String())
+	}
+	if r["register"] != Default().RateLimits["register"] || len(r) != len(Default().RateLimits) {
+		t.Fatalf("untouched rules changed: %v", r.String())
+	}
+	if Default().RateLimits["answer"].Limit != 60 {
+		t.Fatal("Set changed the defaults")
+	}
+}
+
+// a commands own flags dont come from the env or the file, USER is set in
+// most shells
+func TestLoadExtraFlags(t *testing.T) {
+	t.Setenv("CONFIG_FILE", writeFile(t, `{"jwt-secret": "x", "storage": "memory"}`))
+	t.Setenv("USER", "root")
+
+	var user string
+	var dry bool
+	extra := func(fs *flag.FlagSet) {
+		fs.StringVar(&user, "user", "", "")
+		fs.BoolVar(&dry, "dry-run", false, "")
+	}
+	if _, err := Load("test", []string{"-dry-run"}, extra); err != nil {
+		t.Fatal(err)
+	}
+	if user != "" || !dry {
+		t.Fatalf("user %q dry-run %v", user, dry)
+	}
+
+	t.Setenv("CONFIG_FILE", writeFile(t, `{"jwt-secret": "x", "storage": "memory", "user": "root"}`))
+	if _, err := Load("test", nil, extra); err == nil || !strings.Contains(err.Error(), `unknown setting "user"`) {
+		t.Fatalf("the file set a command flag: %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package experiments
+
+import (
+	"server/internal/models"
+	"time"
+)
+
+// trajectoryAt are the answers the difficulty trajectory is sampled at
+var trajectoryAt = []int{1, 5, 10, 25, 50, 100, 250, 500, 1000}
+
+// ArmReport is how the users of one variant did, worked out from their
+// answer-logs inside the experiment
+type ArmReport struct {
+	Variant        string  `json:"variant"`
+	Allocation     int     `json:"allocation"`
+	Exposed        int     `json:"exposed"` // users with an exposure logged
+	Users          int     `json:"users"`   // users with answers in the experiment
+	Answers        int     `json:"answers"`
+	AnswersPerUser float64 `json:"answersPerUser"`
+	Accuracy       float64 `json:"accuracy"`
+	ScorePerAnswer float64 `json:"scorePerAnswer"`
+	// retention proxies, the logs only know when users answered so coming
+	// back means answering again
+	ReturnedAfter1d float64           `json:"returnedAfter1d"` // share of users with an answer a day or more after their first
+	ReturnedAfter7d float64           `json:"returnedAfter7d"`
+	DaysActive      float64           `json:"daysActive"` // mean days users answered on
+	Trajectory      []TrajectoryPoint `json:"trajectory"`
+}
+
+// TrajectoryPoint is the mean difficulty of the nth answer, only users who
+// got that far count
+type TrajectoryPoint struct {
+	Answer     int     `json:"answer"`
+	Users      int     `json:"users"`
+	Difficulty float64 `json:"difficulty"`
+}
+
+type arm struct {
+	ArmReport
+	correct    int
+	score      float64
+	returned1d int
+	returned7d int
+	days       int
+	difficulty []int // summed at each trajectoryAt
+	reached    []int
+}
+
+// analysis adds up answers user by user, days are counted in loc like the
+// daily leaderboards
+type analysis struct {
+	arms  map[string]*arm
+	order []string
+	loc   *time.Location
+}
+
+func newAnalysis(e models.Experiment, loc *time.Location) *analysis {
+	a := &analysis{arms: map[string]*arm{}, loc: loc}
+	for _, v := range e.Variants {
+		a.order = append(a.order, v.Name)
+		a.arms[v.Name] = &arm{
+			ArmReport:  ArmReport{Variant: v.Name, Allocation: v.Allocation},
+			difficulty: make([]int, len(trajectoryAt)),
+			reached:    make([]int, len(trajectoryAt)),
+		}
+	}
+	return a
+}
+
+// add counts one users answers, oldest first
+func (a *analysis) add(answers []models.AnswerLog) {
+	if len(answers) == 0 {
+		return
+	}
+	r := a.arms[answers[0].Variant]
+	if r == nil {
+		return
+	}
+
+	r.Users++
+	days := map[string]bool{}
+	for _, answer := range answers {
+		r.Answers++
+		if answer.Correct {
+			r.correct++
+		}
+		r.score += answer.ScoreDelta
+		days[answer.AnsweredAt.In(a.loc).Format(time.DateOnly)] = true
+	}
+	r.days += len(days)
+
+	since := answers[len(answers)-1].AnsweredAt.Sub(answers[0].AnsweredAt)
+	if since >= 24*time.Hour {
+		r.returned1d++
+	}
+	if since >= 7*24*time.Hour {
+		r.returned7d++
+	}
+
+	for i, n := range trajectoryAt {
+		if len(answers) < n {
+			break
+		}
+		r.difficulty[i] += answers[n-1].Difficulty
+		r.reached[i]++
+	}
+}
+
+func (a *analysis) report() []ArmReport {
+	reports := make([]ArmReport, 0, len(a.order))
+	for _, name := range a.order {
+		r := a.arms[name]
+		users, answers := float64(r.Users), float64(r.Answers)
+		r.AnswersPerUser = ratio(answers, users)
+		r.Accuracy = ratio(float64(r.correct), answers)
+		r.ScorePerAnswer = ratio(r.score, answers)
+		r.ReturnedAfter1d = ratio(float64(r.returned1d), users)
+		r.ReturnedAfter7d = ratio(float64(r.returned7d), users)
+		r.DaysActive = ratio(float64(r.days), users)
+
+		r.Trajectory = []TrajectoryPoint{}
+		for i, n := range trajectoryAt {
+			if r.reached[i] == 0 {
+				break
+			}
+			r.Trajectory = append(r.Trajectory, TrajectoryPoint{
+				Answer:     n,
+				Users:      r.reached[i],
+				Difficulty: ratio(float64(r.difficulty[i]), float64(r.reached[i])),
+			})
+		}
+		reports = append(reports, r.ArmReport)
+	}
+	return reports
+}
+
+// ratio is 0 for an empty arm instead of NaN, json cant carry NaN
+func ratio(n float64, d float64) float64 {
+	if d == 0 {
+		return 0
+	}
+	return n / d
+}
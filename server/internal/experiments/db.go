@@ -0,0 +1,135 @@
+package experiments
+
+import (
+	"context"
+	"errors"
+	"server/internal/models"
+	"server/internal/store"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	EXPERIMENT_NOT_FOUND = "experiment not found"
+	EXPERIMENT_RUNNING   = "an experiment is already running"
+	EXPERIMENT_ENDED     = "experiment already ended"
+)
+
+// startExperiment stores a new running experiment and starts using it on
+// this instance, the others pick it up within params-poll
+func (s *Server) startExperiment(name string, variants []models.Variant, adminID string) (*models.Experiment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	e := models.Experiment{
+		Id:        uuid.NewString(),
+		Name:      name,
+		Variants:  variants,
+		Active:    true,
+		CreatedBy: adminID,
+		StartedAt: time.Now().UTC(),
+	}
+	// a params change at the same time either lands first or sees this one,
+	// see server.GuardParams
+	err := s.DB.Transaction(ctx, func(ctx context.Context) error {
+		if err := s.GuardParams(ctx); err != nil {
+			return err
+		}
+		newest, err := s.NewestParams(ctx)
+		if err != nil {
+			return err
+		}
+		e.ParamsVersion = newest.Version
+		if _, err := s.CollExperiments.InsertOne(ctx, e); err != nil {
+			// the partial unique index only allows one running experiment
+			if mongo.IsDuplicateKeyError(err) {
+				return errors.New(EXPERIMENT_RUNNING)
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &e, s.LoadExperiment(ctx)
+}
+
+func (s *Server) getExperiment(experimentID string) (*models.Experiment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	var e models.Experiment
+	err := s.CollExperiments.FindOne(ctx, bson.M{"_id": experimentID}).Decode(&e)
+	if err == mongo.ErrNoDocuments {
+		return nil, errors.New(EXPERIMENT_NOT_FOUND)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// listExperiments is every experiment, newest first
+func (s *Server) listExperiments() ([]models.Experiment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	cursor, err := s.CollExperiments.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "startedAt", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	experiments := []models.Experiment{}
+	if err := cursor.All(ctx, &experiments); err != nil {
+		return nil, err
+	}
+	return experiments, nil
+}
+
+// endExperiment stops assigning users, everyone goes back to the stored params
+func (s *Server) endExperiment(experimentID string) (*models.Experiment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	var e models.Experiment
+	err := s.CollExperiments.FindOneAndUpdate(ctx,
+		bson.M{"_id": experimentID, "active": true},
+		bson.M{"$set": bson.M{"active": false, "endedAt": time.Now().UTC()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&e)
+	if err == mongo.ErrNoDocuments {
+		if _, err := s.getExperiment(experimentID); err != nil {
+			return nil, err
+		}
+		return nil, errors.New(EXPERIMENT_ENDED)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, s.LoadExperiment(ctx)
+}
+
+// analyze walks the experiments answer-logs one user at a time
+func (s *Server) analyze(ctx context.Context, e models.Experiment) ([]ArmReport, error) {
+	a := newAnalysis(e, s.LeaderboardLocation)
+	for _, v := range e.Variants {
+		n, err := s.CollExposures.CountDocuments(ctx, bson.M{"experimentId": e.Id, "variant": v.Name})
+		if err != nil {
+			return nil, err
+		}
+		a.arms[v.Name].Exposed = int(n)
+	}
+
+	err := s.Answers.EachUser(ctx, store.AnswerQuery{ExperimentID: e.Id}, func(answers []models.AnswerLog) error {
+		a.add(answers)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a.report(), nil
+}
@@ -0,0 +1,148 @@
+package experiments
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"server/internal/config"
+	"server/internal/models"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type VariantReq struct {
+	Name       string `json:"name"       binding:"required,min=1,max=30"`
+	Allocation int    `json:"allocation" binding:"min=1,max=100"`
+	// left out for a control arm on the stored params
+	Params *config.Algorithm `json:"params"`
+}
+
+type StartExperimentReq struct {
+	Name     string       `json:"name"     binding:"required,min=1,max=50"`
+	Variants []VariantReq `json:"variants" binding:"required,min=2,max=10,dive"`
+}
+
+type ExperimentsRes struct {
+	Experiments []models.Experiment `json:"experiments"`
+}
+
+type AnalysisRes struct {
+	Experiment models.Experiment `json:"experiment"`
+	Arms       []ArmReport       `json:"arms"`
+}
+
+// StartExperiment starts splitting users between the variants, only one
+// experiment can run at a time
+func (s *Server) StartExperiment(c *gin.Context) {
+	var req StartExperimentReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name bw 1-50 chars and 2-10 variants, each with a name and an allocation of 1-100"})
+		return
+	}
+	variants, err := toVariants(req.Variants)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	e, err := s.startExperiment(strings.TrimSpace(req.Name), variants, c.GetString("userId"))
+	if err != nil {
+		if err.Error() == EXPERIMENT_RUNNING {
+			c.JSON(http.StatusConflict, gin.H{"error": EXPERIMENT_RUNNING})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusCreated, e)
+}
+
+// toVariants checks the arms fit in 100% and have usable params
+func toVariants(reqs []VariantReq) ([]models.Variant, error) {
+	variants := make([]models.Variant, 0, len(reqs))
+	seen := map[string]bool{}
+	total := 0
+	for _, r := range reqs {
+		name := strings.TrimSpace(r.Name)
+		if name == "" || seen[name] {
+			return nil, fmt.Errorf("variant names have to be unique, %q isnt", r.Name)
+		}
+		seen[name] = true
+		total += r.Allocation
+		if r.Params != nil {
+			if err := r.Params.Validate(); err != nil {
+				return nil, fmt.Errorf("variant %s: %w", name, err)
+			}
+		}
+		variants = append(variants, models.Variant{Name: name, Allocation: r.Allocation, Params: r.Params})
+	}
+	if total > 100 {
+		return nil, fmt.Errorf("allocations add up to %d%%, more than 100", total)
+	}
+	return variants, nil
+}
+
+func (s *Server) ListExperiments(c *gin.Context) {
+	experiments, err := s.listExperiments()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, ExperimentsRes{Experiments: experiments})
+}
+
+func (s *Server) GetExperiment(c *gin.Context) {
+	e, err := s.getExperiment(c.Param("experimentId"))
+	if err != nil {
+		if err.Error() == EXPERIMENT_NOT_FOUND {
+			c.JSON(http.StatusNotFound, gin.H{"error": EXPERIMENT_NOT_FOUND})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, e)
+}
+
+// EndExperiment puts everyone back on the stored params, the answers stay
+// tagged so it can still be analysed
+func (s *Server) EndExperiment(c *gin.Context) {
+	e, err := s.endExperiment(c.Param("experimentId"))
+	if err != nil {
+		switch err.Error() {
+		case EXPERIMENT_NOT_FOUND:
+			c.JSON(http.StatusNotFound, gin.H{"error": EXPERIMENT_NOT_FOUND})
+		case EXPERIMENT_ENDED:
+			c.JSON(http.StatusConflict, gin.H{"error": EXPERIMENT_ENDED})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, e)
+}
+
+// GetAnalysis compares the arms of an experiment, running or ended
+func (s *Server) GetAnalysis(c *gin.Context) {
+	e, err := s.getExperiment(c.Param("experimentId"))
+	if err != nil {
+		if err.Error() == EXPERIMENT_NOT_FOUND {
+			c.JSON(http.StatusNotFound, gin.H{"error": EXPERIMENT_NOT_FOUND})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	// reads every answer in the experiment, so it gets longer than one query
+	ctx, cancel := context.WithTimeout(c.Request.Context(), s.Config.BatchTimeout)
+	defer cancel()
+
+	arms, err := s.analyze(ctx, *e)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "analysis failed " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, AnalysisRes{Experiment: *e, Arms: arms})
+}
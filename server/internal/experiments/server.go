@@ -0,0 +1,13 @@
+package experiments
+
+import (
+	"server/internal/server"
+)
+
+type Server struct {
+	*server.Server
+}
+
+func NewExperimentsServer(s *server.Server) *Server {
+	return &Server{Server: s}
+}
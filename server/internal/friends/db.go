@@ -0,0 +1,164 @@
+package friends
+
+import (
+	"context"
+	"errors"
+	"server/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	FRIEND_SELF       = "cant befriend yourself"
+	FRIEND_GUEST      = "guests cant have friends"
+	REQUEST_EXISTS    = "friend request already sent"
+	ALREADY_FRIENDS   = "already friends"
+	REQUEST_NOT_FOUND = "friend request not found"
+	FRIEND_NOT_FOUND  = "friend not found"
+	TOO_MANY_FRIENDS  = "friend limit reached"
+)
+
+// sendRequest asks to befriend to. If to already asked from, that request is
+// accepted instead of starting a second one.
+func (s *Server) sendRequest(from string, to string) (*models.Friendship, error) {
+	if from == to {
+		return nil, errors.New(FRIEND_SELF)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	var existing models.Friendship
+	err := s.CollFriends.FindOne(ctx, bson.M{"_id": models.FriendshipID(from, to)}).Decode(&existing)
+	if err == nil {
+		switch {
+		case existing.Status == models.FRIEND_ACCEPTED:
+			return nil, errors.New(ALREADY_FRIENDS)
+		case existing.RequestedBy == from:
+			return nil, errors.New(REQUEST_EXISTS)
+		default:
+			return s.acceptRequest(from, to)
+		}
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	friendship := models.Friendship{
+		Id:          models.FriendshipID(from, to),
+		Users:       []string{from, to},
+		RequestedBy: from,
+		Status:      models.FRIEND_PENDING,
+		CreatedAt:   time.Now().UTC(),
+	}
+	if _, err := s.CollFriends.InsertOne(ctx, friendship); err != nil {
+		// both asked at the same time, the first one wins
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, errors.New(REQUEST_EXISTS)
+		}
+		return nil, err
+	}
+
+	return &friendship, nil
+}
+
+// acceptRequest accepts the request from sent to userID, as long as both still
+// have room for one more friend. The slots are taken first like team seats,
+// and given back if the accept doesnt go through.
+func (s *Server) acceptRequest(userID string, from string) (*models.Friendship, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	var reserved []string
+	release := func() error {
+		return s.ReleaseFriend(ctx, reserved...)
+	}
+	for _, id := range []string{userID, from} {
+		ok, err := s.ReserveFriend(ctx, id)
+		if err == nil && !ok {
+			err = errors.New(TOO_MANY_FRIENDS)
+		}
+		if err != nil {
+			if err := release(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		reserved = append(reserved, id)
+	}
+
+	var friendship models.Friendship
+	err := s.CollFriends.FindOneAndUpdate(ctx,
+		bson.M{
+			"_id":         models.FriendshipID(userID, from),
+			"status":      models.FRIEND_PENDING,
+			"requestedBy": from,
+		},
+		bson.M{"$set": bson.M{"status": models.FRIEND_ACCEPTED, "acceptedAt": time.Now().UTC()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&friendship)
+	if err != nil {
+		if err := release(); err != nil {
+			return nil, err
+		}
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New(REQUEST_NOT_FOUND)
+		}
+		return nil, err
+	}
+
+	return &friendship, nil
+}
+
+// removeFriendship ends a friendship, or declines or withdraws a request
+func (s *Server) removeFriendship(userID string, other string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	id := models.FriendshipID(userID, other)
+	res, err := s.CollFriends.DeleteOne(ctx, bson.M{"_id": id, "status": models.FRIEND_ACCEPTED})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 1 {
+		return s.ReleaseFriend(ctx, userID, other)
+	}
+
+	res, err = s.CollFriends.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return errors.New(FRIEND_NOT_FOUND)
+	}
+	return nil
+}
+
+// listFriendships returns the users friendships with the given status, newest first
+func (s *Server) listFriendships(userID string, status string) ([]models.Friendship, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	cursor, err := s.CollFriends.Find(ctx,
+		bson.M{"users": userID, "status": status},
+		options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, err
+	}
+	friendships := []models.Friendship{}
+	if err := cursor.All(ctx, &friendships); err != nil {
+		return nil, err
+	}
+	return friendships, nil
+}
+
+// usernames maps user ids to their current handles
+func (s *Server) usernames(ids []string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	return s.Users.Names(ctx, ids)
+}
@@ -0,0 +1,215 @@
+package friends
+
+import (
+	"net/http"
+	"server/internal/auth"
+	"server/internal/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type FriendRequestReq struct {
+	Username string `json:"username" binding:"required"`
+}
+
+// FriendRes is the other side of a friendship as the caller sees it
+type FriendRes struct {
+	UserID   string    `json:"userId"`
+	Username string    `json:"username"`
+	Status   string    `json:"status"`
+	Since    time.Time `json:"since"` // accepted at for friends, requested at for requests
+}
+
+type FriendsRes struct {
+	Friends []FriendRes `json:"friends"`
+}
+
+type FriendRequestsRes struct {
+	Incoming []FriendRes `json:"incoming"`
+	Outgoing []FriendRes `json:"outgoing"`
+}
+
+// SendFriendRequest asks another user to be friends, accepts straight away
+// if they already asked the caller
+func (s *Server) SendFriendRequest(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	var req FriendRequestReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username required"})
+		return
+	}
+
+	if !s.callerCanHaveFriends(c, userID) {
+		return
+	}
+
+	target, err := s.users.FindInUsersTable(req.Username)
+	if err != nil {
+		if err.Error() == auth.USER_NOT_FOUND {
+			c.JSON(http.StatusNotFound, gin.H{"error": auth.USER_NOT_FOUND})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if target.Guest {
+		c.JSON(http.StatusNotFound, gin.H{"error": auth.USER_NOT_FOUND})
+		return
+	}
+
+	friendship, err := s.sendRequest(userID, target.Id)
+	if err != nil {
+		switch err.Error() {
+		case FRIEND_SELF:
+			c.JSON(http.StatusBadRequest, gin.H{"error": FRIEND_SELF})
+		case REQUEST_EXISTS, ALREADY_FRIENDS, TOO_MANY_FRIENDS:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		}
+		return
+	}
+
+	status := http.StatusCreated
+	if friendship.Status == models.FRIEND_ACCEPTED {
+		status = http.StatusOK
+	}
+	c.JSON(status, friendRes(*friendship, target.Id, target.Username))
+}
+
+// AcceptFriendRequest accepts the pending request from :userId
+func (s *Server) AcceptFriendRequest(c *gin.Context) {
+	userID := c.GetString("userId")
+	from := c.Param("userId")
+
+	friendship, err := s.acceptRequest(userID, from)
+	if err != nil {
+		switch err.Error() {
+		case REQUEST_NOT_FOUND:
+			c.JSON(http.StatusNotFound, gin.H{"error": REQUEST_NOT_FOUND})
+		case TOO_MANY_FRIENDS:
+			c.JSON(http.StatusConflict, gin.H{"error": TOO_MANY_FRIENDS})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		}
+		return
+	}
+
+	names, err := s.usernames([]string{from})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, friendRes(*friendship, from, names[from]))
+}
+
+// RemoveFriend unfriends :userId, or declines or withdraws a request with them
+func (s *Server) RemoveFriend(c *gin.Context) {
+	other := c.Param("userId")
+
+	if err := s.removeFriendship(c.GetString("userId"), other); err != nil {
+		if err.Error() == FRIEND_NOT_FOUND {
+			c.JSON(http.StatusNotFound, gin.H{"error": FRIEND_NOT_FOUND})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"userId": other})
+}
+
+func (s *Server) ListFriends(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	friendships, err := s.listFriendships(userID, models.FRIEND_ACCEPTED)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	friends, err := s.describe(userID, friendships)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, FriendsRes{Friends: friends})
+}
+
+// ListFriendRequests returns the pending requests to and from the caller
+func (s *Server) ListFriendRequests(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	friendships, err := s.listFriendships(userID, models.FRIEND_PENDING)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	requests, err := s.describe(userID, friendships)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	res := FriendRequestsRes{Incoming: []FriendRes{}, Outgoing: []FriendRes{}}
+	for i, f := range friendships {
+		if f.RequestedBy == userID {
+			res.Outgoing = append(res.Outgoing, requests[i])
+		} else {
+			res.Incoming = append(res.Incoming, requests[i])
+		}
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// callerCanHaveFriends rejects guests, they have nothing to show on a board
+func (s *Server) callerCanHaveFriends(c *gin.Context, userID string) bool {
+	caller, err := s.users.GetUser(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return false
+	}
+	if caller.Guest {
+		c.JSON(http.StatusForbidden, gin.H{"error": FRIEND_GUEST})
+		return false
+	}
+	return true
+}
+
+// describe turns friendships into the other users side, in the same order
+func (s *Server) describe(userID string, friendships []models.Friendship) ([]FriendRes, error) {
+	others := make([]string, 0, len(friendships))
+	for _, f := range friendships {
+		others = append(others, otherUser(f, userID))
+	}
+	names, err := s.usernames(others)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]FriendRes, 0, len(friendships))
+	for i, f := range friendships {
+		res = append(res, friendRes(f, others[i], names[others[i]]))
+	}
+	return res, nil
+}
+
+func otherUser(f models.Friendship, userID string) string {
+	for _, id := range f.Users {
+		if id != userID {
+			return id
+		}
+	}
+	return ""
+}
+
+func friendRes(f models.Friendship, otherID string, username string) FriendRes {
+	since := f.CreatedAt
+	if f.AcceptedAt != nil {
+		since = *f.AcceptedAt
+	}
+	return FriendRes{UserID: otherID, Username: username, Status: f.Status, Since: since}
+}
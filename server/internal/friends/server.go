@@ -0,0 +1,15 @@
+package friends
+
+import (
+	"server/internal/auth"
+	"server/internal/server"
+)
+
+type Server struct {
+	*server.Server
+	users *auth.Server // user lookups
+}
+
+func NewFriendsServer(s *server.Server) *Server {
+	return &Server{Server: s, users: auth.NewAuthServer(s)}
+}
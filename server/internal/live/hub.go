@@ -0,0 +1,100 @@
+package live
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"server/internal/store"
+	"sync"
+	"time"
+)
+
+// CHANNEL carries one message per leaderboard change. Every instance publishes
+// to it and listens on it, so a stream on one instance hears about answers
+// submitted on any other.
+const CHANNEL = "live:leaderboard"
+
+type Event struct {
+	UserID string    `json:"userId"`
+	At     time.Time `json:"at"`
+}
+
+// Hub holds one subscription per instance and fans its events out to the
+// streams open on this instance
+type Hub struct {
+	cache store.Cache
+
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// Subscription gets the latest event on C. A slow reader only misses the
+// events in between, it is meant to re-read the boards anyway.
+type Subscription struct {
+	C   chan Event
+	hub *Hub
+}
+
+func NewHub(cache store.Cache) *Hub {
+	return &Hub{cache: cache, subs: map[*Subscription]struct{}{}}
+}
+
+// Run listens on the channel until ctx is done, events sent while the
+// subscription is away are lost
+func (h *Hub) Run(ctx context.Context) {
+	ch := h.cache.Subscribe(ctx, CHANNEL)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case payload, ok := <-ch:
+			if !ok {
+				return
+			}
+			var event Event
+			if err := json.Unmarshal(payload, &event); err != nil {
+				log.Println("live event error:", err)
+				continue
+			}
+			h.fanOut(event)
+		}
+	}
+}
+
+func (h *Hub) fanOut(event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		select {
+		case sub.C <- event:
+		default:
+			// reader is busy, it still has an event waiting
+		}
+	}
+}
+
+// Publish tells every instance that the boards changed
+func (h *Hub) Publish(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return h.cache.Publish(ctx, CHANNEL, payload)
+}
+
+func (h *Hub) Subscribe() *Subscription {
+	sub := &Subscription{C: make(chan Event, 1), hub: h}
+
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+
+	return sub
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	delete(s.hub.subs, s)
+	s.hub.mu.Unlock()
+}
@@ -4,13 +4,35 @@ import "time"
 
 type AnswerLog struct {
 	Id             string    `bson:"_id"           json:"Id"`
-	Username       string    `bson:"username"            json:"username"`
+	UserID         string    `bson:"userId"            json:"userId"`
+	Username       string    `bson:"username"            json:"username"` // handle at the time of answering
 	QuestionID     string    `bson:"questionId"            json:"questionId"`
 	Difficulty     int       `bson:"difficulty"            json:"difficulty"`
+	Topic          string    `bson:"topic,omitempty"            json:"topic,omitempty"`
 	Answer         string    `bson:"answer"            json:"answer"`
 	Correct        bool      `bson:"correct"            json:"correct"`
 	ScoreDelta     float64   `bson:"score"            json:"score"`
 	StreakAtAnswer int       `bson:"streak"            json:"streak"`
-	IdempotencyKey string    `bson:"ikey"            json:"ikey"`
+	IdempotencyKey string    `bson:"ikey"            json:"ikey"` // unique per user
+	SessionID      string    `bson:"sessionId,omitempty"            json:"sessionId,omitempty"`
+	ServedAt       time.Time `bson:"servedAt,omitempty"            json:"servedAt,omitzero"`            // when the question was served, zero when unknown
+	ResponseMs     int64     `bson:"responseMs,omitempty"            json:"responseMs,omitempty"`       // since the question was served, 0 when unknown
+	Response       []byte    `bson:"response,omitempty"            json:"-"`                            // the json sent back, replayed as is for the same key
+	ParamsVersion  int       `bson:"paramsVersion,omitempty"            json:"paramsVersion,omitempty"` // AlgorithmParams it was scored with, 0 when an experiment arm with its own params scored it or from before they were stored
+	ExperimentID   string    `bson:"experimentId,omitempty"            json:"experimentId,omitempty"`   // experiment arm it was scored in, an arm with params replaces the stored ones
+	Variant        string    `bson:"variant,omitempty"            json:"variant,omitempty"`
 	AnsweredAt     time.Time `bson:"answeredAt"            json:"answeredAt"`
 }
+
+// PeriodTotal is one users sums over the answers of a period, with when they
+// reached their score and best streak for breaking ties
+type PeriodTotal struct {
+	UserID   string    `bson:"_id"`
+	Username string    `bson:"username"`
+	Score    float64   `bson:"score"`
+	ScoreAt  time.Time `bson:"scoreAt"`
+	Streak   int       `bson:"streak"`
+	StreakAt time.Time `bson:"streakAt"`
+	Answered float64   `bson:"answered"`
+	Correct  float64   `bson:"correct"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// AuditLog records an administrative or privacy-relevant action
+type AuditLog struct {
+	Id      string         `bson:"_id"     json:"id"`
+	Action  string         `bson:"action"  json:"action"`
+	Subject string         `bson:"subject" json:"subject"` // user id the action was applied to
+	Actor   string         `bson:"actor"   json:"actor"`   // user id that performed it
+	Details map[string]any `bson:"details" json:"details,omitempty"`
+	At      time.Time      `bson:"at"      json:"at"`
+}
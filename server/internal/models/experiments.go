@@ -0,0 +1,40 @@
+package models
+
+import (
+	"server/internal/config"
+	"time"
+)
+
+// Experiment splits users between variants of the algorithm params. Only one
+// runs at a time and its variants cant change once it started, so a user stays
+// in the same arm for the whole experiment.
+type Experiment struct {
+	Id        string     `bson:"_id"               json:"experimentId"`
+	Name      string     `bson:"name"              json:"name"`
+	Variants  []Variant  `bson:"variants"          json:"variants"`
+	Active    bool       `bson:"active"            json:"active"`
+	CreatedBy string     `bson:"createdBy"         json:"createdBy"`
+	StartedAt time.Time  `bson:"startedAt"         json:"startedAt"`
+	EndedAt   *time.Time `bson:"endedAt,omitempty" json:"endedAt,omitempty"`
+	// ParamsVersion is the stored params the control arms play on, they cant
+	// change while the experiment runs
+	ParamsVersion int `bson:"paramsVersion" json:"paramsVersion"`
+}
+
+// Variant is one arm. Users outside every arms allocation arent in the
+// experiment at all.
+type Variant struct {
+	Name       string `bson:"name"       json:"name"`
+	Allocation int    `bson:"allocation" json:"allocation"` // percent of users
+	// nil is a control arm, it plays on the stored params like everyone else
+	Params *config.Algorithm `bson:"params,omitempty" json:"params,omitempty"`
+}
+
+// Exposure is the first time a user answered inside an experiment
+type Exposure struct {
+	Id           string    `bson:"_id"          json:"-"` // experimentId:userId
+	ExperimentID string    `bson:"experimentId" json:"experimentId"`
+	UserID       string    `bson:"userId"       json:"userId"`
+	Variant      string    `bson:"variant"      json:"variant"`
+	ExposedAt    time.Time `bson:"exposedAt"    json:"exposedAt"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+const (
+	FRIEND_PENDING  = "pending"
+	FRIEND_ACCEPTED = "accepted"
+)
+
+// Friendship is one document per pair of users, from the first request on
+type Friendship struct {
+	Id          string     `bson:"_id"                  json:"-"` // both user ids sorted, see FriendshipID
+	Users       []string   `bson:"users"                json:"users"`
+	RequestedBy string     `bson:"requestedBy"          json:"requestedBy"`
+	Status      string     `bson:"status"               json:"status"`
+	CreatedAt   time.Time  `bson:"createdAt"            json:"createdAt"`
+	AcceptedAt  *time.Time `bson:"acceptedAt,omitempty" json:"acceptedAt,omitempty"`
+}
+
+// FriendCount is how many accepted friendships a user has, kept next to the
+// friendships so accepting can take a slot under MaxFriends in one update
+type FriendCount struct {
+	UserID string `bson:"_id"   json:"-"`
+	Count  int    `bson:"count" json:"count"`
+}
+
+// FriendshipID is the same whichever of the two asked
+func FriendshipID(a string, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + ":" + b
+}
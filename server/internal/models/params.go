@@ -0,0 +1,16 @@
+package models
+
+import (
+	"server/internal/config"
+	"time"
+)
+
+// AlgorithmParams is one version of the adaptive algorithm and scoring
+// constants. Versions count up from 1 and are never changed, an update is a
+// new document so answer-logs can be traced back to what scored them.
+type AlgorithmParams struct {
+	Version   int              `bson:"_id"       json:"version"`
+	Params    config.Algorithm `bson:"params"    json:"params"`
+	UpdatedBy string           `bson:"updatedBy" json:"updatedBy,omitempty"` // admin user id, empty for version 1 from the config
+	UpdatedAt time.Time        `bson:"updatedAt" json:"updatedAt"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+const (
+	REVIEW_OPEN     = "open"
+	REVIEW_CLEARED  = "cleared"
+	REVIEW_EXCLUDED = "excluded"
+)
+
+// ReviewAction is the audit action of a review decision
+func ReviewAction(status string) string {
+	return "account.review." + status
+}
+
+// ReviewActions are every review audit action, an excluded user is never told
+// about their review so these stay out of their export
+var ReviewActions = []string{
+	ReviewAction(REVIEW_OPEN),
+	ReviewAction(REVIEW_CLEARED),
+	ReviewAction(REVIEW_EXCLUDED),
+}
+
+// CheatReview is one user in the anti-cheat review queue, the detector opens it
+// and an admin clears or excludes
+type CheatReview struct {
+	UserID     string     `bson:"_id"                  json:"userId"`
+	Username   string     `bson:"username"             json:"username"`
+	Status     string     `bson:"status"               json:"status"`
+	Findings   []Finding  `bson:"findings"             json:"findings"` // from the latest scan that flagged them
+	FlaggedAt  time.Time  `bson:"flaggedAt"            json:"flaggedAt"`
+	UpdatedAt  time.Time  `bson:"updatedAt"            json:"updatedAt"`
+	ReviewedBy string     `bson:"reviewedBy,omitempty" json:"reviewedBy,omitempty"`
+	ReviewedAt *time.Time `bson:"reviewedAt,omitempty" json:"reviewedAt,omitempty"`
+	Note       string     `bson:"note,omitempty"       json:"note,omitempty"`
+}
+
+// Finding is one detector rule a user tripped
+type Finding struct {
+	Rule    string    `bson:"rule"    json:"rule"`
+	Detail  string    `bson:"detail"  json:"detail"`
+	Count   int       `bson:"count"   json:"count"`
+	FirstAt time.Time `bson:"firstAt" json:"firstAt"`
+	LastAt  time.Time `bson:"lastAt"  json:"lastAt"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// Season is a competitive window, only one is active at a time
+type Season struct {
+	Id        string     `bson:"_id"               json:"seasonId"`
+	Name      string     `bson:"name"              json:"name"`
+	Active    bool       `bson:"active"            json:"active"`
+	Finalized bool       `bson:"finalized"         json:"finalized"` // standings archived and badges awarded
+	StartedAt time.Time  `bson:"startedAt"         json:"startedAt"`
+	EndedAt   *time.Time `bson:"endedAt,omitempty" json:"endedAt,omitempty"`
+}
+
+// SeasonStanding is a users final place in an ended season
+type SeasonStanding struct {
+	Id       string  `bson:"_id"             json:"-"` // seasonId:userId
+	SeasonID string  `bson:"seasonId"        json:"seasonId"`
+	UserID   string  `bson:"userId"          json:"userId"`
+	Username string  `bson:"username"        json:"username"`
+	Rank     int     `bson:"rank"            json:"rank"`
+	Score    float64 `bson:"score"           json:"score"`
+	Title    string  `bson:"title,omitempty" json:"title,omitempty"`
+}
+
+// Badge is a title awarded at the end of a season
+type Badge struct {
+	SeasonID   string    `bson:"seasonId"   json:"seasonId"`
+	SeasonName string    `bson:"seasonName" json:"seasonName"`
+	Title      string    `bson:"title"      json:"title"`
+	Rank       int       `bson:"rank"       json:"rank"`
+	AwardedAt  time.Time `bson:"awardedAt"  json:"awardedAt"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Session backs one issued token, revoking it signs that device out
+type Session struct {
+	Id         string     `bson:"_id"                 json:"sessionId"`
+	UserID     string     `bson:"userId"              json:"-"`
+	UserAgent  string     `bson:"userAgent"           json:"userAgent"`
+	IP         string     `bson:"ip"                  json:"ip"`
+	CreatedAt  time.Time  `bson:"createdAt"           json:"createdAt"`
+	LastUsedAt time.Time  `bson:"lastUsedAt"          json:"lastUsedAt"`
+	ExpiresAt  time.Time  `bson:"expiresAt"           json:"expiresAt"`
+	RevokedAt  *time.Time `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	Admin      bool       `bson:"admin,omitempty"     json:"admin,omitempty"` // signed in with the admin key
+}
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+const (
+	TEAM_OWNER  = "owner"
+	TEAM_MEMBER = "member"
+)
+
+// Team is a group of users competing together, a user is in at most one
+type Team struct {
+	Id          string    `bson:"_id"         json:"teamId"`
+	Name        string    `bson:"name"        json:"name"`
+	NameKey     string    `bson:"nameKey"     json:"-"` // lower cased name, kept unique
+	OwnerID     string    `bson:"ownerId"     json:"ownerId"`
+	MemberCount int       `bson:"memberCount" json:"memberCount"`
+	CreatedAt   time.Time `bson:"createdAt"   json:"createdAt"`
+}
+
+// TeamMember is keyed by user id, which is what keeps users to one team
+type TeamMember struct {
+	UserID   string    `bson:"_id"      json:"userId"`
+	TeamID   string    `bson:"teamId"   json:"teamId"`
+	Role     string    `bson:"role"     json:"role"`
+	JoinedAt time.Time `bson:"joinedAt" json:"joinedAt"`
+}
+
+// TeamTotal is one teams sums over its current members, from user-state for
+// all time and from the answer-logs of a period otherwise
+type TeamTotal struct {
+	TeamID   string  `bson:"_id"`
+	Score    float64 `bson:"score"`
+	Answered float64 `bson:"answered"`
+	Correct  float64 `bson:"correct"`
+}
+
+// TeamInvite is an offer to join a team, nobody goes on a team without
+// accepting one
+type TeamInvite struct {
+	Id        string    `bson:"_id"       json:"-"` // see TeamInviteID
+	TeamID    string    `bson:"teamId"    json:"teamId"`
+	UserID    string    `bson:"userId"    json:"userId"`
+	InvitedBy string    `bson:"invitedBy" json:"invitedBy"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// TeamInviteID keeps one invite per team and user
+func TeamInviteID(teamID string, userID string) string {
+	return teamID + ":" + userID
+}
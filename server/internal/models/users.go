@@ -4,17 +4,35 @@ import (
 	"time"
 )
 
+type User struct {
+	Id        string    `bson:"_id"            json:"userId"`
+	Username  string    `bson:"username"       json:"username"`
+	Role      string    `bson:"role,omitempty" json:"role,omitempty"`
+	Guest     bool      `bson:"guest,omitempty" json:"guest,omitempty"`
+	Badges    []Badge   `bson:"badges,omitempty" json:"badges,omitempty"`
+	CreatedAt time.Time `bson:"createdAt"      json:"createdAt"`
+}
+
 type UserState struct {
-	Username          string    `bson:"_id"            json:"userId"`
-	CurrentDifficulty int       `bson:"currentDifficulty" json:"currentDifficulty"`
-	Streak            int       `bson:"streak"            json:"streak"`
-	MaxStreak         int       `bson:"maxStreak"         json:"maxStreak"`
-	TotalScore        float64   `bson:"totalScore"        json:"totalScore"`
-	TotalAnswered     float64   `bson:"totalAnswered"        json:"totalAnswered"`
-	TotalCorrect      float64   `bson:"totalCorrect"        json:"totalCorrect"`
-	LastQuestionID    string    `bson:"lastQuestionId"    json:"lastQuestionId"`
-	LastAnswerAt      time.Time `bson:"lastAnswerAt"      json:"lastAnswerAt"`
-	StateVersion      int       `bson:"stateVersion"      json:"stateVersion"`
+	UserID            string               `bson:"_id"            json:"userId"`
+	Username          string               `bson:"username"          json:"username"`
+	CurrentDifficulty int                  `bson:"currentDifficulty" json:"currentDifficulty"`
+	Streak            int                  `bson:"streak"            json:"streak"`
+	MaxStreak         int                  `bson:"maxStreak"         json:"maxStreak"`
+	TotalScore        float64              `bson:"totalScore"        json:"totalScore"`
+	SeasonID          string               `bson:"seasonId,omitempty" json:"seasonId,omitempty"` // season SeasonScore belongs to
+	SeasonScore       float64              `bson:"seasonScore"       json:"seasonScore"`
+	TotalAnswered     float64              `bson:"totalAnswered"        json:"totalAnswered"`
+	TotalCorrect      float64              `bson:"totalCorrect"        json:"totalCorrect"`
+	Accuracy          float64              `bson:"accuracy"          json:"accuracy"`      // totalCorrect / totalAnswered, stored so mongo can sort by it
+	MaxDifficulty     int                  `bson:"maxDifficulty"     json:"maxDifficulty"` // highest difficulty reached
+	TopicScores       map[string]float64   `bson:"topicScores,omitempty" json:"topicScores,omitempty"`
+	AchievedAt        map[string]time.Time `bson:"achievedAt,omitempty" json:"achievedAt,omitempty"` // board name (or season) -> when the current value was reached, breaks ties
+	LastQuestionID    string               `bson:"lastQuestionId"    json:"lastQuestionId"`
+	LastAnswerAt      time.Time            `bson:"lastAnswerAt"      json:"lastAnswerAt"`
+	StateVersion      int                  `bson:"stateVersion"      json:"stateVersion"`
+	Guest             bool                 `bson:"guest,omitempty"   json:"guest,omitempty"` // kept off public leaderboards
+	ShadowExcluded    bool                 `bson:"shadowExcluded,omitempty" json:"-"`        // kept off public leaderboards after a cheat review, never shown to the user
 	// Adaptive algorithm state
 	CorrectWindow   []bool  `bson:"correctWindow"     json:"correctWindow"` // rolling 5-answer window
 	MomentumScore   float64 `bson:"momentumScore"     json:"momentumScore"` // ping-pong stabilizer
@@ -1,20 +1,22 @@
 // internal/quiz/adaptive.go
 package quiz
 
-import "server/internal/models"
+import (
+	"server/internal/config"
+	"server/internal/models"
+)
 
 const (
-	minDifficulty       = 1
-	maxDifficulty       = 10
-	correctStreakToUp   = 2   // need 2 consecutive correct to go up (hysteresis)
-	wrongStreakToDown   = 1   // 1 wrong is enough to go down
-	rollingWindowSize   = 5   // last 5 answers for momentum
-	momentumThreshold   = 0.6 // 60% correct in window required to increase difficulty
-	maxStreakMultiplier = 5
+	minDifficulty = 1
+	maxDifficulty = 10
 )
 
+// the rest of the constants are in config.Algorithm, by default 2 correct in a
+// row to go up, 1 wrong to go down, momentum over the last 5 answers, 60% of
+// them correct to go up and the streak multiplier capped at 5
+
 // applyAdaptiveAlgorithm returns a mutated copy of state — never modifies in place
-func applyAdaptiveAlgorithm(state models.UserState, correct bool) models.UserState {
+func applyAdaptiveAlgorithm(state models.UserState, correct bool, p config.Algorithm) models.UserState {
 	s := state // copy
 
 	// update streak
@@ -33,8 +35,8 @@ func applyAdaptiveAlgorithm(state models.UserState, correct bool) models.UserSta
 
 	// rolling window
 	s.CorrectWindow = append(s.CorrectWindow, correct)
-	if len(s.CorrectWindow) > rollingWindowSize {
-		s.CorrectWindow = s.CorrectWindow[len(s.CorrectWindow)-rollingWindowSize:]
+	if len(s.CorrectWindow) > p.RollingWindowSize {
+		s.CorrectWindow = s.CorrectWindow[len(s.CorrectWindow)-p.RollingWindowSize:]
 	}
 
 	// ── 3. Compute momentum (% correct in window) ─────────────────────────────
@@ -56,14 +58,14 @@ func applyAdaptiveAlgorithm(state models.UserState, correct bool) models.UserSta
 	//   - momentum requires sustained good performance across the window
 	//
 	if correct {
-		if s.ConsecutiveUp >= correctStreakToUp && s.MomentumScore >= momentumThreshold {
+		if s.ConsecutiveUp >= p.CorrectStreakToUp && s.MomentumScore >= p.MomentumThreshold {
 			if s.CurrentDifficulty < maxDifficulty {
 				s.CurrentDifficulty++
 			}
 			s.ConsecutiveUp = 0 // reset after adjustment
 		}
 	} else {
-		if s.ConsecutiveDown >= wrongStreakToDown {
+		if s.ConsecutiveDown >= p.WrongStreakToDown {
 			if s.CurrentDifficulty > minDifficulty {
 				s.CurrentDifficulty--
 			}
@@ -74,21 +76,26 @@ func applyAdaptiveAlgorithm(state models.UserState, correct bool) models.UserSta
 	return s
 }
 
-func calculateScore(difficulty int, correct bool, streak int) float64 {
+func calculateScore(difficulty int, correct bool, streak int, p config.Algorithm) float64 {
 	// calculateScore returns the score delta for a single answer
 	//
 	// Formula:
 	//
 	//	base      = difficulty * 10
-	//	multiplier = min(1 + (streak * 0.1), maxStreakMultiplier)  → caps at 5x
+	//	multiplier = min(1 + (streak * 0.1), p.MaxStreakMultiplier)  → 5x by default
 	//	delta     = base * multiplier  (0 if wrong)
 	if !correct {
 		return 0
 	}
 	base := float64(difficulty) * 10.0
+	return base * streakMultiplier(streak, p)
+}
+
+// streakMultiplier is 1 + 0.1 per answer in the streak, capped at p.MaxStreakMultiplier
+func streakMultiplier(streak int, p config.Algorithm) float64 {
 	multiplier := 1.0 + float64(streak)*0.1
-	if multiplier > maxStreakMultiplier {
-		multiplier = maxStreakMultiplier
+	if multiplier > p.MaxStreakMultiplier {
+		multiplier = p.MaxStreakMultiplier
 	}
-	return base * multiplier
+	return multiplier
 }
@@ -0,0 +1,142 @@
+package quiz
+
+import (
+	"math/rand"
+	"server/internal/config"
+	"server/internal/models"
+	"slices"
+	"testing"
+)
+
+var defaults = config.Default().Algorithm
+
+// play runs answers through applyAdaptiveAlgorithm from a new users state
+func play(answers ...bool) models.UserState {
+	s := models.UserState{CurrentDifficulty: 3, MomentumScore: 0.5}
+	for _, correct := range answers {
+		s = applyAdaptiveAlgorithm(s, correct, defaults)
+	}
+	return s
+}
+
+const (
+	T = true
+	F = false
+)
+
+func TestApplyAdaptiveAlgorithm(t *testing.T) {
+	tests := []struct {
+		name       string
+		answers    []bool
+		difficulty int
+		streak     int
+		maxStreak  int
+		window     []bool
+		momentum   float64
+	}{
+		{"one correct isnt enough", []bool{T}, 3, 1, 1, []bool{T}, 1},
+		{"two correct go up", []bool{T, T}, 4, 2, 2, []bool{T, T}, 1},
+		{"one wrong goes down", []bool{F}, 2, 0, 0, []bool{F}, 0},
+		{"a wrong answer resets the run", []bool{T, F, T}, 2, 1, 1, []bool{T, F, T}, 2.0 / 3},
+		{"the run starts over after going up", []bool{T, T, T}, 4, 3, 3, []bool{T, T, T}, 1},
+		{"two more go up again", []bool{T, T, T, T}, 5, 4, 4, []bool{T, T, T, T}, 1},
+		{"momentum under 60% holds it back", []bool{F, F, F, T, T}, 1, 2, 2, []bool{F, F, F, T, T}, 0.4},
+		{"exactly 60% is enough", []bool{F, F, F, T, T, T}, 2, 3, 3, []bool{F, F, T, T, T}, 0.6},
+		{"the window keeps the last 5", []bool{F, T, T, T, T, T, T}, 5, 6, 6, []bool{T, T, T, T, T}, 1},
+		{"1 is the floor", []bool{F, F, F, F}, 1, 0, 0, []bool{F, F, F, F}, 0},
+		{"max streak stays after a miss", []bool{T, T, T, F}, 3, 0, 3, []bool{T, T, T, F}, 0.75},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := play(tt.answers...)
+			if s.CurrentDifficulty != tt.difficulty {
+				t.Errorf("difficulty %d, want %d", s.CurrentDifficulty, tt.difficulty)
+			}
+			if s.Streak != tt.streak || s.MaxStreak != tt.maxStreak {
+				t.Errorf("streak %d max %d, want %d max %d", s.Streak, s.MaxStreak, tt.streak, tt.maxStreak)
+			}
+			if !slices.Equal(s.CorrectWindow, tt.window) {
+				t.Errorf("window %v, want %v", s.CorrectWindow, tt.window)
+			}
+			if s.MomentumScore != tt.momentum {
+				t.Errorf("momentum %v, want %v", s.MomentumScore, tt.momentum)
+			}
+		})
+	}
+}
+
+func TestDifficultyCeiling(t *testing.T) {
+	answers := make([]bool, 40)
+	for i := range answers {
+		answers[i] = true
+	}
+	if s := play(answers...); s.CurrentDifficulty != maxDifficulty {
+		t.Fatalf("40 correct answers end at %d, want %d", s.CurrentDifficulty, maxDifficulty)
+	}
+}
+
+func TestCalculateScore(t *testing.T) {
+	tests := []struct {
+		difficulty int
+		correct    bool
+		streak     int
+		want       float64
+	}{
+		{3, false, 0, 0},
+		{10, false, 12, 0},
+		{1, true, 0, 10},
+		{3, true, 1, 33},
+		{5, true, 10, 100},
+		{10, true, 39, 490},
+		{10, true, 40, 500},
+		{10, true, 1000, 500},
+		{7, true, 41, 350},
+	}
+	for _, tt := range tests {
+		got := calculateScore(tt.difficulty, tt.correct, tt.streak, defaults)
+		if diff := got - tt.want; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("calculateScore(%d, %v, %d) = %v, want %v", tt.difficulty, tt.correct, tt.streak, got, tt.want)
+		}
+	}
+}
+
+// random states and answers, the invariants have to hold after every step and
+// the state passed in is never touched
+func TestAlgorithmProperties(t *testing.T) {
+	rng := rand.New(rand.NewSource(46))
+	for range 2000 {
+		s := models.UserState{
+			CurrentDifficulty: minDifficulty + rng.Intn(maxDifficulty),
+			Streak:            rng.Intn(100),
+			ConsecutiveUp:     rng.Intn(defaults.CorrectStreakToUp + 1),
+		}
+		for range rng.Intn(defaults.RollingWindowSize + 1) {
+			s.CorrectWindow = append(s.CorrectWindow, rng.Intn(2) == 0)
+		}
+
+		for range 50 {
+			correct := rng.Intn(2) == 0
+			before := s.CurrentDifficulty
+			window := slices.Clone(s.CorrectWindow)
+
+			next := applyAdaptiveAlgorithm(s, correct, defaults)
+			delta := calculateScore(before, correct, next.Streak, defaults)
+			if err := checkInvariants(next, before, correct, delta, defaults); err != nil {
+				t.Fatal(err)
+			}
+			if m := streakMultiplier(next.Streak, defaults); m > 5 {
+				t.Fatalf("multiplier %v at streak %d", m, next.Streak)
+			}
+			if d := next.CurrentDifficulty - before; d < -1 || d > 1 {
+				t.Fatalf("difficulty moved %d in one answer", d)
+			}
+			if correct && next.CurrentDifficulty < before || !correct && next.CurrentDifficulty > before {
+				t.Fatalf("correct=%v moved difficulty from %d to %d", correct, before, next.CurrentDifficulty)
+			}
+			if s.CurrentDifficulty != before || !slices.Equal(s.CorrectWindow, window) {
+				t.Fatal("applyAdaptiveAlgorithm changed the state it was given")
+			}
+			s = next
+		}
+	}
+}
@@ -3,11 +3,17 @@ package quiz
 import (
 	"context"
 	"errors"
+	"log"
 	"server/internal/auth"
 	"server/internal/models"
+	"server/internal/server"
+	"server/internal/store"
+	"strconv"
+	"strings"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
@@ -15,41 +21,38 @@ import (
 const (
 	NO_QUESTIONS     = "no questions at this difficulty"
 	VERSION_CONFLICT = "version conflict"
+	DUPLICATE_ANSWER = "answer already submitted"
+
+	SEASON_NOT_FOUND = "season not found"
+	SEASON_RUNNING   = "a season is already running"
+	SEASON_FINALIZED = "season already finalized"
+	SEASON_NOT_ENDED = "season still running"
 )
 
-func (s *Server) getUserState(username string) (*models.UserState, error) {
+func (s *Server) getUserState(userID string) (*models.UserState, error) {
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
 	defer cancel()
-	var result models.UserState
-
-	err := s.CollUserState.FindOne(ctx, bson.M{"_id": username}).Decode(&result)
-
-	if err == mongo.ErrNoDocuments {
+	result, err := s.States.Get(ctx, userID)
+	if err == store.ErrNotFound {
 		return nil, errors.New(auth.USER_NOT_FOUND)
 	}
 	if err != nil {
 		return nil, err
 	}
 
-	return &result, nil
+	return result, nil
 
 }
 
 func (s *Server) GetQuestions(diff int) (*[]models.Question, error) {
-	var questions []models.Question
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
 	defer cancel()
 
-	cursor, err := s.CollQuestions.Find(ctx, bson.M{
-		"difficulty": diff,
-	})
+	questions, err := s.Questions.AtDifficulty(ctx, diff)
 	if err != nil {
 		return nil, err
 	}
-	if err := cursor.All(ctx, &questions); err != nil {
-		return nil, err
-	}
 
 	return &questions, nil
 }
@@ -61,7 +64,7 @@ func (s *Server) GetQuestions(diff int) (*[]models.Question, error) {
 // 	result, err := s.CollUserState.UpdateOne(
 // 		ctx,
 // 		bson.M{
-// 			"_id":          username,
+// 			"_id":          userID,
 // 			"stateVersion": expectedVersion,
 // 		},
 // 		bson.M{"$set": newState},
@@ -77,62 +80,542 @@ func (s *Server) GetQuestions(diff int) (*[]models.Question, error) {
 
 // }
 
-func (s *Server) updateUserState(username string, newState models.UserState, expectedVersion int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// saveAnswer writes the new state and the answer log in one transaction, so a
+// crash cant leave a score without its log. The unique userId+ikey key decides
+// between two submissions of the same answer, the loser gets DUPLICATE_ANSWER
+// and nothing of it is written.
+func (s *Server) saveAnswer(newState models.UserState, expectedVersion int, entry models.AnswerLog) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
 	defer cancel()
 
-	result, err := s.CollUserState.UpdateOne(
-		ctx,
-		bson.M{
-			"_id":          username,
-			"stateVersion": expectedVersion,
-		},
-		bson.M{"$set": newState},
-	)
-	if err != nil {
+	return s.DB.Transaction(ctx, func(ctx context.Context) error {
+		// the log goes first so a retry of the same answer runs into the key
+		// before it can run into the version
+		if err := s.Answers.Insert(ctx, entry); err != nil {
+			if err == store.ErrDuplicate {
+				return errors.New(DUPLICATE_ANSWER)
+			}
+			return err
+		}
+
+		err := s.States.Save(ctx, newState, expectedVersion)
+		if err == store.ErrNotFound {
+			return errors.New(VERSION_CONFLICT)
+		}
 		return err
+	})
+}
+
+// getAnswerLog finds the users stored answer for an idempotency key
+func (s *Server) getAnswerLog(userID string, ikey string) (*models.AnswerLog, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	return s.Answers.Get(ctx, userID, ikey)
+}
+
+func servedKey(userID string) string {
+	return "served:" + userID
+}
+
+// markServed remembers which question the user was last given and when, so the
+// answer can record how long it took. Only the last one is kept.
+func (s *Server) markServed(ctx context.Context, userID string, questionID string) {
+	value := questionID + " " + strconv.FormatInt(time.Now().UnixMilli(), 10)
+	if err := s.Cache.Set(ctx, servedKey(userID), []byte(value), time.Hour); err != nil {
+		log.Println("served error:", err)
 	}
-	if result.MatchedCount == 0 {
-		return errors.New(VERSION_CONFLICT)
+}
+
+// servedAt is when questionID was served to the user, zero when it wasnt the
+// last question served (or the cache lost it)
+func (s *Server) servedAt(ctx context.Context, userID string, questionID string) time.Time {
+	value, err := s.Cache.Get(ctx, servedKey(userID))
+	if err != nil {
+		return time.Time{}
 	}
-	return nil
+	id, at, ok := strings.Cut(string(value), " ")
+	if !ok || id != questionID {
+		return time.Time{}
+	}
+	ms, err := strconv.ParseInt(at, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms).UTC()
+}
+
+// responseTime is how many ms it took to answer, 0 when the serve isnt known
+func responseTime(servedAt time.Time, answeredAt time.Time) int64 {
+	if servedAt.IsZero() {
+		return 0
+	}
+	return max(answeredAt.Sub(servedAt).Milliseconds(), 1)
 }
 
-func (s *Server) updateLeaderboards(username string, state models.UserState) {
-	// Upsert score leaderboard
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// logFallback notes a board read the boards couldnt answer, the store answers
+// it instead
+func logFallback(doing string, err error) {
+	log.Println("leaderboard error, "+doing+" in the store:", err)
+}
+
+func (s *Server) updateLeaderboards(state models.UserState, scoreDelta float64, at time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
 	defer cancel()
 
-	s.CollUserState.UpdateOne(ctx,
-		bson.M{"_id": username},
-		bson.M{"$set": bson.M{
-			"totalScore": state.TotalScore,
-			"maxStreak":  state.MaxStreak,
-			"updatedAt":  time.Now().UTC(),
-		}},
+	// redis is only a copy, rebuild-leaderboards fixes whatever gets missed here
+	if err := s.UpdateLeaderboards(ctx, state); err != nil {
+		log.Println("leaderboard error:", err)
+	}
+	if err := s.RecordPeriodLeaderboards(ctx, state, scoreDelta, at); err != nil {
+		log.Println("leaderboard error:", err)
+	}
+	if err := s.UpdateSeasonLeaderboard(ctx, state); err != nil {
+		log.Println("leaderboard error:", err)
+	}
+}
 
-		options.UpdateOne().SetUpsert(true),
-	)
+// boardView is one board over one period, ie the weekly score board
+type boardView struct {
+	board  server.Board
+	period string
+	season string // season id when period is season
+}
+
+func allTime(board server.Board) boardView {
+	return boardView{board: board, period: server.PERIOD_ALL}
+}
 
+// fromState is true for views whose values are kept on user-state, the others
+// are summed from answer-logs
+func (v boardView) fromState() bool {
+	return v.period == server.PERIOD_ALL || v.period == server.PERIOD_SEASON
 }
 
-// getLeaderboardRanks returns (scoreRank, streakRank) for the given username
-// Rank = count of users with strictly higher value + 1
-func (s *Server) getLeaderboardRanks(username string) (int, int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// stateValue is the value of a state on a view kept on user-state, false when
+// the state isnt listed on it
+func stateValue(v boardView, state models.UserState) (float64, bool) {
+	if v.period == server.PERIOD_SEASON {
+		if state.SeasonID != v.season {
+			return 0, true
+		}
+		return state.SeasonScore, true
+	}
+	return v.board.Value(state)
+}
+
+// queries reads a view from the store when the boards cant answer
+func (s *Server) queries(v boardView) store.BoardQueries {
+	switch v.period {
+	case server.PERIOD_SEASON:
+		return s.States.Board(store.StateBoard{Name: server.PERIOD_SEASON, Field: "seasonScore", SeasonID: v.season})
+	case server.PERIOD_ALL:
+		b := v.board
+		return s.States.Board(store.StateBoard{Name: b.Name, Field: b.Field, MinAnswered: b.MinAnswered})
+	}
+	return s.Answers.PeriodBoard(s.PeriodStart(v.period, time.Now()), v.board.Name == server.BOARD_STREAK)
+}
+
+func (s *Server) viewKey(v boardView) string {
+	if v.period == server.PERIOD_SEASON {
+		return server.SeasonLeaderboardKey(v.season)
+	}
+	return s.LeaderboardKey(v.board.Name, v.period, time.Now())
+}
+
+// periodTotal sums one users answers in the period of a view, nil if they
+// have none. With public set users that arent listed have none either.
+func (s *Server) periodTotal(ctx context.Context, v boardView, userID string, public bool) (*models.PeriodTotal, error) {
+	q := store.PeriodQuery{Start: s.PeriodStart(v.period, time.Now()), UserIDs: []string{userID}, Public: public}
+	var total *models.PeriodTotal
+	err := s.Answers.PeriodTotals(ctx, q, func(t models.PeriodTotal) error {
+		total = &t
+		return nil
+	})
+	return total, err
+}
+
+// periodRow is a period total as it is listed on a view
+func periodRow(v boardView, t models.PeriodTotal) store.BoardRow {
+	return store.PeriodRow(t, v.board.Name == server.BOARD_STREAK)
+}
+
+// getBoardValue returns the users value on a view, false when they dont
+// qualify for it (not enough answers, never played the topic)
+func (s *Server) getBoardValue(v boardView, state models.UserState) (float64, bool, error) {
+	if v.fromState() {
+		value, ok := stateValue(v, state)
+		return value, ok, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
 	defer cancel()
 
-	state, err := s.getUserState(username)
+	// users kept off the boards arent in the period sets, their own answers
+	// still count for what they see
+	if !server.Listed(state) {
+		total, err := s.periodTotal(ctx, v, state.UserID, false)
+		if err != nil || total == nil {
+			return 0, true, err
+		}
+		return periodRow(v, *total).Value, true, nil
+	}
+
+	value, err := s.LeaderboardValue(ctx, s.viewKey(v), state.UserID)
+	if err == nil {
+		return value, true, nil
+	}
+	logFallback("summing", err)
+
+	total, err := s.periodTotal(ctx, v, state.UserID, true)
+	if err != nil || total == nil {
+		return 0, true, err
+	}
+	return periodRow(v, *total).Value, true, nil
+}
+
+// getLeaderboardRank returns the rank a value has on a view under a ranking
+// (server.RANK_STANDARD or server.RANK_DENSE). Guests get the rank they would
+// have without showing up for anyone else.
+func (s *Server) getLeaderboardRank(v boardView, value float64, ranking string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	rank, err := s.LeaderboardRank(ctx, s.viewKey(v), value, ranking)
+	if err == nil {
+		return rank, nil
+	}
+	logFallback("counting", err)
+
+	above, err := s.queries(v).Above(ctx, value, ranking == server.RANK_DENSE)
+	if err != nil {
+		return 0, err
+	}
+	return above + 1, nil
+}
+
+// getLeaderboardRanks returns the all time (scoreRank, streakRank) for the given state
+func (s *Server) getLeaderboardRanks(state models.UserState) (int, int, error) {
+	scoreRank, err := s.getLeaderboardRank(allTime(server.ScoreBoard), state.TotalScore, server.RANK_STANDARD)
+	if err != nil {
+		return 0, 0, err
+	}
+	streakRank, err := s.getLeaderboardRank(allTime(server.StreakBoard), float64(state.MaxStreak), server.RANK_STANDARD)
 	if err != nil {
 		return 0, 0, err
 	}
 
-	scoreRank, _ := s.CollUserState.CountDocuments(ctx, bson.M{
-		"totalScore": bson.M{"$gt": state.TotalScore},
-	})
-	streakRank, _ := s.CollUserState.CountDocuments(ctx, bson.M{
-		"maxStreak": bson.M{"$gt": state.MaxStreak},
+	return scoreRank, streakRank, nil
+}
+
+// boardRow is one listed user on a view
+type boardRow struct {
+	UserID   string
+	Username string
+	Value    float64
+	Position int // 0 based, rows left out for deleted users leave a gap
+}
+
+// getBoardPage returns up to limit public users on a view starting at the
+// 0 based position offset, and how many positions it went through. That can
+// be more than the rows, deleted users are left out.
+func (s *Server) getBoardPage(v boardView, offset int, limit int) ([]boardRow, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	page, err := s.LeaderboardRange(ctx, s.viewKey(v), offset, limit)
+	if err != nil {
+		logFallback("sorting", err)
+		rows, err := listedRows(s.queries(v).Page(ctx, offset, limit))
+		// the store only lists users that are still there, there are no gaps
+		for i := range rows {
+			rows[i].Position = offset + i
+		}
+		return rows, len(rows), err
+	}
+
+	rows, err := s.namedRows(ctx, page)
+	return rows, len(page), err
+}
+
+// namedRows puts the usernames to board entries, the boards only hold ids.
+// Users deleted from the store but still on a board are left out.
+func (s *Server) namedRows(ctx context.Context, entries []store.BoardEntry) ([]boardRow, error) {
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.UserID)
+	}
+	found, err := s.States.GetMany(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(found))
+	for _, st := range found {
+		names[st.UserID] = st.Username
+	}
+
+	rows := make([]boardRow, 0, len(entries))
+	for _, entry := range entries {
+		name, ok := names[entry.UserID]
+		if !ok {
+			continue
+		}
+		rows = append(rows, boardRow{UserID: entry.UserID, Username: name, Value: entry.Value, Position: entry.Position})
+	}
+	return rows, nil
+}
+
+// listedRows turns the rows the store read into view rows
+func listedRows(listed []store.BoardRow, err error) ([]boardRow, error) {
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]boardRow, 0, len(listed))
+	for _, row := range listed {
+		rows = append(rows, boardRow{UserID: row.UserID, Username: row.Username, Value: row.Value})
+	}
+	return rows, nil
+}
+
+// getBoardMembers returns which of the given users are listed on a view, in
+// board order
+func (s *Server) getBoardMembers(v boardView, userIDs []string) ([]boardRow, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	listed, err := s.LeaderboardMembers(ctx, s.viewKey(v), userIDs)
+	if err != nil {
+		logFallback("sorting", err)
+		return listedRows(s.queries(v).Members(ctx, userIDs))
+	}
+
+	return s.namedRows(ctx, listed)
+}
+
+// getBoardPosition returns the 0 based position of the user in the view order.
+// Users that arent listed (guests) get the position their value would have,
+// ahead of everyone they tie with.
+func (s *Server) getBoardPosition(v boardView, state models.UserState, value float64) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	pos, listed, err := s.LeaderboardPosition(ctx, s.viewKey(v), state.UserID)
+	if err == nil {
+		if listed {
+			return pos, nil
+		}
+		rank, err := s.getLeaderboardRank(v, value, server.RANK_STANDARD)
+		return rank - 1, err
+	}
+	logFallback("counting", err)
+
+	var at time.Time
+	switch {
+	case v.period == server.PERIOD_SEASON:
+		at = state.AchievedAt[server.PERIOD_SEASON]
+	case v.period == server.PERIOD_ALL:
+		at = v.board.AchievedAt(state)
+	default:
+		total, err := s.periodTotal(ctx, v, state.UserID, true)
+		if err != nil {
+			return 0, err
+		}
+		if total != nil {
+			at = periodRow(v, *total).At
+		}
+	}
+	return s.queries(v).Before(ctx, value, at, state.UserID)
+}
+
+func (s *Server) startSeason(name string) (*models.Season, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	season := models.Season{
+		Id:        uuid.NewString(),
+		Name:      name,
+		Active:    true,
+		StartedAt: time.Now().UTC(),
+	}
+	if _, err := s.CollSeasons.InsertOne(ctx, season); err != nil {
+		// the partial unique index only allows one active season
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, errors.New(SEASON_RUNNING)
+		}
+		return nil, err
+	}
+
+	return &season, nil
+}
+
+func (s *Server) getSeason(seasonID string) (*models.Season, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	var season models.Season
+	err := s.CollSeasons.FindOne(ctx, bson.M{"_id": seasonID}).Decode(&season)
+	if err == mongo.ErrNoDocuments {
+		return nil, errors.New(SEASON_NOT_FOUND)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &season, nil
+}
+
+// stopSeason makes the season inactive so answers stop counting towards it
+func (s *Server) stopSeason(seasonID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	_, err := s.CollSeasons.UpdateOne(ctx,
+		bson.M{"_id": seasonID, "active": true},
+		bson.M{"$set": bson.M{"active": false, "endedAt": time.Now().UTC()}},
+	)
+	return err
+}
+
+// seasonTitle is the badge for a final rank, empty past the top 10
+func seasonTitle(rank int) string {
+	switch {
+	case rank == 1:
+		return "champion"
+	case rank <= 3:
+		return "podium"
+	case rank <= 10:
+		return "top 10"
+	}
+	return ""
+}
+
+// finalizeSeason archives the final standings of a stopped season, awards badges
+// and resets season scores. Lifetime TotalScore is never touched. Every write
+// is keyed or guarded so a failed run can be repeated.
+func (s *Server) finalizeSeason(season models.Season) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.BatchTimeout)
+	defer cancel()
+
+	// the season is stopped so the board holds still while it is paged through
+	board := s.States.Board(store.StateBoard{Name: server.PERIOD_SEASON, Field: "seasonScore", SeasonID: season.Id})
+
+	const batchSize = 500
+	// competition ranking, tied scores share a rank. the board order only
+	// decides which of the tied users is paged first, getStandings lists them
+	// by rank then _id so the standings come out the same either way
+	count, rank := 0, 0
+	lastScore := -1.0
+	now := time.Now().UTC()
+	for {
+		page, err := board.Page(ctx, count, batchSize)
+		if err != nil {
+			return count, err
+		}
+
+		writes := make([]mongo.WriteModel, 0, len(page))
+		for _, row := range page {
+			if row.Value <= 0 {
+				break
+			}
+			count++
+			if row.Value != lastScore {
+				rank = count
+				lastScore = row.Value
+			}
+
+			standing := models.SeasonStanding{
+				Id:       season.Id + ":" + row.UserID,
+				SeasonID: season.Id,
+				UserID:   row.UserID,
+				Username: row.Username,
+				Rank:     rank,
+				Score:    row.Value,
+				Title:    seasonTitle(rank),
+			}
+			writes = append(writes, mongo.NewReplaceOneModel().
+				SetFilter(bson.M{"_id": standing.Id}).
+				SetReplacement(standing).
+				SetUpsert(true))
+
+			if standing.Title != "" {
+				if err := s.Users.AwardBadge(ctx, row.UserID, models.Badge{
+					SeasonID:   season.Id,
+					SeasonName: season.Name,
+					Title:      standing.Title,
+					Rank:       rank,
+					AwardedAt:  now,
+				}); err != nil {
+					return count, err
+				}
+			}
+		}
+		if len(writes) > 0 {
+			if _, err := s.CollStandings.BulkWrite(ctx, writes); err != nil {
+				return count, err
+			}
+		}
+		// a short page is the end of the board, a short batch the end of the scores
+		if len(page) < batchSize || len(writes) < len(page) {
+			break
+		}
+	}
+
+	err := s.States.ResetSeason(ctx, season.Id, func(userIDs []string) error {
+		// a cached state would still show the old season until it expires
+		keys := make([]string, len(userIDs))
+		for i, userID := range userIDs {
+			keys[i] = "user_state:" + userID
+		}
+		if err := s.DeleteCachedStates(ctx, keys...); err != nil {
+			log.Println("cache error:", err)
+		}
+		return nil
 	})
+	if err != nil {
+		return count, err
+	}
 
-	return int(scoreRank) + 1, int(streakRank) + 1, nil
+	if err := s.Boards.Delete(ctx, server.SeasonLeaderboardKey(season.Id)); err != nil {
+		log.Println("leaderboard error:", err)
+	}
+
+	_, err = s.CollSeasons.UpdateOne(ctx,
+		bson.M{"_id": season.Id},
+		bson.M{"$set": bson.M{"finalized": true}},
+	)
+	return count, err
+}
+
+func (s *Server) listSeasons() ([]models.Season, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	cursor, err := s.CollSeasons.Find(ctx, bson.M{},
+		options.Find().SetSort(bson.M{"startedAt": -1}))
+	if err != nil {
+		return nil, err
+	}
+	seasons := []models.Season{}
+	if err := cursor.All(ctx, &seasons); err != nil {
+		return nil, err
+	}
+	return seasons, nil
+}
+
+func (s *Server) getStandings(seasonID string, offset int, limit int) ([]models.SeasonStanding, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	cursor, err := s.CollStandings.Find(ctx, bson.M{"seasonId": seasonID},
+		options.Find().
+			SetSort(bson.D{{Key: "rank", Value: 1}, {Key: "_id", Value: 1}}).
+			SetSkip(int64(offset)).
+			SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	standings := []models.SeasonStanding{}
+	if err := cursor.All(ctx, &standings); err != nil {
+		return nil, err
+	}
+	return standings, nil
 }
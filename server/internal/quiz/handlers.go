@@ -2,20 +2,24 @@ package quiz
 
 import (
 	"context"
+	"encoding/json"
 	"log"
+	"maps"
 	"math/rand"
 	"net/http"
 	"server/internal/models"
+	"server/internal/server"
+	"server/internal/store"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
 type NextQuestionRes struct {
 	QuestionID    string   `json:"questionId"`
 	Difficulty    int      `json:"difficulty"`
+	Topic         string   `json:"topic,omitempty"`
 	Prompt        string   `json:"prompt"`
 	Choices       []string `json:"choices"`
 	StateVersion  int      `json:"stateVersion"`
@@ -23,6 +27,16 @@ type NextQuestionRes struct {
 	CurrentStreak int      `json:"currentStreak"`
 }
 
+const (
+	KEY_REUSED  = "idempotency key already used for a different answer"
+	KEY_EXPIRED = "idempotency key expired"
+
+	// how long a repeated key gets the original response back
+	IdempotencyKeyTTL = 24 * time.Hour
+
+	jsonContentType = "application/json; charset=utf-8"
+)
+
 type SubmitAnswerReq struct {
 	QuestionID           string `json:"questionId"         binding:"required"`
 	Answer               string `json:"answer"             binding:"required"`
@@ -42,28 +56,13 @@ type SubmitAnswerRes struct {
 }
 
 func (s *Server) HandleNextQuestion(c *gin.Context) {
-	username := c.GetString("username")
+	userID := c.GetString("userId")
 
-	// get the users state
-	key := "user_state:" + username
-	// try cache
-	var state *models.UserState
-
-	cachedState, err := s.GetCachedState(c.Request.Context(), key)
-	if err == nil && cachedState != nil {
-		state = cachedState
-	} else {
-		// no cache, load from db
-		dbState, err := s.getUserState(username)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load state " + err.Error()})
-			return
-		}
-
-		state = dbState
-
-		// cache it
-		_ = s.CacheState(c.Request.Context(), *state, key)
+	// get the users state, cache first
+	state, err := s.loadState(c, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load state " + err.Error()})
+		return
 	}
 
 	// get all the questions at current difficulty
@@ -77,10 +76,12 @@ func (s *Server) HandleNextQuestion(c *gin.Context) {
 
 	// pick a random guy, not the lasr asked question tho
 	q := pickQuestion(*questions, state.LastQuestionID)
+	s.markServed(c.Request.Context(), userID, q.Id)
 
 	c.JSON(http.StatusOK, NextQuestionRes{
 		QuestionID:    q.Id,
 		Difficulty:    q.Difficulty,
+		Topic:         q.Topic,
 		Prompt:        q.Prompt,
 		Choices:       q.Choices,
 		StateVersion:  state.StateVersion,
@@ -91,7 +92,7 @@ func (s *Server) HandleNextQuestion(c *gin.Context) {
 }
 
 func (s *Server) SubmitAnswer(c *gin.Context) {
-	username := c.GetString("username")
+	userID := c.GetString("userId")
 
 	var req SubmitAnswerReq
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -99,50 +100,27 @@ func (s *Server) SubmitAnswer(c *gin.Context) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
 	defer cancel()
 
 	// // reject duplicate submissions
-	var existing models.AnswerLog
-	err := s.CollAnswerLog.FindOne(ctx, bson.M{"idempotencyKey": req.AnswerIdempotencyKey}).Decode(&existing)
+	existing, err := s.getAnswerLog(userID, req.AnswerIdempotencyKey)
 	if err == nil {
 		// Already processed — return the stored result idempotently
-		rankScore, rankStreak, err := s.getLeaderboardRanks(username)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": " coulend get leaderboard " + err.Error()})
-		}
-		c.JSON(http.StatusOK, SubmitAnswerRes{
-			Correct:               existing.Correct,
-			NewDifficulty:         existing.Difficulty,
-			NewStreak:             existing.StreakAtAnswer,
-			ScoreDelta:            existing.ScoreDelta,
-			TotalScore:            existing.ScoreDelta,
-			StateVersion:          req.StateVersion,
-			LeaderboardRankScore:  rankScore,
-			LeaderboardRankStreak: rankStreak,
-		})
+		s.replayAnswer(c, userID, *existing, req)
+		return
+	}
+	if err != store.ErrNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check answer"})
 		return
 	}
 
 	// get state from redis
-	var state *models.UserState
-	key := "user_state:" + username
-
-	cachedState, err := s.GetCachedState(c.Request.Context(), key)
-	if err == nil && cachedState != nil {
-		state = cachedState
-	} else {
-		// no cache, load from db
-		dbState, err := s.getUserState(username)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load state " + err.Error()})
-			return
-		}
-
-		state = dbState
-
-		// cache it
-		_ = s.CacheState(c.Request.Context(), *state, key)
+	key := "user_state:" + userID
+	state, err := s.loadState(c, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load state " + err.Error()})
+		return
 	}
 
 	// versin check and get state
@@ -154,8 +132,8 @@ func (s *Server) SubmitAnswer(c *gin.Context) {
 	}
 
 	// get the question
-	var q models.Question
-	if err := s.CollQuestions.FindOne(ctx, bson.M{"_id": req.QuestionID}).Decode(&q); err != nil {
+	q, err := s.Questions.Get(ctx, req.QuestionID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "question not found"})
 		return
 	}
@@ -163,13 +141,38 @@ func (s *Server) SubmitAnswer(c *gin.Context) {
 	// correctness
 	correct := req.Answer == q.CorrectAnswer
 
-	// new difficulty + updated state
-
-	newState := applyAdaptiveAlgorithm(*state, correct)
+	// new difficulty + updated state, both with the same params version even
+	// if an update lands in between
+	params := s.Algorithm()
+	algorithm := params.Params
+	// users in a running experiment play on their arms params
+	var experimentID string
+	var variant *models.Variant
+	if e := s.RunningExperiment(); e != nil {
+		experimentID, variant = e.Id, server.VariantFor(*e, userID)
+		if variant != nil && variant.Params != nil {
+			algorithm = *variant.Params
+		}
+	}
+	newState := applyAdaptiveAlgorithm(*state, correct, algorithm)
 
 	//score delta
-	scoreDelta := calculateScore(q.Difficulty, correct, newState.Streak)
+	scoreDelta := calculateScore(q.Difficulty, correct, newState.Streak, algorithm)
 	newState.TotalScore += scoreDelta
+
+	// season score starts over the first time a user answers in a new season
+	season, err := s.CurrentSeason(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load season"})
+		return
+	}
+	if season != nil {
+		if newState.SeasonID != season.Id {
+			newState.SeasonID = season.Id
+			newState.SeasonScore = 0
+		}
+		newState.SeasonScore += scoreDelta
+	}
 	newState.LastQuestionID = req.QuestionID
 	newState.LastAnswerAt = time.Now().UTC()
 	newState.StateVersion = state.StateVersion + 1
@@ -177,53 +180,57 @@ func (s *Server) SubmitAnswer(c *gin.Context) {
 	if correct {
 		newState.TotalCorrect++
 	}
-
-	// update the state in db
-
-	err = s.updateUserState(username, newState, state.StateVersion)
-	if err != nil {
-		if err.Error() == VERSION_CONFLICT {
-			c.JSON(http.StatusConflict, gin.H{"error": "version conflict"})
-			return
+	newState.Accuracy = server.Accuracy(newState)
+	newState.MaxDifficulty = max(newState.MaxDifficulty, newState.CurrentDifficulty)
+	if q.Topic != "" {
+		// copied so the cached state we loaded isnt changed under us
+		newState.TopicScores = maps.Clone(newState.TopicScores)
+		if newState.TopicScores == nil {
+			newState.TopicScores = map[string]float64{}
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save state"})
-		return
-	}
-	// update state in redis
-	err = s.CacheState(c.Request.Context(), newState, key)
-	if err != nil {
-		log.Println("cache error:", err)
-		// dontr return tho
+		newState.TopicScores[q.Topic] += scoreDelta
 	}
+	server.MarkAchievements(*state, &newState, newState.LastAnswerAt)
 
 	// // answers log
-	log := models.AnswerLog{
+	servedAt := s.servedAt(ctx, userID, req.QuestionID)
+	entry := models.AnswerLog{
 		Id:             uuid.NewString(),
-		Username:       username,
+		UserID:         userID,
+		Username:       state.Username,
 		QuestionID:     req.QuestionID,
 		Difficulty:     q.Difficulty,
+		Topic:          q.Topic,
 		Answer:         req.Answer,
 		Correct:        correct,
 		ScoreDelta:     scoreDelta,
 		StreakAtAnswer: newState.Streak,
 		IdempotencyKey: req.AnswerIdempotencyKey,
-		AnsweredAt:     time.Now().UTC(),
+		SessionID:      c.GetString("sessionId"),
+		ServedAt:       servedAt,
+		ResponseMs:     responseTime(servedAt, newState.LastAnswerAt),
+		AnsweredAt:     newState.LastAnswerAt,
+	}
+	if variant != nil {
+		entry.ExperimentID, entry.Variant = experimentID, variant.Name
+	}
+	// an arm with its own params didnt score it on the stored version, its
+	// answers stay out of everything split by version
+	if variant == nil || variant.Params == nil {
+		entry.ParamsVersion = params.Version
 	}
 
-	s.CollAnswerLog.InsertOne(ctx, log) // write to db
-
-	//update leaderboa5rd
-	s.updateLeaderboards(username, newState)
-
-	// get rank
-	rankScore, rankStreak, err := s.getLeaderboardRanks(username)
+	// ranks only count users strictly above, and neither value can go down,
+	// so they are the same before and after this answer is on the boards
+	rankScore, rankStreak, err := s.getLeaderboardRanks(newState)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get rank"})
 		return
 
 	}
 
-	c.JSON(http.StatusOK, SubmitAnswerRes{
+	// the exact response is stored with the log so a replay gets the same bytes
+	body, err := json.Marshal(SubmitAnswerRes{
 		Correct:               correct,
 		NewDifficulty:         newState.CurrentDifficulty,
 		NewStreak:             newState.Streak,
@@ -233,6 +240,110 @@ func (s *Server) SubmitAnswer(c *gin.Context) {
 		LeaderboardRankScore:  rankScore,
 		LeaderboardRankStreak: rankStreak,
 	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encode response"})
+		return
+	}
+	entry.Response = body
+
+	// state and log go in together, a duplicate key means the same answer got
+	// in first from another request
+	err = s.saveAnswer(newState, state.StateVersion, entry)
+	if err != nil {
+		switch err.Error() {
+		case VERSION_CONFLICT:
+			c.JSON(http.StatusConflict, gin.H{"error": "version conflict"})
+		case DUPLICATE_ANSWER:
+			existing, err := s.getAnswerLog(userID, req.AnswerIdempotencyKey)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load answer"})
+				return
+			}
+			s.replayAnswer(c, userID, *existing, req)
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save state"})
+		}
+		return
+	}
+	// update state in redis
+	err = s.CacheState(c.Request.Context(), newState, key)
+	if err != nil {
+		log.Println("cache error:", err)
+		// dontr return tho
+	}
+
+	if variant != nil {
+		if err := s.LogExposure(ctx, experimentID, variant.Name, userID, newState.LastAnswerAt); err != nil {
+			log.Println("exposure error:", err)
+		}
+	}
+
+	//update leaderboa5rd
+	s.updateLeaderboards(newState, scoreDelta, newState.LastAnswerAt)
+	s.publishUpdate(userID, newState.LastAnswerAt)
+
+	c.Data(http.StatusOK, jsonContentType, body)
+}
+
+// replayAnswer answers a submission that was already processed. Keys are
+// per user and replay for IdempotencyKeyTTL, after that (or for a different
+// answer) the key is only reported as used.
+func (s *Server) replayAnswer(c *gin.Context, userID string, existing models.AnswerLog, req SubmitAnswerReq) {
+	if existing.QuestionID != req.QuestionID || existing.Answer != req.Answer {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": KEY_REUSED})
+		return
+	}
+	if time.Since(existing.AnsweredAt) > IdempotencyKeyTTL {
+		c.JSON(http.StatusConflict, gin.H{"error": KEY_EXPIRED})
+		return
+	}
+	if existing.Response != nil {
+		c.Data(http.StatusOK, jsonContentType, existing.Response)
+		return
+	}
+
+	// answers from before responses were stored, the totals are the current ones
+	state, err := s.loadState(c, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load state " + err.Error()})
+		return
+	}
+	rankScore, rankStreak, err := s.getLeaderboardRanks(*state)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": " coulend get leaderboard " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, SubmitAnswerRes{
+		Correct:               existing.Correct,
+		NewDifficulty:         state.CurrentDifficulty,
+		NewStreak:             existing.StreakAtAnswer,
+		ScoreDelta:            existing.ScoreDelta,
+		TotalScore:            state.TotalScore,
+		StateVersion:          state.StateVersion,
+		LeaderboardRankScore:  rankScore,
+		LeaderboardRankStreak: rankStreak,
+	})
+}
+
+// loadState reads the users state from the cache, falling back to mongo
+func (s *Server) loadState(c *gin.Context, userID string) (*models.UserState, error) {
+	key := "user_state:" + userID
+
+	cachedState, err := s.GetCachedState(c.Request.Context(), key)
+	if err == nil && cachedState != nil {
+		return cachedState, nil
+	}
+
+	// no cache, load from db
+	state, err := s.getUserState(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// cache it
+	_ = s.CacheState(c.Request.Context(), *state, key)
+
+	return state, nil
 }
 
 func pickQuestion(q []models.Question, last string) models.Question {
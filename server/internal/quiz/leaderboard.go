@@ -2,167 +2,441 @@ package quiz
 
 import (
 	"context"
+	"errors"
+	"math"
 	"net/http"
 	"server/internal/models"
-	"time"
+	"server/internal/server"
+	"slices"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	SCOPE_GLOBAL  = "global"
+	SCOPE_FRIENDS = "friends"
+
+	defaultPageSize = 5
+	maxPageSize     = 100
+	maxAroundRadius = 25
 )
 
 type LeaderboardEntry struct {
 	Rank     int     `json:"rank"`
+	UserID   string  `json:"userId"`
 	Username string  `json:"username"`
 	Value    float64 `json:"value"`
 }
 
 type LeaderboardRes struct {
+	Ranking     string             `json:"ranking"`
 	Entries     []LeaderboardEntry `json:"entries"`
 	CurrentUser LeaderboardEntry   `json:"currentUser"`
+	NextOffset  *int               `json:"nextOffset,omitempty"` // missing on the last page
 }
 
-func (s *Server) GetScoreLeaderboard(c *gin.Context) {
-	username := c.GetString("username")
-	key := "user_state:" + username
-	// top 5
-	var states []models.UserState
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// leaderboardPage is what the query asked for, either a page or a window around the caller
+//
+//	?period=weekly      daily, weekly, monthly, season or all (default)
+//	?offset=0&limit=5   page starting at offset (limit max 100)
+//	?around=me&radius=5 radius users above and below the caller (radius max 25)
+//	?rank=dense         standard (default, 1 2 2 4) or dense (1 2 2 3) ranks
+//	?scope=friends      global (default) or only the caller and their friends
+type leaderboardPage struct {
+	period  string
+	ranking string
+	scope   string
+	offset  int
+	limit   int
+	around  bool
+	radius  int
+}
+
+func parseLeaderboardPage(c *gin.Context) (leaderboardPage, error) {
+	page := leaderboardPage{
+		period:  server.PERIOD_ALL,
+		ranking: server.RANK_STANDARD,
+		scope:   SCOPE_GLOBAL,
+		limit:   defaultPageSize,
+		radius:  defaultPageSize,
+	}
+
+	if v := c.Query("period"); v != "" {
+		if !server.ValidPeriod(v) {
+			return page, errors.New("period must be daily, weekly, monthly, season or all")
+		}
+		page.period = v
+	}
+
+	if v := c.Query("rank"); v != "" {
+		if !server.ValidRanking(v) {
+			return page, errors.New("rank must be standard or dense")
+		}
+		page.ranking = v
+	}
+
+	if v := c.Query("scope"); v != "" {
+		if v != SCOPE_GLOBAL && v != SCOPE_FRIENDS {
+			return page, errors.New("scope must be global or friends")
+		}
+		page.scope = v
+	}
 
-	opts := options.Find().
-		SetSort(bson.M{"totalScore": -1}).
-		SetLimit(5)
+	if v := c.Query("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return page, errors.New("offset must be a positive number")
+		}
+		page.offset = n
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			return page, errors.New("limit must be between 1 and " + strconv.Itoa(maxPageSize))
+		}
+		page.limit = n
+	}
 
-	cursor, err := s.CollUserState.Find(ctx, bson.M{}, opts)
+	switch c.Query("around") {
+	case "":
+	case "me":
+		page.around = true
+	default:
+		return page, errors.New("around only supports me")
+	}
+	if v := c.Query("radius"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxAroundRadius {
+			return page, errors.New("radius must be between 0 and " + strconv.Itoa(maxAroundRadius))
+		}
+		page.radius = n
+	}
+
+	return page, nil
+}
+
+type TopicsRes struct {
+	Topics []string `json:"topics"`
+}
+
+// GetLeaderboard serves every board, the fixed ones on /leaderboard/:board
+// (score, streak, accuracy, difficulty) and topics on /leaderboard/topic/:topic
+func (s *Server) GetLeaderboard(c *gin.Context) {
+	board, ok := s.boardFromPath(c)
+	if !ok {
+		return
+	}
+	view, page, ok := s.leaderboardView(c, board)
+	if !ok {
+		return
+	}
+
+	state, err := s.loadState(c, c.GetString("userId"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch leaderboard"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load state " + err.Error()})
 		return
 	}
-	cursor.All(ctx, &states)
 
-	entries := make([]LeaderboardEntry, 0, len(states))
-	for i, st := range states {
-		entries = append(entries, LeaderboardEntry{
-			Rank:     i + 1,
-			Username: st.Username,
-			Value:    st.TotalScore,
-		})
+	res, err := s.buildLeaderboard(view, page, *state)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
-	rankScore, _, err := s.getLeaderboardRanks(username)
+	c.JSON(http.StatusOK, res)
+}
+
+// boardFromPath finds the board named by :topic or :board, answering the
+// request itself when there is none
+func (s *Server) boardFromPath(c *gin.Context) (server.Board, bool) {
+	topic := c.Param("topic")
+	if topic == "" {
+		board, ok := server.LookupBoard(c.Param("board"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown leaderboard"})
+		}
+		return board, ok
+	}
+
+	topics, err := s.Topics(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get rank " + err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load topics"})
+		return server.Board{}, false
+	}
+	if !slices.Contains(topics, topic) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown topic"})
+		return server.Board{}, false
+	}
+	return server.TopicBoard(topic), true
+}
+
+func (s *Server) ListTopics(c *gin.Context) {
+	topics, err := s.Topics(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load topics"})
 		return
+	}
+	c.JSON(http.StatusOK, TopicsRes{Topics: topics})
+}
+
+// leaderboardView reads the query for a board, answering the request itself
+// when it is invalid
+func (s *Server) leaderboardView(c *gin.Context, board server.Board) (boardView, leaderboardPage, bool) {
+	page, err := parseLeaderboardPage(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return boardView{}, page, false
+	}
+	view := boardView{board: board, period: page.period}
 
+	if view.period != server.PERIOD_ALL && view.period != server.PERIOD_SEASON && !board.Periodic {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "the " + board.Name + " board is all time only"})
+		return view, page, false
 	}
-	// get state from redis
-	// try cache
-	var state *models.UserState
 
-	cachedState, err := s.GetCachedState(c.Request.Context(), key)
-	if err == nil && cachedState != nil {
-		state = cachedState
-	} else {
-		// no cache, load from db
-		dbState, err := s.getUserState(username)
+	if view.period == server.PERIOD_SEASON {
+		if board.Name != server.BOARD_SCORE {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "seasons only rank score"})
+			return view, page, false
+		}
+		season, err := s.CurrentSeason(c.Request.Context())
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load state " + err.Error()})
-			return
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load season"})
+			return view, page, false
+		}
+		if season == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no season running"})
+			return view, page, false
 		}
+		view.season = season.Id
+	}
 
-		state = dbState
+	return view, page, true
+}
 
-		// cache it
-		_ = s.CacheState(c.Request.Context(), *state, key)
+// buildLeaderboard is the one implementation behind every board, the page
+// asked for plus the callers own entry
+func (s *Server) buildLeaderboard(view boardView, page leaderboardPage, state models.UserState) (*LeaderboardRes, error) {
+	if page.scope == SCOPE_FRIENDS {
+		return s.buildFriendsLeaderboard(view, page, state)
 	}
 
-	// state, err := s.getUserState(username)
-	// if err != nil {
-	// 	c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get state " + err.Error()})
-	// 	return
+	value, qualified, err := s.getBoardValue(view, state)
+	if err != nil {
+		return nil, errors.New("failed to get value " + err.Error())
+	}
 
-	// }
+	// users that dont qualify yet get rank 0 and around=me falls back to the top
+	rank := 0
+	if qualified {
+		rank, err = s.getLeaderboardRank(view, value, page.ranking)
+		if err != nil {
+			return nil, errors.New("failed to get rank " + err.Error())
+		}
+	}
 
-	c.JSON(http.StatusOK, LeaderboardRes{
-		Entries: entries,
-		CurrentUser: LeaderboardEntry{
-			Rank:     rankScore,
-			Username: username,
-			Value:    state.TotalScore,
-		},
-	})
-}
+	// shadow excluded callers are put back on their own view, so they need
+	// their position too
+	pos := -1
+	if qualified && (page.around || state.ShadowExcluded) {
+		pos, err = s.getBoardPosition(view, state, value)
+		if err != nil {
+			return nil, errors.New("failed to get rank " + err.Error())
+		}
+	}
+	if page.around && pos >= 0 {
+		page.offset = max(0, pos-page.radius)
+		page.limit = pos - page.offset + page.radius + 1
+	}
 
-func (s *Server) GetStreakLeaderboard(c *gin.Context) {
-	username := c.GetString("username")
+	rows, scanned, err := s.getBoardPage(view, page.offset, page.limit)
+	if err != nil {
+		return nil, errors.New("failed to fetch leaderboard " + err.Error())
+	}
 
-	var states []models.UserState
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	entries, err := s.rankRows(view, page, rows)
+	if err != nil {
+		return nil, errors.New("failed to get rank " + err.Error())
+	}
 
-	opts := options.Find().
-		SetSort(bson.M{"totalScore": -1}).
-		SetLimit(5)
+	me := LeaderboardEntry{Rank: rank, UserID: state.UserID, Username: state.Username, Value: value}
+	if state.ShadowExcluded && pos >= 0 {
+		entries, err = s.showExcluded(view, page, entries, me, pos)
+		if err != nil {
+			return nil, errors.New("failed to get rank " + err.Error())
+		}
+	}
 
-	cursor, err := s.CollUserState.Find(ctx, bson.M{}, opts)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch leaderboard " + err.Error()})
-		return
+	res := &LeaderboardRes{
+		Ranking:     page.ranking,
+		Entries:     entries,
+		CurrentUser: me,
+	}
+	// a page of only deleted users is still no last page
+	if scanned == page.limit {
+		next := page.offset + page.limit
+		res.NextOffset = &next
 	}
-	cursor.All(ctx, &states)
 
-	entries := make([]LeaderboardEntry, 0, len(states))
-	for i, st := range states {
-		entries = append(entries, LeaderboardEntry{
-			Rank:     i + 1,
-			Username: st.Username,
-			Value:    float64(st.MaxStreak),
-		})
+	return res, nil
+}
+
+// buildFriendsLeaderboard ranks the caller and their friends among themselves.
+// Friend lists are capped so the whole list is ranked and then paged.
+func (s *Server) buildFriendsLeaderboard(view boardView, page leaderboardPage, state models.UserState) (*LeaderboardRes, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	ids, err := s.FriendIDs(ctx, state.UserID)
+	if err != nil {
+		return nil, errors.New("failed to load friends " + err.Error())
 	}
+	ids = append(ids, state.UserID)
 
-	_, rankStreak, err := s.getLeaderboardRanks(username)
+	rows, err := s.getBoardMembers(view, ids)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get streak " + err.Error()})
-		return
+		return nil, errors.New("failed to fetch leaderboard " + err.Error())
+	}
+	// a shadow excluded caller is ranked among their friends as if they were
+	// still listed, nobody else sees them
+	if state.ShadowExcluded {
+		value, qualified, err := s.getBoardValue(view, state)
+		if err != nil {
+			return nil, errors.New("failed to get value " + err.Error())
+		}
+		if qualified {
+			at := 0
+			for at < len(rows) && rows[at].Value > value {
+				at++
+			}
+			rows = slices.Insert(rows, at, boardRow{UserID: state.UserID, Username: state.Username, Value: value})
+		}
+	}
+	// friends are ranked among themselves, not by where they are on the board
+	for i := range rows {
+		rows[i].Position = i
+	}
+	all, err := s.rankRows(view, leaderboardPage{ranking: page.ranking}, rows)
+	if err != nil {
+		return nil, errors.New("failed to get rank " + err.Error())
+	}
 
+	// callers that arent listed (not qualified yet) get rank 0 like on the global board
+	me := LeaderboardEntry{UserID: state.UserID, Username: state.Username}
+	pos := -1
+	for i, entry := range all {
+		if entry.UserID == state.UserID {
+			me, pos = entry, i
+			break
+		}
+	}
+	if pos < 0 {
+		value, qualified, err := s.getBoardValue(view, state)
+		if err != nil {
+			return nil, errors.New("failed to get value " + err.Error())
+		}
+		me.Value = value
+		if qualified {
+			// guests get the rank they would have, like on the global board
+			me.Rank = rankAmong(all, value, page.ranking)
+		}
 	}
 
-	// get state from redis
-	key := "user_state:" + username
+	if page.around && pos >= 0 {
+		page.offset = max(0, pos-page.radius)
+		page.limit = pos - page.offset + page.radius + 1
+	}
 
-	var state *models.UserState
+	start := min(page.offset, len(all))
+	end := min(start+page.limit, len(all))
+	res := &LeaderboardRes{
+		Ranking:     page.ranking,
+		Entries:     all[start:end],
+		CurrentUser: me,
+	}
+	if end < len(all) {
+		res.NextOffset = &end
+	}
+
+	return res, nil
+}
 
-	cachedState, err := s.GetCachedState(c.Request.Context(), key)
-	if err == nil && cachedState != nil {
-		state = cachedState
-	} else {
-		// no cache, load from db
-		dbState, err := s.getUserState(username)
+// showExcluded puts a shadow excluded caller back into their own page at pos,
+// the position they would have, and moves everyone below them down a rank the
+// way their being listed would. Nothing they see gives the exclusion away.
+func (s *Server) showExcluded(view boardView, page leaderboardPage, entries []LeaderboardEntry, me LeaderboardEntry, pos int) ([]LeaderboardEntry, error) {
+	// under dense ranking a value somebody else has doesnt move anyone down
+	shift := true
+	if page.ranking == server.RANK_DENSE {
+		below, err := s.getLeaderboardRank(view, math.Nextafter(me.Value, math.Inf(-1)), server.RANK_STANDARD)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load state " + err.Error()})
-			return
+			return nil, err
 		}
+		shift = below-1 == pos
+	}
 
-		state = dbState
-
-		// cache it
-		_ = s.CacheState(c.Request.Context(), *state, key)
+	out := make([]LeaderboardEntry, 0, len(entries)+1)
+	for _, entry := range entries {
+		if shift && entry.Value < me.Value {
+			entry.Rank++
+		}
+		out = append(out, entry)
 	}
+	if pos >= page.offset && pos < page.offset+page.limit {
+		at := 0
+		for at < len(out) && out[at].Value > me.Value {
+			at++
+		}
+		out = slices.Insert(out, at, me)
+	}
+	return out, nil
+}
 
-	// state, err := s.getUserState(username)
-	// if err != nil {
-	// 	c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user state " + err.Error()})
-	// 	return
+// rankAmong is the rank value would have on a ranked list it isnt part of
+func rankAmong(entries []LeaderboardEntry, value float64, ranking string) int {
+	above := 0
+	for i, entry := range entries {
+		if entry.Value <= value {
+			break
+		}
+		if ranking == server.RANK_STANDARD || i == 0 || entry.Value != entries[i-1].Value {
+			above++
+		}
+	}
+	return above + 1
+}
 
-	// }
+// rankRows numbers a page of rows with the same ranks getLeaderboardRank gives,
+// so a user has the same rank in the list as in currentUser. Only the first row
+// and rows after a gap (users deleted but still on the board, who still count
+// for the ranks) need a lookup, after them the rank only moves where the value
+// does.
+func (s *Server) rankRows(view boardView, page leaderboardPage, rows []boardRow) ([]LeaderboardEntry, error) {
+	entries := make([]LeaderboardEntry, 0, len(rows))
+	rank := 1
+	for i, row := range rows {
+		switch {
+		case i == 0 && row.Position == 0:
+		case i == 0 || row.Position != rows[i-1].Position+1:
+			r, err := s.getLeaderboardRank(view, row.Value, page.ranking)
+			if err != nil {
+				return nil, err
+			}
+			rank = r
+		case row.Value == rows[i-1].Value:
+		case page.ranking == server.RANK_DENSE:
+			rank++
+		default:
+			rank = row.Position + 1
+		}
 
-	c.JSON(http.StatusOK, LeaderboardRes{
-		Entries: entries,
-		CurrentUser: LeaderboardEntry{
-			Rank:     rankStreak,
-			Username: username,
-			Value:    float64(state.MaxStreak),
-		},
-	})
+		entries = append(entries, LeaderboardEntry{
+			Rank:     rank,
+			UserID:   row.UserID,
+			Username: row.Username,
+			Value:    row.Value,
+		})
+	}
+	return entries, nil
 }
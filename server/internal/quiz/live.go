@@ -0,0 +1,122 @@
+package quiz
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"server/internal/live"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// a stream re-reads the boards at most this often, events in between are merged
+	liveMinInterval = time.Second
+	// re-read even without events so period roll overs and deletions show up,
+	// doubles as a keep alive for proxies
+	liveRefreshInterval = 30 * time.Second
+)
+
+// StreamLeaderboard is the live version of GetLeaderboard as server-sent events.
+// It takes the same query, sends "top" with the page (entries, nextOffset) and
+// "me" with the callers entry when they connect, and again whenever either changes.
+func (s *Server) StreamLeaderboard(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	board, ok := s.boardFromPath(c)
+	if !ok {
+		return
+	}
+	view, page, ok := s.leaderboardView(c, board)
+	if !ok {
+		return
+	}
+
+	state, err := s.loadState(c, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load state " + err.Error()})
+		return
+	}
+
+	// subscribe before the first read so no change slips in between
+	sub := s.Live.Subscribe()
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // nginx would hold the events back
+	c.Status(http.StatusOK)
+
+	var lastTop, lastMe []byte
+	send := func() bool {
+		res, err := s.buildLeaderboard(view, page, *state)
+		if err != nil {
+			log.Println("live leaderboard error:", err)
+			// try again on the next event
+			return true
+		}
+
+		top, _ := json.Marshal(gin.H{"entries": res.Entries, "nextOffset": res.NextOffset, "ranking": res.Ranking})
+		if !bytes.Equal(top, lastTop) {
+			c.SSEvent("top", json.RawMessage(top))
+			lastTop = top
+		}
+		me, _ := json.Marshal(res.CurrentUser)
+		if !bytes.Equal(me, lastMe) {
+			c.SSEvent("me", json.RawMessage(me))
+			lastMe = me
+		}
+		c.Writer.Flush()
+		return c.Request.Context().Err() == nil
+	}
+
+	if !send() {
+		return
+	}
+
+	ctx := c.Request.Context()
+	refresh := time.NewTicker(liveRefreshInterval)
+	defer refresh.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-sub.C:
+			if event.UserID == userID {
+				// the answer may have gone through another instance, its cache
+				// is the only fresh one so read mongo
+				if fresh, err := s.getUserState(userID); err == nil {
+					state = fresh
+				}
+			}
+		case <-refresh.C:
+			c.Writer.WriteString(": ping\n\n")
+		}
+
+		if !send() {
+			return
+		}
+
+		// let a burst of answers settle into one update
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(liveMinInterval):
+		}
+	}
+}
+
+// publishUpdate tells every instance the boards moved after an answer
+func (s *Server) publishUpdate(userID string, at time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := s.Live.Publish(ctx, live.Event{UserID: userID, At: at}); err != nil {
+		log.Println("live publish error:", err)
+	}
+}
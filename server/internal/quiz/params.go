@@ -0,0 +1,60 @@
+package quiz
+
+import (
+	"net/http"
+	"server/internal/config"
+	"server/internal/models"
+	"server/internal/server"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UpdateParamsReq struct {
+	// the version the change is based on, it has to still be the newest
+	Version int              `json:"version" binding:"required,min=1"`
+	Params  config.Algorithm `json:"params"`
+}
+
+type ParamsHistoryRes struct {
+	Versions []models.AlgorithmParams `json:"versions"`
+}
+
+// GetAlgorithmParams is the params this instance is scoring answers with
+func (s *Server) GetAlgorithmParams(c *gin.Context) {
+	c.JSON(http.StatusOK, s.Algorithm())
+}
+
+// ListAlgorithmParams is every version there has been, newest first
+func (s *Server) ListAlgorithmParams(c *gin.Context) {
+	history, err := s.ParamsHistory()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, ParamsHistoryRes{Versions: history})
+}
+
+// UpdateAlgorithmParams stores a new version of the params. This instance
+// uses it straight away, the others within params-poll.
+func (s *Server) UpdateAlgorithmParams(c *gin.Context) {
+	var req UpdateParamsReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "version and params are required"})
+		return
+	}
+	if err := req.Params.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	params, err := s.UpdateParams(req.Version, req.Params, c.GetString("userId"))
+	if err != nil {
+		if err.Error() == server.PARAMS_CONFLICT || err.Error() == server.PARAMS_EXPERIMENT {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusCreated, params)
+}
@@ -0,0 +1,327 @@
+package quiz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"reflect"
+	"server/internal/config"
+	"server/internal/models"
+	"server/internal/server"
+	"server/internal/store"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// A replay rebuilds user-state from answer-logs. Every answer goes through
+// applyAdaptiveAlgorithm and calculateScore again in the order it was given,
+// starting from a new users state, the same way SubmitAnswer got there.
+// Correctness comes from the log, so on other params a user answers the same
+// questions right and wrong, only the difficulty and score around them move.
+// Period leaderboards are summed from the logs and arent touched.
+
+type ReplayOptions struct {
+	// Version replays every answer on that stored params version, 0 uses the
+	// params each answer was scored with (their version or experiment arm)
+	Version int
+	DryRun  bool
+}
+
+// ReplayResult is one user, Diffs is what the replay would change
+type ReplayResult struct {
+	UserID   string      `json:"userId"`
+	Username string      `json:"username"`
+	Answers  int         `json:"answers"`
+	Diffs    []FieldDiff `json:"diffs,omitempty"`
+	Saved    bool        `json:"saved"`
+	Error    string      `json:"error,omitempty"`
+}
+
+type FieldDiff struct {
+	Field    string `json:"field"`
+	Stored   any    `json:"stored"`
+	Replayed any    `json:"replayed"`
+}
+
+// replayParams picks the params each answer is replayed on
+type replayParams struct {
+	versions    map[int]config.Algorithm
+	experiments map[string]models.Experiment
+	fixed       *config.Algorithm
+}
+
+// forAnswer is the params for a, recorded is false when they arent the ones
+// a was scored with
+func (p replayParams) forAnswer(a models.AnswerLog) (params config.Algorithm, recorded bool, err error) {
+	if p.fixed != nil {
+		return *p.fixed, false, nil
+	}
+	if e, ok := p.experiments[a.ExperimentID]; ok {
+		for _, v := range e.Variants {
+			if v.Name == a.Variant && v.Params != nil {
+				return *v.Params, true, nil
+			}
+		}
+	}
+	// an arm with its own params leaves the version off, those params being
+	// gone isnt the same as the answer predating stored params
+	if a.ExperimentID != "" && a.ParamsVersion == 0 {
+		return params, false, fmt.Errorf("answer %s was scored in experiment %s arm %s, its params arent stored", a.Id, a.ExperimentID, a.Variant)
+	}
+	// answers from before the params were stored were scored on the config,
+	// version 1 is what the config was when they started being stored
+	version := max(a.ParamsVersion, 1)
+	params, ok := p.versions[version]
+	if !ok {
+		return params, false, fmt.Errorf("answer %s was scored on params version %d, it isnt stored", a.Id, version)
+	}
+	return params, true, nil
+}
+
+// seasonAt is the season that was running at t, nil between seasons
+func seasonAt(seasons []models.Season, t time.Time) *models.Season {
+	for i, season := range seasons {
+		if !t.Before(season.StartedAt) && (season.EndedAt == nil || t.Before(*season.EndedAt)) {
+			return &seasons[i]
+		}
+	}
+	return nil
+}
+
+// replayState folds answers, oldest first, into a new state for the user
+// stored belongs to. Answers on their recorded params are scored at the
+// difficulty they were served at, on other params the replayed difficulty is
+// used since that is what the user would have been served.
+func replayState(stored models.UserState, answers []models.AnswerLog, params replayParams, seasons []models.Season) (models.UserState, error) {
+	state := models.UserState{
+		UserID:            stored.UserID,
+		Username:          stored.Username,
+		Guest:             stored.Guest,
+		ShadowExcluded:    stored.ShadowExcluded,
+		CurrentDifficulty: 3,
+		CorrectWindow:     []bool{},
+		MomentumScore:     0.5,
+	}
+
+	for _, a := range answers {
+		p, recorded, err := params.forAnswer(a)
+		if err != nil {
+			return state, err
+		}
+		difficulty := state.CurrentDifficulty
+		if recorded {
+			difficulty = a.Difficulty
+		}
+
+		next := applyAdaptiveAlgorithm(state, a.Correct, p)
+		scoreDelta := calculateScore(difficulty, a.Correct, next.Streak, p)
+		next.TotalScore += scoreDelta
+
+		if season := seasonAt(seasons, a.AnsweredAt); season != nil {
+			if next.SeasonID != season.Id {
+				next.SeasonID = season.Id
+				next.SeasonScore = 0
+			}
+			next.SeasonScore += scoreDelta
+		}
+		next.LastQuestionID = a.QuestionID
+		next.LastAnswerAt = a.AnsweredAt
+		next.TotalAnswered++
+		if a.Correct {
+			next.TotalCorrect++
+		}
+		next.Accuracy = server.Accuracy(next)
+		next.MaxDifficulty = max(next.MaxDifficulty, next.CurrentDifficulty)
+		if a.Topic != "" {
+			next.TopicScores = maps.Clone(next.TopicScores)
+			if next.TopicScores == nil {
+				next.TopicScores = map[string]float64{}
+			}
+			next.TopicScores[a.Topic] += scoreDelta
+		}
+		server.MarkAchievements(state, &next, a.AnsweredAt)
+		state = next
+	}
+
+	// finalizing a season takes everyone out of it, see ResetSeason
+	for _, season := range seasons {
+		if season.Id == state.SeasonID && season.Finalized {
+			state.SeasonID, state.SeasonScore = "", 0
+		}
+	}
+	return state, nil
+}
+
+// diffState lists the fields a replay recomputes that came out different,
+// achievedAt only breaks ties so it is left out
+func diffState(stored models.UserState, replayed models.UserState) []FieldDiff {
+	fields := []FieldDiff{
+		{"currentDifficulty", stored.CurrentDifficulty, replayed.CurrentDifficulty},
+		{"streak", stored.Streak, replayed.Streak},
+		{"maxStreak", stored.MaxStreak, replayed.MaxStreak},
+		{"totalScore", stored.TotalScore, replayed.TotalScore},
+		{"seasonId", stored.SeasonID, replayed.SeasonID},
+		{"seasonScore", stored.SeasonScore, replayed.SeasonScore},
+		{"totalAnswered", stored.TotalAnswered, replayed.TotalAnswered},
+		{"totalCorrect", stored.TotalCorrect, replayed.TotalCorrect},
+		{"accuracy", stored.Accuracy, replayed.Accuracy},
+		{"maxDifficulty", stored.MaxDifficulty, replayed.MaxDifficulty},
+		{"topicScores", stored.TopicScores, replayed.TopicScores},
+		{"lastQuestionId", stored.LastQuestionID, replayed.LastQuestionID},
+		{"lastAnswerAt", stored.LastAnswerAt, replayed.LastAnswerAt},
+		{"correctWindow", stored.CorrectWindow, replayed.CorrectWindow},
+		{"momentumScore", stored.MomentumScore, replayed.MomentumScore},
+		{"consecutiveUp", stored.ConsecutiveUp, replayed.ConsecutiveUp},
+		{"consecutiveDown", stored.ConsecutiveDown, replayed.ConsecutiveDown},
+	}
+
+	var diffs []FieldDiff
+	for _, f := range fields {
+		if !sameValue(f.Stored, f.Replayed) {
+			diffs = append(diffs, f)
+		}
+	}
+	return diffs
+}
+
+// sameValue lets float sums differ in the last bits, and an empty map or
+// slice match a missing one
+func sameValue(a any, b any) bool {
+	switch a := a.(type) {
+	case float64:
+		return math.Abs(a-b.(float64)) < 1e-6
+	case time.Time:
+		return a.Equal(b.(time.Time))
+	case map[string]float64:
+		b := b.(map[string]float64)
+		return len(a) == len(b) && maps.EqualFunc(a, b, func(x, y float64) bool { return sameValue(x, y) })
+	case []bool:
+		b := b.([]bool)
+		return len(a) == len(b) && (len(a) == 0 || reflect.DeepEqual(a, b))
+	}
+	return a == b
+}
+
+// ReplayUsers replays the users in userIDs, or everyone with a state when it
+// is empty, and hands each result to each as it goes. A user who answers
+// while they are replayed keeps their state and gets a VERSION_CONFLICT error,
+// running it again picks them up.
+func (s *Server) ReplayUsers(ctx context.Context, opts ReplayOptions, userIDs []string, each func(ReplayResult)) error {
+	params, err := s.loadReplayParams(ctx, opts.Version)
+	if err != nil {
+		return err
+	}
+	var seasons []models.Season
+	cursor, err := s.CollSeasons.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	if err := cursor.All(ctx, &seasons); err != nil {
+		return err
+	}
+
+	q := store.StateQuery{}
+	if len(userIDs) > 0 {
+		q.UserIDs = userIDs
+	}
+	return s.States.Each(ctx, q, func(stored models.UserState) error {
+		res, err := s.replayUser(ctx, stored, params, seasons, opts.DryRun)
+		if err != nil {
+			return err
+		}
+		each(res)
+		return nil
+	})
+}
+
+func (s *Server) loadReplayParams(ctx context.Context, version int) (replayParams, error) {
+	params := replayParams{versions: map[int]config.Algorithm{}, experiments: map[string]models.Experiment{}}
+
+	history, err := s.ParamsHistory()
+	if err != nil {
+		return params, err
+	}
+	for _, v := range history {
+		params.versions[v.Version] = v.Params
+	}
+	if version > 0 {
+		fixed, ok := params.versions[version]
+		if !ok {
+			return params, fmt.Errorf("params version %d isnt stored", version)
+		}
+		params.fixed = &fixed
+	}
+
+	cursor, err := s.CollExperiments.Find(ctx, bson.M{})
+	if err != nil {
+		return params, err
+	}
+	var experiments []models.Experiment
+	if err := cursor.All(ctx, &experiments); err != nil {
+		return params, err
+	}
+	for _, e := range experiments {
+		params.experiments[e.Id] = e
+	}
+	return params, nil
+}
+
+// replayUser only returns an error when the db fails, anything wrong with the
+// user goes in the result
+func (s *Server) replayUser(ctx context.Context, stored models.UserState, params replayParams, seasons []models.Season, dryRun bool) (ReplayResult, error) {
+	res := ReplayResult{UserID: stored.UserID, Username: stored.Username}
+
+	answers, err := s.Answers.ForUser(ctx, stored.UserID)
+	if err != nil {
+		return res, err
+	}
+	res.Answers = len(answers)
+
+	replayed, err := replayState(stored, answers, params, seasons)
+	if err != nil {
+		res.Error = err.Error()
+		return res, nil
+	}
+	res.Diffs = diffState(stored, replayed)
+	if dryRun || len(res.Diffs) == 0 {
+		return res, nil
+	}
+
+	replayed.StateVersion = stored.StateVersion + 1
+	err = s.saveReplayed(ctx, replayed, stored.StateVersion)
+	if err != nil {
+		if err.Error() != VERSION_CONFLICT {
+			return res, err
+		}
+		res.Error = err.Error()
+		return res, nil
+	}
+	res.Saved = true
+
+	// other instances keep their local copy for up to a minute, an answer
+	// there gets a version conflict and the next one loads this state
+	if err := s.DeleteCachedState(ctx, "user_state:"+stored.UserID); err != nil {
+		log.Println("cache error:", err)
+	}
+	if err := s.UpdateLeaderboards(ctx, replayed); err != nil {
+		log.Println("leaderboard error:", err)
+	}
+	if err := s.UpdateSeasonLeaderboard(ctx, replayed); err != nil {
+		log.Println("leaderboard error:", err)
+	}
+	return res, nil
+}
+
+// saveReplayed swaps in the replayed state unless the user answered since it
+// was read
+func (s *Server) saveReplayed(ctx context.Context, state models.UserState, expectedVersion int) error {
+	err := s.States.Save(ctx, state, expectedVersion)
+	if err == store.ErrNotFound {
+		return errors.New(VERSION_CONFLICT)
+	}
+	return err
+}
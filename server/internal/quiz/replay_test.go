@@ -0,0 +1,85 @@
+package quiz
+
+import (
+	"server/internal/config"
+	"server/internal/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplayState(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	ended := start.Add(2 * time.Hour)
+	seasons := []models.Season{
+		{Id: "s1", StartedAt: start, EndedAt: &ended, Finalized: true},
+		{Id: "s2", StartedAt: start.Add(3 * time.Hour)},
+	}
+	fast := defaults
+	fast.CorrectStreakToUp = 1
+	params := replayParams{
+		versions:    map[int]config.Algorithm{1: defaults, 2: fast},
+		experiments: map[string]models.Experiment{"e": {Id: "e", Variants: []models.Variant{{Name: "fast", Params: &fast}, {Name: "control"}}}},
+	}
+	answer := func(hour int, difficulty int, version int, variant string) models.AnswerLog {
+		a := models.AnswerLog{Correct: true, Difficulty: difficulty, ParamsVersion: version, Topic: "t", AnsweredAt: start.Add(time.Duration(hour) * time.Hour)}
+		if variant != "" {
+			a.ExperimentID, a.Variant = "e", variant
+		}
+		return a
+	}
+
+	// in s1: 3 then up to 4 on the second. between seasons on version 2 each
+	// one goes up. in s2 the fast arm goes up, control doesnt.
+	answers := []models.AnswerLog{
+		answer(0, 3, 0, ""), answer(1, 3, 1, ""),
+		answer(2, 4, 2, ""),
+		answer(3, 5, 1, "control"), answer(3, 5, 0, "fast"),
+	}
+	stored := models.UserState{UserID: "u", Username: "name", Guest: true}
+	state, err := replayState(stored, answers, params, seasons)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.UserID != "u" || state.Username != "name" || !state.Guest {
+		t.Fatalf("identity not kept: %+v", state)
+	}
+	if state.CurrentDifficulty != 6 || state.Streak != 5 || state.TotalAnswered != 5 {
+		t.Fatalf("got difficulty %d streak %d answered %v", state.CurrentDifficulty, state.Streak, state.TotalAnswered)
+	}
+	// recorded params score at the logged difficulty
+	want := 30*1.1 + 30*1.2 + 40*1.3 + 50*1.4 + 50*1.5
+	if !sameValue(state.TotalScore, want) || !sameValue(state.TopicScores["t"], want) {
+		t.Fatalf("score %v, want %v", state.TotalScore, want)
+	}
+	if state.SeasonID != "s2" || !sameValue(state.SeasonScore, 50*1.4+50*1.5) {
+		t.Fatalf("season %s %v", state.SeasonID, state.SeasonScore)
+	}
+
+	// only s1, which was finalized and took everyone out of it
+	state, _ = replayState(stored, answers[:2], params, seasons)
+	if state.SeasonID != "" || state.SeasonScore != 0 {
+		t.Fatalf("finalized season kept %s %v", state.SeasonID, state.SeasonScore)
+	}
+
+	// on fixed params the replayed difficulty is used, the logged one is ignored
+	params.fixed = &defaults
+	state, _ = replayState(stored, answers, params, seasons)
+	want = 30*1.1 + 30*1.2 + 40*1.3 + 40*1.4 + 50*1.5
+	if state.CurrentDifficulty != 5 || !sameValue(state.TotalScore, want) {
+		t.Fatalf("fixed: difficulty %d score %v, want %v", state.CurrentDifficulty, state.TotalScore, want)
+	}
+
+	params.fixed = nil
+	_, err = replayState(stored, []models.AnswerLog{answer(0, 3, 7, "")}, params, seasons)
+	if err == nil || !strings.Contains(err.Error(), "version 7") {
+		t.Fatalf("missing version: %v", err)
+	}
+	// an arm answer has no version to fall back on
+	gone := answer(0, 3, 0, "fast")
+	gone.ExperimentID = "deleted"
+	_, err = replayState(stored, []models.AnswerLog{gone}, params, seasons)
+	if err == nil || !strings.Contains(err.Error(), "experiment deleted") {
+		t.Fatalf("missing arm: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package quiz
+
+import (
+	"net/http"
+	"server/internal/models"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type StartSeasonReq struct {
+	Name string `json:"name" binding:"required,min=1,max=50"`
+}
+
+type EndSeasonRes struct {
+	Season models.Season `json:"season"`
+	Ranked int           `json:"ranked"`
+}
+
+type SeasonsRes struct {
+	Seasons []models.Season `json:"seasons"`
+}
+
+type StandingsRes struct {
+	Season     models.Season           `json:"season"`
+	Standings  []models.SeasonStanding `json:"standings"`
+	NextOffset *int                    `json:"nextOffset,omitempty"`
+}
+
+// StartSeason begins a new season, only one can run at a time
+func (s *Server) StartSeason(c *gin.Context) {
+	var req StartSeasonReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name bw 1-50 chars"})
+		return
+	}
+
+	season, err := s.startSeason(strings.TrimSpace(req.Name))
+	if err != nil {
+		if err.Error() == SEASON_RUNNING {
+			c.JSON(http.StatusConflict, gin.H{"error": SEASON_RUNNING})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, season)
+}
+
+// EndSeason stops a season, archives its final standings and awards badges.
+// Calling it again on an ended season that didnt finish finalizing picks up where it stopped.
+func (s *Server) EndSeason(c *gin.Context) {
+	season, err := s.getSeason(c.Param("seasonId"))
+	if err != nil {
+		if err.Error() == SEASON_NOT_FOUND {
+			c.JSON(http.StatusNotFound, gin.H{"error": SEASON_NOT_FOUND})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if season.Finalized {
+		c.JSON(http.StatusConflict, gin.H{"error": SEASON_FINALIZED})
+		return
+	}
+
+	// stop first so the scores cant move while they are archived
+	if err := s.stopSeason(season.Id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	ranked, err := s.finalizeSeason(*season)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to finalize season, retry to resume"})
+		return
+	}
+
+	season, err = s.getSeason(season.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, EndSeasonRes{Season: *season, Ranked: ranked})
+}
+
+func (s *Server) ListSeasons(c *gin.Context) {
+	seasons, err := s.listSeasons()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SeasonsRes{Seasons: seasons})
+}
+
+// GetSeasonStandings pages through the archived final standings of a season
+func (s *Server) GetSeasonStandings(c *gin.Context) {
+	page, err := parseLeaderboardPage(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	season, err := s.getSeason(c.Param("seasonId"))
+	if err != nil {
+		if err.Error() == SEASON_NOT_FOUND {
+			c.JSON(http.StatusNotFound, gin.H{"error": SEASON_NOT_FOUND})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if !season.Finalized {
+		c.JSON(http.StatusConflict, gin.H{"error": SEASON_NOT_ENDED})
+		return
+	}
+
+	standings, err := s.getStandings(season.Id, page.offset, page.limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	res := StandingsRes{Season: *season, Standings: standings}
+	if len(standings) == page.limit {
+		next := page.offset + page.limit
+		res.NextOffset = &next
+	}
+
+	c.JSON(http.StatusOK, res)
+}
@@ -1,11 +1,15 @@
 package quiz
 
-import "server/internal/server"
+import (
+	"server/internal/live"
+	"server/internal/server"
+)
 
 type Server struct {
 	*server.Server
+	Live *live.Hub // start it with Live.Run
 }
 
 func NewQuizServer(s *server.Server) *Server {
-	return &Server{s}
+	return &Server{Server: s, Live: live.NewHub(s.Cache)}
 }
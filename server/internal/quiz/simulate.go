@@ -0,0 +1,250 @@
+package quiz
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"server/internal/config"
+	"server/internal/models"
+	"slices"
+)
+
+// the simulator plays made up users of a known ability through
+// applyAdaptiveAlgorithm and calculateScore the same way SubmitAnswer does, so
+// changes to the constants can be judged on numbers
+
+// ConvergeSpan is how many answers in a row have to stay within 1 of the
+// settled difficulty to count as converged
+const ConvergeSpan = 50
+
+type SimConfig struct {
+	Abilities []float64 // one group of users per ability
+	Users     int       // users per ability
+	Answers   int       // answers per user
+	// Slope is how fast the chance of a right answer falls off above the
+	// users ability, 1 when left at 0
+	Slope float64
+	// Start is the difficulty users begin at, 3 (like a new account) when 0
+	Start int
+	Seed  int64
+	// Algorithm is the constants to try, the defaults when left empty
+	Algorithm config.Algorithm
+}
+
+// SimGroup is what the users of one ability did
+type SimGroup struct {
+	Ability float64 `json:"ability"`
+	// Settled is the difficulty the users spent most of the second half of
+	// their answers at, averaged over the group
+	Settled float64 `json:"settled"`
+	// ConvergedAfter is how many answers it took to get within 1 of settled
+	// for good (ConvergeSpan answers), over the users that got there
+	ConvergedAfter Summary `json:"convergedAfter"`
+	NeverConverged int     `json:"neverConverged"`
+	// ChangesPer100 is difficulty changes per 100 answers, ReversalsPer100
+	// the ones that undo the change before them (up then down or down then up)
+	ChangesPer100   float64 `json:"changesPer100"`
+	ReversalsPer100 float64 `json:"reversalsPer100"`
+	Accuracy        float64 `json:"accuracy"`
+	ScorePerAnswer  float64 `json:"scorePerAnswer"`
+	TotalScore      Summary `json:"totalScore"`
+}
+
+type Summary struct {
+	Mean float64 `json:"mean"`
+	Min  float64 `json:"min"`
+	P10  float64 `json:"p10"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	Max  float64 `json:"max"`
+}
+
+// Chance is the probability a user answers a question at difficulty right,
+// one half at their ability and less the harder it gets
+func Chance(difficulty int, ability float64, slope float64) float64 {
+	return 1 / (1 + math.Exp(slope*(float64(difficulty)-ability)))
+}
+
+// Simulate plays cfg.Users users per ability for cfg.Answers answers each. It
+// stops at the first answer that breaks checkInvariants.
+func Simulate(cfg SimConfig) ([]SimGroup, error) {
+	if len(cfg.Abilities) == 0 || cfg.Users <= 0 || cfg.Answers <= 0 {
+		return nil, errors.New("simulation needs abilities, users and answers")
+	}
+	if cfg.Slope == 0 {
+		cfg.Slope = 1
+	}
+	if cfg.Start == 0 {
+		cfg.Start = 3
+	}
+	if cfg.Start < minDifficulty || cfg.Start > maxDifficulty {
+		return nil, fmt.Errorf("start difficulty %d is outside %d-%d", cfg.Start, minDifficulty, maxDifficulty)
+	}
+	if cfg.Algorithm == (config.Algorithm{}) {
+		cfg.Algorithm = config.Default().Algorithm
+	}
+	if err := cfg.Algorithm.Validate(); err != nil {
+		return nil, err
+	}
+
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	groups := make([]SimGroup, 0, len(cfg.Abilities))
+	for _, ability := range cfg.Abilities {
+		g := SimGroup{Ability: ability}
+		var converged, totals []float64
+		var changes, reversals, correct int
+		for range cfg.Users {
+			run, err := simulateUser(rng, cfg, ability)
+			if err != nil {
+				return nil, fmt.Errorf("ability %v: %w", ability, err)
+			}
+			g.Settled += float64(run.settled)
+			if run.convergedAfter < 0 {
+				g.NeverConverged++
+			} else {
+				converged = append(converged, float64(run.convergedAfter))
+			}
+			totals = append(totals, run.score)
+			changes += run.changes
+			reversals += run.reversals
+			correct += run.correct
+		}
+
+		answers := float64(cfg.Users * cfg.Answers)
+		g.Settled /= float64(cfg.Users)
+		g.ConvergedAfter = summarize(converged)
+		g.ChangesPer100 = float64(changes) / answers * 100
+		g.ReversalsPer100 = float64(reversals) / answers * 100
+		g.Accuracy = float64(correct) / answers
+		g.TotalScore = summarize(totals)
+		g.ScorePerAnswer = g.TotalScore.Mean / float64(cfg.Answers)
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+type simRun struct {
+	settled        int
+	convergedAfter int // -1 if never
+	changes        int
+	reversals      int
+	correct        int
+	score          float64
+}
+
+func simulateUser(rng *rand.Rand, cfg SimConfig, ability float64) (simRun, error) {
+	run := simRun{}
+	state := models.UserState{CurrentDifficulty: cfg.Start, MomentumScore: 0.5}
+	path := make([]int, 0, cfg.Answers)
+	lastMove := 0
+
+	for i := range cfg.Answers {
+		// questions are served at the users current difficulty
+		difficulty := state.CurrentDifficulty
+		correct := rng.Float64() < Chance(difficulty, ability, cfg.Slope)
+
+		next := applyAdaptiveAlgorithm(state, correct, cfg.Algorithm)
+		delta := calculateScore(difficulty, correct, next.Streak, cfg.Algorithm)
+		if err := checkInvariants(next, difficulty, correct, delta, cfg.Algorithm); err != nil {
+			return run, fmt.Errorf("answer %d: %w", i+1, err)
+		}
+		next.TotalScore += delta
+
+		if move := cmp.Compare(next.CurrentDifficulty, difficulty); move != 0 {
+			run.changes++
+			if lastMove != 0 && move != lastMove {
+				run.reversals++
+			}
+			lastMove = move
+		}
+		if correct {
+			run.correct++
+		}
+		path = append(path, next.CurrentDifficulty)
+		state = next
+	}
+
+	run.score = state.TotalScore
+	run.settled = mostCommon(path[len(path)/2:])
+	run.convergedAfter = convergedAfter(path, run.settled)
+	return run, nil
+}
+
+// checkInvariants is what has to hold after every answer whatever the
+// constants are set to
+func checkInvariants(s models.UserState, servedAt int, correct bool, delta float64, p config.Algorithm) error {
+	if s.CurrentDifficulty < minDifficulty || s.CurrentDifficulty > maxDifficulty {
+		return fmt.Errorf("difficulty %d is outside %d-%d", s.CurrentDifficulty, minDifficulty, maxDifficulty)
+	}
+	if len(s.CorrectWindow) > p.RollingWindowSize {
+		return fmt.Errorf("window holds %d answers, max %d", len(s.CorrectWindow), p.RollingWindowSize)
+	}
+	if s.MomentumScore < 0 || s.MomentumScore > 1 {
+		return fmt.Errorf("momentum %v is outside 0-1", s.MomentumScore)
+	}
+	if !correct && delta != 0 {
+		return fmt.Errorf("wrong answer scored %v", delta)
+	}
+	if base := float64(servedAt) * 10; delta > base*p.MaxStreakMultiplier {
+		return fmt.Errorf("score %v at difficulty %d is over %vx", delta, servedAt, p.MaxStreakMultiplier)
+	}
+	return nil
+}
+
+// mostCommon is the most frequent difficulty, the lower one on a tie
+func mostCommon(path []int) int {
+	var counts [maxDifficulty + 1]int
+	best := minDifficulty
+	for _, d := range path {
+		counts[d]++
+	}
+	for d := minDifficulty; d <= maxDifficulty; d++ {
+		if counts[d] > counts[best] {
+			best = d
+		}
+	}
+	return best
+}
+
+// convergedAfter is the first answer that starts ConvergeSpan answers in a row
+// within 1 of settled, -1 if there is none
+func convergedAfter(path []int, settled int) int {
+	run := 0
+	for i, d := range path {
+		if d < settled-1 || d > settled+1 {
+			run = 0
+			continue
+		}
+		run++
+		if run == ConvergeSpan {
+			return i + 1 - ConvergeSpan
+		}
+	}
+	return -1
+}
+
+func summarize(values []float64) Summary {
+	if len(values) == 0 {
+		return Summary{}
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+	at := func(p float64) float64 {
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+	return Summary{
+		Mean: sum / float64(len(sorted)),
+		Min:  sorted[0],
+		P10:  at(0.1),
+		P50:  at(0.5),
+		P90:  at(0.9),
+		Max:  sorted[len(sorted)-1],
+	}
+}
@@ -0,0 +1,119 @@
+package quiz
+
+import (
+	"reflect"
+	"server/internal/config"
+	"testing"
+)
+
+func TestSimulate(t *testing.T) {
+	cfg := SimConfig{
+		Abilities: []float64{1, 3, 5, 7, 10},
+		Users:     50,
+		Answers:   2000,
+		Seed:      46,
+	}
+	groups, err := Simulate(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != len(cfg.Abilities) {
+		t.Fatalf("%d groups for %d abilities", len(groups), len(cfg.Abilities))
+	}
+
+	for i, g := range groups {
+		if g.Settled < minDifficulty || g.Settled > maxDifficulty {
+			t.Errorf("ability %v settled at %v", g.Ability, g.Settled)
+		}
+		if g.ReversalsPer100 > g.ChangesPer100 {
+			t.Errorf("ability %v: %v reversals but %v changes", g.Ability, g.ReversalsPer100, g.ChangesPer100)
+		}
+		if g.NeverConverged == cfg.Users {
+			t.Errorf("ability %v: nobody converged", g.Ability)
+		}
+		if g.TotalScore.Min > g.TotalScore.P50 || g.TotalScore.P50 > g.TotalScore.Max {
+			t.Errorf("ability %v: score summary out of order %+v", g.Ability, g.TotalScore)
+		}
+		// better players settle higher and score more
+		if i > 0 {
+			prev := groups[i-1]
+			if g.Settled <= prev.Settled || g.TotalScore.Mean <= prev.TotalScore.Mean {
+				t.Errorf("ability %v settled at %v scoring %v, ability %v at %v scoring %v",
+					g.Ability, g.Settled, g.TotalScore.Mean, prev.Ability, prev.Settled, prev.TotalScore.Mean)
+			}
+		}
+	}
+
+	// the hysteresis keeps users answering mostly right where they settle
+	for _, g := range groups {
+		if g.Accuracy < 0.4 {
+			t.Errorf("ability %v only gets %v right", g.Ability, g.Accuracy)
+		}
+	}
+
+	again, err := Simulate(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(groups, again) {
+		t.Error("the same seed gave a different report")
+	}
+}
+
+func TestSimulateConfig(t *testing.T) {
+	bad := []SimConfig{
+		{Users: 1, Answers: 1},
+		{Abilities: []float64{5}, Answers: 1},
+		{Abilities: []float64{5}, Users: 1},
+		{Abilities: []float64{5}, Users: 1, Answers: 1, Start: 11},
+		{Abilities: []float64{5}, Users: 1, Answers: 1, Algorithm: config.Algorithm{RollingWindowSize: 5}},
+	}
+	for _, cfg := range bad {
+		if _, err := Simulate(cfg); err == nil {
+			t.Errorf("%+v should be rejected", cfg)
+		}
+	}
+}
+
+func TestConvergedAfter(t *testing.T) {
+	path := make([]int, 0, 200)
+	for range 30 {
+		path = append(path, 1)
+	}
+	for range 30 {
+		path = append(path, 4, 5)
+	}
+	path = append(path, 9)
+	for range 60 {
+		path = append(path, 5)
+	}
+	if got := convergedAfter(path, 5); got != 30 {
+		t.Errorf("converged after %d, want 30", got)
+	}
+	if got := convergedAfter(path[:40], 5); got != -1 {
+		t.Errorf("a short run converged after %d", got)
+	}
+	if got := mostCommon([]int{3, 4, 4, 3}); got != 3 {
+		t.Errorf("a tie picks %d, want the lower 3", got)
+	}
+}
+
+// other constants have to keep the invariants too, checked on every answer
+func TestSimulateOtherConstants(t *testing.T) {
+	_, err := Simulate(SimConfig{
+		Abilities: []float64{2, 6, 10},
+		Users:     20,
+		Answers:   1000,
+		Seed:      46,
+		Algorithm: config.Algorithm{
+			CorrectStreakToUp:   3,
+			WrongStreakToDown:   2,
+			RollingWindowSize:   8,
+			MomentumThreshold:   0.75,
+			MaxStreakMultiplier: 3,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
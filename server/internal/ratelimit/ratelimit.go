@@ -0,0 +1,134 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"server/internal/store"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// KeyFunc picks what a rule counts against, an empty key skips the rule
+type KeyFunc func(c *gin.Context) string
+
+type Rule struct {
+	Name   string // part of the redis key, keep it unique per route
+	Limit  int    // requests allowed inside one window
+	Window time.Duration
+	Key    KeyFunc
+}
+
+type Limiter struct {
+	cache store.Cache
+}
+
+// New counts in the shared cache so every instance sees the same windows
+func New(cache store.Cache) *Limiter {
+	return &Limiter{cache: cache}
+}
+
+// Limit rejects with 429 once any of the rules is exhausted. A rejected
+// request doesnt count against any rule, the hits the rules before the
+// rejecting one took are given back.
+// If redis cant be reached the request is let through, losing the limit is
+// better than taking the api down with it.
+func (l *Limiter) Limit(rules ...Rule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		hit := uuid.NewString()
+		var counted []string
+		for _, rule := range rules {
+			key := rule.Key(c)
+			if key == "" {
+				continue
+			}
+			key = "ratelimit:" + rule.Name + ":" + key
+
+			allowed, retryAfter, err := l.allow(ctx, rule, key, hit)
+			if err != nil {
+				log.Println("rate limit error:", err)
+				continue
+			}
+
+			if !allowed {
+				l.release(ctx, counted, hit)
+				secs := int(math.Ceil(retryAfter.Seconds()))
+				if secs < 1 {
+					secs = 1
+				}
+				c.Header("Retry-After", strconv.Itoa(secs))
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
+				return
+			}
+			counted = append(counted, key)
+		}
+
+		c.Next()
+	}
+}
+
+func (l *Limiter) allow(ctx context.Context, rule Rule, key string, hit string) (bool, time.Duration, error) {
+	// a slow redis shouldnt slow down every request
+	ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+
+	return l.cache.Allow(ctx, key, hit, rule.Limit, rule.Window)
+}
+
+// release gives the hit back to every window in keys, one that cant be given
+// back just runs out with its window
+func (l *Limiter) release(ctx context.Context, keys []string, hit string) {
+	ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+
+	for _, key := range keys {
+		if err := l.cache.Release(ctx, key, hit); err != nil {
+			log.Println("rate limit error:", err)
+		}
+	}
+}
+
+func ByIP(c *gin.Context) string {
+	return c.ClientIP()
+}
+
+// ByUser needs to run after AuthMiddleware
+func ByUser(c *gin.Context) string {
+	return c.GetString("userId")
+}
+
+// maxKeyedBody caps what ByBodyField reads, the bodies it is used on are a
+// few short fields
+const maxKeyedBody = 4 << 10
+
+// ByBodyField keys on a string field of the json body, the body is put back
+// so the handler can still bind it. A body over maxKeyedBody isnt read any
+// further and the handler fails to bind it.
+func ByBodyField(field string) KeyFunc {
+	return func(c *gin.Context) string {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxKeyedBody)
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return ""
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		var fields map[string]any
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return ""
+		}
+		value, _ := fields[field].(string)
+
+		// "Bob " and "bob" are the same target as far as guessing goes
+		return strings.ToLower(strings.TrimSpace(value))
+	}
+}
@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"server/internal/store/memstore"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// a request one rule rejects doesnt use up the rules before it, guessing one
+// username over and over cant lock the whole ip out of logging in
+func TestLimitGivesBackRejectedHits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cache := memstore.NewCache()
+	l := New(cache)
+	r := gin.New()
+	r.POST("/session", l.Limit(
+		Rule{Name: "ip", Limit: 3, Window: time.Hour, Key: ByIP},
+		Rule{Name: "user", Limit: 1, Window: time.Hour, Key: ByBodyField("username")},
+	), func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, "%s", body)
+	})
+	login := func(username string) *httptest.ResponseRecorder {
+		t.Helper()
+		body := `{"username": "` + username + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/session", bytes.NewBufferString(body))
+		req.RemoteAddr = "10.0.0.1:1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code == http.StatusOK && w.Body.String() != body {
+			t.Fatalf("handler got %q", w.Body.String())
+		}
+		return w
+	}
+
+	if w := login("bob"); w.Code != http.StatusOK {
+		t.Fatalf("first login %d", w.Code)
+	}
+	for range 5 {
+		w := login("bob")
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+			t.Fatalf("repeat login %d retry %q", w.Code, w.Header().Get("Retry-After"))
+		}
+	}
+
+	// only bob counts against the ip so far
+	for _, name := range []string{"alice", "carol"} {
+		if w := login(name); w.Code != http.StatusOK {
+			t.Fatalf("%s was limited by bobs rejected tries: %d", name, w.Code)
+		}
+	}
+	if w := login("dave"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("ip limit not applied: %d", w.Code)
+	}
+	// dave was rejected by the first rule and never counted against his name
+	if ok, _, _ := cache.Allow(context.Background(), "ratelimit:user:dave", "x", 1, time.Hour); !ok {
+		t.Fatal("dave was counted")
+	}
+}
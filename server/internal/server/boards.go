@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"regexp"
+	"server/internal/models"
+	"sort"
+)
+
+const (
+	BOARD_SCORE      = "score"
+	BOARD_STREAK     = "streak"
+	BOARD_ACCURACY   = "accuracy"
+	BOARD_DIFFICULTY = "difficulty"
+	BOARD_TOPIC      = "topic" // one board per question topic, named topic:<topic>
+
+	// users need this many answers before they show up on the accuracy board,
+	// otherwise one lucky answer is 100%
+	MinAccuracyAnswers = 20
+)
+
+// Board is one thing users can be ranked by. Every board is kept the same way:
+// a redis sorted set per period, with user-state (or answer-logs for periods)
+// as the source of truth.
+type Board struct {
+	Name        string
+	Field       string  // user-state field the store sorts the all time board by, see store.StateBoard
+	Periodic    bool    // also kept per period, summed from answer-logs
+	MinAnswered float64 // states with fewer answers arent listed
+	topic       string
+}
+
+var (
+	ScoreBoard      = Board{Name: BOARD_SCORE, Field: "totalScore", Periodic: true}
+	StreakBoard     = Board{Name: BOARD_STREAK, Field: "maxStreak", Periodic: true}
+	AccuracyBoard   = Board{Name: BOARD_ACCURACY, Field: "accuracy", MinAnswered: MinAccuracyAnswers}
+	DifficultyBoard = Board{Name: BOARD_DIFFICULTY, Field: "maxDifficulty"}
+)
+
+// boards are the fixed all time boards, topic boards come on top of these
+var boards = []Board{ScoreBoard, StreakBoard, AccuracyBoard, DifficultyBoard}
+
+var topicPattern = regexp.MustCompile(`^[a-z0-9-]{1,30}$`)
+
+// TopicBoard is the score board of one question topic
+func TopicBoard(topic string) Board {
+	return Board{
+		Name:  BOARD_TOPIC + ":" + topic,
+		Field: "topicScores." + topic,
+		topic: topic,
+	}
+}
+
+// LookupBoard finds a fixed board by name
+func LookupBoard(name string) (Board, bool) {
+	for _, b := range boards {
+		if b.Name == name {
+			return b, true
+		}
+	}
+	return Board{}, false
+}
+
+// ValidTopic reports if a topic name is safe to use in keys and field paths
+func ValidTopic(topic string) bool {
+	return topicPattern.MatchString(topic)
+}
+
+// Value is what the board ranks a state by, false when the state isnt listed on it
+func (b Board) Value(state models.UserState) (float64, bool) {
+	if state.TotalAnswered < b.MinAnswered {
+		return 0, false
+	}
+	if b.topic != "" {
+		score, ok := state.TopicScores[b.topic]
+		return score, ok
+	}
+
+	switch b.Name {
+	case BOARD_STREAK:
+		return float64(state.MaxStreak), true
+	case BOARD_ACCURACY:
+		return Accuracy(state), true
+	case BOARD_DIFFICULTY:
+		return float64(state.MaxDifficulty), true
+	default:
+		return state.TotalScore, true
+	}
+}
+
+// Accuracy is the share of answers that were correct, 0 before the first answer
+func Accuracy(state models.UserState) float64 {
+	if state.TotalAnswered == 0 {
+		return 0
+	}
+	return state.TotalCorrect / state.TotalAnswered
+}
+
+// Topics returns every topic the question bank has, sorted
+func (s *Server) Topics(ctx context.Context) ([]string, error) {
+	topics, err := s.Questions.Topics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	valid := topics[:0]
+	for _, topic := range topics {
+		if ValidTopic(topic) {
+			valid = append(valid, topic)
+		}
+	}
+	sort.Strings(valid)
+	return valid, nil
+}
+
+// allBoards is the fixed boards plus one board per topic
+func allBoards(topics []string) []Board {
+	all := make([]Board, 0, len(boards)+len(topics))
+	all = append(all, boards...)
+	for _, topic := range topics {
+		all = append(all, TopicBoard(topic))
+	}
+	return all
+}
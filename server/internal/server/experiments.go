@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"server/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// RunningExperiment is the active experiment as of the last load, nil when
+// none is running
+func (s *Server) RunningExperiment() *models.Experiment {
+	return s.experiment.Load()
+}
+
+// LoadExperiment reads the active experiment, WatchParams calls it with the
+// params so starting or ending one reaches every instance the same way
+func (s *Server) LoadExperiment(ctx context.Context) error {
+	var e models.Experiment
+	err := s.CollExperiments.FindOne(ctx, bson.M{"active": true}).Decode(&e)
+	if err == mongo.ErrNoDocuments {
+		s.experiment.Store(nil)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.experiment.Store(&e)
+	return nil
+}
+
+// Bucket puts a user in 0-99 for an experiment. The same user always lands
+// in the same bucket of one experiment, and the id is part of the hash so
+// being early in one experiment says nothing about the next.
+func Bucket(experimentID string, userID string) int {
+	sum := sha256.Sum256([]byte(experimentID + ":" + userID))
+	return int(binary.BigEndian.Uint64(sum[:8]) % 100)
+}
+
+// VariantFor is the arm of e the user is in, variants take up the buckets in
+// order by their allocation. nil when the user falls outside all of them.
+func VariantFor(e models.Experiment, userID string) *models.Variant {
+	b := Bucket(e.Id, userID)
+	for i, v := range e.Variants {
+		if b < v.Allocation {
+			return &e.Variants[i]
+		}
+		b -= v.Allocation
+	}
+	return nil
+}
+
+// LogExposure records the first answer a user gave in an experiment, later
+// calls for the same user leave it as it is
+func (s *Server) LogExposure(ctx context.Context, experimentID string, variant string, userID string, at time.Time) error {
+	_, err := s.CollExposures.UpdateOne(ctx,
+		bson.M{"_id": experimentID + ":" + userID},
+		bson.M{"$setOnInsert": bson.M{
+			"experimentId": experimentID,
+			"userId":       userID,
+			"variant":      variant,
+			"exposedAt":    at,
+		}},
+		options.UpdateOne().SetUpsert(true))
+	return err
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"server/internal/models"
+	"testing"
+)
+
+func TestBucket(t *testing.T) {
+	const users = 10000
+	counts := make([]int, 100)
+	moved := 0
+	for i := range users {
+		id := fmt.Sprintf("user-%d", i)
+		b := Bucket("exp-a", id)
+		if b < 0 || b > 99 {
+			t.Fatalf("bucket %d out of range", b)
+		}
+		if Bucket("exp-a", id) != b {
+			t.Fatalf("%s moved buckets", id)
+		}
+		counts[b]++
+		if Bucket("exp-b", id) != b {
+			moved++
+		}
+	}
+	// 100 a bucket on average, 5 standard deviations either way
+	for b, n := range counts {
+		if n < 50 || n > 150 {
+			t.Errorf("bucket %d has %d users", b, n)
+		}
+	}
+	if moved < users*9/10 {
+		t.Errorf("only %d users changed bucket in another experiment", moved)
+	}
+}
+
+func TestVariantFor(t *testing.T) {
+	e := models.Experiment{Id: "exp", Variants: []models.Variant{
+		{Name: "a", Allocation: 20},
+		{Name: "b", Allocation: 50},
+	}}
+	const users = 10000
+	counts := map[string]int{}
+	for i := range users {
+		name := "none"
+		if v := VariantFor(e, fmt.Sprintf("user-%d", i)); v != nil {
+			name = v.Name
+		}
+		counts[name]++
+	}
+	for name, want := range map[string]int{"a": 2000, "b": 5000, "none": 3000} {
+		if n := counts[name]; n < want-300 || n > want+300 {
+			t.Errorf("%s got %d users, want about %d", name, n, want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"server/internal/models"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// MaxFriends caps accepted friendships per user, it also keeps friend
+// leaderboards cheap to build
+const MaxFriends = 500
+
+// ReserveFriend takes one of the users MaxFriends slots in friend-counts. The
+// check and the take are one update, so two accepts at once cant both get the
+// last slot.
+func (s *Server) ReserveFriend(ctx context.Context, userID string) (bool, error) {
+	if err := s.seedFriendCount(ctx, userID); err != nil {
+		return false, err
+	}
+	res, err := s.CollFriendCount.UpdateOne(ctx,
+		bson.M{"_id": userID, "count": bson.M{"$lt": MaxFriends}},
+		bson.M{"$inc": bson.M{"count": 1}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+// seedFriendCount starts the count of a user from before friend-counts at the
+// friends they have, every accept after that goes through ReserveFriend
+func (s *Server) seedFriendCount(ctx context.Context, userID string) error {
+	n, err := s.CollFriendCount.CountDocuments(ctx, bson.M{"_id": userID})
+	if err != nil || n > 0 {
+		return err
+	}
+	have, err := s.CollFriends.CountDocuments(ctx, bson.M{"users": userID, "status": models.FRIEND_ACCEPTED})
+	if err != nil {
+		return err
+	}
+	_, err = s.CollFriendCount.InsertOne(ctx, models.FriendCount{UserID: userID, Count: int(have)})
+	if mongo.IsDuplicateKeyError(err) {
+		// another accept seeded it first
+		return nil
+	}
+	return err
+}
+
+// ReleaseFriend gives each user one slot back
+func (s *Server) ReleaseFriend(ctx context.Context, userIDs ...string) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	_, err := s.CollFriendCount.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": userIDs}, "count": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"count": -1}},
+	)
+	return err
+}
+
+// DropFriendships deletes every friendship and request of the user, their
+// friends get the slot back
+func (s *Server) DropFriendships(ctx context.Context, userID string) error {
+	friends, err := s.FriendIDs(ctx, userID)
+	if err != nil {
+		return err
+	}
+	// pending requests both ways go too, not just accepted friends
+	if _, err := s.CollFriends.DeleteMany(ctx, bson.M{"users": userID}); err != nil {
+		return err
+	}
+	if err := s.ReleaseFriend(ctx, friends...); err != nil {
+		return err
+	}
+	_, err = s.CollFriendCount.DeleteOne(ctx, bson.M{"_id": userID})
+	return err
+}
+
+// FriendIDs returns the ids of everyone the user has an accepted friendship with
+func (s *Server) FriendIDs(ctx context.Context, userID string) ([]string, error) {
+	cursor, err := s.CollFriends.Find(ctx,
+		bson.M{"users": userID, "status": models.FRIEND_ACCEPTED},
+		options.Find().SetProjection(bson.M{"users": 1}).SetLimit(MaxFriends))
+	if err != nil {
+		return nil, err
+	}
+	var friendships []models.Friendship
+	if err := cursor.All(ctx, &friendships); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(friendships))
+	for _, f := range friendships {
+		for _, id := range f.Users {
+			if id != userID {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,438 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"server/internal/models"
+	"server/internal/store"
+	"time"
+)
+
+// Leaderboards live in s.Boards, redis sorted sets in production. The store
+// stays the source of truth, the boards can always be rebuilt from s.States
+// and s.Answers.
+const (
+	PERIOD_ALL     = "all"
+	PERIOD_DAILY   = "daily"
+	PERIOD_WEEKLY  = "weekly"
+	PERIOD_MONTHLY = "monthly"
+	PERIOD_SEASON  = "season" // score only, see seasons.go
+)
+
+// periods are the time windows kept next to the all time boards
+var periods = []string{PERIOD_DAILY, PERIOD_WEEKLY, PERIOD_MONTHLY}
+
+func ValidPeriod(period string) bool {
+	switch period {
+	case PERIOD_ALL, PERIOD_DAILY, PERIOD_WEEKLY, PERIOD_MONTHLY, PERIOD_SEASON:
+		return true
+	}
+	return false
+}
+
+// PeriodStart returns the start of the period holding t, in the leaderboard time
+// zone. Weeks start on monday. The all time period starts at the zero time.
+func (s *Server) PeriodStart(period string, t time.Time) time.Time {
+	t = t.In(s.LeaderboardLocation)
+	y, m, d := t.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, s.LeaderboardLocation)
+
+	switch period {
+	case PERIOD_DAILY:
+		return day
+	case PERIOD_WEEKLY:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case PERIOD_MONTHLY:
+		return time.Date(y, m, 1, 0, 0, 0, 0, s.LeaderboardLocation)
+	}
+	return time.Time{}
+}
+
+func periodEnd(period string, start time.Time) time.Time {
+	switch period {
+	case PERIOD_DAILY:
+		return start.AddDate(0, 0, 1)
+	case PERIOD_WEEKLY:
+		return start.AddDate(0, 0, 7)
+	default:
+		return start.AddDate(0, 1, 0)
+	}
+}
+
+// LeaderboardKey is the sorted set for a board in the period holding at.
+// The hashtag keeps a board and its rebuild copy on the same ring shard.
+func (s *Server) LeaderboardKey(board string, period string, at time.Time) string {
+	if period == PERIOD_ALL || period == "" {
+		return "leaderboard:{" + board + "}"
+	}
+	return "leaderboard:{" + board + ":" + period + ":" + s.PeriodStart(period, at).Format("20060102") + "}"
+}
+
+// SeasonLeaderboardKey is the score board of one season
+func SeasonLeaderboardKey(seasonID string) string {
+	return "leaderboard:{" + BOARD_SCORE + ":" + PERIOD_SEASON + ":" + seasonID + "}"
+}
+
+// Listed reports if a state may show up on public boards. Guests and shadow
+// excluded users dont, they still get the rank their values would have.
+func Listed(state models.UserState) bool {
+	return !state.Guest && !state.ShadowExcluded
+}
+
+// stateBoards is every all time board a state can be listed on
+func stateBoards(state models.UserState) []Board {
+	topics := make([]string, 0, len(state.TopicScores))
+	for topic := range state.TopicScores {
+		if ValidTopic(topic) {
+			topics = append(topics, topic)
+		}
+	}
+	return allBoards(topics)
+}
+
+// UpdateLeaderboards puts the users current values on every all time board they
+// qualify for, guests and excluded users are kept off
+func (s *Server) UpdateLeaderboards(ctx context.Context, state models.UserState) error {
+	if !Listed(state) {
+		return s.RemoveFromLeaderboards(ctx, state.UserID)
+	}
+
+	var updates []store.BoardUpdate
+	var off []string
+	for _, board := range stateBoards(state) {
+		key := s.LeaderboardKey(board.Name, PERIOD_ALL, time.Time{})
+		value, ok := board.Value(state)
+		if !ok {
+			off = append(off, key)
+			continue
+		}
+		updates = append(updates, store.BoardUpdate{
+			Key:    key,
+			UserID: state.UserID,
+			Value:  value,
+			At:     board.AchievedAt(state),
+			Mode:   store.UPDATE_SET,
+		})
+	}
+	if err := s.Boards.Remove(ctx, state.UserID, off...); err != nil {
+		return err
+	}
+	return s.Boards.Update(ctx, updates...)
+}
+
+// RecordPeriodLeaderboards adds one answer to the current period boards: the score
+// delta is summed and the streak only replaces a lower one
+func (s *Server) RecordPeriodLeaderboards(ctx context.Context, state models.UserState, scoreDelta float64, at time.Time) error {
+	if !Listed(state) {
+		return nil
+	}
+
+	var updates []store.BoardUpdate
+	for _, period := range periods {
+		start := s.PeriodStart(period, at)
+		// keep the previous period around a while after it ends
+		expireAt := periodEnd(period, periodEnd(period, start))
+
+		updates = append(updates, store.BoardUpdate{
+			Key:      s.LeaderboardKey(BOARD_SCORE, period, at),
+			UserID:   state.UserID,
+			Value:    scoreDelta,
+			At:       at,
+			Mode:     store.UPDATE_INCR,
+			ExpireAt: expireAt,
+		}, store.BoardUpdate{
+			Key:      s.LeaderboardKey(BOARD_STREAK, period, at),
+			UserID:   state.UserID,
+			Value:    float64(state.Streak),
+			At:       at,
+			Mode:     store.UPDATE_GT,
+			ExpireAt: expireAt,
+		})
+	}
+	return s.Boards.Update(ctx, updates...)
+}
+
+// UpdateSeasonLeaderboard sets the users score on the board of their season
+func (s *Server) UpdateSeasonLeaderboard(ctx context.Context, state models.UserState) error {
+	if !Listed(state) || state.SeasonID == "" {
+		return nil
+	}
+	return s.Boards.Update(ctx, store.BoardUpdate{
+		Key:    SeasonLeaderboardKey(state.SeasonID),
+		UserID: state.UserID,
+		Value:  state.SeasonScore,
+		At:     state.AchievedAt[PERIOD_SEASON],
+		Mode:   store.UPDATE_SET,
+	})
+}
+
+func (s *Server) RemoveFromLeaderboards(ctx context.Context, userID string) error {
+	now := time.Now()
+	season, err := s.CurrentSeason(ctx)
+	if err != nil {
+		return err
+	}
+	topics, err := s.Topics(ctx)
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	for _, board := range allBoards(topics) {
+		keys = append(keys, s.LeaderboardKey(board.Name, PERIOD_ALL, now))
+		if !board.Periodic {
+			continue
+		}
+		for _, period := range periods {
+			keys = append(keys, s.LeaderboardKey(board.Name, period, now))
+		}
+	}
+	if season != nil {
+		keys = append(keys, SeasonLeaderboardKey(season.Id))
+	}
+	return s.Boards.Remove(ctx, userID, keys...)
+}
+
+// RestoreToLeaderboards puts a user back on every board they were kept off,
+// the current periods are summed again from their answer-logs since the period
+// boards only ever get deltas
+func (s *Server) RestoreToLeaderboards(ctx context.Context, state models.UserState) error {
+	if !Listed(state) {
+		return nil
+	}
+	if err := s.UpdateLeaderboards(ctx, state); err != nil {
+		return err
+	}
+	if err := s.UpdateSeasonLeaderboard(ctx, state); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, period := range periods {
+		start := s.PeriodStart(period, now)
+		var totals []models.PeriodTotal
+		err := s.Answers.PeriodTotals(ctx, store.PeriodQuery{Start: start, UserIDs: []string{state.UserID}},
+			func(t models.PeriodTotal) error {
+				totals = append(totals, t)
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+		if len(totals) == 0 {
+			continue
+		}
+
+		total := totals[0]
+		expireAt := periodEnd(period, periodEnd(period, start))
+		err = s.Boards.Update(ctx, store.BoardUpdate{
+			Key:      s.LeaderboardKey(BOARD_SCORE, period, now),
+			UserID:   state.UserID,
+			Value:    total.Score,
+			At:       total.ScoreAt,
+			Mode:     store.UPDATE_SET,
+			ExpireAt: expireAt,
+		}, store.BoardUpdate{
+			Key:      s.LeaderboardKey(BOARD_STREAK, period, now),
+			UserID:   state.UserID,
+			Value:    float64(total.Streak),
+			At:       total.StreakAt,
+			Mode:     store.UPDATE_SET,
+			ExpireAt: expireAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BOARD_MISSING is what the reads below fail with when a board came back
+// empty because it isnt there at all, ie redis was flushed or evicted it. The
+// callers fall back to the store like on any other board error.
+const BOARD_MISSING = "leaderboard missing"
+
+// checkBoard tells an empty board apart from a missing one, only called on
+// empty answers so the boards that have users never pay for it. A board
+// nobody is on yet is missing too, the store has nothing to list either.
+func (s *Server) checkBoard(ctx context.Context, key string) error {
+	size, err := s.Boards.Size(ctx, key)
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		return errors.New(BOARD_MISSING)
+	}
+	return nil
+}
+
+// LeaderboardRange returns limit users with their values starting at the
+// 0 based position offset, in board order
+func (s *Server) LeaderboardRange(ctx context.Context, key string, offset int, limit int) ([]store.BoardEntry, error) {
+	entries, err := s.Boards.Range(ctx, key, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		if err := s.checkBoard(ctx, key); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// LeaderboardLookup returns the 0 based position and value of the user on a
+// board, false if they arent on it
+func (s *Server) LeaderboardLookup(ctx context.Context, key string, userID string) (int, float64, bool, error) {
+	entries, err := s.LeaderboardMembers(ctx, key, []string{userID})
+	if err != nil || len(entries) == 0 {
+		return 0, 0, false, err
+	}
+	return entries[0].Position, entries[0].Value, true, nil
+}
+
+// LeaderboardMembers returns which of the given users are on a board, in board order
+func (s *Server) LeaderboardMembers(ctx context.Context, key string, userIDs []string) ([]store.BoardEntry, error) {
+	entries, err := s.Boards.Lookup(ctx, key, userIDs...)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 && len(userIDs) > 0 {
+		if err := s.checkBoard(ctx, key); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// LeaderboardPosition returns the 0 based position of the user on a board,
+// false if they arent on it
+func (s *Server) LeaderboardPosition(ctx context.Context, key string, userID string) (int, bool, error) {
+	pos, _, listed, err := s.LeaderboardLookup(ctx, key, userID)
+	return pos, listed, err
+}
+
+// LeaderboardValue returns the users value on a board, 0 if they arent on it
+func (s *Server) LeaderboardValue(ctx context.Context, key string, userID string) (float64, error) {
+	_, value, _, err := s.LeaderboardLookup(ctx, key, userID)
+	return value, err
+}
+
+// LeaderboardRank is the rank a value has on a board under the given ranking
+func (s *Server) LeaderboardRank(ctx context.Context, key string, value float64, ranking string) (int, error) {
+	above, err := s.Boards.Above(ctx, key, value, ranking == RANK_DENSE)
+	if err != nil {
+		return 0, err
+	}
+	if above == 0 {
+		if err := s.checkBoard(ctx, key); err != nil {
+			return 0, err
+		}
+	}
+	return above + 1, nil
+}
+
+// RebuildLeaderboards refills the all time boards (topic boards included) from
+// user-state and the current period boards from answer-logs, returns the number
+// of users it went through
+func (s *Server) RebuildLeaderboards(ctx context.Context) (int, error) {
+	now := time.Now()
+
+	topics, err := s.Topics(ctx)
+	if err != nil {
+		return 0, err
+	}
+	keys := map[string]string{}
+	var rebuilt []string
+	for _, board := range allBoards(topics) {
+		keys[board.Name] = s.LeaderboardKey(board.Name, PERIOD_ALL, now)
+		rebuilt = append(rebuilt, keys[board.Name])
+	}
+	w, err := s.Boards.Rebuild(ctx, rebuilt...)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = s.States.Each(ctx, store.StateQuery{Public: true}, func(state models.UserState) error {
+		for _, board := range stateBoards(state) {
+			key, ok := keys[board.Name]
+			if !ok {
+				// topic no question has anymore
+				continue
+			}
+			value, ok := board.Value(state)
+			if !ok {
+				continue
+			}
+			if err := w.Add(ctx, key, state.UserID, value, board.AchievedAt(state)); err != nil {
+				return err
+			}
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+	if err := w.Finish(ctx, time.Time{}); err != nil {
+		return count, err
+	}
+
+	for _, period := range periods {
+		if err := s.rebuildPeriod(ctx, period, now); err != nil {
+			return count, err
+		}
+	}
+
+	season, err := s.CurrentSeason(ctx)
+	if err != nil {
+		return count, err
+	}
+	if season != nil {
+		if err := s.rebuildSeason(ctx, season.Id); err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+func (s *Server) rebuildSeason(ctx context.Context, seasonID string) error {
+	key := SeasonLeaderboardKey(seasonID)
+	w, err := s.Boards.Rebuild(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	err = s.States.Each(ctx, store.StateQuery{Public: true, SeasonID: seasonID}, func(state models.UserState) error {
+		return w.Add(ctx, key, state.UserID, state.SeasonScore, state.AchievedAt[PERIOD_SEASON])
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.Finish(ctx, time.Time{})
+}
+
+func (s *Server) rebuildPeriod(ctx context.Context, period string, now time.Time) error {
+	start := s.PeriodStart(period, now)
+
+	score, streak := s.LeaderboardKey(BOARD_SCORE, period, now), s.LeaderboardKey(BOARD_STREAK, period, now)
+	w, err := s.Boards.Rebuild(ctx, score, streak)
+	if err != nil {
+		return err
+	}
+
+	err = s.Answers.PeriodTotals(ctx, store.PeriodQuery{Start: start, Public: true}, func(total models.PeriodTotal) error {
+		if err := w.Add(ctx, score, total.UserID, total.Score, total.ScoreAt); err != nil {
+			return err
+		}
+		return w.Add(ctx, streak, total.UserID, float64(total.Streak), total.StreakAt)
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.Finish(ctx, periodEnd(period, periodEnd(period, start)))
+}
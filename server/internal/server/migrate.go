@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"log"
+	"server/internal/models"
+	"server/internal/store"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// the migrations go at the collections under the repos directly, they only
+// run against mongo
+
+// usernameIndex keeps handles unique, documents without a username
+// (not yet migrated) are left out so they dont collide on null
+func usernameIndex() mongo.IndexModel {
+	return mongo.IndexModel{
+		Keys: bson.D{{Key: "username", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"username": bson.M{"$type": "string"}}),
+	}
+}
+
+// answerKeyIndex keeps idempotency keys unique per user, two users picking the
+// same key dont collide
+func answerKeyIndex() mongo.IndexModel {
+	return mongo.IndexModel{
+		Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "ikey", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+}
+
+// ScopeAnswerKeys swaps the old globally unique ikey index for the per user
+// one. Safe to rerun, the old index is only dropped once.
+func (s *Server) ScopeAnswerKeys(ctx context.Context) error {
+	if err := s.DB.Collection(store.ANSWER_LOGS).CreateIndex(ctx, answerKeyIndex()); err != nil {
+		return err
+	}
+	return s.DB.Collection(store.ANSWER_LOGS).DropIndex(ctx, "ikey_1")
+}
+
+type legacyUser struct {
+	Username  string `bson:"_id"`
+	Role      string `bson:"role,omitempty"`
+	CreatedAt any    `bson:"createdAt"`
+}
+
+// MigrateToUserIDs rewrites users keyed by username to users keyed by a generated id.
+// Every step checks what is already done so the migration can be rerun after a crash.
+func (s *Server) MigrateToUserIDs(ctx context.Context) (int, error) {
+	if err := s.DB.Collection(store.USERS).CreateIndex(ctx, usernameIndex()); err != nil {
+		return 0, err
+	}
+
+	// legacy documents are the ones that never got a username field
+	cursor, err := s.DB.Collection(store.USERS).Find(ctx, bson.M{"username": bson.M{"$exists": false}})
+	if err != nil {
+		return 0, err
+	}
+	var legacy []legacyUser
+	if err := cursor.All(ctx, &legacy); err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, old := range legacy {
+		if err := s.migrateUser(ctx, old); err != nil {
+			return migrated, err
+		}
+		migrated++
+		log.Printf("migrated %s", old.Username)
+	}
+
+	return migrated, nil
+}
+
+func (s *Server) migrateUser(ctx context.Context, old legacyUser) error {
+	// reuse the id if a previous run already created the new document
+	var user models.User
+	err := s.DB.Collection(store.USERS).FindOne(ctx, bson.M{"username": old.Username}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		doc := bson.M{
+			"_id":       uuid.NewString(),
+			"username":  old.Username,
+			"createdAt": old.CreatedAt,
+		}
+		if old.Role != "" {
+			doc["role"] = old.Role
+		}
+		if _, err := s.DB.Collection(store.USERS).InsertOne(ctx, doc); err != nil {
+			return err
+		}
+		user.Id = doc["_id"].(string)
+	} else if err != nil {
+		return err
+	}
+
+	// _id cant be updated in place so the state is copied and the old one removed
+	var state models.UserState
+	err = s.DB.Collection(store.USER_STATE).FindOne(ctx, bson.M{"_id": old.Username}).Decode(&state)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	if err == nil {
+		state.UserID = user.Id
+		state.Username = old.Username
+		if _, err := s.DB.Collection(store.USER_STATE).ReplaceOne(ctx, bson.M{"_id": user.Id}, state,
+			options.Replace().SetUpsert(true)); err != nil {
+			return err
+		}
+		if _, err := s.DB.Collection(store.USER_STATE).DeleteOne(ctx, bson.M{"_id": old.Username}); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.DB.Collection(store.ANSWER_LOGS).UpdateMany(ctx,
+		bson.M{"username": old.Username, "userId": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"userId": user.Id}},
+	); err != nil {
+		return err
+	}
+
+	if _, err := s.CollAudit.UpdateMany(ctx,
+		bson.M{"subject": old.Username},
+		bson.M{"$set": bson.M{"subject": user.Id}},
+	); err != nil {
+		return err
+	}
+	if _, err := s.CollAudit.UpdateMany(ctx,
+		bson.M{"actor": old.Username},
+		bson.M{"$set": bson.M{"actor": user.Id}},
+	); err != nil {
+		return err
+	}
+
+	if err := s.DeleteCachedState(ctx, "user_state:"+old.Username); err != nil {
+		log.Println("cache error:", err)
+	}
+
+	_, err = s.DB.Collection(store.USERS).DeleteOne(ctx, bson.M{"_id": old.Username})
+	return err
+}
+
+type userBoardStats struct {
+	UserID        string `bson:"_id"`
+	MaxDifficulty int    `bson:"maxDifficulty"`
+	Topics        []struct {
+		Topic string  `bson:"topic"`
+		Score float64 `bson:"score"`
+	} `bson:"topics"`
+}
+
+// BackfillBoardStats fills accuracy, maxDifficulty and topicScores on states
+// written before those boards existed, from their answer-logs. Everything is
+// recomputed from scratch so it can be rerun.
+func (s *Server) BackfillBoardStats(ctx context.Context) (int, error) {
+	// old answer-logs dont carry the topic, take it from the question
+	cursor, err := s.DB.Collection(store.QUESTIONS).Find(ctx, bson.M{"topic": bson.M{"$type": "string"}})
+	if err != nil {
+		return 0, err
+	}
+	var questions []models.Question
+	if err := cursor.All(ctx, &questions); err != nil {
+		return 0, err
+	}
+	for _, q := range questions {
+		if _, err := s.DB.Collection(store.ANSWER_LOGS).UpdateMany(ctx,
+			bson.M{"questionId": q.Id, "topic": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"topic": q.Topic}},
+		); err != nil {
+			return 0, err
+		}
+	}
+
+	cursor, err = s.DB.Collection(store.ANSWER_LOGS).Aggregate(ctx, bson.A{
+		bson.M{"$group": bson.M{
+			"_id":        bson.M{"user": "$userId", "topic": "$topic"},
+			"score":      bson.M{"$sum": "$score"},
+			"difficulty": bson.M{"$max": "$difficulty"},
+		}},
+		bson.M{"$group": bson.M{
+			"_id":           "$_id.user",
+			"maxDifficulty": bson.M{"$max": "$difficulty"},
+			"topics":        bson.M{"$push": bson.M{"topic": "$_id.topic", "score": "$score"}},
+		}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	updated := 0
+	for cursor.Next(ctx) {
+		var stats userBoardStats
+		if err := cursor.Decode(&stats); err != nil {
+			return updated, err
+		}
+
+		topicScores := bson.M{}
+		for _, t := range stats.Topics {
+			if ValidTopic(t.Topic) {
+				topicScores[t.Topic] = t.Score
+			}
+		}
+
+		// the version bump makes any cached copy of the state fail its next write
+		res, err := s.DB.Collection(store.USER_STATE).UpdateOne(ctx, bson.M{"_id": stats.UserID}, bson.A{
+			bson.M{"$set": bson.M{
+				"topicScores":   bson.M{"$literal": topicScores},
+				"maxDifficulty": bson.M{"$max": bson.A{"$currentDifficulty", stats.MaxDifficulty}},
+				"stateVersion":  bson.M{"$add": bson.A{"$stateVersion", 1}},
+			}},
+		})
+		if err != nil {
+			return updated, err
+		}
+		if res.MatchedCount == 0 {
+			// anonymised logs of a deleted user
+			continue
+		}
+		if err := s.DeleteCachedState(ctx, "user_state:"+stats.UserID); err != nil {
+			log.Println("cache error:", err)
+		}
+		updated++
+	}
+	if err := cursor.Err(); err != nil {
+		return updated, err
+	}
+
+	_, err = s.DB.Collection(store.USER_STATE).UpdateMany(ctx, bson.M{}, bson.A{
+		bson.M{"$set": bson.M{"accuracy": bson.M{"$cond": bson.A{
+			bson.M{"$gt": bson.A{"$totalAnswered", 0}},
+			bson.M{"$divide": bson.A{"$totalCorrect", "$totalAnswered"}},
+			0,
+		}}}},
+	})
+	return updated, err
+}
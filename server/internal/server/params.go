@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"server/internal/config"
+	"server/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	PARAMS_CONFLICT   = "algorithm params changed since that version"
+	PARAMS_EXPERIMENT = "an experiment is running, end it before changing the params"
+
+	paramsGuard = "algorithm-params"
+)
+
+// Algorithm is the newest version of the algorithm params this instance has
+// seen, answers are scored with it
+func (s *Server) Algorithm() models.AlgorithmParams {
+	return *s.params.Load()
+}
+
+// setParams only ever moves forward, a poll that started before an update
+// cant put the old version back
+func (s *Server) setParams(p models.AlgorithmParams) {
+	for {
+		old := s.params.Load()
+		if old != nil && old.Version >= p.Version {
+			return
+		}
+		if s.params.CompareAndSwap(old, &p) {
+			if old != nil && old.Version > 0 {
+				log.Printf("algorithm params now on version %d", p.Version)
+			}
+			return
+		}
+	}
+}
+
+// LoadParams reads the newest stored params, the ones from the config are
+// stored as version 1 when there arent any yet
+func (s *Server) LoadParams(ctx context.Context) error {
+	p, err := s.NewestParams(ctx)
+	if err == mongo.ErrNoDocuments {
+		p = &models.AlgorithmParams{Version: 1, Params: s.Config.Algorithm, UpdatedAt: time.Now().UTC()}
+		_, err = s.CollParams.InsertOne(ctx, p)
+		if mongo.IsDuplicateKeyError(err) {
+			// another instance started at the same time and got there first
+			p, err = s.NewestParams(ctx)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	s.setParams(*p)
+
+	// made up front, two transactions both creating it could clash on the key
+	_, err = s.CollGuards.UpdateOne(ctx, bson.M{"_id": paramsGuard},
+		bson.M{"$setOnInsert": bson.M{"writes": 0}}, options.UpdateOne().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		err = nil
+	}
+	return err
+}
+
+// GuardParams goes first in the transaction of anything that checks the
+// params against the running experiment or the other way round. They all
+// write this one document, so of two running at once one is retried after
+// the other committed and sees what it wrote, instead of both going ahead on
+// what they read.
+func (s *Server) GuardParams(ctx context.Context) error {
+	_, err := s.CollGuards.UpdateOne(ctx, bson.M{"_id": paramsGuard},
+		bson.M{"$inc": bson.M{"writes": 1}}, options.UpdateOne().SetUpsert(true))
+	return err
+}
+
+func (s *Server) NewestParams(ctx context.Context) (*models.AlgorithmParams, error) {
+	var p models.AlgorithmParams
+	opts := options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}})
+	if err := s.CollParams.FindOne(ctx, bson.M{}, opts).Decode(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// WatchParams reloads the params and the running experiment every ParamsPoll
+// until ctx is done, that is how a change made on another instance gets here
+func (s *Server) WatchParams(ctx context.Context) {
+	ticker := time.NewTicker(s.Config.ParamsPoll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		loadCtx, cancel := context.WithTimeout(ctx, s.Config.DBTimeout)
+		if err := s.LoadParams(loadCtx); err != nil && ctx.Err() == nil {
+			log.Println("algorithm params reload error:", err)
+		}
+		if err := s.LoadExperiment(loadCtx); err != nil && ctx.Err() == nil {
+			log.Println("experiment reload error:", err)
+		}
+		cancel()
+	}
+}
+
+// UpdateParams stores params as the version after from, which has to be the
+// newest one. Two admins editing the same version cant both win. The params
+// are the control of a running experiment, they cant change under it.
+func (s *Server) UpdateParams(from int, params config.Algorithm, adminID string) (*models.AlgorithmParams, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	p := models.AlgorithmParams{
+		Version:   from + 1,
+		Params:    params,
+		UpdatedBy: adminID,
+		UpdatedAt: time.Now().UTC(),
+	}
+	// an experiment starting at the same time either sees the new version or
+	// is seen here, see GuardParams
+	err := s.DB.Transaction(ctx, func(ctx context.Context) error {
+		if err := s.GuardParams(ctx); err != nil {
+			return err
+		}
+
+		running, err := s.CollExperiments.CountDocuments(ctx, bson.M{"active": true})
+		if err != nil {
+			return err
+		}
+		if running > 0 {
+			return errors.New(PARAMS_EXPERIMENT)
+		}
+
+		newest, err := s.NewestParams(ctx)
+		if err != nil {
+			return err
+		}
+		if newest.Version != from {
+			return errors.New(PARAMS_CONFLICT)
+		}
+
+		if _, err := s.CollParams.InsertOne(ctx, p); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return errors.New(PARAMS_CONFLICT)
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.setParams(p)
+	return &p, nil
+}
+
+// ParamsHistory lists every stored version, newest first
+func (s *Server) ParamsHistory() ([]models.AlgorithmParams, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	cursor, err := s.CollParams.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	history := []models.AlgorithmParams{}
+	if err := cursor.All(ctx, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
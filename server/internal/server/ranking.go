@@ -0,0 +1,49 @@
+package server
+
+import (
+	"server/internal/models"
+	"time"
+)
+
+// Rank semantics, the same for list entries and the callers own rank.
+// Ties are always listed in the same order: higher value first, then whoever
+// reached that value first, then by user id.
+//
+//	standard  1, 2, 2, 4  users with a strictly higher value + 1
+//	dense     1, 2, 2, 3  distinct higher values + 1
+const (
+	RANK_STANDARD = "standard"
+	RANK_DENSE    = "dense"
+)
+
+func ValidRanking(ranking string) bool {
+	return ranking == RANK_STANDARD || ranking == RANK_DENSE
+}
+
+// AchievedAt is when the state reached its current value on a board, zero if
+// that was never recorded (states from before tie-breaking)
+func (b Board) AchievedAt(state models.UserState) time.Time {
+	return state.AchievedAt[b.Name]
+}
+
+// MarkAchievements stamps at on every board (and the season) where state moved
+// away from prev, so ties go to whoever got there first
+func MarkAchievements(prev models.UserState, state *models.UserState, at time.Time) {
+	achieved := make(map[string]time.Time, len(state.AchievedAt)+1)
+	for name, t := range state.AchievedAt {
+		achieved[name] = t
+	}
+
+	for _, board := range stateBoards(*state) {
+		value, _ := board.Value(*state)
+		before, listed := board.Value(prev)
+		if !listed || value != before {
+			achieved[board.Name] = at
+		}
+	}
+	if state.SeasonID != prev.SeasonID || state.SeasonScore != prev.SeasonScore {
+		achieved[PERIOD_SEASON] = at
+	}
+
+	state.AchievedAt = achieved
+}
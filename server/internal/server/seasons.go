@@ -0,0 +1,22 @@
+package server
+
+import (
+	"context"
+	"server/internal/models"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// CurrentSeason returns the active season, nil when no season is running
+func (s *Server) CurrentSeason(ctx context.Context) (*models.Season, error) {
+	var season models.Season
+	err := s.CollSeasons.FindOne(ctx, bson.M{"active": true}).Decode(&season)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &season, nil
+}
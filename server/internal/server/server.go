@@ -2,174 +2,376 @@ package server
 
 import (
 	"context"
-	"os"
+	"net/http"
+	"server/internal/config"
 	"server/internal/models"
+	"server/internal/store"
+	"server/internal/store/memstore"
+	"strings"
+	"sync/atomic"
 	"time"
+	_ "time/tzdata" // LEADERBOARD_TZ shouldnt depend on the image having zoneinfo
 
 	"github.com/go-redis/cache/v9"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/redis/go-redis/v9"
-	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 type Server struct {
-	MongoClient   *mongo.Client
-	JwtSecret     []byte
-	CollUsers     *mongo.Collection
-	CollUserState *mongo.Collection
-	CollQuestions *mongo.Collection
-	CollAnswerLog *mongo.Collection
-	StateCache    *cache.Cache
+	// the settings it was started with
+	Config    config.Config
+	DB        store.DB
+	JwtSecret []byte
+	// the core documents, see store.Repos
+	Users           store.UserRepo
+	States          store.StateRepo
+	Questions       store.QuestionRepo
+	Answers         store.AnswerLogRepo
+	CollAudit       store.Collection
+	CollSessions    store.Collection
+	CollSeasons     store.Collection
+	CollStandings   store.Collection
+	CollFriends     store.Collection
+	CollFriendCount store.Collection
+	CollTeams       store.Collection
+	CollMembers     store.Collection
+	CollInvites     store.Collection
+	CollReviews     store.Collection
+	CollParams      store.Collection
+	CollExperiments store.Collection
+	CollExposures   store.Collection
+	CollGuards      store.Collection
+	StateCache      *cache.Cache
+	// shared keys and the leaderboards, redis or memstore
+	Cache  store.Cache
+	Boards store.Boards
+	// cookie sessions
+	CookieSecure   bool
+	CookieSameSite http.SameSite
+	// daily, weekly and monthly leaderboards roll over at midnight here
+	LeaderboardLocation *time.Location
+	// newest algorithm params, see Algorithm
+	params atomic.Pointer[models.AlgorithmParams]
+	// running experiment, see RunningExperiment
+	experiment atomic.Pointer[models.Experiment]
 }
 
-func InitialiseServer() (*Server, error) {
-
-	uri := os.Getenv("MONGODB_URI")
-
-	token := []byte(os.Getenv("JWT_SECRET"))
-
-	clientOptions := options.Client().ApplyURI(uri)
+// InitialiseServer connects to what cfg points at, with memory storage
+// memstore stands in for mongo and redis and the sample questions are put in.
+// This is the one place that picks the backends.
+func InitialiseServer(cfg config.Config) (*Server, error) {
+	// cookies need https outside of local dev
+	secure := cfg.Env != "dev"
+	sameSite := http.SameSiteLaxMode
+	switch strings.ToLower(cfg.CookieSameSite) {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		// frontend on another site, browsers only allow this with Secure
+		sameSite = http.SameSiteNoneMode
+		secure = true
+	}
 
-	client, err := mongo.Connect(clientOptions)
+	loc, err := cfg.Location()
 	if err != nil {
 		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.StartupTimeout)
 	defer cancel()
 
-	if err := client.Ping(ctx, nil); err != nil {
+	var db store.DB
+	var shared store.Cache
+	var boards store.Boards
+	memory := cfg.Storage == config.STORAGE_MEMORY
+	if memory {
+		db, shared, boards = memstore.New(), memstore.NewCache(), memstore.NewBoards()
+	} else {
+		client, err := mongo.Connect(options.Client().ApplyURI(cfg.MongoURI))
+		if err != nil {
+			return nil, err
+		}
+		if err := client.Ping(ctx, nil); err != nil {
+			return nil, err
+		}
+		ring := redis.NewRing(&redis.RingOptions{
+			Addrs: cfg.RedisAddrs,
+		})
+		db, shared, boards = store.NewMongo(client, cfg.MongoDatabase), store.NewRedisCache(ring), store.NewRedisBoards(ring)
+	}
+
+	s := NewServer(db, shared, boards)
+	s.Config = cfg
+	s.JwtSecret = []byte(cfg.JWTSecret)
+	s.CookieSecure, s.CookieSameSite = secure, sameSite
+	s.LeaderboardLocation = loc
+
+	s.createIndexes(ctx)
+	if err := s.LoadParams(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.LoadExperiment(ctx); err != nil {
 		return nil, err
 	}
-	u := client.Database("scaler").Collection("Users")
-	p := client.Database("scaler").Collection("user-state")
-	q := client.Database("scaler").Collection("questions")
-	a := client.Database("scaler").Collection("answer-logs")
+	if memory {
+		s.PopulateQuestions()
+	}
+	return s, nil
+}
 
-	p.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "totalScore", Value: -1}},
-	})
-	p.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "maxStreak", Value: -1}},
+// NewServer puts a server together on its backends. It runs on the default
+// config and algorithm params, the jwt secret and cookie settings
+// InitialiseServer works out are left empty.
+func NewServer(db store.DB, shared store.Cache, boards store.Boards) *Server {
+	repos := db.Repos()
+	s := &Server{DB: db,
+		Users:           repos.Users,
+		States:          repos.States,
+		Questions:       repos.Questions,
+		Answers:         repos.Answers,
+		CollAudit:       db.Collection("audit-logs"),
+		CollSessions:    db.Collection("sessions"),
+		CollSeasons:     db.Collection("seasons"),
+		CollStandings:   db.Collection("season-standings"),
+		CollFriends:     db.Collection("friendships"),
+		CollFriendCount: db.Collection("friend-counts"),
+		CollTeams:       db.Collection("teams"),
+		CollMembers:     db.Collection(store.TEAM_MEMBERS),
+		CollInvites:     db.Collection("team-invites"),
+		CollReviews:     db.Collection("cheat-reviews"),
+		CollParams:      db.Collection("algorithm-params"),
+		CollExperiments: db.Collection("experiments"),
+		CollExposures:   db.Collection("experiment-exposures"),
+		CollGuards:      db.Collection("guards"),
+		StateCache: cache.New(&cache.Options{
+			LocalCache: cache.NewTinyLFU(1000, time.Minute),
+			Redis:      sharedCache{shared},
+		}),
+		Cache:               shared,
+		Boards:              boards,
+		Config:              config.Default(),
+		LeaderboardLocation: time.UTC,
+	}
+	// version 0 is only used until LoadParams
+	s.params.Store(&models.AlgorithmParams{Params: s.Config.Algorithm})
+	return s
+}
+
+// createIndexes is best effort like before, a failed index only costs speed
+// unless it is a unique one
+func (s *Server) createIndexes(ctx context.Context) {
+	u, p, a := s.DB.Collection(store.USERS), s.DB.Collection(store.USER_STATE), s.DB.Collection(store.ANSWER_LOGS)
+	l, se, sn, st := s.CollAudit, s.CollSessions, s.CollSeasons, s.CollStandings
+	fr, tm, mb, rv := s.CollFriends, s.CollTeams, s.CollMembers, s.CollReviews
+	ex, xp, iv := s.CollExperiments, s.CollExposures, s.CollInvites
+
+	u.CreateIndex(ctx, usernameIndex())
+
+	// one per board in board order (value, first to reach it, id), see quiz.viewSort
+	for _, board := range boards {
+		p.CreateIndex(ctx, mongo.IndexModel{
+			Keys: bson.D{
+				{Key: board.Field, Value: -1},
+				{Key: "achievedAt." + board.Name, Value: 1},
+				{Key: "_id", Value: -1},
+			},
+		})
+	}
+	// topic names arent known up front, the wildcard covers every topicScores.<topic>
+	p.CreateIndex(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "topicScores.$**", Value: 1}},
 	})
 
-	a.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.D{{Key: "ikey", Value: 1}},
-		Options: options.Index().SetUnique(true),
+	a.CreateIndex(ctx, answerKeyIndex())
+	a.CreateIndex(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}},
+	})
+	a.CreateIndex(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "answeredAt", Value: 1}},
+	})
+	// the anti-cheat scan walks answers user by user
+	a.CreateIndex(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "answeredAt", Value: 1}},
 	})
 
-	ring := redis.NewRing(&redis.RingOptions{
-		Addrs: map[string]string{
-			"server1": ":6379",
-			"server2": ":6380",
-		},
+	l.CreateIndex(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "subject", Value: 1}},
 	})
 
-	mycache := cache.New(&cache.Options{
-		Redis:      ring,
-		LocalCache: cache.NewTinyLFU(1000, time.Minute),
+	se.CreateIndex(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}},
 	})
 
-	return &Server{MongoClient: client, CollUsers: u, CollUserState: p,
-		CollQuestions: q, JwtSecret: token,
-		CollAnswerLog: a, StateCache: mycache}, nil
+	p.CreateIndex(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "seasonId", Value: 1},
+			{Key: "seasonScore", Value: -1},
+			{Key: "achievedAt." + PERIOD_SEASON, Value: 1},
+			{Key: "_id", Value: -1},
+		},
+	})
+	// at most one active season
+	sn.CreateIndex(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "active", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"active": true}),
+	})
+	st.CreateIndex(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "seasonId", Value: 1}, {Key: "rank", Value: 1}},
+	})
+	st.CreateIndex(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}},
+	})
+	fr.CreateIndex(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "users", Value: 1}, {Key: "status", Value: 1}},
+	})
+	tm.CreateIndex(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "nameKey", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	mb.CreateIndex(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "teamId", Value: 1}, {Key: "joinedAt", Value: 1}},
+	})
+	iv.CreateIndex(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: 1}},
+	})
+	iv.CreateIndex(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "teamId", Value: 1}},
+	})
+	rv.CreateIndex(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "flaggedAt", Value: 1}},
+	})
+	// at most one running experiment, and the analysis reads an experiments
+	// answers user by user
+	ex.CreateIndex(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "active", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"active": true}),
+	})
+	a.CreateIndex(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "experimentId", Value: 1}, {Key: "userId", Value: 1}, {Key: "answeredAt", Value: 1}},
+		Options: options.Index().
+			SetPartialFilterExpression(bson.M{"experimentId": bson.M{"$exists": true}}),
+	})
+	xp.CreateIndex(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "experimentId", Value: 1}, {Key: "variant", Value: 1}},
+	})
+	// mongo drops sessions once they expire
+	se.CreateIndex(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
 }
 
 func (s *Server) PopulateQuestions() {
-	questions := []interface{}{
-		models.Question{
+	questions := []models.Question{
+		{
 			Id:            "1",
 			Difficulty:    1,
+			Topic:         "cats",
 			Prompt:        "meow meow?",
 			Choices:       []string{"yes", "no", "lol idk", "haha"},
 			CorrectAnswer: "yes",
 		},
-		models.Question{
+		{
 			Id:            "11",
 			Difficulty:    1,
+			Topic:         "music",
 			Prompt:        "what happens when i turn my headlights on?",
 			Choices:       []string{"suddenly i can see", "ive got tunnel vision", "im an idiot", "i learn of right and wrong"},
 			CorrectAnswer: "suddenly i can see",
 		},
-		models.Question{
+		{
 			Id:            "2",
 			Difficulty:    4,
+			Topic:         "music",
 			Prompt:        "do u have a cloak?",
 			Choices:       []string{"yeah, its a bit of a joke", "no i dont wear clothes", "why are u asking me this", "im going to shoot myself"},
 			CorrectAnswer: "yeah, its a bit of a joke",
 		},
-		models.Question{
+		{
 			Id:            "3",
 			Difficulty:    2,
+			Topic:         "music",
 			Prompt:        "do u want to go home?",
 			Choices:       []string{"i want to leave the show", "yeah and take off this uniform", "the worms have entered my brain", "no lol i enjoy being in hell haha"},
 			CorrectAnswer: "no lol i enjoy being in hell haha",
 		},
-		models.Question{
+		{
 			Id:            "4",
 			Difficulty:    2,
+			Topic:         "music",
 			Prompt:        "would somebody care if u stayed with me?",
 			Choices:       []string{"baby u can stay and nobody would care", "just pretend im not there", "arnav bought me a gun i will use it one day, yall watch", "u can change"},
 			CorrectAnswer: "baby u can stay and nobody would care",
 		},
-		models.Question{
+		{
 			Id:            "5",
 			Difficulty:    3,
+			Topic:         "music",
 			Prompt:        "what will ur organs do?",
 			Choices:       []string{"soon ur organs will grow little mouths", "they will speak for themselves", "soon they will refuse to hold u up, so embarrased to bear your name", "idek what ur talking about"},
 			CorrectAnswer: "soon they will refuse to hold u up, so embarrased to bear your name",
 		},
-		models.Question{
+		{
 			Id:            "6",
 			Difficulty:    4,
+			Topic:         "music",
 			Prompt:        "what kind on sauce do u add?",
 			Choices:       []string{"its ltr just sauce", "awesome sauce", "idk i think sauce is a very broiad term", "add where? whatr are u even talking about? what are these questions i want a refund"},
 			CorrectAnswer: "awesome sauce",
 		},
-		models.Question{
+		{
 			Id:            "7",
 			Difficulty:    1,
+			Topic:         "music",
 			Prompt:        "what does she move with",
 			Choices:       []string{"im tired grandpa", "lmao wasting time rn but its okay", "a purpouse", "im dumb but happy"},
 			CorrectAnswer: "a purpouse",
 		},
-		models.Question{
+		{
 			Id:            "8",
 			Difficulty:    2,
+			Topic:         "music",
 			Prompt:        "what do u wish for?",
 			Choices:       []string{"late at night when im driving", "i wish that they would swoop down in a country lane", "take me on board their beautiful shit", "show me the world as id love to see it"},
 			CorrectAnswer: "i wish that they would swoop down in a country lane",
 		},
-		models.Question{
+		{
 			Id:            "9",
 			Difficulty:    3,
+			Topic:         "music",
 			Prompt:        "what has the bike got?",
 			Choices:       []string{"its got a basket a bell and things that make it look good", "idk haha what even is a bike", "i want a refund", "is this a pink floyd reference??"},
 			CorrectAnswer: "is this a pink floyd reference??",
 		},
-		models.Question{
+		{
 			Id:            "10",
 			Difficulty:    5,
+			Topic:         "music",
 			Prompt:        "what do u feel like?",
 			Choices:       []string{"i feel like squished face, slick pig living in a smokey city", "wait are all of these songs??? what is wrong with u", "guys, stop", "the worms have enetred my brain"},
 			CorrectAnswer: "i feel like squished face, slick pig living in a smokey city",
 		},
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
 	defer cancel()
 
-	s.CollQuestions.InsertMany(ctx, questions)
+	s.Questions.Insert(ctx, questions...)
 
 }
 
-func (s *Server) GenerateJWT(username string) (string, error) {
+// GenerateJWT signs a token for a stored session, the session id goes in "sid"
+func (s *Server) GenerateJWT(userID string, sessionID string, lifetime time.Duration) (string, error) {
 	claims := jwt.MapClaims{
-		"sub": username,
+		"sub": userID,
+		"sid": sessionID,
 		"iat": time.Now().Unix(),
-		"exp": time.Now().Add(30 * 24 * time.Hour).Unix(), // 30 days
+		"exp": time.Now().Add(lifetime).Unix(),
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(s.JwtSecret)
@@ -177,12 +379,15 @@ func (s *Server) GenerateJWT(username string) (string, error) {
 }
 
 func (s *Server) CacheState(ctx context.Context, state models.UserState, key string) error {
+	// tinylfu adds a second copy when a key is set again instead of replacing
+	// it, and an old copy can win later. dropping ours first keeps only one.
+	s.StateCache.DeleteFromLocalCache(key)
 
 	if err := s.StateCache.Set(&cache.Item{
 		Ctx:   ctx,
 		Key:   key,
 		Value: state,
-		TTL:   time.Hour,
+		TTL:   s.Config.StateCacheTTL,
 	}); err != nil {
 		return err
 	}
@@ -203,3 +408,78 @@ func (s *Server) GetCachedState(ctx context.Context, key string) (*models.UserSt
 	return &wanted, nil
 
 }
+
+func (s *Server) DeleteCachedState(ctx context.Context, key string) error {
+	return s.StateCache.Delete(ctx, key)
+}
+
+// DeleteCachedStates drops many cached states with one round trip to the
+// shared cache
+func (s *Server) DeleteCachedStates(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		s.StateCache.DeleteFromLocalCache(key)
+	}
+	return s.Cache.Del(ctx, keys...)
+}
+
+// sharedCache lets go-redis/cache keep its second level in a store.Cache, it
+// hands over the values already marshalled
+type sharedCache struct{ c store.Cache }
+
+func (r sharedCache) Set(ctx context.Context, key string, value any, ttl time.Duration) *redis.StatusCmd {
+	cmd := redis.NewStatusCmd(ctx)
+	if err := r.c.Set(ctx, key, value.([]byte), ttl); err != nil {
+		cmd.SetErr(err)
+	}
+	return cmd
+}
+
+func (r sharedCache) SetNX(ctx context.Context, key string, value any, ttl time.Duration) *redis.BoolCmd {
+	cmd := redis.NewBoolCmd(ctx)
+	ok, err := r.c.SetNX(ctx, key, value.([]byte), ttl)
+	cmd.SetVal(ok)
+	if err != nil {
+		cmd.SetErr(err)
+	}
+	return cmd
+}
+
+// SetXX isnt atomic here, the cache only uses it for SetXX items which
+// nothing in the server sets
+func (r sharedCache) SetXX(ctx context.Context, key string, value any, ttl time.Duration) *redis.BoolCmd {
+	cmd := redis.NewBoolCmd(ctx)
+	if _, err := r.c.Get(ctx, key); err != nil {
+		if err != store.ErrCacheMiss {
+			cmd.SetErr(err)
+		}
+		return cmd
+	}
+	if err := r.c.Set(ctx, key, value.([]byte), ttl); err != nil {
+		cmd.SetErr(err)
+		return cmd
+	}
+	cmd.SetVal(true)
+	return cmd
+}
+
+func (r sharedCache) Get(ctx context.Context, key string) *redis.StringCmd {
+	cmd := redis.NewStringCmd(ctx)
+	value, err := r.c.Get(ctx, key)
+	switch {
+	case err == store.ErrCacheMiss:
+		cmd.SetErr(redis.Nil)
+	case err != nil:
+		cmd.SetErr(err)
+	default:
+		cmd.SetVal(string(value))
+	}
+	return cmd
+}
+
+func (r sharedCache) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	cmd := redis.NewIntCmd(ctx)
+	if err := r.c.Del(ctx, keys...); err != nil {
+		cmd.SetErr(err)
+	}
+	return cmd
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"context"
+	"server/internal/models"
+	"server/internal/store/memstore"
+	"testing"
+)
+
+// the local cache keeps the first 10 sets of a key side by side, the 11th
+// pushes the oldest out into the main cache where it hides the newer ones
+func TestCacheStateKeepsNewest(t *testing.T) {
+	s := NewServer(memstore.New(), memstore.NewCache(), memstore.NewBoards())
+	ctx := context.Background()
+
+	for v := 1; v <= 20; v++ {
+		if err := s.CacheState(ctx, models.UserState{UserID: "u1", StateVersion: v}, "user_state:u1"); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.GetCachedState(ctx, "user_state:u1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.StateVersion != v {
+			t.Fatalf("cached version %d after setting %d", got.StateVersion, v)
+		}
+	}
+}
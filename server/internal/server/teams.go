@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"server/internal/models"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const NOT_IN_TEAM = "not in a team"
+
+// TeamOf returns the users membership, nil when they arent in a team
+func (s *Server) TeamOf(ctx context.Context, userID string) (*models.TeamMember, error) {
+	var member models.TeamMember
+	err := s.CollMembers.FindOne(ctx, bson.M{"_id": userID}).Decode(&member)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// LeaveTeam takes the user out of their team. An owner hands the team to the
+// member who joined first, the last one out disbands it.
+func (s *Server) LeaveTeam(ctx context.Context, userID string) error {
+	member, err := s.TeamOf(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if member == nil {
+		return errors.New(NOT_IN_TEAM)
+	}
+
+	res, err := s.CollMembers.DeleteOne(ctx, bson.M{"_id": userID, "teamId": member.TeamID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		// someone else removed them in the meantime
+		return errors.New(NOT_IN_TEAM)
+	}
+
+	var team models.Team
+	err = s.CollTeams.FindOneAndUpdate(ctx,
+		bson.M{"_id": member.TeamID},
+		bson.M{"$inc": bson.M{"memberCount": -1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&team)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if team.MemberCount <= 0 {
+		_, err := s.CollTeams.DeleteOne(ctx, bson.M{"_id": team.Id, "memberCount": bson.M{"$lte": 0}})
+		return err
+	}
+	if team.OwnerID != userID {
+		return nil
+	}
+
+	var next models.TeamMember
+	err = s.CollMembers.FindOneAndUpdate(ctx,
+		bson.M{"teamId": team.Id},
+		bson.M{"$set": bson.M{"role": models.TEAM_OWNER}},
+		options.FindOneAndUpdate().SetSort(bson.M{"joinedAt": 1}).SetReturnDocument(options.After),
+	).Decode(&next)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = s.CollTeams.UpdateOne(ctx, bson.M{"_id": team.Id}, bson.M{"$set": bson.M{"ownerId": next.UserID}})
+	return err
+}
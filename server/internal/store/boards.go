@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Boards keeps the leaderboards as sorted sets, redis in production and
+// memstore without it. Every board lists users the same way: higher value
+// first, then whoever reached the value first (no time at all counts as the
+// earliest, times only count to the ms), then by user id descending.
+type Boards interface {
+	// Update applies the updates in order
+	Update(ctx context.Context, updates ...BoardUpdate) error
+	// Remove takes the user off every board in keys
+	Remove(ctx context.Context, userID string, keys ...string) error
+	// Range returns up to limit users starting at the 0 based position offset
+	Range(ctx context.Context, key string, offset int, limit int) ([]BoardEntry, error)
+	// Lookup returns which of the users are on the board, in board order
+	Lookup(ctx context.Context, key string, userIDs ...string) ([]BoardEntry, error)
+	// Above counts the users with a higher value, or with distinct set the
+	// distinct values higher than value
+	Above(ctx context.Context, key string, value float64, distinct bool) (int, error)
+	// Size is how many users are on the board, 0 when there is no board
+	Size(ctx context.Context, key string) (int, error)
+	Delete(ctx context.Context, keys ...string) error
+	// Rebuild starts empty copies of the boards in keys, readers keep seeing
+	// the old ones until Finish swaps them in
+	Rebuild(ctx context.Context, keys ...string) (BoardRebuild, error)
+}
+
+// BoardEntry is one user on a board
+type BoardEntry struct {
+	UserID   string
+	Value    float64
+	Position int // 0 based
+}
+
+// how a BoardUpdate treats the value already on the board
+//
+//	set   take the value as is
+//	incr  add the value to the current one
+//	gt    only take the value if it beats the current one
+//
+// An unchanged value keeps its time so the user keeps their place in ties.
+const (
+	UPDATE_SET  = "set"
+	UPDATE_INCR = "incr"
+	UPDATE_GT   = "gt"
+)
+
+// BoardUpdate is one change to a board
+type BoardUpdate struct {
+	Key      string
+	UserID   string
+	Value    float64
+	At       time.Time // when the value was reached, used for ties
+	Mode     string
+	ExpireAt time.Time // zero keeps the board until it is deleted
+}
+
+// BoardRebuild fills the copies Boards.Rebuild started
+type BoardRebuild interface {
+	// Add puts a user on the copy of key, at is when they reached value
+	Add(ctx context.Context, key string, userID string, value float64, at time.Time) error
+	// Finish swaps every copy over its board, an empty copy deletes the board
+	Finish(ctx context.Context, expireAt time.Time) error
+}
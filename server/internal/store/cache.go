@@ -0,0 +1,33 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrCacheMiss comes back from Cache.Get for a key that isnt there
+var ErrCacheMiss = errors.New("cache miss")
+
+// Cache is the key value store every instance shares, redis in production and
+// memstore without it. Nothing in it is the only copy of anything.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// SetNX only sets a key that isnt there yet, false when it was
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	Del(ctx context.Context, keys ...string) error
+
+	// Allow counts a hit in the sliding window at key when it has fewer than
+	// limit hits inside the last window, otherwise it says how long until the
+	// oldest one drops out. id names the hit for Release.
+	Allow(ctx context.Context, key string, id string, limit int, window time.Duration) (bool, time.Duration, error)
+	// Release takes a hit Allow counted back out of its window
+	Release(ctx context.Context, key string, id string) error
+
+	// Publish sends payload to every subscriber of channel on any instance
+	Publish(ctx context.Context, channel string, payload []byte) error
+	// Subscribe delivers what is published on channel until ctx is done.
+	// Messages sent while a subscriber is busy or disconnected are lost.
+	Subscribe(ctx context.Context, channel string) <-chan []byte
+}
@@ -0,0 +1,178 @@
+package memstore
+
+import (
+	"cmp"
+	"context"
+	"server/internal/models"
+	"server/internal/store"
+	"slices"
+	"strings"
+	"time"
+)
+
+type answers struct {
+	*table[models.AnswerLog]
+	states *states // for the public period totals
+}
+
+func (r *answers) find(userID string, ikey string) (models.AnswerLog, bool) {
+	for _, a := range r.rows {
+		if a.v.UserID == userID && a.v.IdempotencyKey == ikey {
+			return roundTrip(a.v), true
+		}
+	}
+	return models.AnswerLog{}, false
+}
+
+func (r *answers) Insert(ctx context.Context, entry models.AnswerLog) error {
+	defer r.db.lock(ctx)()
+
+	if _, ok := r.rows[entry.Id]; ok {
+		return store.ErrDuplicate
+	}
+	if _, ok := r.find(entry.UserID, entry.IdempotencyKey); ok {
+		return store.ErrDuplicate
+	}
+	r.put(entry.Id, entry)
+	return nil
+}
+
+func (r *answers) Get(ctx context.Context, userID string, ikey string) (*models.AnswerLog, error) {
+	defer r.db.lock(ctx)()
+
+	entry, ok := r.find(userID, ikey)
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return &entry, nil
+}
+
+func byAnsweredAt(a, b models.AnswerLog) int {
+	return a.AnsweredAt.Compare(b.AnsweredAt)
+}
+
+func (r *answers) ForUser(ctx context.Context, userID string) ([]models.AnswerLog, error) {
+	defer r.db.lock(ctx)()
+
+	found := []models.AnswerLog{}
+	for _, a := range r.all() {
+		if a.UserID == userID {
+			found = append(found, a)
+		}
+	}
+	slices.SortStableFunc(found, byAnsweredAt)
+	return found, nil
+}
+
+// matching is every answer q matches by user then time, taken under the lock
+func (r *answers) matching(ctx context.Context, q store.AnswerQuery) []models.AnswerLog {
+	defer r.db.lock(ctx)()
+
+	var found []models.AnswerLog
+	for _, a := range r.all() {
+		if a.AnsweredAt.Before(q.Since) || q.ExperimentID != "" && a.ExperimentID != q.ExperimentID {
+			continue
+		}
+		a.Response = nil
+		found = append(found, a)
+	}
+	slices.SortStableFunc(found, func(a, b models.AnswerLog) int {
+		return cmp.Or(strings.Compare(a.UserID, b.UserID), byAnsweredAt(a, b))
+	})
+	return found
+}
+
+func (r *answers) EachUser(ctx context.Context, q store.AnswerQuery, fn func([]models.AnswerLog) error) error {
+	found := r.matching(ctx, q)
+	for len(found) > 0 {
+		n := 1
+		for n < len(found) && found[n].UserID == found[0].UserID {
+			n++
+		}
+		if err := fn(found[:n]); err != nil {
+			return err
+		}
+		found = found[n:]
+	}
+	return nil
+}
+
+func (r *answers) Anonymize(ctx context.Context, userID string, anon string) (int64, error) {
+	defer r.db.lock(ctx)()
+
+	var n int64
+	for _, a := range r.all() {
+		if a.UserID == userID {
+			a.UserID, a.Username = anon, anon
+			r.put(a.Id, a)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// totals sums the answers per user like the mongo pipeline does, taken under
+// the lock
+func (r *answers) totals(ctx context.Context, q store.PeriodQuery) []models.PeriodTotal {
+	defer r.db.lock(ctx)()
+
+	sums := map[string]*models.PeriodTotal{}
+	var order []string
+	for _, a := range r.all() {
+		if a.AnsweredAt.Before(q.Start) || q.UserIDs != nil && !slices.Contains(q.UserIDs, a.UserID) {
+			continue
+		}
+		t, ok := sums[a.UserID]
+		if !ok {
+			t = &models.PeriodTotal{UserID: a.UserID, Streak: a.StreakAtAnswer, StreakAt: a.AnsweredAt}
+			sums[a.UserID] = t
+			order = append(order, a.UserID)
+		}
+		t.Score += a.ScoreDelta
+		t.Answered++
+		if a.Correct {
+			t.Correct++
+		}
+		// the best streak, first reached
+		if a.StreakAtAnswer > t.Streak || a.StreakAtAnswer == t.Streak && a.AnsweredAt.Before(t.StreakAt) {
+			t.Streak, t.StreakAt = a.StreakAtAnswer, a.AnsweredAt
+		}
+		// the score last moved on the last answer that scored
+		if a.ScoreDelta != 0 && a.AnsweredAt.After(t.ScoreAt) {
+			t.ScoreAt = a.AnsweredAt
+		}
+	}
+
+	totals := make([]models.PeriodTotal, 0, len(order))
+	for _, id := range order {
+		t := sums[id]
+		if q.Public {
+			state, ok := r.states.get(id)
+			if !ok || !public(state) {
+				continue
+			}
+			t.Username = state.Username
+		}
+		totals = append(totals, *t)
+	}
+	return totals
+}
+
+func (r *answers) PeriodTotals(ctx context.Context, q store.PeriodQuery, fn func(models.PeriodTotal) error) error {
+	for _, t := range r.totals(ctx, q) {
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *answers) PeriodBoard(start time.Time, streak bool) store.BoardQueries {
+	return sortedBoard(func(ctx context.Context) ([]store.BoardRow, error) {
+		var rows []store.BoardRow
+		for _, t := range r.totals(ctx, store.PeriodQuery{Start: start, Public: true}) {
+			rows = append(rows, store.PeriodRow(t, streak))
+		}
+		return rows, nil
+	})
+}
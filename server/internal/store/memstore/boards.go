@@ -0,0 +1,245 @@
+package memstore
+
+import (
+	"cmp"
+	"context"
+	"server/internal/store"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Boards keeps sorted boards in memory, in the same order as the redis ones
+type Boards struct {
+	mu     sync.Mutex
+	boards map[string]*board
+}
+
+var _ store.Boards = (*Boards)(nil)
+
+func NewBoards() *Boards {
+	return &Boards{boards: map[string]*board{}}
+}
+
+type boardEntry struct {
+	userID string
+	value  float64
+	tie    int64 // store.TieMs of when the value was reached
+}
+
+// board is kept sorted, users maps a user id to their entry
+type board struct {
+	entries  []boardEntry
+	users    map[string]boardEntry
+	expireAt time.Time
+}
+
+func newBoard() *board {
+	return &board{users: map[string]boardEntry{}}
+}
+
+func compareEntries(a, b boardEntry) int {
+	if c := cmp.Compare(b.value, a.value); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.tie, b.tie); c != 0 {
+		return c
+	}
+	return cmp.Compare(b.userID, a.userID)
+}
+
+// position is where e is, or would go, on the board
+func (b *board) position(e boardEntry) int {
+	i, _ := slices.BinarySearchFunc(b.entries, e, compareEntries)
+	return i
+}
+
+func (b *board) put(e boardEntry) {
+	b.remove(e.userID)
+	b.entries = slices.Insert(b.entries, b.position(e), e)
+	b.users[e.userID] = e
+}
+
+func (b *board) remove(userID string) {
+	old, ok := b.users[userID]
+	if !ok {
+		return
+	}
+	b.entries = slices.Delete(b.entries, b.position(old), b.position(old)+1)
+	delete(b.users, userID)
+}
+
+// get returns the live board at key, nil when there is none. Expired boards
+// go away the first time they are looked at.
+func (bs *Boards) get(key string) *board {
+	b, ok := bs.boards[key]
+	if !ok {
+		return nil
+	}
+	if !b.expireAt.IsZero() && !time.Now().Before(b.expireAt) {
+		delete(bs.boards, key)
+		return nil
+	}
+	return b
+}
+
+func (bs *Boards) Update(ctx context.Context, updates ...store.BoardUpdate) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	for _, u := range updates {
+		b := bs.get(u.Key)
+		if b == nil {
+			b = newBoard()
+			bs.boards[u.Key] = b
+		}
+
+		e := boardEntry{userID: u.UserID, value: u.Value, tie: store.TieMs(u.At)}
+		old, listed := b.users[u.UserID]
+		changed := true
+		if listed {
+			switch u.Mode {
+			case store.UPDATE_INCR:
+				changed = u.Value != 0
+				e.value = old.value + u.Value
+			case store.UPDATE_GT:
+				changed = u.Value > old.value
+			default:
+				changed = u.Value != old.value
+			}
+		}
+		if changed {
+			b.put(e)
+		}
+		if !u.ExpireAt.IsZero() {
+			b.expireAt = u.ExpireAt
+		}
+	}
+	return nil
+}
+
+func (bs *Boards) Remove(ctx context.Context, userID string, keys ...string) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	for _, key := range keys {
+		if b := bs.get(key); b != nil {
+			b.remove(userID)
+		}
+	}
+	return nil
+}
+
+func (bs *Boards) Range(ctx context.Context, key string, offset int, limit int) ([]store.BoardEntry, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	b := bs.get(key)
+	if b == nil {
+		return []store.BoardEntry{}, nil
+	}
+	start := min(offset, len(b.entries))
+	end := min(start+limit, len(b.entries))
+	entries := make([]store.BoardEntry, 0, end-start)
+	for i, e := range b.entries[start:end] {
+		entries = append(entries, store.BoardEntry{UserID: e.userID, Value: e.value, Position: start + i})
+	}
+	return entries, nil
+}
+
+func (bs *Boards) Lookup(ctx context.Context, key string, userIDs ...string) ([]store.BoardEntry, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	entries := []store.BoardEntry{}
+	b := bs.get(key)
+	if b == nil {
+		return entries, nil
+	}
+	for _, id := range userIDs {
+		if e, ok := b.users[id]; ok {
+			entries = append(entries, store.BoardEntry{UserID: id, Value: e.value, Position: b.position(e)})
+		}
+	}
+	slices.SortFunc(entries, func(a, b store.BoardEntry) int { return cmp.Compare(a.Position, b.Position) })
+	return entries, nil
+}
+
+func (bs *Boards) Above(ctx context.Context, key string, value float64, distinct bool) (int, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	b := bs.get(key)
+	if b == nil {
+		return 0, nil
+	}
+	above := 0
+	for i, e := range b.entries {
+		if e.value <= value {
+			break
+		}
+		if !distinct || i == 0 || e.value != b.entries[i-1].value {
+			above++
+		}
+	}
+	return above, nil
+}
+
+func (bs *Boards) Size(ctx context.Context, key string) (int, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if b := bs.get(key); b != nil {
+		return len(b.entries), nil
+	}
+	return 0, nil
+}
+
+func (bs *Boards) Delete(ctx context.Context, keys ...string) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	for _, key := range keys {
+		delete(bs.boards, key)
+	}
+	return nil
+}
+
+// rebuild fills new boards off to the side, Finish swaps them in
+type rebuild struct {
+	bs     *Boards
+	boards map[string]*board
+}
+
+func (bs *Boards) Rebuild(ctx context.Context, keys ...string) (store.BoardRebuild, error) {
+	r := &rebuild{bs: bs, boards: map[string]*board{}}
+	for _, key := range keys {
+		r.boards[key] = newBoard()
+	}
+	return r, nil
+}
+
+func (r *rebuild) Add(ctx context.Context, key string, userID string, value float64, at time.Time) error {
+	b, ok := r.boards[key]
+	if !ok {
+		b = newBoard()
+		r.boards[key] = b
+	}
+	b.put(boardEntry{userID: userID, value: value, tie: store.TieMs(at)})
+	return nil
+}
+
+func (r *rebuild) Finish(ctx context.Context, expireAt time.Time) error {
+	r.bs.mu.Lock()
+	defer r.bs.mu.Unlock()
+
+	for key, b := range r.boards {
+		if len(b.entries) == 0 {
+			delete(r.bs.boards, key)
+			continue
+		}
+		b.expireAt = expireAt
+		r.bs.boards[key] = b
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package memstore
+
+import (
+	"context"
+	"server/internal/store"
+	"testing"
+	"time"
+)
+
+func boardIDs(t *testing.T, entries []store.BoardEntry) []string {
+	t.Helper()
+	ids := make([]string, 0, len(entries))
+	for i, e := range entries {
+		if i > 0 && e.Position <= entries[i-1].Position {
+			t.Fatalf("positions out of order: %+v", entries)
+		}
+		ids = append(ids, e.UserID)
+	}
+	return ids
+}
+
+func sameIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// value desc, then whoever got there first (no time first), then id desc
+func TestBoardsOrder(t *testing.T) {
+	ctx := context.Background()
+	bs := NewBoards()
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	err := bs.Update(ctx,
+		store.BoardUpdate{Key: "b", UserID: "a", Value: 5, At: t0.Add(time.Second)},
+		store.BoardUpdate{Key: "b", UserID: "b", Value: 5, At: t0},
+		store.BoardUpdate{Key: "b", UserID: "c", Value: 5},
+		store.BoardUpdate{Key: "b", UserID: "d", Value: 9, At: t0},
+		store.BoardUpdate{Key: "b", UserID: "e", Value: 5, At: t0},
+		store.BoardUpdate{Key: "b", UserID: "f", Value: 1, At: t0},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page, _ := bs.Range(ctx, "b", 0, 10)
+	if got, want := boardIDs(t, page), []string{"d", "c", "e", "b", "a", "f"}; !sameIDs(got, want) {
+		t.Fatalf("order %v, want %v", got, want)
+	}
+	page, _ = bs.Range(ctx, "b", 4, 10)
+	if got := boardIDs(t, page); !sameIDs(got, []string{"a", "f"}) || page[0].Position != 4 {
+		t.Fatalf("page from 4: %+v", page)
+	}
+
+	found, _ := bs.Lookup(ctx, "b", "f", "missing", "e")
+	if got := boardIDs(t, found); !sameIDs(got, []string{"e", "f"}) || found[0].Position != 2 || found[1].Value != 1 {
+		t.Fatalf("lookup: %+v", found)
+	}
+
+	for _, c := range []struct {
+		value    float64
+		distinct bool
+		want     int
+	}{
+		{5, false, 1},
+		{1, false, 5},
+		{1, true, 2},
+		{0, true, 3},
+		{9, false, 0},
+	} {
+		if got, _ := bs.Above(ctx, "b", c.value, c.distinct); got != c.want {
+			t.Errorf("above %v distinct %v: %d, want %d", c.value, c.distinct, got, c.want)
+		}
+	}
+}
+
+func TestBoardsModes(t *testing.T) {
+	ctx := context.Background()
+	bs := NewBoards()
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	value := func(id string) (float64, int) {
+		t.Helper()
+		found, err := bs.Lookup(ctx, "b", id)
+		if err != nil || len(found) != 1 {
+			t.Fatalf("lookup %s: %v %v", id, found, err)
+		}
+		return found[0].Value, found[0].Position
+	}
+
+	bs.Update(ctx,
+		store.BoardUpdate{Key: "b", UserID: "a", Value: 3, At: t0, Mode: store.UPDATE_INCR},
+		store.BoardUpdate{Key: "b", UserID: "z", Value: 5, At: t0.Add(time.Minute)},
+		store.BoardUpdate{Key: "b", UserID: "a", Value: 2, At: t0.Add(2 * time.Minute), Mode: store.UPDATE_INCR},
+	)
+	if v, pos := value("a"); v != 5 || pos != 1 {
+		t.Fatalf("incr to 5 later than z: value %v position %d", v, pos)
+	}
+
+	// an incr of 0 doesnt count as reaching the value again
+	bs.Update(ctx, store.BoardUpdate{Key: "b", UserID: "z", Value: 0, At: t0.Add(time.Hour), Mode: store.UPDATE_INCR})
+	if _, pos := value("z"); pos != 0 {
+		t.Fatal("incr of 0 moved z down the tie")
+	}
+
+	bs.Update(ctx, store.BoardUpdate{Key: "b", UserID: "z", Value: 4, Mode: store.UPDATE_GT})
+	if v, _ := value("z"); v != 5 {
+		t.Fatalf("gt took a lower value, %v", v)
+	}
+	bs.Update(ctx, store.BoardUpdate{Key: "b", UserID: "z", Value: 5, At: t0.Add(time.Hour)})
+	if _, pos := value("z"); pos != 0 {
+		t.Fatal("setting the same value moved z down the tie")
+	}
+
+	bs.Remove(ctx, "z", "b", "other")
+	if found, _ := bs.Lookup(ctx, "b", "z"); len(found) != 0 {
+		t.Fatal("z still on the board")
+	}
+}
+
+func TestBoardsExpireAndRebuild(t *testing.T) {
+	ctx := context.Background()
+	bs := NewBoards()
+
+	bs.Update(ctx, store.BoardUpdate{Key: "old", UserID: "a", Value: 1, ExpireAt: time.Now().Add(-time.Second)})
+	if page, _ := bs.Range(ctx, "old", 0, 10); len(page) != 0 {
+		t.Fatal("expired board still listed")
+	}
+
+	bs.Update(ctx, store.BoardUpdate{Key: "b", UserID: "stale", Value: 9}, store.BoardUpdate{Key: "gone", UserID: "a", Value: 1})
+	r, err := bs.Rebuild(ctx, "b", "gone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Add(ctx, "b", "a", 2, time.Time{})
+	r.Add(ctx, "b", "b", 3, time.Time{})
+	if page, _ := bs.Range(ctx, "b", 0, 10); !sameIDs(boardIDs(t, page), []string{"stale"}) {
+		t.Fatal("rebuild showed before Finish")
+	}
+	if err := r.Finish(ctx, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if page, _ := bs.Range(ctx, "b", 0, 10); !sameIDs(boardIDs(t, page), []string{"b", "a"}) {
+		t.Fatalf("rebuilt board %+v", page)
+	}
+	if page, _ := bs.Range(ctx, "gone", 0, 10); len(page) != 0 {
+		t.Fatal("an empty rebuild should delete the board")
+	}
+}
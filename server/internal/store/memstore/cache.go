@@ -0,0 +1,165 @@
+package memstore
+
+import (
+	"context"
+	"server/internal/store"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Cache is store.Cache for a single instance, keys expire when they are read
+// after their ttl
+type Cache struct {
+	mu      sync.Mutex
+	values  map[string]cached
+	windows map[string][]hit
+	subs    map[string]map[chan []byte]struct{}
+	now     func() time.Time // time.Now, tests move the clock by hand
+}
+
+var _ store.Cache = (*Cache)(nil)
+
+// hit is one request counted in a sliding window
+type hit struct {
+	at int64 // unix ms
+	id string
+}
+
+type cached struct {
+	value     []byte
+	expiresAt time.Time // zero never expires
+}
+
+func NewCache() *Cache {
+	return &Cache{
+		values:  map[string]cached{},
+		windows: map[string][]hit{},
+		subs:    map[string]map[chan []byte]struct{}{},
+		now:     time.Now,
+	}
+}
+
+// live is the value at key unless it expired
+func (c *Cache) live(key string) ([]byte, bool) {
+	v, ok := c.values[key]
+	if !ok {
+		return nil, false
+	}
+	if !v.expiresAt.IsZero() && !c.now().Before(v.expiresAt) {
+		delete(c.values, key)
+		return nil, false
+	}
+	return v.value, true
+}
+
+func (c *Cache) set(key string, value []byte, ttl time.Duration) {
+	v := cached{value: slices.Clone(value)}
+	if ttl > 0 {
+		v.expiresAt = c.now().Add(ttl)
+	}
+	c.values[key] = v
+}
+
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value, ok := c.live(key)
+	if !ok {
+		return nil, store.ErrCacheMiss
+	}
+	return slices.Clone(value), nil
+}
+
+func (c *Cache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(key, value, ttl)
+	return nil
+}
+
+func (c *Cache) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.live(key); ok {
+		return false, nil
+	}
+	c.set(key, value, ttl)
+	return true, nil
+}
+
+func (c *Cache) Del(ctx context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		delete(c.values, key)
+		delete(c.windows, key)
+	}
+	return nil
+}
+
+// Allow is the sliding window of the redis script on a slice of hits
+func (c *Cache) Allow(ctx context.Context, key string, id string, limit int, window time.Duration) (bool, time.Duration, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now().UnixMilli()
+	ms := window.Milliseconds()
+
+	hits := c.windows[key]
+	for len(hits) > 0 && hits[0].at <= now-ms {
+		hits = hits[1:]
+	}
+	if len(hits) < limit {
+		c.windows[key] = append(hits, hit{at: now, id: id})
+		return true, 0, nil
+	}
+	c.windows[key] = hits
+	return false, time.Duration(hits[0].at+ms-now) * time.Millisecond, nil
+}
+
+func (c *Cache) Release(ctx context.Context, key string, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.windows[key] = slices.DeleteFunc(c.windows[key], func(h hit) bool { return h.id == id })
+	return nil
+}
+
+func (c *Cache) Publish(ctx context.Context, channel string, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for sub := range c.subs[channel] {
+		select {
+		case sub <- slices.Clone(payload):
+		default:
+			// like a redis subscriber that cant keep up, it misses this one
+		}
+	}
+	return nil
+}
+
+func (c *Cache) Subscribe(ctx context.Context, channel string) <-chan []byte {
+	sub := make(chan []byte, 64)
+
+	c.mu.Lock()
+	if c.subs[channel] == nil {
+		c.subs[channel] = map[chan []byte]struct{}{}
+	}
+	c.subs[channel][sub] = struct{}{}
+	c.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		c.mu.Lock()
+		delete(c.subs[channel], sub)
+		c.mu.Unlock()
+		close(sub)
+	}()
+	return sub
+}
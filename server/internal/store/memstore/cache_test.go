@@ -0,0 +1,110 @@
+package memstore
+
+import (
+	"context"
+	"server/internal/store"
+	"testing"
+	"time"
+)
+
+func TestCacheKeys(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache()
+
+	if _, err := c.Get(ctx, "k"); err != store.ErrCacheMiss {
+		t.Fatalf("missing key: %v", err)
+	}
+	c.Set(ctx, "k", []byte("v"), 0)
+	if ok, _ := c.SetNX(ctx, "k", []byte("other"), 0); ok {
+		t.Fatal("SetNX replaced a key")
+	}
+	if v, err := c.Get(ctx, "k"); err != nil || string(v) != "v" {
+		t.Fatalf("got %q %v", v, err)
+	}
+
+	c.Set(ctx, "short", []byte("v"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, err := c.Get(ctx, "short"); err != store.ErrCacheMiss {
+		t.Fatal("key outlived its ttl")
+	}
+	if ok, _ := c.SetNX(ctx, "short", []byte("again"), 0); !ok {
+		t.Fatal("SetNX refused an expired key")
+	}
+
+	c.Del(ctx, "k", "short")
+	if _, err := c.Get(ctx, "k"); err != store.ErrCacheMiss {
+		t.Fatal("deleted key still there")
+	}
+}
+
+func TestCacheAllow(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache()
+	now := time.UnixMilli(1_000_000)
+	c.now = func() time.Time { return now }
+	window := time.Second
+	allow := func(key string, id string) (bool, time.Duration) {
+		t.Helper()
+		ok, retry, err := c.Allow(ctx, key, id, 2, window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok, retry
+	}
+
+	if ok, _ := allow("w", "a"); !ok {
+		t.Fatal("first hit rejected")
+	}
+	now = now.Add(600 * time.Millisecond)
+	if ok, _ := allow("w", "b"); !ok {
+		t.Fatal("second hit rejected")
+	}
+	now = now.Add(300 * time.Millisecond)
+	if ok, retry := allow("w", "c"); ok || retry != 100*time.Millisecond {
+		t.Fatalf("third hit: allowed %v retry %v", ok, retry)
+	}
+	if ok, _ := allow("other", "c"); !ok {
+		t.Fatal("keys share a window")
+	}
+
+	// a hit drops out exactly one window after it, the window slides instead
+	// of starting over so b still counts
+	now = now.Add(99 * time.Millisecond)
+	if ok, retry := allow("w", "c"); ok || retry != time.Millisecond {
+		t.Fatalf("just inside the window: allowed %v retry %v", ok, retry)
+	}
+	now = now.Add(time.Millisecond)
+	if ok, _ := allow("w", "c"); !ok {
+		t.Fatal("still rejected once a moved out")
+	}
+	if ok, retry := allow("w", "d"); ok || retry != 600*time.Millisecond {
+		t.Fatalf("b and c fill the window: allowed %v retry %v", ok, retry)
+	}
+
+	// a released hit frees its slot, releasing what isnt there does nothing
+	c.Release(ctx, "w", "b")
+	c.Release(ctx, "w", "nope")
+	if ok, _ := allow("w", "d"); !ok {
+		t.Fatal("released slot not freed")
+	}
+	if ok, _ := allow("w", "e"); ok {
+		t.Fatal("c and d should fill the window")
+	}
+}
+
+func TestCachePubSub(t *testing.T) {
+	c := NewCache()
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := c.Subscribe(ctx, "ch")
+
+	c.Publish(ctx, "other", []byte("no"))
+	c.Publish(ctx, "ch", []byte("yes"))
+	if got := <-sub; string(got) != "yes" {
+		t.Fatalf("got %q", got)
+	}
+
+	cancel()
+	if _, open := <-sub; open {
+		t.Fatal("subscription still open after ctx is done")
+	}
+}
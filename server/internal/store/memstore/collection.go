@@ -0,0 +1,394 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"server/internal/store"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var _ store.Collection = (*Collection)(nil)
+
+func (c *Collection) Find(ctx context.Context, filter any, opts ...options.Lister[options.FindOptions]) (*mongo.Cursor, error) {
+	o, err := applyOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	f, err := normalizeDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := c.db.lock(ctx)
+	defer unlock()
+
+	docs, _, err := c.findSorted(f, o.Sort, o.Skip)
+	if err != nil {
+		return nil, err
+	}
+	if o.Limit != nil && *o.Limit > 0 {
+		docs = docs[:min(int(*o.Limit), len(docs))]
+	}
+	for i, d := range docs {
+		if docs[i], err = projected(d, o.Projection); err != nil {
+			return nil, err
+		}
+	}
+	return cursor(docs)
+}
+
+func (c *Collection) FindOne(ctx context.Context, filter any, opts ...options.Lister[options.FindOneOptions]) *mongo.SingleResult {
+	o, err := applyOptions(opts)
+	if err != nil {
+		return single(nil, err)
+	}
+	f, err := normalizeDoc(filter)
+	if err != nil {
+		return single(nil, err)
+	}
+
+	unlock := c.db.lock(ctx)
+	defer unlock()
+
+	docs, _, err := c.findSorted(f, o.Sort, o.Skip)
+	if err != nil || len(docs) == 0 {
+		return single(nil, err)
+	}
+	return single(projected(docs[0], o.Projection))
+}
+
+func (c *Collection) FindOneAndUpdate(ctx context.Context, filter any, update any, opts ...options.Lister[options.FindOneAndUpdateOptions]) *mongo.SingleResult {
+	o, err := applyOptions(opts)
+	if err != nil {
+		return single(nil, err)
+	}
+	f, err := normalizeDoc(filter)
+	if err != nil {
+		return single(nil, err)
+	}
+	u, err := normalize(update)
+	if err != nil {
+		return single(nil, err)
+	}
+
+	unlock := c.db.lock(ctx)
+	defer unlock()
+
+	upsert := o.Upsert != nil && *o.Upsert
+	_, before, after, err := c.update(f, u, upsert, false, o.Sort)
+	if err != nil {
+		return single(nil, err)
+	}
+	if o.ReturnDocument != nil && *o.ReturnDocument == options.After {
+		return single(projected(after, o.Projection))
+	}
+	return single(projected(before, o.Projection))
+}
+
+func (c *Collection) InsertOne(ctx context.Context, document any, opts ...options.Lister[options.InsertOneOptions]) (*mongo.InsertOneResult, error) {
+	doc, err := normalizeDoc(document)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := c.db.lock(ctx)
+	defer unlock()
+
+	id, err := c.insert(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &mongo.InsertOneResult{InsertedID: id, Acknowledged: true}, nil
+}
+
+// InsertMany is ordered, it stops at the first document that fails
+func (c *Collection) InsertMany(ctx context.Context, documents any, opts ...options.Lister[options.InsertManyOptions]) (*mongo.InsertManyResult, error) {
+	list := reflect.ValueOf(documents)
+	if list.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("memstore: InsertMany needs a slice, got %T", documents)
+	}
+	docs := make([]bson.D, list.Len())
+	for i := range docs {
+		doc, err := normalizeDoc(list.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		docs[i] = doc
+	}
+
+	unlock := c.db.lock(ctx)
+	defer unlock()
+
+	res := &mongo.InsertManyResult{Acknowledged: true}
+	for _, doc := range docs {
+		id, err := c.insert(doc)
+		if err != nil {
+			return res, err
+		}
+		res.InsertedIDs = append(res.InsertedIDs, id)
+	}
+	return res, nil
+}
+
+func (c *Collection) UpdateOne(ctx context.Context, filter any, update any, opts ...options.Lister[options.UpdateOneOptions]) (*mongo.UpdateResult, error) {
+	o, err := applyOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.updateWith(ctx, filter, update, o.Upsert, false, o.Sort)
+}
+
+func (c *Collection) UpdateMany(ctx context.Context, filter any, update any, opts ...options.Lister[options.UpdateManyOptions]) (*mongo.UpdateResult, error) {
+	o, err := applyOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.updateWith(ctx, filter, update, o.Upsert, true, nil)
+}
+
+func (c *Collection) updateWith(ctx context.Context, filter any, update any, upsert *bool, many bool, sort any) (*mongo.UpdateResult, error) {
+	f, err := normalizeDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+	u, err := normalize(update)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := c.db.lock(ctx)
+	defer unlock()
+
+	res, _, _, err := c.update(f, u, upsert != nil && *upsert, many, sort)
+	return res, err
+}
+
+func (c *Collection) ReplaceOne(ctx context.Context, filter any, replacement any, opts ...options.Lister[options.ReplaceOptions]) (*mongo.UpdateResult, error) {
+	o, err := applyOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	f, err := normalizeDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := c.db.lock(ctx)
+	defer unlock()
+
+	return c.replaceOne(f, replacement, o.Upsert != nil && *o.Upsert)
+}
+
+func (c *Collection) DeleteOne(ctx context.Context, filter any, opts ...options.Lister[options.DeleteOneOptions]) (*mongo.DeleteResult, error) {
+	return c.deleteWith(ctx, filter, false)
+}
+
+func (c *Collection) DeleteMany(ctx context.Context, filter any, opts ...options.Lister[options.DeleteManyOptions]) (*mongo.DeleteResult, error) {
+	return c.deleteWith(ctx, filter, true)
+}
+
+func (c *Collection) deleteWith(ctx context.Context, filter any, many bool) (*mongo.DeleteResult, error) {
+	f, err := normalizeDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := c.db.lock(ctx)
+	defer unlock()
+
+	return c.delete(f, many)
+}
+
+func (c *Collection) CountDocuments(ctx context.Context, filter any, opts ...options.Lister[options.CountOptions]) (int64, error) {
+	f, err := normalizeDoc(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	unlock := c.db.lock(ctx)
+	defer unlock()
+
+	found, err := c.find(f)
+	return int64(len(found)), err
+}
+
+func (c *Collection) Distinct(ctx context.Context, field string, filter any) *store.DistinctResult {
+	f, err := normalizeDoc(filter)
+	if err != nil {
+		return store.NewDistinctResult(nil, err)
+	}
+
+	unlock := c.db.lock(ctx)
+	defer unlock()
+
+	found, err := c.find(f)
+	if err != nil {
+		return store.NewDistinctResult(nil, err)
+	}
+	seen := map[string]bool{}
+	values := bson.A{}
+	for _, r := range found {
+		for _, v := range lookup(r.doc, split(field)) {
+			items := bson.A{v}
+			if arr, ok := v.(bson.A); ok {
+				items = arr
+			}
+			for _, item := range items {
+				if k := keyString(item); !seen[k] {
+					seen[k] = true
+					values = append(values, item)
+				}
+			}
+		}
+	}
+	return store.NewDistinctResult(toRawArray(values))
+}
+
+// Aggregate isnt done in memory, the pipelines all sit behind the mongo repos
+// and the migrations, which only run against mongo
+func (c *Collection) Aggregate(ctx context.Context, pipeline any, opts ...options.Lister[options.AggregateOptions]) (*mongo.Cursor, error) {
+	return nil, unsupported("Aggregate")
+}
+
+// BulkWrite is ordered, it stops at the first write that fails
+func (c *Collection) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...options.Lister[options.BulkWriteOptions]) (*mongo.BulkWriteResult, error) {
+	unlock := c.db.lock(ctx)
+	defer unlock()
+
+	res := &mongo.BulkWriteResult{UpsertedIDs: map[int64]any{}, Acknowledged: true}
+	addUpdate := func(i int, u *mongo.UpdateResult) {
+		res.MatchedCount += u.MatchedCount
+		res.ModifiedCount += u.ModifiedCount
+		res.UpsertedCount += u.UpsertedCount
+		if u.UpsertedID != nil {
+			res.UpsertedIDs[int64(i)] = u.UpsertedID
+		}
+	}
+
+	for i, model := range models {
+		var err error
+		switch m := model.(type) {
+		case *mongo.InsertOneModel:
+			var doc bson.D
+			if doc, err = normalizeDoc(m.Document); err == nil {
+				if _, err = c.insert(doc); err == nil {
+					res.InsertedCount++
+				}
+			}
+		case *mongo.UpdateOneModel, *mongo.UpdateManyModel:
+			var filter, update any
+			var upsert *bool
+			many := false
+			if one, ok := m.(*mongo.UpdateOneModel); ok {
+				filter, update, upsert = one.Filter, one.Update, one.Upsert
+			} else {
+				all := m.(*mongo.UpdateManyModel)
+				filter, update, upsert, many = all.Filter, all.Update, all.Upsert, true
+			}
+			var f bson.D
+			var u any
+			var r *mongo.UpdateResult
+			if f, err = normalizeDoc(filter); err == nil {
+				if u, err = normalize(update); err == nil {
+					if r, _, _, err = c.update(f, u, upsert != nil && *upsert, many, nil); err == nil {
+						addUpdate(i, r)
+					}
+				}
+			}
+		case *mongo.ReplaceOneModel:
+			var f bson.D
+			var r *mongo.UpdateResult
+			if f, err = normalizeDoc(m.Filter); err == nil {
+				if r, err = c.replaceOne(f, m.Replacement, m.Upsert != nil && *m.Upsert); err == nil {
+					addUpdate(i, r)
+				}
+			}
+		case *mongo.DeleteOneModel, *mongo.DeleteManyModel:
+			var filter any
+			many := false
+			if one, ok := m.(*mongo.DeleteOneModel); ok {
+				filter = one.Filter
+			} else {
+				filter, many = m.(*mongo.DeleteManyModel).Filter, true
+			}
+			var f bson.D
+			var r *mongo.DeleteResult
+			if f, err = normalizeDoc(filter); err == nil {
+				if r, err = c.delete(f, many); err == nil {
+					res.DeletedCount += r.DeletedCount
+				}
+			}
+		default:
+			err = unsupported(fmt.Sprintf("write model %T", model))
+		}
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// CreateIndex keeps unique indexes, the others would only make things faster
+func (c *Collection) CreateIndex(ctx context.Context, model mongo.IndexModel) error {
+	o := &options.IndexOptions{}
+	if model.Options != nil {
+		for _, set := range model.Options.List() {
+			if err := set(o); err != nil {
+				return err
+			}
+		}
+	}
+	if o.Unique == nil || !*o.Unique {
+		return nil
+	}
+
+	keys, err := normalizeDoc(model.Keys)
+	if err != nil {
+		return err
+	}
+	index := uniqueIndex{}
+	names := make([]string, 0, len(keys))
+	for _, k := range keys {
+		index.keys = append(index.keys, k.Key)
+		names = append(names, fmt.Sprintf("%s_%v", k.Key, k.Value))
+	}
+	index.name = strings.Join(names, "_")
+	if o.Name != nil {
+		index.name = *o.Name
+	}
+	if o.PartialFilterExpression != nil {
+		if index.partial, err = normalizeDoc(o.PartialFilterExpression); err != nil {
+			return err
+		}
+	}
+
+	unlock := c.db.lock(ctx)
+	defer unlock()
+
+	for _, existing := range c.indexes {
+		if existing.name == index.name {
+			return nil
+		}
+	}
+	c.indexes = append(c.indexes, index)
+	return nil
+}
+
+func (c *Collection) DropIndex(ctx context.Context, name string) error {
+	unlock := c.db.lock(ctx)
+	defer unlock()
+
+	for i, index := range c.indexes {
+		if index.name == name {
+			c.indexes = append(c.indexes[:i], c.indexes[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
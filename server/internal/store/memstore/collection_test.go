@@ -0,0 +1,425 @@
+package memstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type item struct {
+	ID      string         `bson:"_id"`
+	Kind    string         `bson:"kind,omitempty"`
+	N       float64        `bson:"n,omitempty"`
+	Count   int            `bson:"count,omitempty"`
+	Arr     []string       `bson:"arr,omitempty"`
+	Obj     map[string]int `bson:"obj,omitempty"`
+	Items   []bson.M       `bson:"items,omitempty"`
+	Rm      *int           `bson:"rm,omitempty"`
+	Created string         `bson:"created,omitempty"`
+}
+
+func findIDs(t *testing.T, c *Collection, filter any, opts ...options.Lister[options.FindOptions]) []string {
+	t.Helper()
+	cursor, err := c.Find(context.Background(), filter, opts...)
+	if err != nil {
+		t.Fatalf("find %v: %v", filter, err)
+	}
+	var docs []item
+	if err := cursor.All(context.Background(), &docs); err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, len(docs))
+	for _, d := range docs {
+		ids = append(ids, d.ID)
+	}
+	return ids
+}
+
+func getItem(t *testing.T, c *Collection, id string) item {
+	t.Helper()
+	var it item
+	if err := c.FindOne(context.Background(), bson.M{"_id": id}).Decode(&it); err != nil {
+		t.Fatalf("get %s: %v", id, err)
+	}
+	return it
+}
+
+func filterFixture(t *testing.T) *Collection {
+	t.Helper()
+	c := New().collection("things")
+	_, err := c.InsertMany(context.Background(), []any{
+		bson.D{{Key: "_id", Value: "a"}, {Key: "n", Value: 1}, {Key: "tags", Value: bson.A{"x", "y"}},
+			{Key: "sub", Value: bson.D{{Key: "k", Value: "v"}}},
+			{Key: "items", Value: bson.A{bson.D{{Key: "k", Value: 1}}, bson.D{{Key: "k", Value: 2}}}}},
+		bson.D{{Key: "_id", Value: "b"}, {Key: "n", Value: 2.5}, {Key: "tags", Value: bson.A{"y"}}, {Key: "s", Value: "str"}},
+		bson.D{{Key: "_id", Value: "c"}, {Key: "n", Value: nil}},
+		bson.D{{Key: "_id", Value: "d"}, {Key: "s", Value: "zz"}},
+		bson.D{{Key: "_id", Value: "e"}, {Key: "n", Value: int64(3)}, {Key: "tags", Value: bson.A{}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// every query operator memstore answers, against what mongo returns for it
+func TestFilterOperators(t *testing.T) {
+	c := filterFixture(t)
+
+	for _, tc := range []struct {
+		filter bson.M
+		want   []string
+	}{
+		{bson.M{}, []string{"a", "b", "c", "d", "e"}},
+		{bson.M{"n": 1}, []string{"a"}},
+		{bson.M{"n": 3}, []string{"e"}}, // numbers compare across int and long
+		{bson.M{"sub.k": "v"}, []string{"a"}},
+		{bson.M{"tags": "y"}, []string{"a", "b"}},
+		{bson.M{"tags": bson.A{"y"}}, []string{"b"}},
+		{bson.M{"n": nil}, []string{"c", "d"}}, // null matches missing too
+		{bson.M{"items.k": 2}, []string{"a"}},
+
+		{bson.M{"n": bson.M{"$eq": 2.5}}, []string{"b"}},
+		{bson.M{"n": bson.M{"$ne": 1}}, []string{"b", "c", "d", "e"}},
+		{bson.M{"n": bson.M{"$gt": 1}}, []string{"b", "e"}},
+		{bson.M{"n": bson.M{"$gte": 1}}, []string{"a", "b", "e"}},
+		{bson.M{"n": bson.M{"$lt": 3}}, []string{"a", "b"}},
+		{bson.M{"n": bson.M{"$lte": 3, "$gt": 1}}, []string{"b", "e"}},
+		{bson.M{"n": bson.M{"$lte": nil}}, []string{"c", "d"}},
+		{bson.M{"s": bson.M{"$gt": 1}}, []string{}}, // strings dont compare with numbers
+		{bson.M{"s": bson.M{"$gt": "a"}}, []string{"b", "d"}},
+
+		{bson.M{"n": bson.M{"$in": bson.A{1, 3}}}, []string{"a", "e"}},
+		{bson.M{"n": bson.M{"$nin": bson.A{1, 3}}}, []string{"b", "c", "d"}},
+		{bson.M{"tags": bson.M{"$in": bson.A{"x"}}}, []string{"a"}},
+
+		{bson.M{"n": bson.M{"$exists": true}}, []string{"a", "b", "c", "e"}},
+		{bson.M{"n": bson.M{"$exists": false}}, []string{"d"}},
+
+		{bson.M{"n": bson.M{"$type": "number"}}, []string{"a", "b", "e"}},
+		{bson.M{"n": bson.M{"$type": "double"}}, []string{"b"}},
+		{bson.M{"n": bson.M{"$type": bson.A{"int", "long"}}}, []string{"a", "e"}},
+		{bson.M{"n": bson.M{"$type": 10}}, []string{"c"}},
+		{bson.M{"tags": bson.M{"$type": "array"}}, []string{"a", "b", "e"}},
+		{bson.M{"sub": bson.M{"$type": "object"}}, []string{"a"}},
+
+		{bson.M{"tags": bson.M{"$size": 0}}, []string{"e"}},
+		{bson.M{"tags": bson.M{"$size": 2}}, []string{"a"}},
+
+		{bson.M{"n": bson.M{"$not": bson.M{"$gt": 1}}}, []string{"a", "c", "d"}},
+
+		{bson.M{"items": bson.M{"$elemMatch": bson.M{"k": bson.M{"$gt": 1}}}}, []string{"a"}},
+		{bson.M{"items": bson.M{"$elemMatch": bson.M{"k": bson.M{"$gt": 5}}}}, []string{}},
+		{bson.M{"tags": bson.M{"$elemMatch": bson.M{"$eq": "x"}}}, []string{"a"}},
+
+		{bson.M{"$and": bson.A{bson.M{"n": bson.M{"$gt": 0}}, bson.M{"tags": "y"}}}, []string{"a", "b"}},
+		{bson.M{"$or": bson.A{bson.M{"n": 1}, bson.M{"s": "zz"}}}, []string{"a", "d"}},
+		{bson.M{"$nor": bson.A{bson.M{"n": 1}, bson.M{"s": "zz"}}}, []string{"b", "c", "e"}},
+	} {
+		if got := findIDs(t, c, tc.filter); !sameIDs(got, tc.want) {
+			t.Errorf("%v: got %v, want %v", tc.filter, got, tc.want)
+		}
+	}
+
+	// anything else errors rather than quietly matching nothing
+	for _, filter := range []bson.M{
+		{"$where": "true"},
+		{"s": bson.M{"$regex": "z"}},
+		{"$or": bson.M{"n": 1}},
+	} {
+		if _, err := c.Find(context.Background(), filter); err == nil {
+			t.Errorf("%v: no error", filter)
+		}
+	}
+}
+
+func TestFindOptions(t *testing.T) {
+	ctx := context.Background()
+	c := filterFixture(t)
+
+	sorted := options.Find().SetSort(bson.D{{Key: "n", Value: -1}, {Key: "_id", Value: 1}})
+	// a missing field sorts like null, below the numbers
+	if got := findIDs(t, c, bson.M{}, sorted); !sameIDs(got, []string{"e", "b", "a", "c", "d"}) {
+		t.Fatalf("sorted %v", got)
+	}
+	if got := findIDs(t, c, bson.M{}, sorted.SetSkip(1).SetLimit(2)); !sameIDs(got, []string{"b", "a"}) {
+		t.Fatalf("skip and limit %v", got)
+	}
+	if got := findIDs(t, c, bson.M{}, options.Find().SetSkip(9)); len(got) != 0 {
+		t.Fatalf("skipped past the end %v", got)
+	}
+
+	projected := func(spec bson.M) []string {
+		t.Helper()
+		raw, err := c.FindOne(ctx, bson.M{"_id": "a"}, options.FindOne().SetProjection(spec)).Raw()
+		if err != nil {
+			t.Fatalf("projection %v: %v", spec, err)
+		}
+		elems, _ := raw.Elements()
+		keys := make([]string, 0, len(elems))
+		for _, e := range elems {
+			keys = append(keys, e.Key())
+		}
+		return keys
+	}
+	if got := projected(bson.M{"n": 1, "sub.k": 1}); !sameIDs(got, []string{"_id", "n", "sub"}) {
+		t.Fatalf("inclusion %v", got)
+	}
+	if got := projected(bson.M{"n": 1, "_id": 0}); !sameIDs(got, []string{"n"}) {
+		t.Fatalf("without id %v", got)
+	}
+	if got := projected(bson.M{"items": 0, "tags": false, "sub": 0}); !sameIDs(got, []string{"_id", "n"}) {
+		t.Fatalf("exclusion %v", got)
+	}
+	if err := c.FindOne(ctx, bson.M{"_id": "a"}, options.FindOne().SetProjection(bson.M{"double": bson.M{"$multiply": bson.A{"$n", 2}}})).Err(); err == nil {
+		t.Fatal("computed field didnt error")
+	}
+
+	first := options.FindOne().SetSort(bson.M{"n": 1})
+	var it item
+	if err := c.FindOne(ctx, bson.M{"n": bson.M{"$type": "number"}}, first).Decode(&it); err != nil || it.ID != "a" {
+		t.Fatalf("find one sorted %+v %v", it, err)
+	}
+	if err := c.FindOne(ctx, bson.M{"_id": "zz"}).Err(); err != mongo.ErrNoDocuments {
+		t.Fatalf("missing %v", err)
+	}
+
+	if n, err := c.CountDocuments(ctx, bson.M{"tags": "y"}); err != nil || n != 2 {
+		t.Fatalf("count %d %v", n, err)
+	}
+	var tags []string
+	if err := c.Distinct(ctx, "tags", bson.M{}).Decode(&tags); err != nil || !sameIDs(tags, []string{"x", "y"}) {
+		t.Fatalf("distinct %v %v", tags, err)
+	}
+	if _, err := c.Aggregate(ctx, bson.A{}); err == nil {
+		t.Fatal("aggregate didnt error")
+	}
+}
+
+func TestUpdateOperators(t *testing.T) {
+	ctx := context.Background()
+	c := New().collection("things")
+	c.InsertOne(ctx, bson.M{
+		"_id":   "u",
+		"n":     1,
+		"arr":   bson.A{"a"},
+		"rm":    1,
+		"obj":   bson.M{"x": 1},
+		"items": bson.A{bson.M{"k": 1}, bson.M{"k": 2}},
+	})
+	update := func(u any) {
+		t.Helper()
+		if _, err := c.UpdateOne(ctx, bson.M{"_id": "u"}, u); err != nil {
+			t.Fatalf("%v: %v", u, err)
+		}
+	}
+
+	update(bson.D{
+		{Key: "$set", Value: bson.M{"obj.y": 2}},
+		{Key: "$unset", Value: bson.M{"rm": ""}},
+		{Key: "$inc", Value: bson.M{"n": 2, "count": 1}},
+		{Key: "$push", Value: bson.M{"arr": "b"}},
+	})
+	it := getItem(t, c, "u")
+	if it.N != 3 || it.Count != 1 || it.Rm != nil || it.Obj["x"] != 1 || it.Obj["y"] != 2 || !sameIDs(it.Arr, []string{"a", "b"}) {
+		t.Fatalf("set/unset/inc/push %+v", it)
+	}
+
+	update(bson.M{"$max": bson.M{"n": 2}})
+	if it := getItem(t, c, "u"); it.N != 3 {
+		t.Fatalf("$max lowered %v", it.N)
+	}
+	update(bson.M{"$min": bson.M{"n": 2}})
+	if it := getItem(t, c, "u"); it.N != 2 {
+		t.Fatalf("$min %v", it.N)
+	}
+	update(bson.M{"$max": bson.M{"n": 5.5}})
+	if it := getItem(t, c, "u"); it.N != 5.5 {
+		t.Fatalf("$max %v", it.N)
+	}
+	update(bson.M{"$inc": bson.M{"n": -0.5}})
+	if it := getItem(t, c, "u"); it.N != 5 {
+		t.Fatalf("$inc a double %v", it.N)
+	}
+
+	update(bson.M{"$addToSet": bson.M{"arr": bson.M{"$each": bson.A{"a", "c"}}}})
+	if it := getItem(t, c, "u"); !sameIDs(it.Arr, []string{"a", "b", "c"}) {
+		t.Fatalf("$addToSet %v", it.Arr)
+	}
+	update(bson.M{"$push": bson.M{"arr": bson.M{"$each": bson.A{"c"}}}})
+	update(bson.M{"$pull": bson.M{"arr": "c", "items": bson.M{"k": bson.M{"$gt": 1}}}})
+	if it := getItem(t, c, "u"); !sameIDs(it.Arr, []string{"a", "b"}) || len(it.Items) != 1 {
+		t.Fatalf("$pull %+v", it)
+	}
+
+	res, err := c.UpdateOne(ctx, bson.M{"_id": "u"}, bson.M{"$setOnInsert": bson.M{"created": "x"}}, options.UpdateOne().SetUpsert(true))
+	if err != nil || res.MatchedCount != 1 || res.ModifiedCount != 0 || getItem(t, c, "u").Created != "" {
+		t.Fatalf("$setOnInsert on a match %+v %v", res, err)
+	}
+
+	for _, u := range []any{
+		bson.M{"n": 1}, // a replacement, not an update
+		bson.A{bson.M{"$set": bson.M{"n": 1}}},
+		bson.M{"$rename": bson.M{"n": "m"}},
+		bson.M{"$push": bson.M{"n": 1}}, // not an array
+	} {
+		if _, err := c.UpdateOne(ctx, bson.M{"_id": "u"}, u); err == nil {
+			t.Errorf("%v: no error", u)
+		}
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	ctx := context.Background()
+	c := New().collection("things")
+
+	// the equality parts of the filter seed the new document
+	res, err := c.UpdateOne(ctx,
+		bson.M{"_id": "new", "kind": bson.M{"$eq": "k"}, "n": bson.M{"$gt": 1}},
+		bson.M{"$setOnInsert": bson.M{"created": "x"}, "$inc": bson.M{"count": 1}},
+		options.UpdateOne().SetUpsert(true))
+	if err != nil || res.UpsertedCount != 1 || res.UpsertedID != "new" {
+		t.Fatalf("upsert %+v %v", res, err)
+	}
+	if it := getItem(t, c, "new"); it.Kind != "k" || it.N != 0 || it.Created != "x" || it.Count != 1 {
+		t.Fatalf("upserted %+v", it)
+	}
+
+	c.InsertOne(ctx, bson.M{"_id": "low", "kind": "k", "n": 1})
+	c.InsertOne(ctx, bson.M{"_id": "high", "kind": "k", "n": 9})
+
+	// the sort picks which match gets updated, before is the default
+	var before item
+	err = c.FindOneAndUpdate(ctx, bson.M{"n": bson.M{"$exists": true}}, bson.M{"$inc": bson.M{"count": 1}},
+		options.FindOneAndUpdate().SetSort(bson.M{"n": -1})).Decode(&before)
+	if err != nil || before.ID != "high" || before.Count != 0 {
+		t.Fatalf("before %+v %v", before, err)
+	}
+	var after item
+	err = c.FindOneAndUpdate(ctx, bson.M{"n": bson.M{"$exists": true}}, bson.M{"$inc": bson.M{"count": 1}},
+		options.FindOneAndUpdate().SetSort(bson.M{"n": 1}).SetReturnDocument(options.After)).Decode(&after)
+	if err != nil || after.ID != "low" || after.Count != 1 {
+		t.Fatalf("after %+v %v", after, err)
+	}
+
+	err = c.FindOneAndUpdate(ctx, bson.M{"$and": bson.A{bson.M{"_id": "seeded"}, bson.M{"kind": "j"}}},
+		bson.M{"$set": bson.M{"n": 4}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&after)
+	if err != nil || after.ID != "seeded" || after.Kind != "j" || after.N != 4 {
+		t.Fatalf("upserted after %+v %v", after, err)
+	}
+	// no document before an upsert
+	err = c.FindOneAndUpdate(ctx, bson.M{"_id": "other"}, bson.M{"$set": bson.M{"n": 4}},
+		options.FindOneAndUpdate().SetUpsert(true)).Err()
+	if err != mongo.ErrNoDocuments {
+		t.Fatalf("upserted before %v", err)
+	}
+
+	res, err = c.UpdateMany(ctx, bson.M{"kind": "k"}, bson.M{"$set": bson.M{"n": 9}})
+	if err != nil || res.MatchedCount != 3 || res.ModifiedCount != 2 {
+		t.Fatalf("update many %+v %v", res, err)
+	}
+}
+
+func TestUniqueIndexes(t *testing.T) {
+	ctx := context.Background()
+	c := New().collection("things")
+	c.CreateIndex(ctx, mongo.IndexModel{Keys: bson.D{{Key: "kind", Value: 1}}, Options: options.Index().SetUnique(true)})
+	c.CreateIndex(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "n", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("live_n").SetPartialFilterExpression(bson.M{"count": bson.M{"$gt": 0}}),
+	})
+	// plain indexes are left to mongo
+	c.CreateIndex(ctx, mongo.IndexModel{Keys: bson.D{{Key: "arr", Value: 1}}})
+
+	if _, err := c.InsertOne(ctx, bson.M{"_id": "a", "kind": "k", "n": 1, "count": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.InsertOne(ctx, bson.M{"_id": "a"}); !mongo.IsDuplicateKeyError(err) {
+		t.Fatalf("same id %v", err)
+	}
+	if _, err := c.InsertOne(ctx, bson.M{"_id": "b", "kind": "k"}); !mongo.IsDuplicateKeyError(err) {
+		t.Fatalf("same kind %v", err)
+	}
+	// outside the partial filter the same n is fine
+	if _, err := c.InsertOne(ctx, bson.M{"_id": "b", "kind": "j", "n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UpdateOne(ctx, bson.M{"_id": "b"}, bson.M{"$set": bson.M{"count": 1}}); !mongo.IsDuplicateKeyError(err) {
+		t.Fatalf("moved into the partial index %v", err)
+	}
+	if it := getItem(t, c, "b"); it.Count != 0 {
+		t.Fatalf("failed update was kept %+v", it)
+	}
+
+	c.DropIndex(ctx, "live_n")
+	if _, err := c.UpdateOne(ctx, bson.M{"_id": "b"}, bson.M{"$set": bson.M{"count": 1}}); err != nil {
+		t.Fatalf("dropped index still enforced %v", err)
+	}
+}
+
+func TestWrites(t *testing.T) {
+	ctx := context.Background()
+	db := New()
+	c := db.collection("things")
+
+	res, err := c.InsertMany(ctx, []bson.M{{"_id": "a"}, {"_id": "b"}, {"_id": "a"}, {"_id": "c"}})
+	if !mongo.IsDuplicateKeyError(err) || len(res.InsertedIDs) != 2 {
+		t.Fatalf("insert many stops at the duplicate %+v %v", res, err)
+	}
+	id, _ := c.InsertOne(ctx, bson.M{"kind": "generated"})
+	if _, ok := id.InsertedID.(bson.ObjectID); !ok {
+		t.Fatalf("generated id %v", id.InsertedID)
+	}
+	c.DeleteOne(ctx, bson.M{"_id": id.InsertedID})
+
+	bulk, err := c.BulkWrite(ctx, []mongo.WriteModel{
+		mongo.NewInsertOneModel().SetDocument(bson.M{"_id": "c", "n": 1}),
+		mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": "a"}).SetUpdate(bson.M{"$set": bson.M{"n": 2}}),
+		mongo.NewUpdateManyModel().SetFilter(bson.M{"n": bson.M{"$exists": true}}).SetUpdate(bson.M{"$inc": bson.M{"count": 1}}),
+		mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": "d"}).SetReplacement(bson.M{"n": 4}).SetUpsert(true),
+		mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": "b"}).SetReplacement(bson.M{"kind": "replaced"}),
+		mongo.NewDeleteOneModel().SetFilter(bson.M{"_id": "c"}),
+	})
+	if err != nil || bulk.InsertedCount != 1 || bulk.MatchedCount != 4 || bulk.ModifiedCount != 4 ||
+		bulk.UpsertedIDs[3] != "d" || bulk.DeletedCount != 1 {
+		t.Fatalf("bulk %+v %v", bulk, err)
+	}
+	if it := getItem(t, c, "a"); it.N != 2 || it.Count != 1 {
+		t.Fatalf("bulk updated %+v", it)
+	}
+	if it := getItem(t, c, "b"); it.Kind != "replaced" {
+		t.Fatalf("bulk replaced %+v", it)
+	}
+
+	// ordered, the write after a failing one doesnt happen
+	_, err = c.BulkWrite(ctx, []mongo.WriteModel{
+		mongo.NewInsertOneModel().SetDocument(bson.M{"_id": "a"}),
+		mongo.NewDeleteManyModel().SetFilter(bson.M{}),
+	})
+	if !mongo.IsDuplicateKeyError(err) || len(findIDs(t, c, bson.M{})) != 3 {
+		t.Fatalf("bulk went past the failure %v", err)
+	}
+
+	if del, err := c.DeleteMany(ctx, bson.M{"n": bson.M{"$gt": 1}}); err != nil || del.DeletedCount != 2 {
+		t.Fatalf("delete many %+v %v", del, err)
+	}
+	if del, _ := c.DeleteOne(ctx, bson.M{}); del.DeletedCount != 1 {
+		t.Fatalf("delete one %+v", del)
+	}
+
+	// a failed transaction puts the collection back
+	before := findIDs(t, c, bson.M{})
+	err = db.Transaction(ctx, func(ctx context.Context) error {
+		c.InsertOne(ctx, bson.M{"_id": "tx"})
+		c.DeleteMany(ctx, bson.M{})
+		return errors.New("failed")
+	})
+	if err == nil || !sameIDs(findIDs(t, c, bson.M{}), before) {
+		t.Fatalf("transaction kept its writes: %v", findIDs(t, c, bson.M{}))
+	}
+}
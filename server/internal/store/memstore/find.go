@@ -0,0 +1,97 @@
+package memstore
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// orderBy compares two documents by a sort spec like {score: -1, _id: 1}
+func orderBy(order bson.D) (func(a, b bson.D) int, error) {
+	dirs := make([]int, len(order))
+	for i, e := range order {
+		dir, err := toInt(e.Value)
+		if err != nil {
+			return nil, err
+		}
+		dirs[i] = dir
+	}
+	return func(a, b bson.D) int {
+		for i, e := range order {
+			va, _ := getPath(a, split(e.Key))
+			vb, _ := getPath(b, split(e.Key))
+			if c := compare(va, vb); c != 0 {
+				if dirs[i] < 0 {
+					return -c
+				}
+				return c
+			}
+		}
+		return 0
+	}, nil
+}
+
+// project is a find projection. 1 keeps a field, 0 drops it and _id stays
+// unless dropped. Computed fields are aggregation, which memstore doesnt do.
+func project(doc bson.D, spec any) (bson.D, error) {
+	fields, ok := spec.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("memstore: a projection needs a document")
+	}
+
+	exclusion := true
+	for _, f := range fields {
+		if f.Key == "_id" {
+			continue
+		}
+		if isNumber(f.Value) || isBool(f.Value) {
+			exclusion = exclusion && !truthy(f.Value)
+		} else {
+			exclusion = false
+		}
+	}
+
+	if exclusion {
+		out := cloneDoc(doc)
+		for _, f := range fields {
+			if !truthy(f.Value) {
+				out = unsetPath(out, split(f.Key))
+			}
+		}
+		return out, nil
+	}
+
+	out := bson.D{}
+	keepID := true
+	for _, f := range fields {
+		if f.Key == "_id" && (isNumber(f.Value) || isBool(f.Value)) && !truthy(f.Value) {
+			keepID = false
+		}
+	}
+	if id, ok := get(doc, "_id"); ok && keepID {
+		out = append(out, bson.E{Key: "_id", Value: clone(id)})
+	}
+
+	var err error
+	for _, f := range fields {
+		if isNumber(f.Value) || isBool(f.Value) {
+			if f.Key == "_id" || !truthy(f.Value) {
+				continue
+			}
+			if v, ok := getPath(doc, split(f.Key)); ok {
+				out, err = setPath(out, split(f.Key), clone(v))
+			}
+		} else {
+			err = unsupported("computed projection field " + f.Key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func isBool(v any) bool {
+	_, ok := v.(bool)
+	return ok
+}
@@ -0,0 +1,292 @@
+package memstore
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// matches reports if doc passes a query filter
+func matches(doc bson.D, filter bson.D) (bool, error) {
+	for _, e := range filter {
+		switch e.Key {
+		case "$and", "$or", "$nor":
+			subs, ok := e.Value.(bson.A)
+			if !ok {
+				return false, fmt.Errorf("memstore: %s needs an array", e.Key)
+			}
+			passed := 0
+			for _, sub := range subs {
+				f, ok := sub.(bson.D)
+				if !ok {
+					return false, fmt.Errorf("memstore: %s needs documents", e.Key)
+				}
+				ok, err := matches(doc, f)
+				if err != nil {
+					return false, err
+				}
+				if ok {
+					passed++
+				}
+			}
+			switch {
+			case e.Key == "$and" && passed < len(subs):
+				return false, nil
+			case e.Key == "$or" && passed == 0:
+				return false, nil
+			case e.Key == "$nor" && passed > 0:
+				return false, nil
+			}
+		default:
+			if strings.HasPrefix(e.Key, "$") {
+				return false, unsupported("query operator " + e.Key)
+			}
+			ok, err := matchField(doc, e.Key, e.Value)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// isOperatorDoc tells {$gt: 1} from a plain document to compare against
+func isOperatorDoc(v any) (bson.D, bool) {
+	d, ok := v.(bson.D)
+	if !ok || len(d) == 0 || !strings.HasPrefix(d[0].Key, "$") {
+		return nil, false
+	}
+	return d, true
+}
+
+func matchField(doc bson.D, path string, cond any) (bool, error) {
+	values := lookup(doc, split(path))
+	ops, ok := isOperatorDoc(cond)
+	if !ok {
+		return matchEq(values, cond), nil
+	}
+	for _, op := range ops {
+		ok, err := matchOp(values, op.Key, op.Value)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// matchEq is {field: x}, a missing field equals null and an array matches
+// when any element does
+func matchEq(values []any, x any) bool {
+	if x == nil && len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if equal(v, x) {
+			return true
+		}
+		if arr, ok := v.(bson.A); ok {
+			for _, elem := range arr {
+				if equal(elem, x) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// expand puts the elements of arrays next to the arrays themselves
+func expand(values []any) []any {
+	out := make([]any, 0, len(values))
+	for _, v := range values {
+		out = append(out, v)
+		if arr, ok := v.(bson.A); ok {
+			out = append(out, arr...)
+		}
+	}
+	return out
+}
+
+func matchOp(values []any, op string, x any) (bool, error) {
+	switch op {
+	case "$eq":
+		return matchEq(values, x), nil
+	case "$ne":
+		return !matchEq(values, x), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		if x == nil {
+			// only null compares with null
+			return (op == "$gte" || op == "$lte") && matchEq(values, nil), nil
+		}
+		for _, v := range expand(values) {
+			// like mongo, only values of the same type compare
+			if typeRank(v) != typeRank(x) {
+				continue
+			}
+			c := compare(v, x)
+			if (op == "$gt" && c > 0) || (op == "$gte" && c >= 0) ||
+				(op == "$lt" && c < 0) || (op == "$lte" && c <= 0) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$in", "$nin":
+		list, ok := x.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("memstore: %s needs an array", op)
+		}
+		found := false
+		for _, item := range list {
+			if matchEq(values, item) {
+				found = true
+				break
+			}
+		}
+		return found == (op == "$in"), nil
+	case "$exists":
+		return (len(values) > 0) == truthy(x), nil
+	case "$type":
+		names := bson.A{x}
+		if list, ok := x.(bson.A); ok {
+			names = list
+		}
+		for _, v := range expand(values) {
+			for _, name := range names {
+				if hasType(v, name) {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	case "$size":
+		n, err := toInt(x)
+		if err != nil {
+			return false, err
+		}
+		for _, v := range values {
+			if arr, ok := v.(bson.A); ok && len(arr) == n {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$not":
+		ops, ok := isOperatorDoc(x)
+		if !ok {
+			return false, fmt.Errorf("memstore: $not needs operators")
+		}
+		for _, sub := range ops {
+			ok, err := matchOp(values, sub.Key, sub.Value)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$elemMatch":
+		cond, ok := x.(bson.D)
+		if !ok {
+			return false, fmt.Errorf("memstore: $elemMatch needs a document")
+		}
+		for _, v := range values {
+			arr, _ := v.(bson.A)
+			for _, elem := range arr {
+				ok, err := elemMatches(elem, cond)
+				if err != nil {
+					return false, err
+				}
+				if ok {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	}
+	return false, unsupported("query operator " + op)
+}
+
+func elemMatches(elem any, cond bson.D) (bool, error) {
+	if ops, ok := isOperatorDoc(cond); ok {
+		for _, op := range ops {
+			ok, err := matchOp([]any{elem}, op.Key, op.Value)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	d, ok := elem.(bson.D)
+	if !ok {
+		return false, nil
+	}
+	return matches(d, cond)
+}
+
+// hasType is $type, by alias or by number
+func hasType(v any, name any) bool {
+	if n, err := toInt(name); err == nil {
+		name = typeAliases[n]
+	}
+	switch name {
+	case "number":
+		return isNumber(v)
+	case "double":
+		_, ok := v.(float64)
+		return ok
+	case "int":
+		_, ok := v.(int32)
+		return ok
+	case "long":
+		_, ok := v.(int64)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "object":
+		_, ok := v.(bson.D)
+		return ok
+	case "array":
+		_, ok := v.(bson.A)
+		return ok
+	case "binData":
+		_, ok := v.(bson.Binary)
+		return ok
+	case "objectId":
+		_, ok := v.(bson.ObjectID)
+		return ok
+	case "bool":
+		_, ok := v.(bool)
+		return ok
+	case "date":
+		_, ok := v.(bson.DateTime)
+		return ok
+	case "null":
+		return v == nil
+	case "timestamp":
+		_, ok := v.(bson.Timestamp)
+		return ok
+	case "decimal":
+		_, ok := v.(bson.Decimal128)
+		return ok
+	}
+	return false
+}
+
+var typeAliases = map[int]string{
+	1: "double", 2: "string", 3: "object", 4: "array", 5: "binData", 7: "objectId",
+	8: "bool", 9: "date", 10: "null", 16: "int", 17: "timestamp", 18: "long", 19: "decimal",
+}
+
+func truthy(v any) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case int32, int64, float64:
+		return toFloat(t) != 0
+	}
+	return true
+}
@@ -0,0 +1,418 @@
+// Package memstore keeps collections in memory behind store.Collection, so the
+// api runs and can be tested without mongo. It understands the filters,
+// updates and pipeline stages the app uses, not all of mongo.
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"server/internal/store"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// DB holds every collection behind one lock, a transaction holds it until
+// it is done
+type DB struct {
+	mu    sync.Mutex
+	colls map[string]*Collection
+	seq   int64
+
+	// undo reverts the writes of the running transaction, newest last
+	inTx bool
+	undo []func()
+
+	repos store.Repos
+}
+
+var _ store.DB = (*DB)(nil)
+
+func New() *DB {
+	db := &DB{colls: map[string]*Collection{}}
+	db.repos = newRepos(db)
+	return db
+}
+
+type txKey struct{}
+
+// lock takes the database lock, unless ctx belongs to the transaction that
+// already has it
+func (db *DB) lock(ctx context.Context) func() {
+	if ctx.Value(txKey{}) == db {
+		return func() {}
+	}
+	db.mu.Lock()
+	return db.mu.Unlock
+}
+
+func (db *DB) Collection(name string) store.Collection {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.collection(name)
+}
+
+func (db *DB) collection(name string) *Collection {
+	c, ok := db.colls[name]
+	if !ok {
+		c = &Collection{db: db, name: name, docs: map[string]*record{}}
+		db.colls[name] = c
+	}
+	return c
+}
+
+// Transaction runs fn alone, if it fails everything it wrote is put back
+func (db *DB) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(txKey{}) == db {
+		return fn(ctx)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.inTx, db.undo = true, nil
+	defer func() { db.inTx, db.undo = false, nil }()
+
+	err := fn(context.WithValue(ctx, txKey{}, db))
+	if err != nil {
+		for i := len(db.undo) - 1; i >= 0; i-- {
+			db.undo[i]()
+		}
+	}
+	return err
+}
+
+type record struct {
+	seq int64 // insertion order, what a find without a sort returns
+	doc bson.D
+}
+
+type uniqueIndex struct {
+	name    string
+	keys    []string
+	partial bson.D
+}
+
+// Collection is one collection, only used with the database lock held
+type Collection struct {
+	db      *DB
+	name    string
+	docs    map[string]*record
+	indexes []uniqueIndex
+}
+
+// all is every record in insertion order
+func (c *Collection) all() []*record {
+	records := make([]*record, 0, len(c.docs))
+	for _, r := range c.docs {
+		records = append(records, r)
+	}
+	slices.SortFunc(records, func(a, b *record) int { return cmpInt(a.seq, b.seq) })
+	return records
+}
+
+func (c *Collection) find(filter bson.D) ([]*record, error) {
+	var found []*record
+	for _, r := range c.all() {
+		ok, err := matches(r.doc, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			found = append(found, r)
+		}
+	}
+	return found, nil
+}
+
+// findSorted is find with a sort and skip applied
+func (c *Collection) findSorted(filter bson.D, sort any, skip *int64) ([]bson.D, []*record, error) {
+	found, err := c.find(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sort != nil {
+		order, err := normalizeDoc(sort)
+		if err != nil {
+			return nil, nil, err
+		}
+		cmp, err := orderBy(order)
+		if err != nil {
+			return nil, nil, err
+		}
+		slices.SortStableFunc(found, func(a, b *record) int { return cmp(a.doc, b.doc) })
+	}
+	if skip != nil {
+		found = found[min(max(int(*skip), 0), len(found)):]
+	}
+	docs := make([]bson.D, len(found))
+	for i, r := range found {
+		docs[i] = r.doc
+	}
+	return docs, found, nil
+}
+
+func idKey(id any) string {
+	return keyString(id)
+}
+
+// put stores doc under its id, checking the unique indexes first
+func (c *Collection) put(doc bson.D, seq int64) error {
+	id, _ := get(doc, "_id")
+	key := idKey(id)
+	for _, index := range c.indexes {
+		if err := c.checkUnique(index, key, doc); err != nil {
+			return err
+		}
+	}
+
+	prev, existed := c.docs[key]
+	if c.db.inTx {
+		c.db.undo = append(c.db.undo, func() {
+			if existed {
+				c.docs[key] = prev
+			} else {
+				delete(c.docs, key)
+			}
+		})
+	}
+	c.docs[key] = &record{seq: seq, doc: doc}
+	return nil
+}
+
+func (c *Collection) remove(r *record) {
+	id, _ := get(r.doc, "_id")
+	key := idKey(id)
+	if c.db.inTx {
+		c.db.undo = append(c.db.undo, func() { c.docs[key] = r })
+	}
+	delete(c.docs, key)
+}
+
+func (c *Collection) checkUnique(index uniqueIndex, key string, doc bson.D) error {
+	in := func(d bson.D) (string, bool) {
+		if index.partial != nil {
+			ok, _ := matches(d, index.partial)
+			if !ok {
+				return "", false
+			}
+		}
+		var b strings.Builder
+		for _, k := range index.keys {
+			v, _ := getPath(d, split(k))
+			writeKey(&b, v)
+		}
+		return b.String(), true
+	}
+
+	mine, ok := in(doc)
+	if !ok {
+		return nil
+	}
+	for otherKey, r := range c.docs {
+		if otherKey == key {
+			continue
+		}
+		if theirs, ok := in(r.doc); ok && theirs == mine {
+			return duplicateKey(c.name, index.name)
+		}
+	}
+	return nil
+}
+
+// duplicateKey is the error mongo gives, so mongo.IsDuplicateKeyError works on it
+func duplicateKey(coll string, index string) error {
+	return mongo.WriteException{WriteErrors: mongo.WriteErrors{{
+		Code:    11000,
+		Message: "E11000 duplicate key error collection: " + coll + " index: " + index,
+	}}}
+}
+
+func (c *Collection) insert(doc bson.D) (any, error) {
+	id, ok := get(doc, "_id")
+	if !ok || id == nil {
+		id = bson.NewObjectID()
+		doc = append(bson.D{{Key: "_id", Value: id}}, unsetPath(doc, []string{"_id"})...)
+	}
+	if _, exists := c.docs[idKey(id)]; exists {
+		return nil, duplicateKey(c.name, "_id_")
+	}
+	c.db.seq++
+	return id, c.put(doc, c.db.seq)
+}
+
+// replace swaps the document of a record, the id cant change
+func (c *Collection) replace(r *record, doc bson.D) error {
+	oldID, _ := get(r.doc, "_id")
+	newID, ok := get(doc, "_id")
+	if !ok {
+		doc = append(bson.D{{Key: "_id", Value: oldID}}, doc...)
+	} else if !equal(oldID, newID) {
+		return fmt.Errorf("memstore: the _id of a document cant change")
+	}
+	return c.put(doc, r.seq)
+}
+
+// update applies an update to the first (or every) match, upserting when
+// asked. Returns the result and the documents before and after.
+func (c *Collection) update(filter bson.D, update any, upsert bool, many bool, sort any) (*mongo.UpdateResult, bson.D, bson.D, error) {
+	docs, found, err := c.findSorted(filter, sort, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !many && len(found) > 1 {
+		docs, found = docs[:1], found[:1]
+	}
+
+	res := &mongo.UpdateResult{Acknowledged: true}
+	if len(found) == 0 {
+		if !upsert {
+			return res, nil, nil, nil
+		}
+		seed, err := upsertSeed(filter)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		doc, err := applyUpdate(seed, update, true)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		id, err := c.insert(doc)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		res.UpsertedCount, res.UpsertedID = 1, id
+		return res, nil, c.docs[idKey(id)].doc, nil
+	}
+
+	var before, after bson.D
+	for i, r := range found {
+		doc, err := applyUpdate(docs[i], update, false)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		res.MatchedCount++
+		if before == nil {
+			before = r.doc
+		}
+		if equal(doc, r.doc) {
+			if after == nil {
+				after = r.doc
+			}
+			continue
+		}
+		if err := c.replace(r, doc); err != nil {
+			return nil, nil, nil, err
+		}
+		if after == nil {
+			after = doc
+		}
+		res.ModifiedCount++
+	}
+	return res, before, after, nil
+}
+
+func (c *Collection) replaceOne(filter bson.D, replacement any, upsert bool) (*mongo.UpdateResult, error) {
+	doc, err := normalizeDoc(replacement)
+	if err != nil {
+		return nil, err
+	}
+	found, err := c.find(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &mongo.UpdateResult{Acknowledged: true}
+	if len(found) == 0 {
+		if !upsert {
+			return res, nil
+		}
+		if _, ok := get(doc, "_id"); !ok {
+			seed, err := upsertSeed(filter)
+			if err != nil {
+				return nil, err
+			}
+			if id, ok := get(seed, "_id"); ok {
+				doc = append(bson.D{{Key: "_id", Value: id}}, doc...)
+			}
+		}
+		id, err := c.insert(doc)
+		if err != nil {
+			return nil, err
+		}
+		res.UpsertedCount, res.UpsertedID = 1, id
+		return res, nil
+	}
+
+	res.MatchedCount = 1
+	if !equal(doc, unsetPath(cloneDoc(found[0].doc), []string{"_id"})) {
+		if err := c.replace(found[0], doc); err != nil {
+			return nil, err
+		}
+		res.ModifiedCount = 1
+	}
+	return res, nil
+}
+
+func (c *Collection) delete(filter bson.D, many bool) (*mongo.DeleteResult, error) {
+	found, err := c.find(filter)
+	if err != nil {
+		return nil, err
+	}
+	if !many && len(found) > 1 {
+		found = found[:1]
+	}
+	for _, r := range found {
+		c.remove(r)
+	}
+	return &mongo.DeleteResult{DeletedCount: int64(len(found)), Acknowledged: true}, nil
+}
+
+func applyOptions[T any](opts []options.Lister[T]) (*T, error) {
+	o := new(T)
+	for _, l := range opts {
+		if l == nil || reflect.ValueOf(l).IsNil() {
+			continue
+		}
+		for _, set := range l.List() {
+			if err := set(o); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return o, nil
+}
+
+func cursor(docs []bson.D) (*mongo.Cursor, error) {
+	items := make([]any, len(docs))
+	for i, d := range docs {
+		items[i] = d
+	}
+	return mongo.NewCursorFromDocuments(items, nil, nil)
+}
+
+func single(doc bson.D, err error) *mongo.SingleResult {
+	if err == nil && doc == nil {
+		err = mongo.ErrNoDocuments
+	}
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	return mongo.NewSingleResultFromDocument(doc, nil, nil)
+}
+
+func projected(doc bson.D, projection any) (bson.D, error) {
+	if projection == nil || doc == nil {
+		return doc, nil
+	}
+	spec, err := normalizeDoc(projection)
+	if err != nil {
+		return nil, err
+	}
+	return project(doc, spec)
+}
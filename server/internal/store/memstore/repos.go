@@ -0,0 +1,278 @@
+package memstore
+
+import (
+	"context"
+	"server/internal/models"
+	"server/internal/store"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func (db *DB) Repos() store.Repos {
+	return db.repos
+}
+
+func newRepos(db *DB) store.Repos {
+	states := &states{newTable[models.UserState](db)}
+	return store.Repos{
+		Users:     &users{newTable[models.User](db)},
+		States:    states,
+		Questions: &questions{newTable[models.Question](db)},
+		Answers:   &answers{table: newTable[models.AnswerLog](db), states: states},
+	}
+}
+
+// table holds one kind of document by id under the database lock. Writes in
+// a transaction are put back if it fails.
+type table[T any] struct {
+	db   *DB
+	rows map[string]row[T]
+}
+
+type row[T any] struct {
+	seq int64 // insertion order, what mongo returns without a sort
+	v   T
+}
+
+func newTable[T any](db *DB) *table[T] {
+	return &table[T]{db: db, rows: map[string]row[T]{}}
+}
+
+// roundTrip copies a document the way a trip through mongo would, times are cut
+// to the ms and nothing is shared with the caller
+func roundTrip[T any](v T) T {
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	var c T
+	if err := bson.Unmarshal(raw, &c); err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func (t *table[T]) get(id string) (T, bool) {
+	r, ok := t.rows[id]
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	return roundTrip(r.v), true
+}
+
+// all is a copy of every document in insertion order
+func (t *table[T]) all() []T {
+	rows := make([]row[T], 0, len(t.rows))
+	for _, r := range t.rows {
+		rows = append(rows, r)
+	}
+	slices.SortFunc(rows, func(a, b row[T]) int { return cmpInt(a.seq, b.seq) })
+
+	all := make([]T, len(rows))
+	for i, r := range rows {
+		all[i] = roundTrip(r.v)
+	}
+	return all
+}
+
+func (t *table[T]) put(id string, v T) {
+	prev, existed := t.rows[id]
+	if t.db.inTx {
+		t.db.undo = append(t.db.undo, func() {
+			if existed {
+				t.rows[id] = prev
+			} else {
+				delete(t.rows, id)
+			}
+		})
+	}
+	seq := prev.seq
+	if !existed {
+		t.db.seq++
+		seq = t.db.seq
+	}
+	t.rows[id] = row[T]{seq: seq, v: roundTrip(v)}
+}
+
+func (t *table[T]) delete(id string) {
+	prev, existed := t.rows[id]
+	if !existed {
+		return
+	}
+	if t.db.inTx {
+		t.db.undo = append(t.db.undo, func() { t.rows[id] = prev })
+	}
+	delete(t.rows, id)
+}
+
+type users struct{ *table[models.User] }
+
+// taken is true when someone other than userID has the username
+func (r *users) taken(username string, userID string) bool {
+	for id, u := range r.rows {
+		if id != userID && u.v.Username == username {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *users) Insert(ctx context.Context, user models.User) error {
+	defer r.db.lock(ctx)()
+
+	if _, ok := r.rows[user.Id]; ok || r.taken(user.Username, user.Id) {
+		return store.ErrDuplicate
+	}
+	r.put(user.Id, user)
+	return nil
+}
+
+func (r *users) Get(ctx context.Context, userID string) (*models.User, error) {
+	defer r.db.lock(ctx)()
+
+	user, ok := r.get(userID)
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return &user, nil
+}
+
+func (r *users) ByUsername(ctx context.Context, username string) (*models.User, error) {
+	defer r.db.lock(ctx)()
+
+	for id, u := range r.rows {
+		if u.v.Username == username {
+			user, _ := r.get(id)
+			return &user, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (r *users) Names(ctx context.Context, userIDs []string) (map[string]string, error) {
+	defer r.db.lock(ctx)()
+
+	names := make(map[string]string, len(userIDs))
+	for _, id := range userIDs {
+		if u, ok := r.rows[id]; ok {
+			names[id] = u.v.Username
+		}
+	}
+	return names, nil
+}
+
+// update changes the user with fn, if they pass the guard
+func (r *users) update(ctx context.Context, userID string, guard func(models.User) bool, fn func(*models.User) error) error {
+	defer r.db.lock(ctx)()
+
+	user, ok := r.get(userID)
+	if !ok || !guard(user) {
+		return store.ErrNotFound
+	}
+	if err := fn(&user); err != nil {
+		return err
+	}
+	r.put(userID, user)
+	return nil
+}
+
+func anyUser(models.User) bool { return true }
+
+func (r *users) Rename(ctx context.Context, userID string, username string) error {
+	return r.update(ctx, userID, anyUser, func(u *models.User) error {
+		if r.taken(username, userID) {
+			return store.ErrDuplicate
+		}
+		u.Username = username
+		return nil
+	})
+}
+
+func (r *users) UpgradeGuest(ctx context.Context, userID string, username string) error {
+	isGuest := func(u models.User) bool { return u.Guest }
+	return r.update(ctx, userID, isGuest, func(u *models.User) error {
+		if r.taken(username, userID) {
+			return store.ErrDuplicate
+		}
+		u.Username, u.Guest = username, false
+		return nil
+	})
+}
+
+func (r *users) SetRole(ctx context.Context, userID string, role string) error {
+	return r.update(ctx, userID, anyUser, func(u *models.User) error {
+		u.Role = role
+		return nil
+	})
+}
+
+func (r *users) AwardBadge(ctx context.Context, userID string, badge models.Badge) error {
+	noBadge := func(u models.User) bool {
+		return !slices.ContainsFunc(u.Badges, func(b models.Badge) bool { return b.SeasonID == badge.SeasonID })
+	}
+	err := r.update(ctx, userID, noBadge, func(u *models.User) error {
+		u.Badges = append(u.Badges, badge)
+		return nil
+	})
+	if err == store.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (r *users) Delete(ctx context.Context, userID string) error {
+	defer r.db.lock(ctx)()
+
+	r.delete(userID)
+	return nil
+}
+
+type questions struct{ *table[models.Question] }
+
+func (r *questions) Insert(ctx context.Context, questions ...models.Question) error {
+	defer r.db.lock(ctx)()
+
+	for _, q := range questions {
+		if _, ok := r.rows[q.Id]; ok {
+			return store.ErrDuplicate
+		}
+		r.put(q.Id, q)
+	}
+	return nil
+}
+
+func (r *questions) Get(ctx context.Context, questionID string) (*models.Question, error) {
+	defer r.db.lock(ctx)()
+
+	q, ok := r.get(questionID)
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return &q, nil
+}
+
+func (r *questions) AtDifficulty(ctx context.Context, difficulty int) ([]models.Question, error) {
+	defer r.db.lock(ctx)()
+
+	found := []models.Question{}
+	for _, q := range r.all() {
+		if q.Difficulty == difficulty {
+			found = append(found, q)
+		}
+	}
+	return found, nil
+}
+
+func (r *questions) Topics(ctx context.Context) ([]string, error) {
+	defer r.db.lock(ctx)()
+
+	var topics []string
+	for _, q := range r.rows {
+		if q.v.Topic != "" && !slices.Contains(topics, q.v.Topic) {
+			topics = append(topics, q.v.Topic)
+		}
+	}
+	return topics, nil
+}
@@ -0,0 +1,256 @@
+package memstore
+
+import (
+	"context"
+	"errors"
+	"server/internal/models"
+	"server/internal/store"
+	"testing"
+	"time"
+)
+
+func rowIDs(rows []store.BoardRow) []string {
+	ids := make([]string, 0, len(rows))
+	for _, r := range rows {
+		ids = append(ids, r.UserID)
+	}
+	return ids
+}
+
+func TestUsersUnique(t *testing.T) {
+	ctx := context.Background()
+	users := New().Repos().Users
+
+	if err := users.Insert(ctx, models.User{Id: "1", Username: "jack", Guest: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Insert(ctx, models.User{Id: "2", Username: "jack"}); err != store.ErrDuplicate {
+		t.Fatalf("taken username: %v", err)
+	}
+	if err := users.Insert(ctx, models.User{Id: "1", Username: "kate"}); err != store.ErrDuplicate {
+		t.Fatalf("taken id: %v", err)
+	}
+	users.Insert(ctx, models.User{Id: "2", Username: "kate"})
+
+	if err := users.Rename(ctx, "2", "jack"); err != store.ErrDuplicate {
+		t.Fatalf("rename onto a taken name: %v", err)
+	}
+	if err := users.UpgradeGuest(ctx, "2", "kate2"); err != store.ErrNotFound {
+		t.Fatalf("upgraded a regular user: %v", err)
+	}
+	if err := users.UpgradeGuest(ctx, "1", "jacko"); err != nil {
+		t.Fatal(err)
+	}
+	if u, err := users.ByUsername(ctx, "jacko"); err != nil || u.Id != "1" || u.Guest {
+		t.Fatalf("got %+v %v", u, err)
+	}
+
+	badge := models.Badge{SeasonID: "s1", Title: "champion"}
+	users.AwardBadge(ctx, "1", badge)
+	users.AwardBadge(ctx, "1", badge)
+	if u, _ := users.Get(ctx, "1"); len(u.Badges) != 1 {
+		t.Fatalf("badges %+v", u.Badges)
+	}
+}
+
+// Save keeps what mongo keeps when it $sets a struct with empty omitempty fields
+func TestStatesSave(t *testing.T) {
+	ctx := context.Background()
+	states := New().Repos().States
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	states.Insert(ctx, models.UserState{
+		UserID:      "1",
+		SeasonID:    "s1",
+		TopicScores: map[string]float64{"cats": 2},
+		AchievedAt:  map[string]time.Time{"score": at},
+	})
+	if err := states.Save(ctx, models.UserState{UserID: "1", TotalScore: 5, StateVersion: 1}, 1); err != store.ErrNotFound {
+		t.Fatalf("saved on the wrong version: %v", err)
+	}
+	if err := states.Save(ctx, models.UserState{UserID: "1", TotalScore: 5, StateVersion: 1}, 0); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := states.Get(ctx, "1")
+	if got.TotalScore != 5 || got.SeasonID != "s1" || got.TopicScores["cats"] != 2 || !got.AchievedAt["score"].Equal(at) {
+		t.Fatalf("got %+v", got)
+	}
+
+	if _, err := states.Include(ctx, "1"); err != store.ErrNotFound {
+		t.Fatalf("included a user that wasnt excluded: %v", err)
+	}
+	before, _ := states.Exclude(ctx, "1")
+	if before.ShadowExcluded || before.StateVersion != 1 {
+		t.Fatalf("before %+v", before)
+	}
+	// a stale copy cant save the exclusion away
+	if err := states.Save(ctx, *got, got.StateVersion); err != store.ErrNotFound {
+		t.Fatalf("stale save: %v", err)
+	}
+	after, err := states.Include(ctx, "1")
+	if err != nil || after.ShadowExcluded || after.StateVersion != 3 {
+		t.Fatalf("after %+v %v", after, err)
+	}
+}
+
+func TestTransactionRollsBackRepos(t *testing.T) {
+	ctx := context.Background()
+	db := New()
+	repos := db.Repos()
+	repos.States.Insert(ctx, models.UserState{UserID: "1"})
+
+	err := db.Transaction(ctx, func(ctx context.Context) error {
+		repos.Answers.Insert(ctx, models.AnswerLog{Id: "a", UserID: "1", IdempotencyKey: "k"})
+		repos.States.Save(ctx, models.UserState{UserID: "1", TotalScore: 3, StateVersion: 1}, 0)
+		return errors.New("failed")
+	})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if _, err := repos.Answers.Get(ctx, "1", "k"); err != store.ErrNotFound {
+		t.Fatalf("answer kept: %v", err)
+	}
+	if st, _ := repos.States.Get(ctx, "1"); st.TotalScore != 0 || st.StateVersion != 0 {
+		t.Fatalf("state kept %+v", st)
+	}
+}
+
+// the board order matches store.Boards: value desc, earliest first, id desc
+func TestStateBoard(t *testing.T) {
+	ctx := context.Background()
+	states := New().Repos().States
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) map[string]time.Time { return map[string]time.Time{"score": t0.Add(d)} }
+
+	for _, st := range []models.UserState{
+		{UserID: "a", TotalScore: 5, AchievedAt: at(time.Second)},
+		{UserID: "b", TotalScore: 5, AchievedAt: at(0)},
+		{UserID: "c", TotalScore: 5},
+		{UserID: "d", TotalScore: 9, AchievedAt: at(0)},
+		{UserID: "e", TotalScore: 5, AchievedAt: at(0)},
+		{UserID: "f", TotalScore: 1, AchievedAt: at(0)},
+		{UserID: "g", TotalScore: 99, Guest: true},
+		{UserID: "h", TotalScore: 99, ShadowExcluded: true},
+	} {
+		states.Insert(ctx, st)
+	}
+	board := states.Board(store.StateBoard{Name: "score", Field: "totalScore"})
+
+	page, err := board.Page(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rowIDs(page), []string{"d", "c", "e", "b", "a", "f"}; !sameIDs(got, want) {
+		t.Fatalf("order %v, want %v", got, want)
+	}
+	if page, _ := board.Page(ctx, 4, 10); !sameIDs(rowIDs(page), []string{"a", "f"}) {
+		t.Fatalf("offset page %v", rowIDs(page))
+	}
+	if rows, _ := board.Members(ctx, []string{"f", "b", "g"}); !sameIDs(rowIDs(rows), []string{"b", "f"}) {
+		t.Fatalf("members %v", rowIDs(rows))
+	}
+
+	if n, _ := board.Above(ctx, 1, false); n != 5 {
+		t.Fatalf("above %d", n)
+	}
+	if n, _ := board.Above(ctx, 1, true); n != 2 {
+		t.Fatalf("distinct above %d", n)
+	}
+	// a guest with 5 reached at t0 and id "bb" goes between e and b
+	if n, _ := board.Before(ctx, 5, t0, "bb"); n != 3 {
+		t.Fatalf("before %d", n)
+	}
+}
+
+func TestPeriodTotals(t *testing.T) {
+	ctx := context.Background()
+	repos := New().Repos()
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	repos.States.Insert(ctx, models.UserState{UserID: "1", Username: "jack"})
+	repos.States.Insert(ctx, models.UserState{UserID: "2", Username: "kate", Guest: true})
+	for i, a := range []models.AnswerLog{
+		{UserID: "1", ScoreDelta: 9, StreakAtAnswer: 1, Correct: true, AnsweredAt: t0.Add(-time.Hour)}, // before the period
+		{UserID: "1", ScoreDelta: 2, StreakAtAnswer: 2, Correct: true, AnsweredAt: t0},
+		{UserID: "1", ScoreDelta: 3, StreakAtAnswer: 3, Correct: true, AnsweredAt: t0.Add(time.Minute)},
+		{UserID: "1", ScoreDelta: 0, StreakAtAnswer: 0, AnsweredAt: t0.Add(2 * time.Minute)},
+		{UserID: "1", ScoreDelta: 1, StreakAtAnswer: 3, Correct: true, AnsweredAt: t0.Add(3 * time.Minute)},
+		{UserID: "2", ScoreDelta: 50, StreakAtAnswer: 1, Correct: true, AnsweredAt: t0},
+	} {
+		a.Id, a.IdempotencyKey = string(rune('a'+i)), string(rune('a'+i))
+		if err := repos.Answers.Insert(ctx, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var totals []models.PeriodTotal
+	repos.Answers.PeriodTotals(ctx, store.PeriodQuery{Start: t0, Public: true}, func(pt models.PeriodTotal) error {
+		totals = append(totals, pt)
+		return nil
+	})
+	if len(totals) != 1 {
+		t.Fatalf("the guest is listed: %+v", totals)
+	}
+	got := totals[0]
+	if got.Username != "jack" || got.Score != 6 || got.Answered != 4 || got.Correct != 3 ||
+		got.Streak != 3 || !got.StreakAt.Equal(t0.Add(time.Minute)) || !got.ScoreAt.Equal(t0.Add(3*time.Minute)) {
+		t.Fatalf("got %+v", got)
+	}
+
+	board := repos.Answers.PeriodBoard(t0, false)
+	if page, _ := board.Page(ctx, 0, 10); !sameIDs(rowIDs(page), []string{"1"}) || page[0].Value != 6 {
+		t.Fatalf("period page %+v", page)
+	}
+
+	var users []string
+	repos.Answers.EachUser(ctx, store.AnswerQuery{Since: t0}, func(answers []models.AnswerLog) error {
+		users = append(users, answers[0].UserID)
+		return nil
+	})
+	if !sameIDs(users, []string{"1", "2"}) {
+		t.Fatalf("users %v", users)
+	}
+}
+
+func TestTeamTotals(t *testing.T) {
+	ctx := context.Background()
+	db := New()
+	repos := db.Repos()
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	repos.States.Insert(ctx, models.UserState{UserID: "1", TotalScore: 10, TotalAnswered: 4, TotalCorrect: 3})
+	repos.States.Insert(ctx, models.UserState{UserID: "2", TotalScore: 5, TotalAnswered: 2, TotalCorrect: 1})
+	repos.States.Insert(ctx, models.UserState{UserID: "3", TotalScore: 99, TotalAnswered: 9, TotalCorrect: 9, ShadowExcluded: true})
+	repos.States.Insert(ctx, models.UserState{UserID: "4", TotalScore: 7, TotalAnswered: 1, TotalCorrect: 1}) // on no team
+	members := db.Collection(store.TEAM_MEMBERS)
+	for _, m := range []models.TeamMember{{UserID: "1", TeamID: "a"}, {UserID: "2", TeamID: "a"}, {UserID: "3", TeamID: "b"}} {
+		members.InsertOne(ctx, m)
+	}
+	for i, a := range []models.AnswerLog{
+		{UserID: "1", ScoreDelta: 9, Correct: true, AnsweredAt: t0.Add(-time.Hour)}, // before the period
+		{UserID: "1", ScoreDelta: 2, Correct: true, AnsweredAt: t0},
+		{UserID: "2", ScoreDelta: 0, AnsweredAt: t0},
+		{UserID: "3", ScoreDelta: 50, Correct: true, AnsweredAt: t0},
+		{UserID: "4", ScoreDelta: 50, Correct: true, AnsweredAt: t0},
+	} {
+		a.Id, a.IdempotencyKey = string(rune('a'+i)), string(rune('a'+i))
+		repos.Answers.Insert(ctx, a)
+	}
+
+	collect := func(totals *[]models.TeamTotal) func(models.TeamTotal) error {
+		return func(t models.TeamTotal) error {
+			*totals = append(*totals, t)
+			return nil
+		}
+	}
+	var all, period []models.TeamTotal
+	repos.States.TeamTotals(ctx, collect(&all))
+	repos.Answers.TeamTotals(ctx, t0, collect(&period))
+
+	if len(all) != 1 || all[0] != (models.TeamTotal{TeamID: "a", Score: 15, Answered: 6, Correct: 4}) {
+		t.Fatalf("all time %+v", all)
+	}
+	if len(period) != 1 || period[0] != (models.TeamTotal{TeamID: "a", Score: 2, Answered: 2, Correct: 1}) {
+		t.Fatalf("period %+v", period)
+	}
+}
@@ -0,0 +1,294 @@
+package memstore
+
+import (
+	"cmp"
+	"context"
+	"server/internal/models"
+	"server/internal/store"
+	"slices"
+	"strings"
+	"time"
+)
+
+type states struct{ *table[models.UserState] }
+
+func (r *states) Insert(ctx context.Context, state models.UserState) error {
+	defer r.db.lock(ctx)()
+
+	if _, ok := r.rows[state.UserID]; ok {
+		return store.ErrDuplicate
+	}
+	r.put(state.UserID, state)
+	return nil
+}
+
+func (r *states) Get(ctx context.Context, userID string) (*models.UserState, error) {
+	defer r.db.lock(ctx)()
+
+	state, ok := r.get(userID)
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return &state, nil
+}
+
+func (r *states) GetMany(ctx context.Context, userIDs []string) ([]models.UserState, error) {
+	defer r.db.lock(ctx)()
+
+	found := []models.UserState{}
+	for _, id := range userIDs {
+		if state, ok := r.get(id); ok {
+			found = append(found, state)
+		}
+	}
+	return found, nil
+}
+
+// Save keeps the old value of the fields that are left out when empty, like
+// mongo does when it $sets the whole struct
+func (r *states) Save(ctx context.Context, state models.UserState, expectedVersion int) error {
+	return r.update(ctx, state.UserID, func(old *models.UserState) error {
+		if old.StateVersion != expectedVersion {
+			return store.ErrNotFound
+		}
+		if state.SeasonID == "" {
+			state.SeasonID = old.SeasonID
+		}
+		if len(state.TopicScores) == 0 {
+			state.TopicScores = old.TopicScores
+		}
+		if len(state.AchievedAt) == 0 {
+			state.AchievedAt = old.AchievedAt
+		}
+		state.Guest = state.Guest || old.Guest
+		state.ShadowExcluded = state.ShadowExcluded || old.ShadowExcluded
+		*old = state
+		return nil
+	})
+}
+
+// update changes the state with fn, ErrNotFound when there is none
+func (r *states) update(ctx context.Context, userID string, fn func(*models.UserState) error) error {
+	defer r.db.lock(ctx)()
+
+	state, ok := r.get(userID)
+	if !ok {
+		return store.ErrNotFound
+	}
+	if err := fn(&state); err != nil {
+		return err
+	}
+	r.put(userID, state)
+	return nil
+}
+
+func (r *states) Rename(ctx context.Context, userID string, username string) error {
+	err := r.update(ctx, userID, func(s *models.UserState) error {
+		s.Username = username
+		s.StateVersion++
+		return nil
+	})
+	if err == store.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (r *states) Upgrade(ctx context.Context, userID string, username string) (*models.UserState, error) {
+	var after models.UserState
+	err := r.update(ctx, userID, func(s *models.UserState) error {
+		s.Username, s.Guest = username, false
+		s.StateVersion++
+		after = *s
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &after, nil
+}
+
+func (r *states) Exclude(ctx context.Context, userID string) (*models.UserState, error) {
+	var before models.UserState
+	err := r.update(ctx, userID, func(s *models.UserState) error {
+		before = roundTrip(*s)
+		s.ShadowExcluded = true
+		s.StateVersion++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &before, nil
+}
+
+func (r *states) Include(ctx context.Context, userID string) (*models.UserState, error) {
+	var after models.UserState
+	err := r.update(ctx, userID, func(s *models.UserState) error {
+		if !s.ShadowExcluded {
+			return store.ErrNotFound
+		}
+		s.ShadowExcluded = false
+		s.StateVersion++
+		after = *s
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &after, nil
+}
+
+func (r *states) ResetSeason(ctx context.Context, seasonID string, fn func(userIDs []string) error) error {
+	userIDs, err := r.resetSeason(ctx, seasonID)
+	if err != nil || len(userIDs) == 0 {
+		return err
+	}
+	return fn(userIDs)
+}
+
+func (r *states) resetSeason(ctx context.Context, seasonID string) ([]string, error) {
+	defer r.db.lock(ctx)()
+
+	var userIDs []string
+	for _, state := range r.all() {
+		if state.SeasonID == seasonID {
+			state.SeasonScore, state.SeasonID = 0, ""
+			state.StateVersion++
+			r.put(state.UserID, state)
+			userIDs = append(userIDs, state.UserID)
+		}
+	}
+	return userIDs, nil
+}
+
+func (r *states) Delete(ctx context.Context, userID string) error {
+	defer r.db.lock(ctx)()
+
+	r.delete(userID)
+	return nil
+}
+
+func public(state models.UserState) bool {
+	return !state.Guest && !state.ShadowExcluded
+}
+
+// find is every state q matches in user id order, taken under the lock
+func (r *states) find(ctx context.Context, q store.StateQuery) []models.UserState {
+	defer r.db.lock(ctx)()
+
+	var found []models.UserState
+	for _, state := range r.all() {
+		if q.Public && !public(state) ||
+			q.UserIDs != nil && !slices.Contains(q.UserIDs, state.UserID) ||
+			q.SeasonID != "" && state.SeasonID != q.SeasonID {
+			continue
+		}
+		found = append(found, state)
+	}
+	slices.SortFunc(found, func(a, b models.UserState) int { return strings.Compare(a.UserID, b.UserID) })
+	return found
+}
+
+// Each calls fn without the lock held, so fn can use the other repos
+func (r *states) Each(ctx context.Context, q store.StateQuery, fn func(models.UserState) error) error {
+	for _, state := range r.find(ctx, q) {
+		if err := fn(state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *states) Board(b store.StateBoard) store.BoardQueries {
+	return sortedBoard(func(ctx context.Context) ([]store.BoardRow, error) {
+		var rows []store.BoardRow
+		for _, state := range r.find(ctx, store.StateQuery{Public: true, SeasonID: b.SeasonID}) {
+			value, ok := store.StateValue(state, b.Field)
+			if !ok || state.TotalAnswered < b.MinAnswered {
+				continue
+			}
+			rows = append(rows, store.BoardRow{
+				UserID:   state.UserID,
+				Username: state.Username,
+				Value:    value,
+				At:       state.AchievedAt[b.Name],
+			})
+		}
+		return rows, nil
+	})
+}
+
+// sortedBoard answers the board queries by sorting every listed row, in the
+// order store.Boards keeps
+type sortedBoard func(ctx context.Context) ([]store.BoardRow, error)
+
+func boardOrder(a, b store.BoardRow) int {
+	return cmp.Or(
+		cmp.Compare(b.Value, a.Value),
+		cmp.Compare(store.TieMs(a.At), store.TieMs(b.At)),
+		strings.Compare(b.UserID, a.UserID),
+	)
+}
+
+func (q sortedBoard) sorted(ctx context.Context) ([]store.BoardRow, error) {
+	rows, err := q(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(rows, boardOrder)
+	return rows, nil
+}
+
+func (q sortedBoard) Page(ctx context.Context, offset int, limit int) ([]store.BoardRow, error) {
+	rows, err := q.sorted(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows = rows[min(max(offset, 0), len(rows)):]
+	return rows[:min(limit, len(rows))], nil
+}
+
+func (q sortedBoard) Members(ctx context.Context, userIDs []string) ([]store.BoardRow, error) {
+	rows, err := q.sorted(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(rows, func(row store.BoardRow) bool {
+		return !slices.Contains(userIDs, row.UserID)
+	}), nil
+}
+
+func (q sortedBoard) Above(ctx context.Context, value float64, distinct bool) (int, error) {
+	rows, err := q(ctx)
+	if err != nil {
+		return 0, err
+	}
+	above := map[float64]bool{}
+	n := 0
+	for _, row := range rows {
+		if row.Value > value {
+			above[row.Value] = true
+			n++
+		}
+	}
+	if distinct {
+		return len(above), nil
+	}
+	return n, nil
+}
+
+func (q sortedBoard) Before(ctx context.Context, value float64, at time.Time, userID string) (int, error) {
+	rows, err := q(ctx)
+	if err != nil {
+		return 0, err
+	}
+	them := store.BoardRow{UserID: userID, Value: value, At: at}
+	n := 0
+	for _, row := range rows {
+		if boardOrder(row, them) < 0 {
+			n++
+		}
+	}
+	return n, nil
+}
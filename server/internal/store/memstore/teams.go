@@ -0,0 +1,84 @@
+package memstore
+
+import (
+	"context"
+	"server/internal/models"
+	"server/internal/store"
+	"time"
+)
+
+// teamOf maps the users on team-members to their team, taken under the lock
+func (db *DB) teamOf() map[string]string {
+	teamOf := map[string]string{}
+	for _, r := range db.collection(store.TEAM_MEMBERS).all() {
+		userID, _ := get(r.doc, "_id")
+		teamID, _ := get(r.doc, "teamId")
+		if u, ok := userID.(string); ok {
+			if t, ok := teamID.(string); ok {
+				teamOf[u] = t
+			}
+		}
+	}
+	return teamOf
+}
+
+// teamSums adds user totals up per team like the mongo pipelines do, leaving
+// out excluded members
+type teamSums struct {
+	teamOf map[string]string
+	states *states
+	sums   map[string]*models.TeamTotal
+	order  []string
+}
+
+func (t *teamSums) add(userID string, score float64, answered float64, correct float64) {
+	teamID, ok := t.teamOf[userID]
+	if !ok {
+		return
+	}
+	if state, ok := t.states.get(userID); ok && state.ShadowExcluded {
+		return
+	}
+	sum, ok := t.sums[teamID]
+	if !ok {
+		sum = &models.TeamTotal{TeamID: teamID}
+		t.sums[teamID] = sum
+		t.order = append(t.order, teamID)
+	}
+	sum.Score += score
+	sum.Answered += answered
+	sum.Correct += correct
+}
+
+func (t *teamSums) each(fn func(models.TeamTotal) error) error {
+	for _, id := range t.order {
+		if err := fn(*t.sums[id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *states) TeamTotals(ctx context.Context, fn func(models.TeamTotal) error) error {
+	unlock := r.db.lock(ctx)
+	sums := &teamSums{teamOf: r.db.teamOf(), states: r, sums: map[string]*models.TeamTotal{}}
+	for _, state := range r.all() {
+		sums.add(state.UserID, state.TotalScore, state.TotalAnswered, state.TotalCorrect)
+	}
+	unlock()
+
+	return sums.each(fn)
+}
+
+func (r *answers) TeamTotals(ctx context.Context, start time.Time, fn func(models.TeamTotal) error) error {
+	totals := r.totals(ctx, store.PeriodQuery{Start: start})
+
+	unlock := r.db.lock(ctx)
+	sums := &teamSums{teamOf: r.db.teamOf(), states: r.states, sums: map[string]*models.TeamTotal{}}
+	for _, t := range totals {
+		sums.add(t.UserID, t.Score, t.Answered, t.Correct)
+	}
+	unlock()
+
+	return sums.each(fn)
+}
@@ -0,0 +1,156 @@
+package memstore
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// applyUpdate runs an operator document ({$set: ...}) on a copy of doc.
+// inserting is set on an upsert so $setOnInsert applies. Update pipelines are
+// only used by the migrations, which dont run in memory.
+func applyUpdate(doc bson.D, update any, inserting bool) (bson.D, error) {
+	doc = cloneDoc(doc)
+
+	switch u := update.(type) {
+	case bson.A:
+		return nil, unsupported("update pipeline")
+	case bson.D:
+		if _, ok := isOperatorDoc(u); !ok {
+			return nil, fmt.Errorf("memstore: an update needs $ operators, use ReplaceOne to replace")
+		}
+		var err error
+		for _, op := range u {
+			fields, ok := op.Value.(bson.D)
+			if !ok {
+				return nil, fmt.Errorf("memstore: %s needs a document", op.Key)
+			}
+			if op.Key == "$setOnInsert" && !inserting {
+				continue
+			}
+			for _, f := range fields {
+				doc, err = applyUpdateOp(doc, op.Key, f.Key, f.Value)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("memstore: unsupported update %T", update)
+}
+
+func applyUpdateOp(doc bson.D, op string, path string, v any) (bson.D, error) {
+	parts := split(path)
+	cur, exists := getPath(doc, parts)
+
+	switch op {
+	case "$set", "$setOnInsert":
+		return setPath(doc, parts, clone(v))
+	case "$unset":
+		return unsetPath(doc, parts), nil
+	case "$inc":
+		if !exists || cur == nil {
+			return setPath(doc, parts, v)
+		}
+		sum, err := addNumbers(cur, v)
+		if err != nil {
+			return nil, err
+		}
+		return setPath(doc, parts, sum)
+	case "$max", "$min":
+		c := compare(v, cur)
+		if !exists || (op == "$max" && c > 0) || (op == "$min" && c < 0) {
+			return setPath(doc, parts, clone(v))
+		}
+		return doc, nil
+	case "$push", "$addToSet":
+		items := bson.A{v}
+		if d, ok := v.(bson.D); ok {
+			if each, ok := get(d, "$each"); ok {
+				items, _ = each.(bson.A)
+			}
+		}
+		arr := bson.A{}
+		if exists && cur != nil {
+			a, ok := cur.(bson.A)
+			if !ok {
+				return nil, fmt.Errorf("memstore: %s on %s which isnt an array", op, path)
+			}
+			arr = a
+		}
+		for _, item := range items {
+			if op == "$addToSet" && matchEq([]any{arr}, item) {
+				continue
+			}
+			arr = append(arr, clone(item))
+		}
+		return setPath(doc, parts, arr)
+	case "$pull":
+		arr, ok := cur.(bson.A)
+		if !ok {
+			return doc, nil
+		}
+		kept := bson.A{}
+		for _, elem := range arr {
+			var drop bool
+			if cond, isDoc := v.(bson.D); isDoc {
+				var err error
+				drop, err = elemMatches(elem, cond)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				drop = equal(elem, v)
+			}
+			if !drop {
+				kept = append(kept, elem)
+			}
+		}
+		return setPath(doc, parts, kept)
+	}
+	return nil, unsupported("update operator " + op)
+}
+
+// upsertSeed is the document an upsert starts from, the equality conditions of
+// its filter
+func upsertSeed(filter bson.D) (bson.D, error) {
+	seed := bson.D{}
+	var err error
+	for _, e := range filter {
+		if e.Key == "$and" {
+			subs, _ := e.Value.(bson.A)
+			for _, sub := range subs {
+				f, _ := sub.(bson.D)
+				s, err := upsertSeed(f)
+				if err != nil {
+					return nil, err
+				}
+				for _, se := range s {
+					seed, err = setPath(seed, split(se.Key), se.Value)
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(e.Key, "$") {
+			continue
+		}
+		v := e.Value
+		if ops, ok := isOperatorDoc(v); ok {
+			eq, ok := get(ops, "$eq")
+			if !ok {
+				continue
+			}
+			v = eq
+		}
+		seed, err = setPath(seed, split(e.Key), clone(v))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return seed, nil
+}
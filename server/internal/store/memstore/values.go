@@ -0,0 +1,488 @@
+package memstore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Documents are kept the way they would come back from mongo: bson.D all the
+// way down with bson.A for arrays, and only these scalars in them
+//
+//	nil, int32, int64, float64, string, bool, bson.DateTime, bson.Binary,
+//	bson.ObjectID, bson.Timestamp, bson.Decimal128
+//
+// Everything going in (documents, filters, updates, pipelines) is put through
+// the driver's own marshalling first, so a time.Time in a filter compares the
+// same as the bson.DateTime it was stored as.
+
+// normalize turns anything the driver can marshal into the stored form
+func normalize(v any) (any, error) {
+	raw, err := bson.Marshal(bson.D{{Key: "v", Value: v}})
+	if err != nil {
+		return nil, err
+	}
+	return fromRaw(bson.Raw(raw).Lookup("v"))
+}
+
+// normalizeDoc is normalize for documents, nil is an empty one
+func normalizeDoc(v any) (bson.D, error) {
+	if v == nil {
+		return bson.D{}, nil
+	}
+	n, err := normalize(v)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := n.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("memstore: expected a document, got %T", v)
+	}
+	return d, nil
+}
+
+func fromRaw(rv bson.RawValue) (any, error) {
+	switch rv.Type {
+	case bson.TypeEmbeddedDocument:
+		elems, err := rv.Document().Elements()
+		if err != nil {
+			return nil, err
+		}
+		d := make(bson.D, 0, len(elems))
+		for _, e := range elems {
+			v, err := fromRaw(e.Value())
+			if err != nil {
+				return nil, err
+			}
+			d = append(d, bson.E{Key: e.Key(), Value: v})
+		}
+		return d, nil
+	case bson.TypeArray:
+		values, err := rv.Array().Values()
+		if err != nil {
+			return nil, err
+		}
+		a := make(bson.A, 0, len(values))
+		for _, value := range values {
+			v, err := fromRaw(value)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		return a, nil
+	case bson.TypeDouble:
+		return rv.Double(), nil
+	case bson.TypeString:
+		return rv.StringValue(), nil
+	case bson.TypeBoolean:
+		return rv.Boolean(), nil
+	case bson.TypeInt32:
+		return rv.Int32(), nil
+	case bson.TypeInt64:
+		return rv.Int64(), nil
+	case bson.TypeNull, bson.TypeUndefined:
+		return nil, nil
+	case bson.TypeDateTime:
+		return bson.DateTime(rv.DateTime()), nil
+	case bson.TypeBinary:
+		subtype, data := rv.Binary()
+		return bson.Binary{Subtype: subtype, Data: data}, nil
+	case bson.TypeObjectID:
+		return rv.ObjectID(), nil
+	case bson.TypeTimestamp:
+		t, i := rv.Timestamp()
+		return bson.Timestamp{T: t, I: i}, nil
+	case bson.TypeDecimal128:
+		return rv.Decimal128(), nil
+	}
+	return nil, fmt.Errorf("memstore: unsupported bson type %s", rv.Type)
+}
+
+// toRawArray is how Distinct hands its values back
+func toRawArray(values bson.A) (bson.RawArray, error) {
+	raw, err := bson.Marshal(bson.D{{Key: "v", Value: values}})
+	if err != nil {
+		return nil, err
+	}
+	return bson.Raw(raw).Lookup("v").Array(), nil
+}
+
+func clone(v any) any {
+	switch t := v.(type) {
+	case bson.D:
+		d := make(bson.D, len(t))
+		for i, e := range t {
+			d[i] = bson.E{Key: e.Key, Value: clone(e.Value)}
+		}
+		return d
+	case bson.A:
+		a := make(bson.A, len(t))
+		for i, e := range t {
+			a[i] = clone(e)
+		}
+		return a
+	case bson.Binary:
+		return bson.Binary{Subtype: t.Subtype, Data: bytes.Clone(t.Data)}
+	}
+	return v
+}
+
+func cloneDoc(d bson.D) bson.D {
+	return clone(d).(bson.D)
+}
+
+// typeRank is the order mongo sorts mixed types in
+func typeRank(v any) int {
+	switch v.(type) {
+	case nil:
+		return 1
+	case int32, int64, float64, bson.Decimal128:
+		return 2
+	case string:
+		return 3
+	case bson.D:
+		return 4
+	case bson.A:
+		return 5
+	case bson.Binary:
+		return 6
+	case bson.ObjectID:
+		return 7
+	case bool:
+		return 8
+	case bson.DateTime:
+		return 9
+	case bson.Timestamp:
+		return 10
+	}
+	return 11
+}
+
+func isNumber(v any) bool {
+	switch v.(type) {
+	case int32, int64, float64:
+		return true
+	}
+	return false
+}
+
+func toFloat(v any) float64 {
+	switch n := v.(type) {
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+func toInt(v any) (int, error) {
+	switch n := v.(type) {
+	case int32:
+		return int(n), nil
+	case int64:
+		return int(n), nil
+	case float64:
+		if n == math.Trunc(n) {
+			return int(n), nil
+		}
+	}
+	return 0, fmt.Errorf("memstore: expected a whole number, got %v", v)
+}
+
+// compare orders two values like a mongo sort does, 1 and 1.0 are equal
+func compare(a, b any) int {
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		return cmpInt(ra, rb)
+	}
+
+	switch x := a.(type) {
+	case int32, int64, float64:
+		if ia, ok := asInt64(a); ok {
+			if ib, ok := asInt64(b); ok {
+				return cmpInt(ia, ib)
+			}
+		}
+		fa, fb := toFloat(a), toFloat(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(x, b.(string))
+	case bson.D:
+		y := b.(bson.D)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := strings.Compare(x[i].Key, y[i].Key); c != 0 {
+				return c
+			}
+			if c := compare(x[i].Value, y[i].Value); c != 0 {
+				return c
+			}
+		}
+		return cmpInt(len(x), len(y))
+	case bson.A:
+		y := b.(bson.A)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compare(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return cmpInt(len(x), len(y))
+	case bson.Binary:
+		return bytes.Compare(x.Data, b.(bson.Binary).Data)
+	case bson.ObjectID:
+		y := b.(bson.ObjectID)
+		return bytes.Compare(x[:], y[:])
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	case bson.DateTime:
+		return cmpInt(x, b.(bson.DateTime))
+	case bson.Timestamp:
+		y := b.(bson.Timestamp)
+		if x.T != y.T {
+			return cmpInt(x.T, y.T)
+		}
+		return cmpInt(x.I, y.I)
+	}
+	return strings.Compare(keyString(a), keyString(b))
+}
+
+func asInt64(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	}
+	return 0, false
+}
+
+func cmpInt[T int | int64 | uint32 | bson.DateTime](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func equal(a, b any) bool {
+	return compare(a, b) == 0
+}
+
+// keyString writes a value out so equal values (1 and 1.0 too) give the same
+// string, for grouping and for unique keys
+func keyString(v any) string {
+	var b strings.Builder
+	writeKey(&b, v)
+	return b.String()
+}
+
+func writeKey(b *strings.Builder, v any) {
+	switch t := v.(type) {
+	case nil:
+		b.WriteString("n")
+	case int32, int64, float64:
+		b.WriteString("#" + strconv.FormatFloat(toFloat(t), 'g', -1, 64))
+	case string:
+		b.WriteString("s" + strconv.Quote(t))
+	case bool:
+		b.WriteString("b" + strconv.FormatBool(t))
+	case bson.DateTime:
+		b.WriteString("d" + strconv.FormatInt(int64(t), 10))
+	case bson.ObjectID:
+		b.WriteString("o" + t.Hex())
+	case bson.Binary:
+		b.WriteString("x" + hex.EncodeToString(t.Data))
+	case bson.D:
+		b.WriteString("{")
+		for _, e := range t {
+			b.WriteString(strconv.Quote(e.Key) + ":")
+			writeKey(b, e.Value)
+			b.WriteString(",")
+		}
+		b.WriteString("}")
+	case bson.A:
+		b.WriteString("[")
+		for _, e := range t {
+			writeKey(b, e)
+			b.WriteString(",")
+		}
+		b.WriteString("]")
+	default:
+		fmt.Fprintf(b, "?%T:%v", t, t)
+	}
+}
+
+// addNumbers keeps ints as ints like mongo does, going to int64 on overflow
+func addNumbers(a, b any) (any, error) {
+	if !isNumber(a) || !isNumber(b) {
+		return nil, fmt.Errorf("memstore: cant add %v and %v", a, b)
+	}
+	ia, aInt := asInt64(a)
+	ib, bInt := asInt64(b)
+	if !aInt || !bInt {
+		return toFloat(a) + toFloat(b), nil
+	}
+	sum := ia + ib
+	_, a32 := a.(int32)
+	_, b32 := b.(int32)
+	if a32 && b32 && sum >= math.MinInt32 && sum <= math.MaxInt32 {
+		return int32(sum), nil
+	}
+	return sum, nil
+}
+
+func get(d bson.D, key string) (any, bool) {
+	for _, e := range d {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+func set(d bson.D, key string, v any) bson.D {
+	for i, e := range d {
+		if e.Key == key {
+			d[i].Value = v
+			return d
+		}
+	}
+	return append(d, bson.E{Key: key, Value: v})
+}
+
+func split(path string) []string {
+	return strings.Split(path, ".")
+}
+
+// lookup finds every value a query path reaches. Arrays on the way are walked
+// into, a number picks an array element. A final array comes back whole, the
+// query operators look inside it themselves.
+func lookup(v any, parts []string) []any {
+	if len(parts) == 0 {
+		return []any{v}
+	}
+	switch t := v.(type) {
+	case bson.D:
+		child, ok := get(t, parts[0])
+		if !ok {
+			return nil
+		}
+		return lookup(child, parts[1:])
+	case bson.A:
+		var found []any
+		if i, err := strconv.Atoi(parts[0]); err == nil && i >= 0 && i < len(t) {
+			found = append(found, lookup(t[i], parts[1:])...)
+		}
+		for _, elem := range t {
+			if d, ok := elem.(bson.D); ok {
+				found = append(found, lookup(d, parts)...)
+			}
+		}
+		return found
+	}
+	return nil
+}
+
+// resolve is a "$field.path" in an expression. Going through an array gives
+// the array of what each element has there.
+func resolve(v any, parts []string) (any, bool) {
+	if len(parts) == 0 {
+		return v, true
+	}
+	switch t := v.(type) {
+	case bson.D:
+		child, ok := get(t, parts[0])
+		if !ok {
+			return nil, false
+		}
+		return resolve(child, parts[1:])
+	case bson.A:
+		found := bson.A{}
+		for _, elem := range t {
+			if r, ok := resolve(elem, parts); ok {
+				found = append(found, r)
+			}
+		}
+		return found, true
+	}
+	return nil, false
+}
+
+// setPath sets a dotted path, making the documents on the way
+func setPath(d bson.D, parts []string, v any) (bson.D, error) {
+	if len(parts) == 1 {
+		return set(d, parts[0], v), nil
+	}
+	child, _ := get(d, parts[0])
+	var sub bson.D
+	switch t := child.(type) {
+	case bson.D:
+		sub = t
+	case nil:
+		sub = bson.D{}
+	default:
+		return nil, fmt.Errorf("memstore: cant set %s inside a %T", strings.Join(parts, "."), child)
+	}
+	sub, err := setPath(sub, parts[1:], v)
+	if err != nil {
+		return nil, err
+	}
+	return set(d, parts[0], sub), nil
+}
+
+func unsetPath(d bson.D, parts []string) bson.D {
+	for i, e := range d {
+		if e.Key != parts[0] {
+			continue
+		}
+		if len(parts) == 1 {
+			return append(d[:i:i], d[i+1:]...)
+		}
+		if sub, ok := e.Value.(bson.D); ok {
+			d[i].Value = unsetPath(sub, parts[1:])
+		}
+		return d
+	}
+	return d
+}
+
+// getPath is the value at a dotted path of documents only, no arrays
+func getPath(d bson.D, parts []string) (any, bool) {
+	v, ok := get(d, parts[0])
+	if !ok || len(parts) == 1 {
+		return v, ok
+	}
+	sub, isDoc := v.(bson.D)
+	if !isDoc {
+		return nil, false
+	}
+	return getPath(sub, parts[1:])
+}
+
+func unsupported(what string) error {
+	return errors.New("memstore: " + what + " is not supported")
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+type mongoDB struct {
+	client *mongo.Client
+	db     *mongo.Database
+}
+
+// NewMongo serves collections from one database of a connected client
+func NewMongo(client *mongo.Client, database string) DB {
+	return &mongoDB{client: client, db: client.Database(database)}
+}
+
+func (m *mongoDB) Repos() Repos {
+	return mongoRepos(m)
+}
+
+func (m *mongoDB) Collection(name string) Collection {
+	return mongoCollection{m.db.Collection(name)}
+}
+
+// Transaction needs mongo to run as a replica set
+func (m *mongoDB) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	session, err := m.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(ctx context.Context) (any, error) {
+		return nil, fn(ctx)
+	})
+	return err
+}
+
+// mongoCollection is the driver collection with the few calls it does
+// differently filled in
+type mongoCollection struct {
+	*mongo.Collection
+}
+
+func (c mongoCollection) Distinct(ctx context.Context, field string, filter any) *DistinctResult {
+	values, err := c.Collection.Distinct(ctx, field, filter).Raw()
+	return NewDistinctResult(values, err)
+}
+
+func (c mongoCollection) CreateIndex(ctx context.Context, model mongo.IndexModel) error {
+	_, err := c.Indexes().CreateOne(ctx, model)
+	return err
+}
+
+func (c mongoCollection) DropIndex(ctx context.Context, name string) error {
+	specs, err := c.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return err
+	}
+	for _, spec := range specs {
+		if spec.Name == name {
+			return c.Indexes().DropOne(ctx, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,772 @@
+package store
+
+import (
+	"context"
+	"server/internal/models"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// mongoRepos runs the repos as queries on the collections of db
+func mongoRepos(db DB) Repos {
+	return Repos{
+		Users:     mongoUsers{db.Collection(USERS)},
+		States:    mongoStates{c: db.Collection(USER_STATE), members: db.Collection(TEAM_MEMBERS)},
+		Questions: mongoQuestions{db.Collection(QUESTIONS)},
+		Answers:   mongoAnswers{db.Collection(ANSWER_LOGS)},
+	}
+}
+
+func notFound(err error) error {
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+	return err
+}
+
+func duplicate(err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+// matched turns a write that matched nothing into ErrNotFound
+func matched(res *mongo.UpdateResult, err error) error {
+	if err != nil {
+		return duplicate(err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type mongoUsers struct{ c Collection }
+
+func (r mongoUsers) Insert(ctx context.Context, user models.User) error {
+	_, err := r.c.InsertOne(ctx, user)
+	return duplicate(err)
+}
+
+func (r mongoUsers) find(ctx context.Context, filter bson.M) (*models.User, error) {
+	var user models.User
+	if err := r.c.FindOne(ctx, filter).Decode(&user); err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (r mongoUsers) Get(ctx context.Context, userID string) (*models.User, error) {
+	return r.find(ctx, bson.M{"_id": userID})
+}
+
+func (r mongoUsers) ByUsername(ctx context.Context, username string) (*models.User, error) {
+	return r.find(ctx, bson.M{"username": username})
+}
+
+func (r mongoUsers) Names(ctx context.Context, userIDs []string) (map[string]string, error) {
+	cursor, err := r.c.Find(ctx, bson.M{"_id": bson.M{"$in": userIDs}},
+		options.Find().SetProjection(bson.M{"username": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string, len(users))
+	for _, u := range users {
+		names[u.Id] = u.Username
+	}
+	return names, nil
+}
+
+func (r mongoUsers) Rename(ctx context.Context, userID string, username string) error {
+	return matched(r.c.UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"username": username}},
+	))
+}
+
+func (r mongoUsers) UpgradeGuest(ctx context.Context, userID string, username string) error {
+	return matched(r.c.UpdateOne(ctx,
+		bson.M{"_id": userID, "guest": true},
+		bson.M{
+			"$set":   bson.M{"username": username},
+			"$unset": bson.M{"guest": ""},
+		},
+	))
+}
+
+func (r mongoUsers) SetRole(ctx context.Context, userID string, role string) error {
+	return matched(r.c.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"role": role}}))
+}
+
+func (r mongoUsers) AwardBadge(ctx context.Context, userID string, badge models.Badge) error {
+	_, err := r.c.UpdateOne(ctx,
+		bson.M{"_id": userID, "badges.seasonId": bson.M{"$ne": badge.SeasonID}},
+		bson.M{"$push": bson.M{"badges": badge}},
+	)
+	return err
+}
+
+func (r mongoUsers) Delete(ctx context.Context, userID string) error {
+	_, err := r.c.DeleteOne(ctx, bson.M{"_id": userID})
+	return err
+}
+
+type mongoStates struct {
+	c       Collection
+	members Collection // for the team totals
+}
+
+func (r mongoStates) Insert(ctx context.Context, state models.UserState) error {
+	_, err := r.c.InsertOne(ctx, state)
+	return duplicate(err)
+}
+
+func (r mongoStates) Get(ctx context.Context, userID string) (*models.UserState, error) {
+	var state models.UserState
+	if err := r.c.FindOne(ctx, bson.M{"_id": userID}).Decode(&state); err != nil {
+		return nil, notFound(err)
+	}
+	return &state, nil
+}
+
+func (r mongoStates) GetMany(ctx context.Context, userIDs []string) ([]models.UserState, error) {
+	cursor, err := r.c.Find(ctx, bson.M{"_id": bson.M{"$in": userIDs}})
+	if err != nil {
+		return nil, err
+	}
+	states := []models.UserState{}
+	if err := cursor.All(ctx, &states); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+func (r mongoStates) Save(ctx context.Context, state models.UserState, expectedVersion int) error {
+	return matched(r.c.UpdateOne(ctx,
+		bson.M{"_id": state.UserID, "stateVersion": expectedVersion},
+		bson.M{"$set": state},
+	))
+}
+
+func (r mongoStates) Rename(ctx context.Context, userID string, username string) error {
+	_, err := r.c.UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{
+			"$set": bson.M{"username": username},
+			"$inc": bson.M{"stateVersion": 1},
+		},
+	)
+	return err
+}
+
+func (r mongoStates) Upgrade(ctx context.Context, userID string, username string) (*models.UserState, error) {
+	var state models.UserState
+	err := r.c.FindOneAndUpdate(ctx,
+		bson.M{"_id": userID},
+		bson.M{
+			"$set":   bson.M{"username": username},
+			"$unset": bson.M{"guest": ""},
+			"$inc":   bson.M{"stateVersion": 1},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&state)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &state, nil
+}
+
+func (r mongoStates) Exclude(ctx context.Context, userID string) (*models.UserState, error) {
+	var state models.UserState
+	err := r.c.FindOneAndUpdate(ctx,
+		bson.M{"_id": userID},
+		bson.M{
+			"$set": bson.M{"shadowExcluded": true},
+			"$inc": bson.M{"stateVersion": 1},
+		},
+	).Decode(&state)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &state, nil
+}
+
+func (r mongoStates) Include(ctx context.Context, userID string) (*models.UserState, error) {
+	var state models.UserState
+	err := r.c.FindOneAndUpdate(ctx,
+		bson.M{"_id": userID, "shadowExcluded": true},
+		bson.M{
+			"$unset": bson.M{"shadowExcluded": ""},
+			"$inc":   bson.M{"stateVersion": 1},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&state)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &state, nil
+}
+
+// resetBatch is how many states ResetSeason takes out of a season per write
+const resetBatch = 500
+
+// ResetSeason runs once the season is stopped, nobody joins it while it runs.
+// A reset state no longer matches the season, so every batch is simply the
+// first ones still in it and a failed run picks up where it stopped.
+func (r mongoStates) ResetSeason(ctx context.Context, seasonID string, fn func(userIDs []string) error) error {
+	for {
+		cursor, err := r.c.Find(ctx, bson.M{"seasonId": seasonID},
+			options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(resetBatch))
+		if err != nil {
+			return err
+		}
+		var docs []struct {
+			Id string `bson:"_id"`
+		}
+		if err := cursor.All(ctx, &docs); err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			return nil
+		}
+		userIDs := make([]string, len(docs))
+		for i, d := range docs {
+			userIDs[i] = d.Id
+		}
+
+		_, err = r.c.UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": userIDs}, "seasonId": seasonID},
+			bson.M{
+				"$set":   bson.M{"seasonScore": 0},
+				"$unset": bson.M{"seasonId": ""},
+				"$inc":   bson.M{"stateVersion": 1},
+			},
+		)
+		if err != nil {
+			return err
+		}
+		if err := fn(userIDs); err != nil {
+			return err
+		}
+	}
+}
+
+func (r mongoStates) Delete(ctx context.Context, userID string) error {
+	_, err := r.c.DeleteOne(ctx, bson.M{"_id": userID})
+	return err
+}
+
+// publicFilter matches the user-state documents listed on public boards
+func publicFilter() bson.M {
+	return bson.M{"guest": bson.M{"$ne": true}, "shadowExcluded": bson.M{"$ne": true}}
+}
+
+func (r mongoStates) Each(ctx context.Context, q StateQuery, fn func(models.UserState) error) error {
+	filter := bson.M{}
+	if q.Public {
+		filter = publicFilter()
+	}
+	if q.UserIDs != nil {
+		filter["_id"] = bson.M{"$in": q.UserIDs}
+	}
+	if q.SeasonID != "" {
+		filter["seasonId"] = q.SeasonID
+	}
+
+	cursor, err := r.c.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var state models.UserState
+		if err := cursor.Decode(&state); err != nil {
+			return err
+		}
+		if err := fn(state); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (r mongoStates) Board(b StateBoard) BoardQueries {
+	return mongoStateBoard{c: r.c, b: b}
+}
+
+func (r mongoStates) TeamTotals(ctx context.Context, fn func(models.TeamTotal) error) error {
+	return eachTeamTotal(ctx, r.members, bson.A{
+		bson.M{"$lookup": bson.M{
+			"from":         USER_STATE,
+			"localField":   "_id",
+			"foreignField": "_id",
+			"as":           "state",
+		}},
+		bson.M{"$unwind": "$state"},
+		bson.M{"$match": bson.M{"state.shadowExcluded": bson.M{"$ne": true}}},
+		bson.M{"$group": bson.M{
+			"_id":      "$teamId",
+			"score":    bson.M{"$sum": "$state.totalScore"},
+			"answered": bson.M{"$sum": "$state.totalAnswered"},
+			"correct":  bson.M{"$sum": "$state.totalCorrect"},
+		}},
+	}, fn)
+}
+
+// eachTeamTotal runs a pipeline that ends in team totals and hands them to fn
+func eachTeamTotal(ctx context.Context, c Collection, pipeline bson.A, fn func(models.TeamTotal) error) error {
+	cursor, err := c.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var total models.TeamTotal
+		if err := cursor.Decode(&total); err != nil {
+			return err
+		}
+		if err := fn(total); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// StateValue is the value of a user-state field a StateBoard sorts by, false
+// when the state doesnt have it
+func StateValue(state models.UserState, field string) (float64, bool) {
+	if topic, ok := strings.CutPrefix(field, "topicScores."); ok {
+		score, ok := state.TopicScores[topic]
+		return score, ok
+	}
+	switch field {
+	case "maxStreak":
+		return float64(state.MaxStreak), true
+	case "accuracy":
+		return state.Accuracy, true
+	case "maxDifficulty":
+		return float64(state.MaxDifficulty), true
+	case "seasonScore":
+		return state.SeasonScore, true
+	default:
+		return state.TotalScore, true
+	}
+}
+
+// sortedBefore matches the documents listed ahead of a user with value
+// reached at at: value, then the earliest to reach it (no time first), then
+// user id descending
+func sortedBefore(field string, timeField string, value float64, at time.Time, userID string) bson.M {
+	tied := bson.M{field: value, timeField: nil, "_id": bson.M{"$gt": userID}}
+	if !at.IsZero() {
+		tied = bson.M{field: value, "$or": bson.A{
+			bson.M{timeField: nil},
+			bson.M{timeField: bson.M{"$lt": at}},
+			bson.M{timeField: at, "_id": bson.M{"$gt": userID}},
+		}}
+	}
+	return bson.M{"$or": bson.A{bson.M{field: bson.M{"$gt": value}}, tied}}
+}
+
+type mongoStateBoard struct {
+	c Collection
+	b StateBoard
+}
+
+func (q mongoStateBoard) filter() bson.M {
+	filter := publicFilter()
+	if q.b.MinAnswered > 0 {
+		filter["totalAnswered"] = bson.M{"$gte": q.b.MinAnswered}
+	}
+	if strings.HasPrefix(q.b.Field, "topicScores.") {
+		filter[q.b.Field] = bson.M{"$exists": true}
+	}
+	if q.b.SeasonID != "" {
+		filter["seasonId"] = q.b.SeasonID
+	}
+	return filter
+}
+
+func (q mongoStateBoard) timeField() string {
+	return "achievedAt." + q.b.Name
+}
+
+func (q mongoStateBoard) find(ctx context.Context, filter bson.M, opts *options.FindOptionsBuilder) ([]BoardRow, error) {
+	cursor, err := q.c.Find(ctx, filter, opts.SetSort(bson.D{
+		{Key: q.b.Field, Value: -1},
+		{Key: q.timeField(), Value: 1},
+		{Key: "_id", Value: -1},
+	}))
+	if err != nil {
+		return nil, err
+	}
+	var states []models.UserState
+	if err := cursor.All(ctx, &states); err != nil {
+		return nil, err
+	}
+
+	rows := make([]BoardRow, 0, len(states))
+	for _, st := range states {
+		value, _ := StateValue(st, q.b.Field)
+		rows = append(rows, BoardRow{UserID: st.UserID, Username: st.Username, Value: value, At: st.AchievedAt[q.b.Name]})
+	}
+	return rows, nil
+}
+
+func (q mongoStateBoard) Page(ctx context.Context, offset int, limit int) ([]BoardRow, error) {
+	return q.find(ctx, q.filter(), options.Find().SetSkip(int64(offset)).SetLimit(int64(limit)))
+}
+
+func (q mongoStateBoard) Members(ctx context.Context, userIDs []string) ([]BoardRow, error) {
+	filter := q.filter()
+	filter["_id"] = bson.M{"$in": userIDs}
+	return q.find(ctx, filter, options.Find())
+}
+
+func (q mongoStateBoard) Above(ctx context.Context, value float64, distinct bool) (int, error) {
+	filter := q.filter()
+	filter[q.b.Field] = bson.M{"$gt": value}
+	if distinct {
+		var above []float64
+		if err := q.c.Distinct(ctx, q.b.Field, filter).Decode(&above); err != nil {
+			return 0, err
+		}
+		return len(above), nil
+	}
+	n, err := q.c.CountDocuments(ctx, filter)
+	return int(n), err
+}
+
+func (q mongoStateBoard) Before(ctx context.Context, value float64, at time.Time, userID string) (int, error) {
+	filter := q.filter()
+	for k, cond := range sortedBefore(q.b.Field, q.timeField(), value, at, userID) {
+		filter[k] = cond
+	}
+	n, err := q.c.CountDocuments(ctx, filter)
+	return int(n), err
+}
+
+type mongoQuestions struct{ c Collection }
+
+func (r mongoQuestions) Insert(ctx context.Context, questions ...models.Question) error {
+	docs := make([]any, 0, len(questions))
+	for _, q := range questions {
+		docs = append(docs, q)
+	}
+	_, err := r.c.InsertMany(ctx, docs)
+	return duplicate(err)
+}
+
+func (r mongoQuestions) Get(ctx context.Context, questionID string) (*models.Question, error) {
+	var q models.Question
+	if err := r.c.FindOne(ctx, bson.M{"_id": questionID}).Decode(&q); err != nil {
+		return nil, notFound(err)
+	}
+	return &q, nil
+}
+
+func (r mongoQuestions) AtDifficulty(ctx context.Context, difficulty int) ([]models.Question, error) {
+	cursor, err := r.c.Find(ctx, bson.M{"difficulty": difficulty})
+	if err != nil {
+		return nil, err
+	}
+	questions := []models.Question{}
+	if err := cursor.All(ctx, &questions); err != nil {
+		return nil, err
+	}
+	return questions, nil
+}
+
+func (r mongoQuestions) Topics(ctx context.Context) ([]string, error) {
+	var topics []string
+	if err := r.c.Distinct(ctx, "topic", bson.M{"topic": bson.M{"$type": "string"}}).Decode(&topics); err != nil {
+		return nil, err
+	}
+	return topics, nil
+}
+
+type mongoAnswers struct{ c Collection }
+
+func (r mongoAnswers) Insert(ctx context.Context, entry models.AnswerLog) error {
+	_, err := r.c.InsertOne(ctx, entry)
+	return duplicate(err)
+}
+
+func (r mongoAnswers) Get(ctx context.Context, userID string, ikey string) (*models.AnswerLog, error) {
+	var entry models.AnswerLog
+	if err := r.c.FindOne(ctx, bson.M{"userId": userID, "ikey": ikey}).Decode(&entry); err != nil {
+		return nil, notFound(err)
+	}
+	return &entry, nil
+}
+
+func (r mongoAnswers) ForUser(ctx context.Context, userID string) ([]models.AnswerLog, error) {
+	cursor, err := r.c.Find(ctx, bson.M{"userId": userID},
+		options.Find().SetSort(bson.M{"answeredAt": 1}))
+	if err != nil {
+		return nil, err
+	}
+	answers := []models.AnswerLog{}
+	if err := cursor.All(ctx, &answers); err != nil {
+		return nil, err
+	}
+	return answers, nil
+}
+
+func (r mongoAnswers) EachUser(ctx context.Context, q AnswerQuery, fn func([]models.AnswerLog) error) error {
+	filter := bson.M{}
+	if !q.Since.IsZero() {
+		filter["answeredAt"] = bson.M{"$gte": q.Since}
+	}
+	if q.ExperimentID != "" {
+		filter["experimentId"] = q.ExperimentID
+	}
+	cursor, err := r.c.Find(ctx, filter,
+		options.Find().
+			SetSort(bson.D{{Key: "userId", Value: 1}, {Key: "answeredAt", Value: 1}}).
+			SetProjection(bson.M{"response": 0}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var answers []models.AnswerLog
+	for cursor.Next(ctx) {
+		var answer models.AnswerLog
+		if err := cursor.Decode(&answer); err != nil {
+			return err
+		}
+		if len(answers) > 0 && answers[0].UserID != answer.UserID {
+			if err := fn(answers); err != nil {
+				return err
+			}
+			answers = nil
+		}
+		answers = append(answers, answer)
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if len(answers) == 0 {
+		return nil
+	}
+	return fn(answers)
+}
+
+func (r mongoAnswers) Anonymize(ctx context.Context, userID string, anon string) (int64, error) {
+	res, err := r.c.UpdateMany(ctx,
+		bson.M{"userId": userID},
+		bson.M{"$set": bson.M{"userId": anon, "username": anon}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// periodSums sums answer-logs from start on into one PeriodTotal per user id
+func periodSums(start time.Time) bson.A {
+	return bson.A{
+		bson.M{"$match": bson.M{"answeredAt": bson.M{"$gte": start}}},
+		// best streak first, so $first below is the answer that first reached it
+		bson.M{"$sort": bson.D{{Key: "streak", Value: -1}, {Key: "answeredAt", Value: 1}}},
+		bson.M{"$group": bson.M{
+			"_id":      "$userId",
+			"score":    bson.M{"$sum": "$score"},
+			"streak":   bson.M{"$first": "$streak"},
+			"answered": bson.M{"$sum": 1},
+			"correct":  bson.M{"$sum": bson.M{"$cond": bson.A{"$correct", 1, 0}}},
+			// the score last moved on the last answer that scored, $max skips the nulls
+			"scoreAt": bson.M{"$max": bson.M{"$cond": bson.A{
+				bson.M{"$ne": bson.A{"$score", 0}}, "$answeredAt", nil,
+			}}},
+			"streakAt": bson.M{"$first": "$answeredAt"},
+		}},
+	}
+}
+
+// publicTotals drops deleted users, guests and excluded users from period
+// sums and adds the usernames
+func publicTotals() bson.A {
+	return bson.A{
+		bson.M{"$lookup": bson.M{
+			"from":         USER_STATE,
+			"localField":   "_id",
+			"foreignField": "_id",
+			"as":           "state",
+		}},
+		bson.M{"$match": bson.M{
+			"state.0":              bson.M{"$exists": true},
+			"state.guest":          bson.M{"$ne": true},
+			"state.shadowExcluded": bson.M{"$ne": true},
+		}},
+		bson.M{"$project": bson.M{
+			"score":    1,
+			"scoreAt":  1,
+			"streak":   1,
+			"streakAt": 1,
+			"answered": 1,
+			"correct":  1,
+			"username": bson.M{"$arrayElemAt": bson.A{"$state.username", 0}},
+		}},
+	}
+}
+
+func (r mongoAnswers) PeriodTotals(ctx context.Context, q PeriodQuery, fn func(models.PeriodTotal) error) error {
+	pipeline := bson.A{}
+	if q.UserIDs != nil {
+		pipeline = append(pipeline, bson.M{"$match": bson.M{"userId": bson.M{"$in": q.UserIDs}}})
+	}
+	pipeline = append(pipeline, periodSums(q.Start)...)
+	if q.Public {
+		pipeline = append(pipeline, publicTotals()...)
+	}
+
+	cursor, err := r.c.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var total models.PeriodTotal
+		if err := cursor.Decode(&total); err != nil {
+			return err
+		}
+		if err := fn(total); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (r mongoAnswers) TeamTotals(ctx context.Context, start time.Time, fn func(models.TeamTotal) error) error {
+	pipeline := append(periodSums(start),
+		bson.M{"$lookup": bson.M{
+			"from":         TEAM_MEMBERS,
+			"localField":   "_id",
+			"foreignField": "_id",
+			"as":           "member",
+		}},
+		bson.M{"$unwind": "$member"},
+		bson.M{"$lookup": bson.M{
+			"from":         USER_STATE,
+			"localField":   "_id",
+			"foreignField": "_id",
+			"as":           "state",
+		}},
+		bson.M{"$match": bson.M{"state.shadowExcluded": bson.M{"$ne": true}}},
+		bson.M{"$group": bson.M{
+			"_id":      "$member.teamId",
+			"score":    bson.M{"$sum": "$score"},
+			"answered": bson.M{"$sum": "$answered"},
+			"correct":  bson.M{"$sum": "$correct"},
+		}},
+	)
+	return eachTeamTotal(ctx, r.c, pipeline, fn)
+}
+
+func (r mongoAnswers) PeriodBoard(start time.Time, streak bool) BoardQueries {
+	field := "score"
+	if streak {
+		field = "streak"
+	}
+	return mongoPeriodBoard{c: r.c, start: start, field: field}
+}
+
+// mongoPeriodBoard sorts the public period totals by score or streak
+type mongoPeriodBoard struct {
+	c     Collection
+	start time.Time
+	field string
+}
+
+func (q mongoPeriodBoard) aggregate(ctx context.Context, stages ...bson.M) (*mongo.Cursor, error) {
+	pipeline := append(periodSums(q.start), publicTotals()...)
+	for _, stage := range stages {
+		pipeline = append(pipeline, stage)
+	}
+	return q.c.Aggregate(ctx, pipeline)
+}
+
+func (q mongoPeriodBoard) sort() bson.M {
+	return bson.M{"$sort": bson.D{
+		{Key: q.field, Value: -1},
+		{Key: q.field + "At", Value: 1},
+		{Key: "_id", Value: -1},
+	}}
+}
+
+func (q mongoPeriodBoard) rows(ctx context.Context, stages ...bson.M) ([]BoardRow, error) {
+	cursor, err := q.aggregate(ctx, stages...)
+	if err != nil {
+		return nil, err
+	}
+	var totals []models.PeriodTotal
+	if err := cursor.All(ctx, &totals); err != nil {
+		return nil, err
+	}
+
+	rows := make([]BoardRow, 0, len(totals))
+	for _, t := range totals {
+		rows = append(rows, PeriodRow(t, q.field == "streak"))
+	}
+	return rows, nil
+}
+
+// PeriodRow is a period total as it is listed on the score or streak board
+func PeriodRow(t models.PeriodTotal, streak bool) BoardRow {
+	if streak {
+		return BoardRow{UserID: t.UserID, Username: t.Username, Value: float64(t.Streak), At: t.StreakAt}
+	}
+	return BoardRow{UserID: t.UserID, Username: t.Username, Value: t.Score, At: t.ScoreAt}
+}
+
+func (q mongoPeriodBoard) Page(ctx context.Context, offset int, limit int) ([]BoardRow, error) {
+	return q.rows(ctx, q.sort(), bson.M{"$skip": offset}, bson.M{"$limit": limit})
+}
+
+func (q mongoPeriodBoard) Members(ctx context.Context, userIDs []string) ([]BoardRow, error) {
+	return q.rows(ctx, bson.M{"$match": bson.M{"_id": bson.M{"$in": userIDs}}}, q.sort())
+}
+
+func (q mongoPeriodBoard) count(ctx context.Context, stages ...bson.M) (int, error) {
+	cursor, err := q.aggregate(ctx, append(stages, bson.M{"$count": "n"})...)
+	if err != nil {
+		return 0, err
+	}
+	var res []struct {
+		N int `bson:"n"`
+	}
+	if err := cursor.All(ctx, &res); err != nil {
+		return 0, err
+	}
+	if len(res) == 0 {
+		return 0, nil
+	}
+	return res[0].N, nil
+}
+
+func (q mongoPeriodBoard) Above(ctx context.Context, value float64, distinct bool) (int, error) {
+	stages := []bson.M{{"$match": bson.M{q.field: bson.M{"$gt": value}}}}
+	if distinct {
+		stages = append(stages, bson.M{"$group": bson.M{"_id": "$" + q.field}})
+	}
+	return q.count(ctx, stages...)
+}
+
+func (q mongoPeriodBoard) Before(ctx context.Context, value float64, at time.Time, userID string) (int, error) {
+	return q.count(ctx, bson.M{"$match": sortedBefore(q.field, q.field+"At", value, at, userID)})
+}
@@ -0,0 +1,459 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type redisBoards struct {
+	ring *redis.Ring
+}
+
+// NewRedisBoards keeps the boards in sorted sets on the ring
+func NewRedisBoards(ring *redis.Ring) Boards {
+	return &redisBoards{ring: ring}
+}
+
+// A board is kept in four keys, callers put a hashtag in the key so they
+// stay on one shard with the rebuild copies:
+//
+//	key          sorted set, member is tieKey(achieved at) + ":" + user id
+//	key:members  hash of user id to their current member
+//	key:values   sorted set of the distinct values on the board (for dense ranks)
+//	key:counts   hash of value to how many users hold it
+//
+// Redis orders equal scores by member in reverse on ZREVRANGE, so the inverted
+// time in front lists the earliest achiever first.
+func boardKeys(key string) []string {
+	return []string{key, key + ":members", key + ":values", key + ":counts"}
+}
+
+const tieMax = 9999999999999 // 13 digits of unix ms, good until the year 2286
+
+// tieKey sorts later times lower, no time at all counts as the earliest
+func tieKey(at time.Time) string {
+	return fmt.Sprintf("%013d", tieMax-TieMs(at))
+}
+
+// TieMs is the time ties are broken on, in unix ms with no time at all as 0
+func TieMs(at time.Time) int64 {
+	if at.IsZero() {
+		return 0
+	}
+	return max(at.UnixMilli(), 0)
+}
+
+func boardMember(userID string, at time.Time) string {
+	return tieKey(at) + ":" + userID
+}
+
+// memberUser takes the user id back out of a board member
+func memberUser(member string) string {
+	if i := strings.IndexByte(member, ':'); i >= 0 {
+		return member[i+1:]
+	}
+	return member
+}
+
+// valueKey is how a value is written in key:values and key:counts,
+// the same as the %.17g the scripts use
+func valueKey(value float64) string {
+	return strconv.FormatFloat(value, 'g', 17, 64)
+}
+
+// the value bookkeeping shared by the scripts
+const valueHelpers = `
+local function hold(board, v)
+	local k = string.format('%.17g', tonumber(v))
+	if redis.call('HINCRBY', board .. ':counts', k, 1) == 1 then
+		redis.call('ZADD', board .. ':values', v, k)
+	end
+end
+
+local function release(board, v)
+	local k = string.format('%.17g', tonumber(v))
+	if redis.call('HINCRBY', board .. ':counts', k, -1) <= 0 then
+		redis.call('HDEL', board .. ':counts', k)
+		redis.call('ZREM', board .. ':values', k)
+	end
+end
+`
+
+// moves a user to a new value. ARGV: user, value, tie key, mode, expire at (0 for never).
+// Scores go to redis as strings so they keep full precision.
+var setOnBoard = redis.NewScript(valueHelpers + `
+local board, members = KEYS[1], KEYS[2]
+local user, value, mode = ARGV[1], ARGV[2], ARGV[4]
+
+local member = redis.call('HGET', members, user)
+local old = false
+if member then
+	old = redis.call('ZSCORE', board, member)
+end
+
+local changed = true
+if old then
+	if mode == 'incr' then
+		if tonumber(value) == 0 then
+			changed = false
+		else
+			value = redis.call('ZINCRBY', board, value, member)
+		end
+	elseif mode == 'gt' then
+		changed = tonumber(value) > tonumber(old)
+	else
+		changed = tonumber(value) ~= tonumber(old)
+	end
+end
+
+if changed then
+	if old then
+		redis.call('ZREM', board, member)
+		release(board, old)
+	end
+	local entry = ARGV[3] .. ':' .. user
+	redis.call('ZADD', board, value, entry)
+	redis.call('HSET', members, user, entry)
+	hold(board, value)
+end
+
+if ARGV[5] ~= '0' then
+	for _, key in ipairs(KEYS) do
+		redis.call('EXPIREAT', key, ARGV[5])
+	end
+end
+return changed and 1 or 0
+`)
+
+// takes a user off a board. ARGV: user
+var removeFromBoard = redis.NewScript(valueHelpers + `
+local board, members = KEYS[1], KEYS[2]
+local member = redis.call('HGET', members, ARGV[1])
+if not member then
+	return 0
+end
+
+local old = redis.call('ZSCORE', board, member)
+redis.call('ZREM', board, member)
+redis.call('HDEL', members, ARGV[1])
+if old then
+	release(board, old)
+end
+return 1
+`)
+
+// returns {0 based position, value} of a user, nil if they arent on the board.
+// The value goes back as a string, number replies would be cut to integers.
+var lookupOnBoard = redis.NewScript(`
+local member = redis.call('HGET', KEYS[2], ARGV[1])
+if not member then
+	return false
+end
+local pos = redis.call('ZREVRANK', KEYS[1], member)
+if not pos then
+	return false
+end
+return {pos, redis.call('ZSCORE', KEYS[1], member)}
+`)
+
+func (b *redisBoards) Update(ctx context.Context, updates ...BoardUpdate) error {
+	_, err := b.ring.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, u := range updates {
+			expireAt := int64(0)
+			if !u.ExpireAt.IsZero() {
+				expireAt = u.ExpireAt.Unix()
+			}
+			// Eval not Run, a pipeline cant fall back to EVAL after a NOSCRIPT
+			setOnBoard.Eval(ctx, pipe, boardKeys(u.Key),
+				u.UserID, strconv.FormatFloat(u.Value, 'f', -1, 64), tieKey(u.At), u.Mode, expireAt)
+		}
+		return nil
+	})
+	return err
+}
+
+func (b *redisBoards) Remove(ctx context.Context, userID string, keys ...string) error {
+	_, err := b.ring.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			removeFromBoard.Eval(ctx, pipe, boardKeys(key), userID)
+		}
+		return nil
+	})
+	return err
+}
+
+func (b *redisBoards) Range(ctx context.Context, key string, offset int, limit int) ([]BoardEntry, error) {
+	page, err := b.ring.ZRevRangeWithScores(ctx, key, int64(offset), int64(offset+limit-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]BoardEntry, 0, len(page))
+	for i, z := range page {
+		entries = append(entries, BoardEntry{UserID: memberUser(z.Member.(string)), Value: z.Score, Position: offset + i})
+	}
+	return entries, nil
+}
+
+func (b *redisBoards) Lookup(ctx context.Context, key string, userIDs ...string) ([]BoardEntry, error) {
+	cmds := make([]*redis.Cmd, len(userIDs))
+	_, err := b.ring.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range userIDs {
+			cmds[i] = lookupOnBoard.Eval(ctx, pipe, boardKeys(key), id)
+		}
+		return nil
+	})
+	// users that arent on the board come back as redis.Nil
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	entries := make([]BoardEntry, 0, len(userIDs))
+	for i, cmd := range cmds {
+		res, err := cmd.Slice()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		pos, _ := res[0].(int64)
+		raw, _ := res[1].(string)
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, BoardEntry{UserID: userIDs[i], Value: value, Position: int(pos)})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Position < entries[j].Position })
+	return entries, nil
+}
+
+func (b *redisBoards) Above(ctx context.Context, key string, value float64, distinct bool) (int, error) {
+	counted := key
+	if distinct {
+		// one entry per distinct value
+		counted = key + ":values"
+	}
+	n, err := b.ring.ZCount(ctx, counted, "("+strconv.FormatFloat(value, 'f', -1, 64), "+inf").Result()
+	return int(n), err
+}
+
+func (b *redisBoards) Size(ctx context.Context, key string) (int, error) {
+	n, err := b.ring.ZCard(ctx, key).Result()
+	return int(n), err
+}
+
+func (b *redisBoards) Delete(ctx context.Context, keys ...string) error {
+	_, err := b.ring.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			for _, k := range boardKeys(key) {
+				pipe.Del(ctx, k)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// redisRebuild fills the :rebuild copies of some boards in batches and renames
+// them over the live keys at the end, so readers never see a board half built
+type redisRebuild struct {
+	ring    *redis.Ring
+	keys    []string
+	pending map[string][]BoardUpdate
+	size    int
+}
+
+const rebuildBatchSize = 1000
+
+func (b *redisBoards) Rebuild(ctx context.Context, keys ...string) (BoardRebuild, error) {
+	for _, key := range keys {
+		for _, k := range boardKeys(key) {
+			if err := b.ring.Del(ctx, k+":rebuild").Err(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &redisRebuild{ring: b.ring, keys: keys, pending: map[string][]BoardUpdate{}}, nil
+}
+
+func (w *redisRebuild) Add(ctx context.Context, key string, userID string, value float64, at time.Time) error {
+	w.pending[key] = append(w.pending[key], BoardUpdate{UserID: userID, Value: value, At: at})
+	if w.size++; w.size >= rebuildBatchSize {
+		return w.flush(ctx)
+	}
+	return nil
+}
+
+func (w *redisRebuild) flush(ctx context.Context) error {
+	_, err := w.ring.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, updates := range w.pending {
+			if len(updates) == 0 {
+				continue
+			}
+			keys := boardKeys(key)
+
+			members := make([]redis.Z, 0, len(updates))
+			owners := make([]any, 0, 2*len(updates))
+			counts := map[string]int64{}
+			values := map[string]float64{}
+			for _, u := range updates {
+				member := boardMember(u.UserID, u.At)
+				members = append(members, redis.Z{Score: u.Value, Member: member})
+				owners = append(owners, u.UserID, member)
+				counts[valueKey(u.Value)]++
+				values[valueKey(u.Value)] = u.Value
+			}
+
+			pipe.ZAdd(ctx, keys[0]+":rebuild", members...)
+			pipe.HSet(ctx, keys[1]+":rebuild", owners...)
+			for k, value := range values {
+				pipe.ZAdd(ctx, keys[2]+":rebuild", redis.Z{Score: value, Member: k})
+				pipe.HIncrBy(ctx, keys[3]+":rebuild", k, counts[k])
+			}
+		}
+		return nil
+	})
+	w.pending, w.size = map[string][]BoardUpdate{}, 0
+	return err
+}
+
+func (w *redisRebuild) Finish(ctx context.Context, expireAt time.Time) error {
+	if err := w.flush(ctx); err != nil {
+		return err
+	}
+
+	for _, key := range w.keys {
+		for _, k := range boardKeys(key) {
+			exists, err := w.ring.Exists(ctx, k+":rebuild").Result()
+			if err != nil {
+				return err
+			}
+			if exists == 0 {
+				// nothing to rename, an empty board is just a missing key
+				if err := w.ring.Del(ctx, k).Err(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := w.ring.Rename(ctx, k+":rebuild", k).Err(); err != nil {
+				return err
+			}
+			if !expireAt.IsZero() {
+				if err := w.ring.ExpireAt(ctx, k, expireAt).Err(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+type redisCache struct {
+	ring *redis.Ring
+}
+
+// NewRedisCache keeps the shared keys on the ring
+func NewRedisCache(ring *redis.Ring) Cache {
+	return &redisCache{ring: ring}
+}
+
+func (c *redisCache) Get(ctx context.Context, key string) ([]byte, error) {
+	b, err := c.ring.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrCacheMiss
+	}
+	return b, err
+}
+
+func (c *redisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.ring.Set(ctx, key, value, ttl).Err()
+}
+
+func (c *redisCache) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return c.ring.SetNX(ctx, key, value, ttl).Result()
+}
+
+func (c *redisCache) Del(ctx context.Context, keys ...string) error {
+	// keys can be on different shards, a multi key DEL would go to one of them
+	_, err := c.ring.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, key)
+		}
+		return nil
+	})
+	return err
+}
+
+// sliding window log: one sorted set entry per hit, scored by its time in ms.
+// returns {allowed, retry after ms}
+var slidingWindow = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', key, 0, now - window)
+if redis.call('ZCARD', key) < limit then
+	redis.call('ZADD', key, now, ARGV[4])
+	redis.call('PEXPIRE', key, window)
+	return {1, 0}
+end
+
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+return {0, tonumber(oldest[2]) + window - now}
+`)
+
+func (c *redisCache) Allow(ctx context.Context, key string, id string, limit int, window time.Duration) (bool, time.Duration, error) {
+	res, err := slidingWindow.Run(ctx, c.ring, []string{key},
+		time.Now().UnixMilli(), window.Milliseconds(), limit, id,
+	).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond, nil
+}
+
+func (c *redisCache) Release(ctx context.Context, key string, id string) error {
+	return c.ring.ZRem(ctx, key, id).Err()
+}
+
+func (c *redisCache) Publish(ctx context.Context, channel string, payload []byte) error {
+	return c.ring.Publish(ctx, channel, payload).Err()
+}
+
+// Subscribe holds one pubsub connection, it reconnects by itself if redis drops
+func (c *redisCache) Subscribe(ctx context.Context, channel string) <-chan []byte {
+	pubsub := c.ring.Subscribe(ctx, channel)
+	out := make(chan []byte)
+	go func() {
+		defer close(out)
+		defer pubsub.Close()
+
+		ch := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				select {
+				case out <- []byte(msg.Payload):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
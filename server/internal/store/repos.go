@@ -0,0 +1,168 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"server/internal/models"
+	"time"
+)
+
+var (
+	// ErrNotFound is a missing document, or one a guarded write didnt match
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicate is a write a unique key turned down
+	ErrDuplicate = errors.New("duplicate")
+)
+
+// the collections behind the repos, migrations still go at them directly
+const (
+	USERS       = "Users"
+	USER_STATE  = "user-state"
+	QUESTIONS   = "questions"
+	ANSWER_LOGS = "answer-logs"
+
+	// TEAM_MEMBERS is owned by the teams package, the team totals read it
+	TEAM_MEMBERS = "team-members"
+)
+
+// Repos are the stores of the core documents. Every method does one thing the
+// app needs, so each backend can answer it its own way.
+type Repos struct {
+	Users     UserRepo
+	States    StateRepo
+	Questions QuestionRepo
+	Answers   AnswerLogRepo
+}
+
+type UserRepo interface {
+	// Insert gives ErrDuplicate when the id or username is taken
+	Insert(ctx context.Context, user models.User) error
+	Get(ctx context.Context, userID string) (*models.User, error)
+	ByUsername(ctx context.Context, username string) (*models.User, error)
+	// Names maps the ids that exist to their current usernames
+	Names(ctx context.Context, userIDs []string) (map[string]string, error)
+	Rename(ctx context.Context, userID string, username string) error
+	// UpgradeGuest renames a guest and makes them a regular user, ErrNotFound
+	// when the user isnt a guest (anymore)
+	UpgradeGuest(ctx context.Context, userID string, username string) error
+	SetRole(ctx context.Context, userID string, role string) error
+	// AwardBadge adds the badge unless the user has one for its season already
+	AwardBadge(ctx context.Context, userID string, badge models.Badge) error
+	Delete(ctx context.Context, userID string) error
+}
+
+type StateRepo interface {
+	Insert(ctx context.Context, state models.UserState) error
+	Get(ctx context.Context, userID string) (*models.UserState, error)
+	// GetMany returns the states of the ids that exist, in no order
+	GetMany(ctx context.Context, userIDs []string) ([]models.UserState, error)
+	// Save replaces the state if it is still at expectedVersion, ErrNotFound
+	// when it moved on (or is gone)
+	Save(ctx context.Context, state models.UserState, expectedVersion int) error
+
+	// the writes below bump the state version, so a stale cached copy fails
+	// its next Save instead of undoing them
+
+	Rename(ctx context.Context, userID string, username string) error
+	// Upgrade renames a guest state and makes it public, returns the new state
+	Upgrade(ctx context.Context, userID string, username string) (*models.UserState, error)
+	// Exclude shadow excludes the user, returns the state before
+	Exclude(ctx context.Context, userID string) (*models.UserState, error)
+	// Include lifts an exclusion, returns the new state and ErrNotFound when
+	// the user wasnt excluded
+	Include(ctx context.Context, userID string) (*models.UserState, error)
+	// ResetSeason zeroes the season score of everyone still in the season and
+	// hands fn who that was, a batch at a time. The version bump makes a stale
+	// cached copy fail its next write instead of putting the old season back.
+	ResetSeason(ctx context.Context, seasonID string, fn func(userIDs []string) error) error
+
+	Delete(ctx context.Context, userID string) error
+	// Each hands fn the states q matches, in user id order
+	Each(ctx context.Context, q StateQuery, fn func(models.UserState) error) error
+	// Board answers the reads of a board kept on user-state
+	Board(b StateBoard) BoardQueries
+	// TeamTotals sums the states of the members on team-members per team,
+	// shadow excluded members left out, in no order
+	TeamTotals(ctx context.Context, fn func(models.TeamTotal) error) error
+}
+
+// StateQuery picks states, the zero value is all of them
+type StateQuery struct {
+	UserIDs  []string // only these
+	Public   bool     // only users listed on public boards
+	SeasonID string   // only users in this season
+}
+
+// StateBoard is a board sorted by one user-state field
+type StateBoard struct {
+	Name        string // key into achievedAt for ties
+	Field       string // totalScore, maxStreak, accuracy, maxDifficulty, seasonScore or topicScores.<topic>
+	MinAnswered float64
+	SeasonID    string // for seasonScore, only users in the season
+}
+
+// BoardQueries read a board from the store, in the order store.Boards keeps.
+// Only public users are listed.
+type BoardQueries interface {
+	// Page returns up to limit users from the 0 based position offset
+	Page(ctx context.Context, offset int, limit int) ([]BoardRow, error)
+	// Members returns which of the users are listed, in board order
+	Members(ctx context.Context, userIDs []string) ([]BoardRow, error)
+	// Above counts the users with a higher value, or with distinct set the
+	// distinct values higher than value
+	Above(ctx context.Context, value float64, distinct bool) (int, error)
+	// Before counts the users listed ahead of userID holding value since at
+	Before(ctx context.Context, value float64, at time.Time, userID string) (int, error)
+}
+
+// BoardRow is one listed user, At is when they reached Value
+type BoardRow struct {
+	UserID   string
+	Username string
+	Value    float64
+	At       time.Time
+}
+
+type QuestionRepo interface {
+	// Insert stops at the first question whose id is taken, with ErrDuplicate
+	Insert(ctx context.Context, questions ...models.Question) error
+	Get(ctx context.Context, questionID string) (*models.Question, error)
+	AtDifficulty(ctx context.Context, difficulty int) ([]models.Question, error)
+	// Topics is every distinct topic, in no order
+	Topics(ctx context.Context) ([]string, error)
+}
+
+type AnswerLogRepo interface {
+	// Insert gives ErrDuplicate when the user already has an answer with the
+	// same idempotency key
+	Insert(ctx context.Context, entry models.AnswerLog) error
+	Get(ctx context.Context, userID string, ikey string) (*models.AnswerLog, error)
+	// ForUser is every answer of the user, oldest first
+	ForUser(ctx context.Context, userID string) ([]models.AnswerLog, error)
+	// EachUser hands fn the answers q matches one user at a time, oldest first.
+	// The stored responses are left out.
+	EachUser(ctx context.Context, q AnswerQuery, fn func([]models.AnswerLog) error) error
+	// Anonymize moves the users answers to anon, returns how many it moved
+	Anonymize(ctx context.Context, userID string, anon string) (int64, error)
+	// PeriodTotals sums the answers from q.Start on per user, in no order
+	PeriodTotals(ctx context.Context, q PeriodQuery, fn func(models.PeriodTotal) error) error
+	// TeamTotals sums the answers from start on of the members on team-members
+	// per team, shadow excluded members left out, in no order
+	TeamTotals(ctx context.Context, start time.Time, fn func(models.TeamTotal) error) error
+	// PeriodBoard answers the reads of the score (or streak) board of the
+	// period starting at start
+	PeriodBoard(start time.Time, streak bool) BoardQueries
+}
+
+// AnswerQuery picks answers, the zero value is all of them
+type AnswerQuery struct {
+	Since        time.Time
+	ExperimentID string
+}
+
+// PeriodQuery picks the users PeriodTotals sums
+type PeriodQuery struct {
+	Start   time.Time
+	UserIDs []string // only these, nil for everyone
+	Public  bool     // only users listed on public boards, with their usernames
+}
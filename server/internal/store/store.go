@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Collection is the part of a mongo collection the app uses. The mongo backend
+// hands every call to the driver, memstore answers them from memory, so the
+// queries are written once against this and run on either.
+type Collection interface {
+	Find(ctx context.Context, filter any, opts ...options.Lister[options.FindOptions]) (*mongo.Cursor, error)
+	FindOne(ctx context.Context, filter any, opts ...options.Lister[options.FindOneOptions]) *mongo.SingleResult
+	FindOneAndUpdate(ctx context.Context, filter any, update any, opts ...options.Lister[options.FindOneAndUpdateOptions]) *mongo.SingleResult
+	InsertOne(ctx context.Context, document any, opts ...options.Lister[options.InsertOneOptions]) (*mongo.InsertOneResult, error)
+	InsertMany(ctx context.Context, documents any, opts ...options.Lister[options.InsertManyOptions]) (*mongo.InsertManyResult, error)
+	UpdateOne(ctx context.Context, filter any, update any, opts ...options.Lister[options.UpdateOneOptions]) (*mongo.UpdateResult, error)
+	UpdateMany(ctx context.Context, filter any, update any, opts ...options.Lister[options.UpdateManyOptions]) (*mongo.UpdateResult, error)
+	ReplaceOne(ctx context.Context, filter any, replacement any, opts ...options.Lister[options.ReplaceOptions]) (*mongo.UpdateResult, error)
+	DeleteOne(ctx context.Context, filter any, opts ...options.Lister[options.DeleteOneOptions]) (*mongo.DeleteResult, error)
+	DeleteMany(ctx context.Context, filter any, opts ...options.Lister[options.DeleteManyOptions]) (*mongo.DeleteResult, error)
+	CountDocuments(ctx context.Context, filter any, opts ...options.Lister[options.CountOptions]) (int64, error)
+	Distinct(ctx context.Context, field string, filter any) *DistinctResult
+	Aggregate(ctx context.Context, pipeline any, opts ...options.Lister[options.AggregateOptions]) (*mongo.Cursor, error)
+	BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...options.Lister[options.BulkWriteOptions]) (*mongo.BulkWriteResult, error)
+
+	// CreateIndex only has to keep unique indexes working, anything else is a
+	// speed up the memory backend can ignore
+	CreateIndex(ctx context.Context, model mongo.IndexModel) error
+	// DropIndex drops an index by name if it exists
+	DropIndex(ctx context.Context, name string) error
+}
+
+// DB hands out the repos, and collections by name for everything else
+type DB interface {
+	Repos() Repos
+	Collection(name string) Collection
+	// Transaction runs fn so its writes all land or none do, fn has to use
+	// the ctx it gets for them
+	Transaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// DistinctResult holds the values of a Distinct, the drivers own result cant
+// be made outside of it
+type DistinctResult struct {
+	values bson.RawArray
+	err    error
+}
+
+func NewDistinctResult(values bson.RawArray, err error) *DistinctResult {
+	return &DistinctResult{values: values, err: err}
+}
+
+// Decode fills a slice with the distinct values
+func (r *DistinctResult) Decode(v any) error {
+	if r.err != nil {
+		return r.err
+	}
+	return bson.RawValue{Type: bson.TypeArray, Value: r.values}.Unmarshal(v)
+}
+
+func (r *DistinctResult) Err() error {
+	return r.err
+}
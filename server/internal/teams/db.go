@@ -0,0 +1,328 @@
+package teams
+
+import (
+	"context"
+	"errors"
+	"server/internal/models"
+	"server/internal/server"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	TEAM_NAME_TAKEN  = "team name taken"
+	TEAM_NOT_FOUND   = "team not found"
+	TEAM_FULL        = "team is full"
+	ALREADY_IN_TEAM  = "already in a team"
+	TEAM_GUEST       = "guests cant join teams"
+	NOT_TEAM_OWNER   = "only the team owner can do that"
+	INVITE_EXISTS    = "already invited"
+	INVITE_NOT_FOUND = "invite not found"
+
+	MaxTeamSize = 50
+)
+
+// createTeam starts a team with userID as its owner and only member
+func (s *Server) createTeam(userID string, name string) (*models.Team, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+	team := models.Team{
+		Id:          uuid.NewString(),
+		Name:        name,
+		NameKey:     strings.ToLower(name),
+		OwnerID:     userID,
+		MemberCount: 1,
+		CreatedAt:   now,
+	}
+
+	// the membership goes in first, its _id is what stops a second team
+	_, err := s.CollMembers.InsertOne(ctx, models.TeamMember{
+		UserID:   userID,
+		TeamID:   team.Id,
+		Role:     models.TEAM_OWNER,
+		JoinedAt: now,
+	})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, errors.New(ALREADY_IN_TEAM)
+		}
+		return nil, err
+	}
+
+	if _, err := s.CollTeams.InsertOne(ctx, team); err != nil {
+		if _, err := s.CollMembers.DeleteOne(ctx, bson.M{"_id": userID, "teamId": team.Id}); err != nil {
+			return nil, err
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, errors.New(TEAM_NAME_TAKEN)
+		}
+		return nil, err
+	}
+
+	return &team, nil
+}
+
+func (s *Server) getTeam(teamID string) (*models.Team, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	var team models.Team
+	err := s.CollTeams.FindOne(ctx, bson.M{"_id": teamID}).Decode(&team)
+	if err == mongo.ErrNoDocuments {
+		return nil, errors.New(TEAM_NOT_FOUND)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &team, nil
+}
+
+// addMember puts userID on the team. The seat is taken on the team first so
+// two adds at once cant push it over MaxTeamSize.
+func (s *Server) addMember(teamID string, userID string) (*models.TeamMember, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	res, err := s.CollTeams.UpdateOne(ctx,
+		bson.M{"_id": teamID, "memberCount": bson.M{"$lt": MaxTeamSize}},
+		bson.M{"$inc": bson.M{"memberCount": 1}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if res.MatchedCount == 0 {
+		if _, err := s.getTeam(teamID); err != nil {
+			return nil, err
+		}
+		return nil, errors.New(TEAM_FULL)
+	}
+
+	member := models.TeamMember{
+		UserID:   userID,
+		TeamID:   teamID,
+		Role:     models.TEAM_MEMBER,
+		JoinedAt: time.Now().UTC(),
+	}
+	if _, err := s.CollMembers.InsertOne(ctx, member); err != nil {
+		// give the seat back
+		if _, err := s.CollTeams.UpdateOne(ctx, bson.M{"_id": teamID}, bson.M{"$inc": bson.M{"memberCount": -1}}); err != nil {
+			return nil, err
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, errors.New(ALREADY_IN_TEAM)
+		}
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+// inviteMember offers userID a seat on the team, they only join once they accept
+func (s *Server) inviteMember(teamID string, userID string, invitedBy string) (*models.TeamInvite, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	member, err := s.TeamOf(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if member != nil && member.TeamID == teamID {
+		return nil, errors.New(ALREADY_IN_TEAM)
+	}
+
+	invite := models.TeamInvite{
+		Id:        models.TeamInviteID(teamID, userID),
+		TeamID:    teamID,
+		UserID:    userID,
+		InvitedBy: invitedBy,
+		CreatedAt: time.Now().UTC(),
+	}
+	if _, err := s.CollInvites.InsertOne(ctx, invite); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, errors.New(INVITE_EXISTS)
+		}
+		return nil, err
+	}
+
+	return &invite, nil
+}
+
+// acceptInvite puts userID on the team they were invited to. The invite stays
+// when the team is full or they are still on another one, so they can retry.
+func (s *Server) acceptInvite(userID string, teamID string) (*models.TeamMember, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	err := s.CollInvites.FindOne(ctx, bson.M{"_id": models.TeamInviteID(teamID, userID)}).Err()
+	if err == mongo.ErrNoDocuments {
+		return nil, errors.New(INVITE_NOT_FOUND)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := s.addMember(teamID, userID)
+	if err != nil {
+		// the team was disbanded since, the invite goes with it
+		if err.Error() == TEAM_NOT_FOUND {
+			s.CollInvites.DeleteOne(ctx, bson.M{"_id": models.TeamInviteID(teamID, userID)})
+		}
+		return nil, err
+	}
+
+	if _, err := s.CollInvites.DeleteOne(ctx, bson.M{"_id": models.TeamInviteID(teamID, userID)}); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// dropInvite declines or withdraws an invite
+func (s *Server) dropInvite(teamID string, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	res, err := s.CollInvites.DeleteOne(ctx, bson.M{"_id": models.TeamInviteID(teamID, userID)})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return errors.New(INVITE_NOT_FOUND)
+	}
+	return nil
+}
+
+// listInvites returns the invites waiting on the user, oldest first
+func (s *Server) listInvites(userID string) ([]models.TeamInvite, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	cursor, err := s.CollInvites.Find(ctx, bson.M{"userId": userID},
+		options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		return nil, err
+	}
+	invites := []models.TeamInvite{}
+	if err := cursor.All(ctx, &invites); err != nil {
+		return nil, err
+	}
+	return invites, nil
+}
+
+// teamNames maps team ids to their names, teams that are gone are left out
+func (s *Server) teamNames(teamIDs []string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	cursor, err := s.CollTeams.Find(ctx, bson.M{"_id": bson.M{"$in": teamIDs}},
+		options.Find().SetProjection(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var teams []models.Team
+	if err := cursor.All(ctx, &teams); err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(teams))
+	for _, t := range teams {
+		names[t.Id] = t.Name
+	}
+	return names, nil
+}
+
+// removeMember takes userID off teamID, see server.LeaveTeam for owners
+func (s *Server) removeMember(teamID string, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	member, err := s.TeamOf(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if member == nil || member.TeamID != teamID {
+		return errors.New(server.NOT_IN_TEAM)
+	}
+	return s.LeaveTeam(ctx, userID)
+}
+
+// disbandTeam drops the team, every membership in it and its open invites
+func (s *Server) disbandTeam(teamID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	res, err := s.CollTeams.DeleteOne(ctx, bson.M{"_id": teamID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return errors.New(TEAM_NOT_FOUND)
+	}
+	if _, err := s.CollMembers.DeleteMany(ctx, bson.M{"teamId": teamID}); err != nil {
+		return err
+	}
+	_, err = s.CollInvites.DeleteMany(ctx, bson.M{"teamId": teamID})
+	return err
+}
+
+// TeamMemberRes is a member with the stats their team is built from
+type TeamMemberRes struct {
+	UserID   string    `json:"userId"`
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joinedAt"`
+	Score    float64   `json:"score"`
+	Accuracy float64   `json:"accuracy"`
+
+	answered, correct float64 // for the team accuracy
+}
+
+// teamMembers lists a team in the order people joined
+func (s *Server) teamMembers(teamID string) ([]TeamMemberRes, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	cursor, err := s.CollMembers.Find(ctx, bson.M{"teamId": teamID},
+		options.Find().SetSort(bson.M{"joinedAt": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var members []models.TeamMember
+	if err := cursor.All(ctx, &members); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(members))
+	for _, m := range members {
+		ids = append(ids, m.UserID)
+	}
+	states, err := s.States.GetMany(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]models.UserState, len(states))
+	for _, st := range states {
+		byID[st.UserID] = st
+	}
+
+	res := make([]TeamMemberRes, 0, len(members))
+	for _, m := range members {
+		st := byID[m.UserID]
+		res = append(res, TeamMemberRes{
+			UserID:   m.UserID,
+			Username: st.Username,
+			Role:     m.Role,
+			JoinedAt: m.JoinedAt,
+			Score:    st.TotalScore,
+			Accuracy: server.Accuracy(st),
+			answered: st.TotalAnswered,
+			correct:  st.TotalCorrect,
+		})
+	}
+	return res, nil
+}
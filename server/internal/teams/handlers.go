@@ -0,0 +1,328 @@
+package teams
+
+import (
+	"net/http"
+	"server/internal/auth"
+	"server/internal/models"
+	"server/internal/server"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreateTeamReq struct {
+	Name string `json:"name" binding:"required,min=3,max=30"`
+}
+
+type InviteReq struct {
+	Username string `json:"username" binding:"required"`
+}
+
+// TeamInviteRes is an invite waiting on the caller
+type TeamInviteRes struct {
+	models.TeamInvite
+	TeamName string `json:"teamName"`
+}
+
+type TeamInvitesRes struct {
+	Invites []TeamInviteRes `json:"invites"`
+}
+
+// TeamRes is a team with its members and their all time sums
+type TeamRes struct {
+	models.Team
+	Members  []TeamMemberRes `json:"members"`
+	Score    float64         `json:"score"`
+	Accuracy float64         `json:"accuracy"`
+}
+
+func (s *Server) CreateTeam(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	var req CreateTeamReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name bw 3-30 chars"})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if len(name) < 3 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name bw 3-30 chars"})
+		return
+	}
+
+	caller, err := s.users.GetUser(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if caller.Guest {
+		c.JSON(http.StatusForbidden, gin.H{"error": TEAM_GUEST})
+		return
+	}
+
+	team, err := s.createTeam(userID, name)
+	if err != nil {
+		switch err.Error() {
+		case ALREADY_IN_TEAM, TEAM_NAME_TAKEN:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, team)
+}
+
+func (s *Server) GetTeam(c *gin.Context) {
+	s.respondWithTeam(c, c.Param("teamId"))
+}
+
+// GetMyTeam is the callers own team, 404 when they arent in one
+func (s *Server) GetMyTeam(c *gin.Context) {
+	member, err := s.TeamOf(c.Request.Context(), c.GetString("userId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if member == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": server.NOT_IN_TEAM})
+		return
+	}
+	s.respondWithTeam(c, member.TeamID)
+}
+
+func (s *Server) respondWithTeam(c *gin.Context, teamID string) {
+	team, err := s.getTeam(teamID)
+	if err != nil {
+		if err.Error() == TEAM_NOT_FOUND {
+			c.JSON(http.StatusNotFound, gin.H{"error": TEAM_NOT_FOUND})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	members, err := s.teamMembers(teamID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	res := TeamRes{Team: *team, Members: members}
+	var answered, correct float64
+	for _, m := range members {
+		res.Score += m.Score
+		answered += m.answered
+		correct += m.correct
+	}
+	if answered > 0 {
+		res.Accuracy = correct / answered
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// InviteTeamMember offers a user a seat on the team, only the owner (or an
+// admin) can. The user joins when they accept.
+func (s *Server) InviteTeamMember(c *gin.Context) {
+	teamID := c.Param("teamId")
+
+	var req InviteReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username required"})
+		return
+	}
+
+	if !s.canManage(c, teamID) {
+		return
+	}
+
+	target, err := s.users.FindInUsersTable(req.Username)
+	if err != nil {
+		if err.Error() == auth.USER_NOT_FOUND {
+			c.JSON(http.StatusNotFound, gin.H{"error": auth.USER_NOT_FOUND})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if target.Guest {
+		c.JSON(http.StatusBadRequest, gin.H{"error": TEAM_GUEST})
+		return
+	}
+
+	invite, err := s.inviteMember(teamID, target.Id, c.GetString("userId"))
+	if err != nil {
+		switch err.Error() {
+		case INVITE_EXISTS, ALREADY_IN_TEAM:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, invite)
+}
+
+// ListTeamInvites returns the invites waiting on the caller
+func (s *Server) ListTeamInvites(c *gin.Context) {
+	invites, err := s.listInvites(c.GetString("userId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	ids := make([]string, 0, len(invites))
+	for _, inv := range invites {
+		ids = append(ids, inv.TeamID)
+	}
+	names, err := s.teamNames(ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	res := TeamInvitesRes{Invites: []TeamInviteRes{}}
+	for _, inv := range invites {
+		// invites to teams that were disbanded since arent worth showing
+		if name, ok := names[inv.TeamID]; ok {
+			res.Invites = append(res.Invites, TeamInviteRes{TeamInvite: inv, TeamName: name})
+		}
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// AcceptTeamInvite puts the caller on :teamId, they need an invite to it
+func (s *Server) AcceptTeamInvite(c *gin.Context) {
+	member, err := s.acceptInvite(c.GetString("userId"), c.Param("teamId"))
+	if err != nil {
+		switch err.Error() {
+		case INVITE_NOT_FOUND, TEAM_NOT_FOUND:
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case TEAM_FULL, ALREADY_IN_TEAM:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, member)
+}
+
+// DropTeamInvite removes the invite of :userId to :teamId. The invited user
+// declines their own, anyone else needs the owner or an admin.
+func (s *Server) DropTeamInvite(c *gin.Context) {
+	teamID := c.Param("teamId")
+	userID := c.Param("userId")
+
+	if userID != c.GetString("userId") && !s.canManage(c, teamID) {
+		return
+	}
+
+	if err := s.dropInvite(teamID, userID); err != nil {
+		if err.Error() == INVITE_NOT_FOUND {
+			c.JSON(http.StatusNotFound, gin.H{"error": INVITE_NOT_FOUND})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"teamId": teamID, "userId": userID})
+}
+
+// RemoveTeamMember takes :userId off the team. Members can remove themselves
+// (leave), anyone else needs the owner or an admin.
+func (s *Server) RemoveTeamMember(c *gin.Context) {
+	teamID := c.Param("teamId")
+	userID := c.Param("userId")
+
+	if userID != c.GetString("userId") && !s.canManage(c, teamID) {
+		return
+	}
+
+	if err := s.removeMember(teamID, userID); err != nil {
+		if err.Error() == server.NOT_IN_TEAM {
+			c.JSON(http.StatusNotFound, gin.H{"error": server.NOT_IN_TEAM})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"teamId": teamID, "userId": userID})
+}
+
+// DeleteTeam disbands a team, owner or admin only
+func (s *Server) DeleteTeam(c *gin.Context) {
+	teamID := c.Param("teamId")
+	if !s.canManage(c, teamID) {
+		return
+	}
+
+	if err := s.disbandTeam(teamID); err != nil {
+		if err.Error() == TEAM_NOT_FOUND {
+			c.JSON(http.StatusNotFound, gin.H{"error": TEAM_NOT_FOUND})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"teamId": teamID})
+}
+
+// GetTeamLeaderboard ranks teams on /leaderboard/teams/:board (score or accuracy)
+func (s *Server) GetTeamLeaderboard(c *gin.Context) {
+	board := c.Param("board")
+	if !validTeamBoard(board) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown leaderboard"})
+		return
+	}
+	page, err := parseTeamPage(c, board)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	res, err := s.buildTeamLeaderboard(page, c.GetString("userId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// canManage lets the team owner and admins through, answering the request
+// itself otherwise
+func (s *Server) canManage(c *gin.Context, teamID string) bool {
+	team, err := s.getTeam(teamID)
+	if err != nil {
+		if err.Error() == TEAM_NOT_FOUND {
+			c.JSON(http.StatusNotFound, gin.H{"error": TEAM_NOT_FOUND})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return false
+	}
+
+	userID := c.GetString("userId")
+	if team.OwnerID == userID {
+		return true
+	}
+	caller, err := s.users.GetUser(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return false
+	}
+	// same as the admin routes, only a session started with the admin key
+	if caller.Role != auth.ROLE_ADMIN || !c.GetBool("adminSession") {
+		c.JSON(http.StatusForbidden, gin.H{"error": NOT_TEAM_OWNER})
+		return false
+	}
+	return true
+}
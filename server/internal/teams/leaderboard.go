@@ -0,0 +1,257 @@
+package teams
+
+import (
+	"context"
+	"errors"
+	"server/internal/models"
+	"server/internal/server"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/cache/v9"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Team boards are summed per team in the store and cached for a minute, there
+// are far fewer teams than users so they arent kept in sorted sets.
+//
+//	score     total score of the members (answer-logs for periods)
+//	accuracy  correct answers / answers over all members, teams need 20+ answers
+const (
+	defaultPageSize = 5
+	maxPageSize     = 100
+
+	boardCacheTTL = time.Minute
+)
+
+// TeamTotal is one teams sums on a board
+type TeamTotal struct {
+	TeamID   string  `bson:"_id"      json:"teamId"`
+	Name     string  `bson:"name"     json:"name"`
+	Members  int     `bson:"members"  json:"members"`
+	Score    float64 `bson:"score"    json:"score"`
+	Answered float64 `bson:"answered" json:"answered"`
+	Correct  float64 `bson:"correct"  json:"correct"`
+}
+
+type TeamEntry struct {
+	Rank    int     `json:"rank"`
+	TeamID  string  `json:"teamId"`
+	Name    string  `json:"name"`
+	Members int     `json:"members"`
+	Value   float64 `json:"value"`
+}
+
+type TeamLeaderboardRes struct {
+	Ranking    string      `json:"ranking"`
+	Entries    []TeamEntry `json:"entries"`
+	MyTeam     *TeamEntry  `json:"myTeam,omitempty"` // missing when the caller has no team or it isnt listed
+	NextOffset *int        `json:"nextOffset,omitempty"`
+}
+
+// teamPage is what the query asked for
+//
+//	?period=weekly      daily, weekly, monthly or all (default)
+//	?offset=0&limit=5   page starting at offset (limit max 100)
+//	?rank=dense         standard (default) or dense ranks
+type teamPage struct {
+	board   string
+	period  string
+	ranking string
+	offset  int
+	limit   int
+}
+
+func validTeamBoard(board string) bool {
+	return board == server.BOARD_SCORE || board == server.BOARD_ACCURACY
+}
+
+func parseTeamPage(c *gin.Context, board string) (teamPage, error) {
+	page := teamPage{
+		board:   board,
+		period:  server.PERIOD_ALL,
+		ranking: server.RANK_STANDARD,
+		limit:   defaultPageSize,
+	}
+
+	if v := c.Query("period"); v != "" {
+		if !server.ValidPeriod(v) || v == server.PERIOD_SEASON {
+			return page, errors.New("period must be daily, weekly, monthly or all")
+		}
+		page.period = v
+	}
+
+	if v := c.Query("rank"); v != "" {
+		if !server.ValidRanking(v) {
+			return page, errors.New("rank must be standard or dense")
+		}
+		page.ranking = v
+	}
+
+	if v := c.Query("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return page, errors.New("offset must be a positive number")
+		}
+		page.offset = n
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			return page, errors.New("limit must be between 1 and " + strconv.Itoa(maxPageSize))
+		}
+		page.limit = n
+	}
+
+	return page, nil
+}
+
+// teamValue is what a board ranks a team by, false when the team isnt listed
+func teamValue(board string, t TeamTotal) (float64, bool) {
+	if board == server.BOARD_ACCURACY {
+		if t.Answered < server.MinAccuracyAnswers {
+			return 0, false
+		}
+		return t.Correct / t.Answered, true
+	}
+	return t.Score, true
+}
+
+// rankedTeams is the whole board, ranked. Equal values are listed by name.
+func (s *Server) rankedTeams(ctx context.Context, page teamPage) ([]TeamEntry, error) {
+	key := "team_board:" + page.board + ":" + page.period
+	if page.period != server.PERIOD_ALL {
+		key += ":" + s.PeriodStart(page.period, time.Now()).Format("20060102")
+	}
+
+	var entries []TeamEntry
+	err := s.StateCache.Once(&cache.Item{
+		Ctx:   ctx,
+		Key:   key,
+		Value: &entries,
+		TTL:   boardCacheTTL,
+		Do: func(*cache.Item) (any, error) {
+			totals, err := s.teamTotals(ctx, page.period)
+			if err != nil {
+				return nil, err
+			}
+
+			listed := []TeamEntry{}
+			for _, t := range totals {
+				if value, ok := teamValue(page.board, t); ok {
+					listed = append(listed, TeamEntry{TeamID: t.TeamID, Name: t.Name, Members: t.Members, Value: value})
+				}
+			}
+			sort.Slice(listed, func(i, j int) bool {
+				if listed[i].Value != listed[j].Value {
+					return listed[i].Value > listed[j].Value
+				}
+				return listed[i].Name < listed[j].Name
+			})
+			return listed, nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rankTeams(entries, page.ranking)
+	return entries, nil
+}
+
+// rankTeams fills in ranks on a sorted board
+func rankTeams(entries []TeamEntry, ranking string) {
+	for i := range entries {
+		switch {
+		case i > 0 && entries[i].Value == entries[i-1].Value:
+			entries[i].Rank = entries[i-1].Rank
+		case ranking == server.RANK_DENSE && i > 0:
+			entries[i].Rank = entries[i-1].Rank + 1
+		default:
+			entries[i].Rank = i + 1
+		}
+	}
+}
+
+// teamTotals sums every team in the store, from user-state for all time and
+// from the period's answer-logs otherwise. Both count the current members
+// only, and leave out shadow excluded ones.
+func (s *Server) teamTotals(ctx context.Context, period string) ([]TeamTotal, error) {
+	sums := map[string]models.TeamTotal{}
+	add := func(t models.TeamTotal) error {
+		sums[t.TeamID] = t
+		return nil
+	}
+	var err error
+	if period == server.PERIOD_ALL {
+		err = s.States.TeamTotals(ctx, add)
+	} else {
+		err = s.Answers.TeamTotals(ctx, s.PeriodStart(period, time.Now()), add)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	teamIDs := make([]string, 0, len(sums))
+	for id := range sums {
+		teamIDs = append(teamIDs, id)
+	}
+	cursor, err := s.CollTeams.Find(ctx, bson.M{"_id": bson.M{"$in": teamIDs}})
+	if err != nil {
+		return nil, err
+	}
+	var teams []models.Team
+	if err := cursor.All(ctx, &teams); err != nil {
+		return nil, err
+	}
+
+	totals := make([]TeamTotal, 0, len(teams))
+	for _, team := range teams {
+		t := sums[team.Id]
+		totals = append(totals, TeamTotal{
+			TeamID:   team.Id,
+			Name:     team.Name,
+			Members:  team.MemberCount,
+			Score:    t.Score,
+			Answered: t.Answered,
+			Correct:  t.Correct,
+		})
+	}
+	return totals, nil
+}
+
+// buildTeamLeaderboard pages the board and finds the callers team on it
+func (s *Server) buildTeamLeaderboard(page teamPage, userID string) (*TeamLeaderboardRes, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.DBTimeout)
+	defer cancel()
+
+	all, err := s.rankedTeams(ctx, page)
+	if err != nil {
+		return nil, errors.New("failed to fetch team leaderboard " + err.Error())
+	}
+
+	res := &TeamLeaderboardRes{Ranking: page.ranking, Entries: []TeamEntry{}}
+	start := min(page.offset, len(all))
+	end := min(start+page.limit, len(all))
+	res.Entries = append(res.Entries, all[start:end]...)
+	if end < len(all) {
+		res.NextOffset = &end
+	}
+
+	member, err := s.TeamOf(ctx, userID)
+	if err != nil {
+		return nil, errors.New("failed to load team " + err.Error())
+	}
+	if member != nil {
+		for _, entry := range all {
+			if entry.TeamID == member.TeamID {
+				res.MyTeam = &entry
+				break
+			}
+		}
+	}
+
+	return res, nil
+}